@@ -0,0 +1,319 @@
+package wallet
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// amountScale is the number of decimal digits after the point which is stored
+// by Amount. No currency may have a bigger scale.
+const amountScale = 4
+
+// amountFactor is 10 in the power of amountScale.
+const amountFactor = 10000
+
+// maxAmountValue is the maximal absolute amount value in 1/10000 units, it is
+// the maximal value of the database type numeric(18,4). The range is
+// symmetric, so the negation is always in range, and the sum of two amounts
+// could not overflow int64.
+const maxAmountValue = 999999999999999999
+
+// defaultCurrencyScale is the scale for currencies which are not listed in
+// currencyScales, it is the most common number of minor units in ISO 4217.
+const defaultCurrencyScale = 2
+
+// currencyScales has the number of minor unit digits for currencies which have
+// a scale different from defaultCurrencyScale.
+var currencyScales = map[string]uint{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0,
+	"KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0,
+	"XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4}
+
+// GetCurrencyScale returns the number of digits after the decimal point which
+// the currency allows.
+func GetCurrencyScale(currency string) uint {
+	if result, has := currencyScales[currency]; has {
+		return result
+	}
+	return defaultCurrencyScale
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Amount is an exact fixed-point decimal money amount. It stores the value as
+// an integer number of 1/10000 units, so the zero value is the zero amount and
+// amounts could be compared by the operator "==". The supported range is
+// ±99999999999999.9999.
+type Amount struct{ value int64 }
+
+// NewAmount creates an amount from an integer number of 1/10^scale units, for
+// example, NewAmount(12345, 2) is 123.45.
+func NewAmount(value int64, scale uint) Amount {
+	if scale > amountScale {
+		log.Panicf(`Amount scale %d is bigger than supported %d.`,
+			scale, amountScale)
+	}
+	factor := int64(1)
+	for i := scale; i < amountScale; i++ {
+		factor *= 10
+	}
+	if value > maxAmountValue/factor || value < -maxAmountValue/factor {
+		log.Panicf(`Amount %d (scale %d) is out of range.`, value, scale)
+	}
+	return Amount{value: value * factor}
+}
+
+// isInRange returns true if the value is an amount value in the supported
+// range.
+func isInRange(value int64) bool {
+	return value <= maxAmountValue && value >= -maxAmountValue
+}
+
+// ParseAmount parses decimal string representation of an amount, like "123",
+// "-0.5" or "+100.25". Exponential and other float notations are not allowed
+// to not lose precision.
+func ParseAmount(source string) (Amount, error) {
+	str := source
+	negative := false
+	if strings.HasPrefix(str, "-") {
+		negative = true
+		str = str[1:]
+	} else if strings.HasPrefix(str, "+") {
+		str = str[1:]
+	}
+	intPart := str
+	fracPart := ""
+	if point := strings.IndexByte(str, '.'); point >= 0 {
+		intPart = str[:point]
+		fracPart = str[point+1:]
+	}
+	if (len(intPart) == 0 && len(fracPart) == 0) ||
+		!isDecimalDigits(intPart) || !isDecimalDigits(fracPart) {
+
+		return Amount{}, fmt.Errorf(`Failed to parse amount "%s"`, source)
+	}
+	// Trailing zeros do not change the value, but could exceed the scale.
+	fracPart = strings.TrimRight(fracPart, "0")
+	if len(fracPart) > amountScale {
+		return Amount{},
+			fmt.Errorf(`Amount "%s" has more than %d digits after the point`,
+				source, amountScale)
+	}
+	fracPart += strings.Repeat("0", amountScale-len(fracPart))
+	if len(intPart) == 0 {
+		intPart = "0"
+	}
+	digits := intPart + fracPart
+	if negative {
+		digits = "-" + digits
+	}
+	value, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || !isInRange(value) {
+		return Amount{}, fmt.Errorf(`Amount "%s" is out of range`, source)
+	}
+	return Amount{value: value}, nil
+}
+
+func isDecimalDigits(str string) bool {
+	for _, c := range str {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the decimal representation of the amount without trailing
+// zeros after the point.
+func (a Amount) String() string {
+	abs := uint64(a.value)
+	sign := ""
+	if a.value < 0 {
+		abs = uint64(-a.value)
+		sign = "-"
+	}
+	result := sign + strconv.FormatUint(abs/amountFactor, 10)
+	if frac := abs % amountFactor; frac != 0 {
+		fracStr := strconv.FormatUint(frac+amountFactor, 10)[1:]
+		result += "." + strings.TrimRight(fracStr, "0")
+	}
+	return result
+}
+
+// Format returns the decimal representation of the amount with the number of
+// digits after the point which is defined by the currency.
+func (a Amount) Format(currency string) string {
+	result := a.String()
+	scale := int(GetCurrencyScale(currency))
+	if scale == 0 {
+		return result
+	}
+	point := strings.IndexByte(result, '.')
+	if point < 0 {
+		return result + "." + strings.Repeat("0", scale)
+	}
+	if digits := len(result) - point - 1; digits < scale {
+		result += strings.Repeat("0", scale-digits)
+	}
+	return result
+}
+
+// Scale returns the number of significant digits after the decimal point.
+func (a Amount) Scale() uint {
+	result := uint(amountScale)
+	for value := a.value; result > 0 && value%10 == 0; value /= 10 {
+		result--
+	}
+	return result
+}
+
+// CheckCurrency returns an error if the amount has more digits after the
+// decimal point than the currency allows.
+func (a Amount) CheckCurrency(currency string) error {
+	if scale := GetCurrencyScale(currency); a.Scale() > scale {
+		return newError(ErrInvalidAmount,
+			`Amount %s has more than %d digits after the point for currency "%s"`,
+			a, scale, currency)
+	}
+	return nil
+}
+
+// IsZero returns true if the amount is zero.
+func (a Amount) IsZero() bool { return a.value == 0 }
+
+// IsNegative returns true if the amount is less than zero.
+func (a Amount) IsNegative() bool { return a.value < 0 }
+
+// IsPositive returns true if the amount is greater than zero.
+func (a Amount) IsPositive() bool { return a.value > 0 }
+
+// Cmp compares amounts and returns -1 if a < rhs, 0 if a == rhs and +1 if
+// a > rhs.
+func (a Amount) Cmp(rhs Amount) int {
+	switch {
+	case a.value < rhs.value:
+		return -1
+	case a.value > rhs.value:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Add returns the sum of amounts, or ErrInvalidAmount if the sum is out of
+// range.
+func (a Amount) Add(rhs Amount) (Amount, error) {
+	result := a.value + rhs.value
+	if !isInRange(result) {
+		return Amount{},
+			newError(ErrInvalidAmount, "Amount %s + %s is out of range", a, rhs)
+	}
+	return Amount{value: result}, nil
+}
+
+// Sub returns the difference of amounts, or ErrInvalidAmount if the difference
+// is out of range.
+func (a Amount) Sub(rhs Amount) (Amount, error) {
+	result := a.value - rhs.value
+	if !isInRange(result) {
+		return Amount{},
+			newError(ErrInvalidAmount, "Amount %s - %s is out of range", a, rhs)
+	}
+	return Amount{value: result}, nil
+}
+
+// Neg returns the amount with the opposite sign, the range is symmetric, so the
+// result is always in range.
+func (a Amount) Neg() Amount { return Amount{value: -a.value} }
+
+// Abs returns the absolute value of the amount.
+func (a Amount) Abs() Amount {
+	if a.value < 0 {
+		return a.Neg()
+	}
+	return a
+}
+
+// Convert returns the amount multiplied by the exchange rate, the result is
+// rounded towards zero to the scale. Returns ErrInvalidAmount if the result is
+// out of range.
+func (a Amount) Convert(rate *big.Rat, scale uint) (Amount, error) {
+	if scale > amountScale {
+		log.Panicf(`Amount scale %d is bigger than supported %d.`,
+			scale, amountScale)
+	}
+	result := new(big.Rat).Mul(new(big.Rat).SetInt64(a.value), rate)
+	unit := big.NewInt(1)
+	for i := scale; i < amountScale; i++ {
+		unit.Mul(unit, big.NewInt(10))
+	}
+	// Quo truncates towards zero.
+	value := new(big.Int).Quo(
+		result.Num(), new(big.Int).Mul(result.Denom(), unit))
+	value.Mul(value, unit)
+	if !value.IsInt64() || !isInRange(value.Int64()) {
+		return Amount{},
+			newError(ErrInvalidAmount, "Amount %s * %s is out of range",
+				a, rate.RatString())
+	}
+	return Amount{value: value.Int64()}, nil
+}
+
+// MarshalJSON implements json.Marshaler, the amount is encoded as a string to
+// not lose precision by JSON-parsers which use float numbers.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler, it accepts both string and number
+// notations, numbers are parsed from the source text without float rounding.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	str := string(data)
+	if strings.HasPrefix(str, `"`) {
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+	}
+	result, err := ParseAmount(str)
+	if err != nil {
+		return err
+	}
+	*a = result
+	return nil
+}
+
+// Value implements driver.Valuer, the amount is stored as a decimal string for
+// NUMERIC-columns.
+func (a Amount) Value() (driver.Value, error) { return a.String(), nil }
+
+// Scan implements sql.Scanner.
+func (a *Amount) Scan(source interface{}) error {
+	var err error
+	switch value := source.(type) {
+	case []byte:
+		*a, err = ParseAmount(string(value))
+	case string:
+		*a, err = ParseAmount(value)
+	case int64:
+		if value > maxAmountValue/amountFactor ||
+			value < -maxAmountValue/amountFactor {
+
+			return fmt.Errorf(`Amount %d is out of range`, value)
+		}
+		*a = NewAmount(value, 0)
+	case nil:
+		*a = Amount{}
+	default:
+		err = fmt.Errorf(`Failed to scan amount from %T`, source)
+	}
+	return err
+}
+
+////////////////////////////////////////////////////////////////////////////////
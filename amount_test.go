@@ -0,0 +1,216 @@
+package wallet_test
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	w "github.com/palchukovsky/wallet"
+)
+
+// Test_Amount_Parse tests amount parsing and string representation.
+func Test_Amount_Parse(test *testing.T) {
+	for source, expected := range map[string]string{
+		"0":                      "0",
+		"-0":                     "0",
+		"123":                    "123",
+		"+123":                   "123",
+		"-123.45":                "-123.45",
+		"0.1":                    "0.1",
+		".5":                     "0.5",
+		"10.":                    "10",
+		"1.1000":                 "1.1",
+		"0.00010000":             "0.0001",
+		"99999999999999.9999":    "99999999999999.9999",
+		"-99999999999999.9999":   "-99999999999999.9999",
+		"000000000000000000001":  "1",
+		"-0000000000000000.0001": "-0.0001"} {
+
+		amount, err := w.ParseAmount(source)
+		if err != nil {
+			test.Errorf(`Failed to parse "%s": "%s".`, source, err)
+			continue
+		}
+		if amount.String() != expected {
+			test.Errorf(`Wrong amount for "%s": "%s".`, source, amount)
+		}
+	}
+
+	for _, source := range []string{
+		"", "-", ".", "1e3", "0x10", "1,5", "1.2.3", "--1", "+-1", " 1", "1.00001",
+		"100000000000000", "-100000000000000", "922337203685477.5808"} {
+
+		if amount, err := w.ParseAmount(source); err == nil {
+			test.Errorf(`Parsing "%s" has to fail, but returned "%s".`,
+				source, amount)
+		}
+	}
+}
+
+// Test_Amount_Arithmetic tests exact amount arithmetic.
+func Test_Amount_Arithmetic(test *testing.T) {
+	sum := w.Amount{}
+	for i := 0; i < 10; i++ {
+		var err error
+		if sum, err = sum.Add(w.NewAmount(1, 1)); err != nil {
+			test.Fatalf(`Failed to add: "%s".`, err)
+		}
+	}
+	if sum != w.NewAmount(1, 0) {
+		test.Errorf(`Wrong sum: "%s".`, sum)
+	}
+	if diff, err := sum.Sub(w.NewAmount(3, 2)); err != nil ||
+		diff != w.NewAmount(97, 2) {
+
+		test.Errorf(`Wrong difference: "%s", "%v".`, diff, err)
+	}
+	if sum.Neg() != w.NewAmount(-1, 0) || sum.Neg().Abs() != sum {
+		test.Errorf(`Wrong negation: "%s".`, sum.Neg())
+	}
+	if !sum.Neg().IsNegative() || sum.IsNegative() || !sum.IsPositive() ||
+		sum.IsZero() || !(w.Amount{}).IsZero() {
+
+		test.Error("Wrong sign.")
+	}
+	if sum.Cmp(w.NewAmount(2, 0)) != -1 || sum.Cmp(sum) != 0 ||
+		sum.Cmp(w.NewAmount(-2, 0)) != 1 {
+
+		test.Error("Wrong comparison.")
+	}
+
+	max, err := w.ParseAmount("99999999999999.9999")
+	if err != nil {
+		test.Fatalf(`Failed to parse: "%s".`, err)
+	}
+	if _, err := max.Add(w.NewAmount(1, 4)); !w.IsError(
+		err, w.ErrInvalidAmount) {
+
+		test.Errorf(`Overflow is not detected: "%v".`, err)
+	}
+	if _, err := max.Neg().Sub(max); !w.IsError(err, w.ErrInvalidAmount) {
+		test.Errorf(`Overflow is not detected: "%v".`, err)
+	}
+}
+
+// Test_Amount_Currency tests currency scale rules.
+func Test_Amount_Currency(test *testing.T) {
+	if w.GetCurrencyScale("USD") != 2 || w.GetCurrencyScale("JPY") != 0 ||
+		w.GetCurrencyScale("KWD") != 3 {
+
+		test.Error("Wrong currency scale.")
+	}
+
+	if err := w.NewAmount(12345, 2).CheckCurrency("USD"); err != nil {
+		test.Errorf(`Unexpected error: "%s".`, err)
+	}
+	err := w.NewAmount(12345, 3).CheckCurrency("USD")
+	if err == nil ||
+		err.Error() != `Amount 12.345 has more than 2 digits after the point for currency "USD"` {
+
+		test.Errorf(`Wrong error: "%v".`, err)
+	}
+	if err := w.NewAmount(1, 1).CheckCurrency("JPY"); err == nil {
+		test.Error("Error expected.")
+	}
+
+	for _, check := range []struct {
+		amount   w.Amount
+		currency string
+		result   string
+	}{
+		{w.NewAmount(100, 0), "USD", "100.00"},
+		{w.NewAmount(-15, 1), "EUR", "-1.50"},
+		{w.NewAmount(100, 0), "JPY", "100"},
+		{w.NewAmount(1, 3), "KWD", "0.001"},
+		{w.NewAmount(12345, 4), "USD", "1.2345"}} {
+
+		if result := check.amount.Format(check.currency); result != check.result {
+			test.Errorf(`Wrong format for %s (%s): "%s".`,
+				check.amount, check.currency, result)
+		}
+	}
+}
+
+// Test_Amount_Convert tests amount conversion by exchange rate.
+func Test_Amount_Convert(test *testing.T) {
+	for _, check := range []struct {
+		amount w.Amount
+		rate   *big.Rat
+		scale  uint
+		result w.Amount
+	}{
+		{w.NewAmount(1001, 2), big.NewRat(10915, 100), 0, w.NewAmount(1092, 0)},
+		{w.NewAmount(1001, 2), big.NewRat(10915, 100), 2, w.NewAmount(109259, 2)},
+		{w.NewAmount(-1001, 2), big.NewRat(10915, 100), 0, w.NewAmount(-1092, 0)},
+		{w.NewAmount(1, 0), big.NewRat(1, 3), 4, w.NewAmount(3333, 4)},
+		{w.NewAmount(1, 2), big.NewRat(9, 10), 2, w.Amount{}}} {
+
+		result, err := check.amount.Convert(check.rate, check.scale)
+		if err != nil || result != check.result {
+			test.Errorf(`Wrong conversion of %s by %s: "%s", "%v".`,
+				check.amount, check.rate, result, err)
+		}
+	}
+
+	max, err := w.ParseAmount("99999999999999.9999")
+	if err != nil {
+		test.Fatalf(`Failed to parse: "%s".`, err)
+	}
+	_, err = max.Convert(big.NewRat(2, 1), 2)
+	if !w.IsError(err, w.ErrInvalidAmount) {
+		test.Errorf(`Overflow is not detected: "%v".`, err)
+	}
+}
+
+// Test_Amount_JSON tests amount JSON serialization.
+func Test_Amount_JSON(test *testing.T) {
+	result, err := json.Marshal(w.NewAmount(-12345, 2))
+	if err != nil || string(result) != `"-123.45"` {
+		test.Errorf(`Wrong JSON: "%s", "%v".`, result, err)
+	}
+
+	for _, source := range []string{`"0.3"`, `0.3`, `0.30`} {
+		var amount w.Amount
+		if err := json.Unmarshal([]byte(source), &amount); err != nil {
+			test.Errorf(`Failed to parse JSON "%s": "%s".`, source, err)
+		}
+		if amount != w.NewAmount(3, 1) {
+			test.Errorf(`Wrong amount from JSON "%s": "%s".`, source, amount)
+		}
+	}
+	var amount w.Amount
+	if err := json.Unmarshal([]byte(`3e-1`), &amount); err == nil {
+		test.Error("Exponential notation has to be rejected.")
+	}
+}
+
+// Test_Amount_SQL tests amount database value conversion.
+func Test_Amount_SQL(test *testing.T) {
+	value, err := w.NewAmount(5, 1).Value()
+	if err != nil || value != "0.5" {
+		test.Errorf(`Wrong value: "%v", "%v".`, value, err)
+	}
+
+	for _, source := range []interface{}{
+		[]byte("12.5000"), "12.5", int64(12)} {
+
+		var amount w.Amount
+		if err := amount.Scan(source); err != nil {
+			test.Errorf(`Failed to scan "%v": "%s".`, source, err)
+		}
+		if amount.Cmp(w.NewAmount(12, 0)) < 0 {
+			test.Errorf(`Wrong scan result for "%v": "%s".`, source, amount)
+		}
+	}
+	var amount w.Amount
+	if err := amount.Scan(12.5); err == nil {
+		test.Error("Float scan has to be rejected.")
+	}
+	for _, source := range []interface{}{
+		"100000000000000", int64(100000000000000)} {
+
+		if err := amount.Scan(source); err == nil {
+			test.Errorf(`Scan of "%v" out of range has to fail.`, source)
+		}
+	}
+}
@@ -4,7 +4,12 @@ CREATE TABLE account (
 	id serial NOT NULL,
 	name text,
 	currency text,
-	balance double precision,
+	balance numeric(18, 4) NOT NULL DEFAULT 0,
+	-- "active", "frozen" or "closed".
+	status text NOT NULL DEFAULT 'active',
+	-- Credit line, the available balance could be decreased by clients down to
+	-- the negative overdraft value.
+	overdraft numeric(18, 4) NOT NULL DEFAULT 0,
 	PRIMARY KEY(id),
 	CONSTRAINT account_unique UNIQUE(currency, name));
 
@@ -13,11 +18,133 @@ CREATE TABLE trans (
   id serial NOT NULL,
 	time timestamp NOT NULL,
 	author text NOT NULL,
+	idempotency_key text,
+	-- Hash of the previous transaction hash and the transaction content, see
+	-- trans_chain.
+	hash text NOT NULL DEFAULT '',
+	PRIMARY KEY(id),
+	CONSTRAINT trans_idempotency_key_unique UNIQUE(idempotency_key));
+-- Transaction list is paginated by time and ID.
+CREATE INDEX trans_time ON trans(time, id);
+
+-- Head of the transaction hash chain, the only row refers to the last
+-- transaction. The row is locked by each transaction storing, so transactions
+-- are chained in the order of IDs.
+CREATE TABLE trans_chain (
+	id integer NOT NULL,
+	-- Last transaction, NULL if there are no transactions yet.
+	trans integer REFERENCES trans(id) ON DELETE RESTRICT,
+	hash text NOT NULL,
 	PRIMARY KEY(id));
+INSERT INTO trans_chain(id, trans, hash) VALUES(1, NULL, '');
 
 -- Actions applied to accounts.
 CREATE TABLE action (
   account integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
 	trans integer NOT NULL REFERENCES trans(id) ON DELETE CASCADE,
-  volume double precision NOT NULL,
-	PRIMARY KEY(account, trans));
\ No newline at end of file
+  volume numeric(18, 4) NOT NULL,
+	-- Fee actions credit fee accounts with payment fees.
+	fee boolean NOT NULL DEFAULT false,
+	PRIMARY KEY(account, trans));
+-- Transaction actions are loaded and filtered by transaction.
+CREATE INDEX action_trans ON action(trans);
+
+-- Links of reversal transactions to reversed payments.
+CREATE TABLE reversal (
+	trans integer NOT NULL REFERENCES trans(id) ON DELETE CASCADE,
+	original integer NOT NULL REFERENCES trans(id) ON DELETE RESTRICT,
+	PRIMARY KEY(trans));
+-- Reversals are summarized for the payment to check the reversed amount.
+CREATE INDEX reversal_original ON reversal(original);
+
+-- Funds reservations for future payments.
+CREATE TABLE hold (
+	id serial NOT NULL,
+	account integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	destination integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	amount numeric(18, 4) NOT NULL,
+	author text NOT NULL,
+	time timestamp NOT NULL,
+	expiration timestamp NOT NULL,
+	-- "active", "captured" or "released".
+	status text NOT NULL,
+	-- Name of the release initiator, empty if the hold is not released.
+	released_by text NOT NULL DEFAULT '',
+	PRIMARY KEY(id));
+-- Active holds are summarized for the account available balance.
+CREATE INDEX hold_account ON hold(account, status);
+
+-- Outgoing payment limits of accounts, zero value means that the limit is not
+-- set.
+CREATE TABLE account_limits (
+	account integer NOT NULL REFERENCES account(id) ON DELETE CASCADE,
+	max_payment numeric(18, 4) NOT NULL DEFAULT 0,
+	daily_amount numeric(18, 4) NOT NULL DEFAULT 0,
+	monthly_amount numeric(18, 4) NOT NULL DEFAULT 0,
+	daily_count integer NOT NULL DEFAULT 0,
+	monthly_count integer NOT NULL DEFAULT 0,
+	PRIMARY KEY(account));
+
+-- Links of account status change transactions to accounts, such transaction
+-- has no actions.
+CREATE TABLE account_status_change (
+	trans integer NOT NULL REFERENCES trans(id) ON DELETE CASCADE,
+	account integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	-- New account status: "active", "frozen" or "closed".
+	status text NOT NULL,
+	PRIMARY KEY(trans));
+-- Status changes are selected for the account history.
+CREATE INDEX account_status_change_account ON account_status_change(account);
+
+-- Standing orders, payments which are executed by the schedule.
+CREATE TABLE schedule (
+	id serial NOT NULL,
+	account integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	destination integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	amount numeric(18, 4) NOT NULL,
+	author text NOT NULL,
+	start_time timestamp NOT NULL,
+	-- "once", "daily", "weekly" or "monthly".
+	period text NOT NULL,
+	-- Number of completed runs.
+	runs integer NOT NULL,
+	-- Number of failed attempts of the next run.
+	attempts integer NOT NULL,
+	-- Time of the next payment attempt, NULL if the schedule has no runs
+	-- anymore.
+	next_run timestamp,
+	PRIMARY KEY(id));
+-- Due schedules are selected by the scheduler.
+CREATE INDEX schedule_next_run ON schedule(next_run);
+
+-- Payment attempts of schedules.
+CREATE TABLE schedule_run (
+	id serial NOT NULL,
+	schedule integer NOT NULL REFERENCES schedule(id) ON DELETE CASCADE,
+	run integer NOT NULL,
+	attempt integer NOT NULL,
+	time timestamp NOT NULL,
+	-- Executed payment, NULL if the attempt has failed.
+	trans integer REFERENCES trans(id) ON DELETE RESTRICT,
+	error text NOT NULL,
+	PRIMARY KEY(id));
+CREATE INDEX schedule_run_schedule ON schedule_run(schedule);
+
+-- Manager balance adjustments, which are executed only after the approval of
+-- another manager.
+CREATE TABLE adjustment (
+	id serial NOT NULL,
+	account integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	amount numeric(18, 4) NOT NULL,
+	proposer text NOT NULL,
+	time timestamp NOT NULL,
+	-- "pending", "approved" or "rejected".
+	status text NOT NULL,
+	-- Manager who approved or rejected the adjustment, empty if it is pending.
+	checker text NOT NULL,
+	decision_time timestamp,
+	-- Executed transaction, NULL if the adjustment is not approved.
+	trans integer REFERENCES trans(id) ON DELETE RESTRICT,
+	PRIMARY KEY(id));
+-- Pending adjustments are selected for the approval.
+CREATE INDEX adjustment_status ON adjustment(status);
@@ -0,0 +1,119 @@
+-- Upgrades the database, which was created by init.sql of an earlier version,
+-- to the actual schema of init.sql. Each statement is skipped if the change is
+-- already applied, so the script could be applied several times.
+
+-- Transaction list pagination.
+CREATE INDEX IF NOT EXISTS trans_time ON trans(time, id);
+CREATE INDEX IF NOT EXISTS action_trans ON action(trans);
+
+-- Holds.
+CREATE TABLE IF NOT EXISTS hold (
+	id serial NOT NULL,
+	account integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	destination integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	amount numeric(18, 4) NOT NULL,
+	author text NOT NULL,
+	time timestamp NOT NULL,
+	expiration timestamp NOT NULL,
+	status text NOT NULL,
+	PRIMARY KEY(id));
+CREATE INDEX IF NOT EXISTS hold_account ON hold(account, status);
+ALTER TABLE hold ADD COLUMN IF NOT EXISTS released_by text NOT NULL DEFAULT '';
+
+-- Payment reversals.
+CREATE TABLE IF NOT EXISTS reversal (
+	trans integer NOT NULL REFERENCES trans(id) ON DELETE CASCADE,
+	original integer NOT NULL REFERENCES trans(id) ON DELETE RESTRICT,
+	PRIMARY KEY(trans));
+CREATE INDEX IF NOT EXISTS reversal_original ON reversal(original);
+
+-- Payment limits.
+CREATE TABLE IF NOT EXISTS account_limits (
+	account integer NOT NULL REFERENCES account(id) ON DELETE CASCADE,
+	max_payment numeric(18, 4) NOT NULL DEFAULT 0,
+	daily_amount numeric(18, 4) NOT NULL DEFAULT 0,
+	monthly_amount numeric(18, 4) NOT NULL DEFAULT 0,
+	daily_count integer NOT NULL DEFAULT 0,
+	monthly_count integer NOT NULL DEFAULT 0,
+	PRIMARY KEY(account));
+
+-- Account statuses.
+ALTER TABLE account ADD COLUMN IF NOT EXISTS
+	status text NOT NULL DEFAULT 'active';
+CREATE TABLE IF NOT EXISTS account_status_change (
+	trans integer NOT NULL REFERENCES trans(id) ON DELETE CASCADE,
+	account integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	status text NOT NULL,
+	PRIMARY KEY(trans));
+CREATE INDEX IF NOT EXISTS account_status_change_account
+	ON account_status_change(account);
+
+-- Account overdrafts.
+ALTER TABLE account ADD COLUMN IF NOT EXISTS
+	overdraft numeric(18, 4) NOT NULL DEFAULT 0;
+
+-- Scheduled payments.
+CREATE TABLE IF NOT EXISTS schedule (
+	id serial NOT NULL,
+	account integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	destination integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	amount numeric(18, 4) NOT NULL,
+	author text NOT NULL,
+	start_time timestamp NOT NULL,
+	period text NOT NULL,
+	runs integer NOT NULL,
+	attempts integer NOT NULL,
+	next_run timestamp,
+	PRIMARY KEY(id));
+CREATE INDEX IF NOT EXISTS schedule_next_run ON schedule(next_run);
+CREATE TABLE IF NOT EXISTS schedule_run (
+	id serial NOT NULL,
+	schedule integer NOT NULL REFERENCES schedule(id) ON DELETE CASCADE,
+	run integer NOT NULL,
+	attempt integer NOT NULL,
+	time timestamp NOT NULL,
+	trans integer REFERENCES trans(id) ON DELETE RESTRICT,
+	error text NOT NULL,
+	PRIMARY KEY(id));
+CREATE INDEX IF NOT EXISTS schedule_run_schedule ON schedule_run(schedule);
+
+-- Adjustment approvals.
+CREATE TABLE IF NOT EXISTS adjustment (
+	id serial NOT NULL,
+	account integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	amount numeric(18, 4) NOT NULL,
+	proposer text NOT NULL,
+	time timestamp NOT NULL,
+	status text NOT NULL,
+	checker text NOT NULL,
+	decision_time timestamp,
+	trans integer REFERENCES trans(id) ON DELETE RESTRICT,
+	PRIMARY KEY(id));
+CREATE INDEX IF NOT EXISTS adjustment_status ON adjustment(status);
+
+-- Transaction hash chain. Hashes of existing transactions are calculated by the
+-- server at the first start after the upgrade.
+ALTER TABLE trans ADD COLUMN IF NOT EXISTS hash text NOT NULL DEFAULT '';
+CREATE TABLE IF NOT EXISTS trans_chain (
+	id integer NOT NULL,
+	trans integer REFERENCES trans(id) ON DELETE RESTRICT,
+	hash text NOT NULL,
+	PRIMARY KEY(id));
+INSERT INTO trans_chain(id, trans, hash) VALUES(1, NULL, '')
+	ON CONFLICT DO NOTHING;
+
+-- Exact decimal amounts in the range of the server amount type. Earlier
+-- versions stored amounts as double precision or as numeric(19, 4).
+ALTER TABLE account ALTER COLUMN balance TYPE numeric(18, 4);
+ALTER TABLE account ALTER COLUMN overdraft TYPE numeric(18, 4);
+ALTER TABLE action ALTER COLUMN volume TYPE numeric(18, 4);
+ALTER TABLE hold ALTER COLUMN amount TYPE numeric(18, 4);
+ALTER TABLE account_limits ALTER COLUMN max_payment TYPE numeric(18, 4);
+ALTER TABLE account_limits ALTER COLUMN daily_amount TYPE numeric(18, 4);
+ALTER TABLE account_limits ALTER COLUMN monthly_amount TYPE numeric(18, 4);
+ALTER TABLE schedule ALTER COLUMN amount TYPE numeric(18, 4);
+ALTER TABLE adjustment ALTER COLUMN amount TYPE numeric(18, 4);
+
+-- Fee marks of payment actions. Fee actions of payments stored by earlier
+-- versions are not marked, so such payments with fees could not be reversed.
+ALTER TABLE action ADD COLUMN IF NOT EXISTS fee boolean NOT NULL DEFAULT false;
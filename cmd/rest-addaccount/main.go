@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
 	"io/ioutil"
 	"log"
@@ -12,15 +14,30 @@ var (
 	host     = flag.String("host", "localhost:80", "service host and port")
 	id       = flag.String("id", "", "new account ID")
 	currency = flag.String("currency", "USD", "new account currency")
+	apiKey   = flag.String("api_key", "", "API key of the manager")
 )
 
 func main() {
 	flag.Parse()
 
-	req := url.URL{Scheme: "http", Host: *host, Path: "/account"}
-	resp, err := http.PostForm(
-		req.String(), url.Values{"id": {*id}, "currency": {*currency}})
+	reqBody, err := json.Marshal(map[string]string{
+		"id": *id, "currency": *currency})
 	if err != nil {
+		log.Panicf(`Failed to marshal request: "%s".`, err)
+	}
+
+	reqURL := url.URL{Scheme: "http", Host: *host, Path: "/v1/account"}
+	req, err := http.NewRequest(
+		"POST", reqURL.String(), bytes.NewReader(reqBody))
+	if err != nil {
+		log.Panicf(`Failed to request: "%s".`, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Api-Key", *apiKey)
+
+	client := &http.Client{}
+	var resp *http.Response
+	if resp, err = client.Do(req); err != nil {
 		log.Panicf(`Failed to request: "%s".`, err)
 	}
 	defer resp.Body.Close()
@@ -5,22 +5,40 @@ import (
 	"flag"
 	"io/ioutil"
 	"log"
-	"math"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/palchukovsky/wallet"
 )
 
 var (
-	host     = flag.String("host", "localhost:80", "service host and port")
-	id       = flag.String("id", "", "new account ID")
-	currency = flag.String("currency", "USD", "new account currency")
+	host = flag.String("host", "localhost:80", "service host and port")
+	id   = flag.String("id", "",
+		"account ID to print the account and its statement")
+	currency = flag.String("currency", "USD", "account currency")
+	from     = flag.String("from", "",
+		"statement time range beginning in RFC 3339 format, like"+
+			` "2019-05-01T00:00:00Z", the account opening by default`)
+	to = flag.String("to", "",
+		"statement time range end in RFC 3339 format, the current time by"+
+			" default")
+	apiKey = flag.String("api_key", "", "API key of the manager or the auditor")
 )
 
+// get sends GET request with the API key.
+func get(reqURL url.URL) (*http.Response, error) {
+	req, err := http.NewRequest("GET", reqURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Api-Key", *apiKey)
+	return (&http.Client{}).Do(req)
+}
+
 func printAccounts() {
-	req := url.URL{Scheme: "http", Host: *host, Path: "/account"}
-	resp, err := http.Get(req.String())
+	req := url.URL{Scheme: "http", Host: *host, Path: "/v1/account"}
+	resp, err := get(req)
 	if err != nil {
 		log.Panicf(`Failed to request: "%s".`, err)
 	}
@@ -50,15 +68,22 @@ func printAccounts() {
 	log.Println("")
 	for _, account := range list {
 		log.Printf("id: %s", account.ID.ID)
-		log.Printf("balance: %f", account.Balance)
+		log.Printf("balance: %s", account.Balance.Format(account.ID.Currency))
 		log.Printf("currency: %s", account.ID.Currency)
 		log.Println("")
 	}
 }
 
-func printPayments() {
-	req := url.URL{Scheme: "http", Host: *host, Path: "/payment"}
-	resp, err := http.Get(req.String())
+// getAccountPath returns the path of the account from arguments.
+func getAccountPath() string {
+	return "/v1/account/" + url.PathEscape(*currency) + "/" + url.PathEscape(*id)
+}
+
+// printAccount prints the account from arguments and returns true, or returns
+// false if the server has returned error.
+func printAccount() bool {
+	req := url.URL{Scheme: "http", Host: *host, Path: getAccountPath()}
+	resp, err := get(req)
 	if err != nil {
 		log.Panicf(`Failed to request: "%s".`, err)
 	}
@@ -74,31 +99,94 @@ func printPayments() {
 
 		log.Printf(`Server has returned error: "%s" (code %d).`,
 			body, resp.StatusCode)
-		return
+		return false
 	}
 
-	list := []wallet.Trans{}
+	account := wallet.Account{}
+	err = json.Unmarshal([]byte(body), &account)
+	if err != nil {
+		log.Fatalf(`Failed to parse server response: "%s".`, err)
+	}
+
+	log.Println("==========================================================================")
+	log.Println("ACCOUNT:")
+	log.Println("")
+	log.Printf("id: %s", account.ID.ID)
+	log.Printf("balance: %s", account.Balance.Format(account.ID.Currency))
+	log.Printf("currency: %s", account.ID.Currency)
+	log.Println("")
+	return true
+}
+
+// requestPayments requests one page of the payment list and returns the page
+// and the cursor of the next page, or false if the server has returned error.
+func requestPayments(cursor string) ([]wallet.Transaction, string, bool) {
+	req := url.URL{Scheme: "http", Host: *host, Path: "/v1/payment"}
+	if cursor != "" {
+		req.RawQuery = url.Values{"cursor": {cursor}}.Encode()
+	}
+	resp, err := get(req)
+	if err != nil {
+		log.Panicf(`Failed to request: "%s".`, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Panicf(`Failed to read response: "%s".`, err)
+	}
+
+	if resp.StatusCode < http.StatusOK ||
+		resp.StatusCode >= http.StatusMultipleChoices {
+
+		log.Printf(`Server has returned error: "%s" (code %d).`,
+			body, resp.StatusCode)
+		return nil, "", false
+	}
+
+	list := []wallet.Transaction{}
 	err = json.Unmarshal([]byte(body), &list)
 	if err != nil {
 		log.Fatalf(`Failed to parse server response: "%s".`, err)
 	}
+	return list, resp.Header.Get("Next-Cursor"), true
+}
+
+func printPayments() {
+	list := []wallet.Transaction{}
+	cursor := ""
+	for {
+		page, nextCursor, isOk := requestPayments(cursor)
+		if !isOk {
+			return
+		}
+		list = append(list, page...)
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
 
 	log.Println("==========================================================================")
 	log.Printf("Transactions (%d):", len(list))
 	log.Println("")
-	for i, trans := range list {
-		log.Printf("transaction %d:", i+1)
+	for _, transaction := range list {
+		log.Printf("transaction %d:", transaction.ID)
+		log.Printf("time: %s", transaction.Time.Format(time.RFC3339))
+		log.Printf("author: %s", transaction.Author)
+		trans := transaction.Actions
 		if len(trans) != 2 ||
-			(trans[0].Volume < 0) == (trans[1].Volume < 0) ||
-			(trans[0].Volume == 0 || trans[1].Volume == 0) {
+			trans[0].Volume.IsNegative() == trans[1].Volume.IsNegative() ||
+			trans[0].Volume.IsZero() || trans[1].Volume.IsZero() {
 
 			for _, action := range trans {
 				log.Printf("account: %s (%s)", action.Account.ID, action.Account.Currency)
-				log.Printf("amount: %f", math.Abs(action.Volume))
+				log.Printf("amount: %s",
+					action.Volume.Abs().Format(action.Account.Currency))
 				var direction string
-				if action.Volume == 0 {
+				if action.Volume.IsZero() {
 					direction = "none"
-				} else if action.Volume < 0 {
+				} else if action.Volume.IsNegative() {
 					direction = "outgoing"
 				} else {
 					direction = "incoming"
@@ -108,7 +196,7 @@ func printPayments() {
 		} else {
 			var outgoing wallet.BalanceAction
 			var incoming wallet.BalanceAction
-			if trans[0].Volume < 0 {
+			if trans[0].Volume.IsNegative() {
 				incoming = trans[1]
 				outgoing = trans[0]
 			} else {
@@ -117,14 +205,16 @@ func printPayments() {
 			}
 			log.Printf("account: %s (%s)",
 				outgoing.Account.ID, outgoing.Account.Currency)
-			log.Printf("amount: %f", -outgoing.Volume)
+			log.Printf("amount: %s",
+				outgoing.Volume.Neg().Format(outgoing.Account.Currency))
 			log.Printf("to_account: %s (%s)",
 				incoming.Account.ID, incoming.Account.Currency)
 			log.Println("direction: outgoing")
 			log.Println("")
 			log.Printf("account: %s (%s)",
 				incoming.Account.ID, incoming.Account.Currency)
-			log.Printf("amount: %f", incoming.Volume)
+			log.Printf("amount: %s",
+				incoming.Volume.Format(incoming.Account.Currency))
 			log.Printf("from_account: %s (%s)",
 				outgoing.Account.ID, outgoing.Account.Currency)
 			log.Println("direction: incoming")
@@ -133,8 +223,71 @@ func printPayments() {
 	}
 }
 
+func printStatement() {
+	req := url.URL{
+		Scheme:   "http",
+		Host:     *host,
+		Path:     getAccountPath() + "/statement",
+		RawQuery: url.Values{"from": {*from}, "to": {*to}}.Encode()}
+	resp, err := get(req)
+	if err != nil {
+		log.Panicf(`Failed to request: "%s".`, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Panicf(`Failed to read response: "%s".`, err)
+	}
+
+	if resp.StatusCode < http.StatusOK ||
+		resp.StatusCode >= http.StatusMultipleChoices {
+
+		log.Printf(`Server has returned error: "%s" (code %d).`,
+			body, resp.StatusCode)
+		return
+	}
+
+	statement := wallet.Statement{}
+	err = json.Unmarshal([]byte(body), &statement)
+	if err != nil {
+		log.Fatalf(`Failed to parse server response: "%s".`, err)
+	}
+
+	accountCurrency := statement.Account.Currency
+	log.Println("==========================================================================")
+	log.Printf("STATEMENT: %s (%s)", statement.Account.ID, accountCurrency)
+	if !statement.From.IsZero() {
+		log.Printf("from: %s", statement.From.Format(time.RFC3339))
+	}
+	log.Printf("to: %s", statement.To.Format(time.RFC3339))
+	log.Printf("opening balance: %s",
+		statement.OpeningBalance.Format(accountCurrency))
+	log.Println("")
+	for _, item := range statement.Items {
+		log.Printf("transaction %d:", item.TransID)
+		log.Printf("time: %s", item.Time.Format(time.RFC3339))
+		log.Printf("author: %s", item.Author)
+		log.Printf("volume: %s", item.Volume.Format(accountCurrency))
+		for _, counterparty := range item.Counterparties {
+			log.Printf("counterparty: %s (%s)",
+				counterparty.ID, counterparty.Currency)
+		}
+		log.Printf("balance: %s", item.Balance.Format(accountCurrency))
+		log.Println("------------------------------------")
+	}
+	log.Printf("closing balance: %s",
+		statement.ClosingBalance.Format(accountCurrency))
+}
+
 func main() {
 	flag.Parse()
+	if *id != "" {
+		if printAccount() {
+			printStatement()
+		}
+		return
+	}
 	printAccounts()
 	printPayments()
 }
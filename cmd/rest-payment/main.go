@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -14,22 +16,56 @@ var (
 	fromAccount = flag.String("from_account", "", "source account")
 	toAccount   = flag.String("to_account", "", "destinaction account")
 	currency    = flag.String("currency", "USD", "accounts currency")
-	amount      = flag.Float64("amount", .0, "transaction amount")
+	toCurrency  = flag.String("to_currency", "",
+		"destination account currency, if it is different from the source"+
+			" account currency, the amount is converted by the actual rate")
+	amount         = flag.String("amount", "0", "transaction amount")
+	idempotencyKey = flag.String("idempotency_key", "",
+		"unique request key to retry the request without the second execution")
+	apiKey     = flag.String("api_key", "", "API key of the client")
+	reversalOf = flag.Int("reversal_of", 0,
+		"ID of the payment to return the amount back to the source account,"+
+			" accounts and currency are not required for the reversal")
 )
 
 func main() {
 	flag.Parse()
 
-	req := url.URL{Scheme: "http", Host: *host, Path: "/payment"}
-	resp, err := http.PostForm(
-		req.String(), url.Values{
-			"from_account": {*fromAccount},
-			"to_account":   {*toAccount},
-			"currency":     {*currency},
-			"amount":       {fmt.Sprintf("%f", *amount)}})
+	payment := map[string]string{"amount": *amount}
+	reqURL := url.URL{Scheme: "http", Host: *host, Path: "/v1/payment"}
+	if *reversalOf != 0 {
+		reqURL.Path = fmt.Sprintf("/v1/payment/%d/reversal", *reversalOf)
+	} else {
+		payment["from_account"] = *fromAccount
+		payment["to_account"] = *toAccount
+		if *toCurrency == "" || *toCurrency == *currency {
+			payment["currency"] = *currency
+		} else {
+			payment["from_currency"] = *currency
+			payment["to_currency"] = *toCurrency
+		}
+	}
+	reqBody, err := json.Marshal(payment)
+	if err != nil {
+		log.Panicf(`Failed to marshal request: "%s".`, err)
+	}
+
+	req, err := http.NewRequest(
+		"POST", reqURL.String(), bytes.NewReader(reqBody))
 	if err != nil {
 		log.Panicf(`Failed to request: "%s".`, err)
 	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Api-Key", *apiKey)
+	if *idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", *idempotencyKey)
+	}
+
+	client := &http.Client{}
+	var resp *http.Response
+	if resp, err = client.Do(req); err != nil {
+		log.Panicf(`Failed to request: "%s".`, err)
+	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
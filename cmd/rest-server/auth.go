@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/palchukovsky/wallet"
+)
+
+// apiKeyHeader is the request header with the API key of the request
+// initiator.
+const apiKeyHeader = "Api-Key"
+
+// minAPIKeyLen is the minimal length of an API key to make it hard to guess.
+const minAPIKeyLen = 16
+
+// Role defines requests which are allowed for the principal.
+type Role string
+
+const (
+	// ClientRole allows to make payments.
+	ClientRole Role = "client"
+	// ManagerRole allows to create accounts, to set up account balances and to
+	// read accounts and payments.
+	ManagerRole Role = "manager"
+	// AuditorRole allows only to read accounts and payments.
+	AuditorRole Role = "auditor"
+)
+
+// Principal is an authenticated request initiator.
+type Principal struct {
+	// Name is stored as the author of transactions of the principal.
+	Name string
+	Role Role
+	// Accounts are IDs of client accounts, which could be debited by the
+	// client, in all currencies.
+	Accounts []string
+}
+
+// Owns returns true if the account could be debited by the principal.
+func (p Principal) Owns(account wallet.AccountID) bool {
+	for _, id := range p.Accounts {
+		if id == account.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator authenticates requests.
+type Authenticator interface {
+	// Authenticate returns the request initiator, or nil if the request has no
+	// valid credentials.
+	Authenticate(req *http.Request) *Principal
+}
+
+type apiKeyAuthenticator struct {
+	// principals are stored by key hash, so the lookup time doesn't depend on
+	// the key prefix.
+	principals map[[sha256.Size]byte]Principal
+}
+
+// CreateAPIKeyAuthenticator creates authenticator which accepts requests with
+// the API key in the header "Api-Key". The key list has one key on each line
+// in format "<key> <principal name> <role> [<accounts>]", where accounts are
+// comma-separated IDs of accounts of the client, empty lines and lines which
+// start with "#" are ignored.
+func CreateAPIKeyAuthenticator(keys io.Reader) (Authenticator, error) {
+	result := &apiKeyAuthenticator{
+		principals: map[[sha256.Size]byte]Principal{}}
+	scanner := bufio.NewScanner(keys)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 && len(fields) != 4 {
+			return nil, fmt.Errorf(`Failed to parse API key at line %d`, lineNumber)
+		}
+		if len(fields[0]) < minAPIKeyLen {
+			return nil, fmt.Errorf(`API key at line %d is shorter than %d symbols`,
+				lineNumber, minAPIKeyLen)
+		}
+		principal := Principal{Name: fields[1], Role: Role(fields[2])}
+		switch principal.Role {
+		case ClientRole, ManagerRole, AuditorRole:
+		default:
+			return nil, fmt.Errorf(`Unknown role "%s" at line %d`,
+				principal.Role, lineNumber)
+		}
+		if len(fields) == 4 {
+			if principal.Role != ClientRole {
+				return nil, fmt.Errorf(`Accounts are set for role "%s" at line %d`,
+					principal.Role, lineNumber)
+			}
+			principal.Accounts = strings.Split(fields[3], ",")
+			for _, id := range principal.Accounts {
+				if id == "" {
+					return nil, fmt.Errorf(`Failed to parse accounts at line %d`,
+						lineNumber)
+				}
+			}
+		}
+		hash := sha256.Sum256([]byte(fields[0]))
+		if _, has := result.principals[hash]; has {
+			return nil, fmt.Errorf(`API key at line %d is not unique`, lineNumber)
+		}
+		result.principals[hash] = principal
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(result.principals) == 0 {
+		return nil, fmt.Errorf("API key list is empty")
+	}
+	return result, nil
+}
+
+func (a *apiKeyAuthenticator) Authenticate(req *http.Request) *Principal {
+	key := req.Header.Get(apiKeyHeader)
+	if key == "" {
+		return nil
+	}
+	principal, has := a.principals[sha256.Sum256([]byte(key))]
+	if !has {
+		return nil
+	}
+	return &principal
+}
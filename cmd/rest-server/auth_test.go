@@ -0,0 +1,71 @@
+package main_test
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	w "github.com/palchukovsky/wallet"
+	rs "github.com/palchukovsky/wallet/cmd/rest-server"
+)
+
+// Test_Auth_APIKey tests API key authentication.
+func Test_Auth_APIKey(test *testing.T) {
+	auth, err := rs.CreateAPIKeyAuthenticator(strings.NewReader(`
+# Comment.
+aaaaaaaaaaaaaaaa1 alice client
+dddddddddddddddd4 dave client dave,shop
+
+bbbbbbbbbbbbbbbb2 bob manager
+  cccccccccccccccc3   carol   auditor  
+`))
+	if err != nil {
+		test.Fatalf(`Failed to create authenticator: "%s".`, err)
+	}
+
+	for key, expected := range map[string]*rs.Principal{
+		"aaaaaaaaaaaaaaaa1": {Name: "alice", Role: rs.ClientRole},
+		"dddddddddddddddd4": {
+			Name: "dave", Role: rs.ClientRole, Accounts: []string{"dave", "shop"}},
+		"bbbbbbbbbbbbbbbb2": {Name: "bob", Role: rs.ManagerRole},
+		"cccccccccccccccc3": {Name: "carol", Role: rs.AuditorRole},
+		"aaaaaaaaaaaaaaaa":  nil,
+		"alice":             nil,
+		"":                  nil} {
+
+		req, _ := http.NewRequest("GET", "/account", nil)
+		if key != "" {
+			req.Header.Set("Api-Key", key)
+		}
+		principal := auth.Authenticate(req)
+		if !reflect.DeepEqual(principal, expected) {
+			test.Errorf(`Wrong principal for key "%s": "%v".`, key, principal)
+		}
+	}
+
+	dave := rs.Principal{Accounts: []string{"dave", "shop"}}
+	if !dave.Owns(w.AccountID{ID: "shop", Currency: "EUR"}) ||
+		dave.Owns(w.AccountID{ID: "alice", Currency: "EUR"}) {
+
+		test.Errorf(`Wrong account ownership.`)
+	}
+
+	for _, source := range []string{
+		"",
+		"# Comment only.",
+		"aaaaaaaaaaaaaaaa1 alice",
+		"aaaaaaaaaaaaaaaa1 alice client alice extra",
+		"aaaaaaaaaaaaaaaa1 alice client alice,,shop",
+		"aaaaaaaaaaaaaaaa1 bob manager bob",
+		"short alice client",
+		"aaaaaaaaaaaaaaaa1 alice admin",
+		"aaaaaaaaaaaaaaaa1 alice client\naaaaaaaaaaaaaaaa1 bob manager"} {
+
+		if _, err := rs.CreateAPIKeyAuthenticator(
+			strings.NewReader(source)); err == nil {
+
+			test.Errorf(`Key list "%s" has to be rejected.`, source)
+		}
+	}
+}
@@ -3,25 +3,124 @@ package main
 import (
 	"flag"
 	"log"
+	"net/url"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/palchukovsky/wallet"
 )
 
 var (
+	dbDriver = flag.String("db_driver", "postgres",
+		`database driver: "postgres", "sqlite3" or "memory"`)
+	dbDataSourceName = flag.String("db_dsn", "",
+		`database data source name, for "postgres" it is built from other`+
+			` database flags if it is not set, for "sqlite3" it is a file path`)
 	dbHost     = flag.String("db_host", "localhost", "database host")
 	dbName     = flag.String("db_name", "wallet", "database name")
 	dbLogin    = flag.String("db_login", "wallet", "database user login name")
 	dbPassword = flag.String(
 		"db_password", "WaLlEtSeCrEtPaSsWoRd4", "database user login password")
-	port = flag.Uint("port", 80, "HTTP server port")
+	port        = flag.Uint("port", 80, "HTTP server port")
+	apiKeysFile = flag.String("api_keys_file", "",
+		`API key list file, each line has format "<key> <name> <role>", where`+
+			` role is "client", "manager" or "auditor"`)
+	fxRates = flag.String("fx_rates", "",
+		`currency exchange rates, like "USD/EUR=0.9,EUR/RUB=70.5", where each`+
+			` rate is the amount of the second currency for one first currency unit`)
+	fxAccount = flag.String("fx_account", "fx",
+		"ID of house accounts which exchange currencies, the account has to be"+
+			" created for each exchanged currency")
+	fees = flag.String("fees", "",
+		`client payment fees, like "USD=0.3+2.9%,EUR=1%", where each fee has`+
+			` the fixed part, the percentage of the payment amount, or both`)
+	feesFile = flag.String("fees_file", "",
+		"client payment fee config file in JSON format, allows to set tiers,"+
+			` minimal and maximal fees, could not be used with "-fees"`)
+	feeAccount = flag.String("fee_account", "fee",
+		"ID of house accounts which receive client payment fees, the account"+
+			" has to be created for each currency with fees")
+	scheduleInterval = flag.Duration("schedule_interval", time.Minute,
+		"interval of checks for due payment schedules")
+	scheduleRetryInterval = flag.Duration("schedule_retry_interval", time.Hour,
+		"delay before the next attempt of the failed scheduled payment")
+	scheduleAttempts = flag.Int("schedule_attempts", 3,
+		"maximal number of attempts of each scheduled payment")
+	requireApproval = flag.Bool("require_approval", true,
+		"forbid the direct account setup by a manager, each balance adjustment"+
+			" has to be approved by another manager")
 )
 
+func createAuthenticatorOrExit() Authenticator {
+	if *apiKeysFile == "" {
+		log.Fatalln(
+			`API key list file is not set, see the argument "-api_keys_file".`)
+	}
+	file, err := os.Open(*apiKeysFile)
+	if err != nil {
+		log.Fatalf(`Failed to open API key list file: "%s".`, err)
+	}
+	defer file.Close()
+	result, err := CreateAPIKeyAuthenticator(file)
+	if err != nil {
+		log.Fatalf(`Failed to load API key list: "%s".`, err)
+	}
+	return result
+}
+
+// loadFeesOrExit returns the fee policy of client payments, or nil if
+// payments are free.
+func loadFeesOrExit() wallet.FeePolicy {
+	if *fees == "" && *feesFile == "" {
+		return nil
+	}
+	if *fees != "" && *feesFile != "" {
+		log.Fatalln(`Arguments "-fees" and "-fees_file" could not be used` +
+			` together.`)
+	}
+	var schedules map[string]wallet.FeeSchedule
+	var err error
+	if *fees != "" {
+		schedules, err = wallet.ParseFees(*fees)
+	} else {
+		var file *os.File
+		file, err = os.Open(*feesFile)
+		if err != nil {
+			log.Fatalf(`Failed to open fee config file: "%s".`, err)
+		}
+		defer file.Close()
+		schedules, err = wallet.LoadFees(file)
+	}
+	if err != nil {
+		log.Fatalf(`Failed to load fees: "%s".`, err)
+	}
+	return wallet.CreateFeePolicy(schedules)
+}
+
 func main() {
 	flag.Parse()
 
-	db, err := wallet.CreateDB(*dbHost, *dbName, *dbLogin, *dbPassword)
+	dataSourceName := *dbDataSourceName
+	switch {
+	case *dbDriver == "postgres" && dataSourceName == "":
+		dataSourceName = (&url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(*dbLogin, *dbPassword),
+			Host:     *dbHost,
+			Path:     "/" + *dbName,
+			RawQuery: "sslmode=disable"}).String()
+	case *dbDriver == "sqlite3" && dataSourceName == "":
+		dataSourceName = "wallet.db"
+	case *dbDriver == "memory":
+		log.Println(`Using in-memory database, all data will be lost at exit.`)
+	}
+	auth := createAuthenticatorOrExit()
+	if *scheduleAttempts < 1 {
+		log.Fatalln(`Number of schedule attempts has to be positive.`)
+	}
+
+	db, err := wallet.CreateDB(*dbDriver, dataSourceName)
 	if err != nil {
 		log.Panicf(`Failed to connect to the database: "%s".`, err)
 	}
@@ -30,15 +129,39 @@ func main() {
 	repo := wallet.CreateRepo(db)
 	defer repo.Close()
 
+	rates, err := wallet.ParseRates(*fxRates)
+	if err != nil {
+		log.Fatalf(`Failed to parse exchange rates: "%s".`, err)
+	}
+
 	managerExec := wallet.CreateManagerExecutor()
+	rateProvider := wallet.CreateFixedRateProvider(rates)
 	clientExec := wallet.CreateClientExecutor()
+	conversionExec := wallet.CreateConversionExecutor(rateProvider, *fxAccount)
+	batchExec := wallet.CreateBatchExecutor()
+	// All client payments are charged by the same fee policy.
+	if feePolicy := loadFeesOrExit(); feePolicy != nil {
+		clientExec = wallet.CreateClientExecutorWithFees(feePolicy, *feeAccount)
+		conversionExec = wallet.CreateConversionExecutorWithFees(
+			rateProvider, *fxAccount, feePolicy, *feeAccount)
+		batchExec = wallet.CreateBatchExecutorWithFees(feePolicy, *feeAccount)
+	}
 
-	service := wallet.CreateService(repo, clientExec, managerExec)
+	service := wallet.CreateService(
+		repo, clientExec, managerExec, conversionExec, batchExec)
 	defer service.Close()
 
-	server := createServerOrExit(service, CreateProtocol(), *port)
+	clock := CreateSystemClock()
+
+	server := createListenerOrExit(
+		CreateHandler(service, CreateProtocol(), auth, clock, *requireApproval),
+		*port)
 	defer server.close()
 
+	scheduler := CreateScheduler(service, clock,
+		*scheduleInterval, *scheduleRetryInterval, *scheduleAttempts)
+	defer scheduler.Close()
+
 	interruptChan := make(chan os.Signal, 1)
 	defer close(interruptChan)
 	signal.Notify(interruptChan, os.Interrupt)
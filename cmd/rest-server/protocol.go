@@ -1,20 +1,140 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
+	"time"
 
 	"github.com/palchukovsky/wallet"
 )
 
-// Protocol encapsulates responses serialization.
+// AccountRequest is a request document to create an account.
+type AccountRequest struct {
+	ID       string `json:"id"`
+	Currency string `json:"currency"`
+}
+
+// BalanceRequest is a request document to update an account balance by the
+// manager, or to propose such update for the approval of another manager.
+type BalanceRequest struct {
+	ID       string        `json:"id"`
+	Currency string        `json:"currency"`
+	Amount   wallet.Amount `json:"amount"`
+}
+
+// PaymentRequest is a request document to make a payment. A payment between
+// accounts in the same currency has only Currency, a cross-currency payment
+// has FromCurrency and ToCurrency instead.
+type PaymentRequest struct {
+	FromAccount  string        `json:"from_account"`
+	ToAccount    string        `json:"to_account"`
+	Currency     string        `json:"currency"`
+	FromCurrency string        `json:"from_currency"`
+	ToCurrency   string        `json:"to_currency"`
+	Amount       wallet.Amount `json:"amount"`
+}
+
+// BatchPaymentRequest is a request document to make several payments in one
+// transaction. Actions have the same format as actions of the payment list,
+// negative volumes debit accounts and positive volumes credit accounts.
+type BatchPaymentRequest struct {
+	Actions wallet.Trans `json:"actions"`
+}
+
+// ReversalRequest is a request document to return funds of the payment.
+type ReversalRequest struct {
+	Amount wallet.Amount `json:"amount"`
+}
+
+// HoldRequest is a request document to reserve funds for the future payment.
+// Zero expiration time means the default hold duration.
+type HoldRequest struct {
+	Account     string        `json:"account"`
+	Destination string        `json:"destination"`
+	Currency    string        `json:"currency"`
+	Amount      wallet.Amount `json:"amount"`
+	Expiration  time.Time     `json:"expiration"`
+}
+
+// CaptureRequest is a request document to capture the hold. Zero amount means
+// the full hold amount.
+type CaptureRequest struct {
+	Amount wallet.Amount `json:"amount"`
+}
+
+// AccountStatusRequest is a request document to change the account status.
+type AccountStatusRequest struct {
+	Status wallet.AccountStatus `json:"status"`
+}
+
+// OverdraftRequest is a request document to set the account overdraft.
+type OverdraftRequest struct {
+	Overdraft wallet.Amount `json:"overdraft"`
+}
+
+// ScheduleRequest is a request document to create a payment schedule. Zero
+// start time means the current time.
+type ScheduleRequest struct {
+	Account     string                `json:"account"`
+	Destination string                `json:"destination"`
+	Currency    string                `json:"currency"`
+	Amount      wallet.Amount         `json:"amount"`
+	Start       time.Time             `json:"start"`
+	Period      wallet.SchedulePeriod `json:"period"`
+}
+
+// ScheduleUpdateRequest is a request document to change the plan of the
+// payment schedule, schedule accounts could not be changed. Zero start time
+// means the current time.
+type ScheduleUpdateRequest struct {
+	Amount wallet.Amount         `json:"amount"`
+	Start  time.Time             `json:"start"`
+	Period wallet.SchedulePeriod `json:"period"`
+}
+
+// Protocol encapsulates requests parsing and responses serialization.
 type Protocol interface {
 	// GetContentType returns content type for document specification.
 	GetContentType() string
+	// ParseRequest parses request document into the request structure, like
+	// AccountRequest.
+	ParseRequest(data []byte, request interface{}) error
+	// SerializeAccount serializes account.
+	SerializeAccount(wallet.Account) []byte
+	// SerializeTransResult serializes executed transaction result.
+	SerializeTransResult(wallet.TransResult) []byte
 	// SerializeTrans serializes transaction list.
-	SerializeTransList([]wallet.Trans) []byte
+	SerializeTransList([]wallet.Transaction) []byte
 	// SerializeAccounts serializes account list.
 	SerializeAccounts([]wallet.Account) []byte
+	// SerializeStatement serializes account statement.
+	SerializeStatement(wallet.Statement) []byte
+	// SerializeHold serializes hold.
+	SerializeHold(wallet.Hold) []byte
+	// SerializeLimits serializes account limits.
+	SerializeLimits(wallet.Limits) []byte
+	// SerializeAccountStatusChange serializes account status change.
+	SerializeAccountStatusChange(wallet.AccountStatusChange) []byte
+	// SerializeAccountStatusChanges serializes account status change list.
+	SerializeAccountStatusChanges([]wallet.AccountStatusChange) []byte
+	// SerializeSchedule serializes payment schedule.
+	SerializeSchedule(wallet.Schedule) []byte
+	// SerializeSchedules serializes payment schedule list.
+	SerializeSchedules([]wallet.Schedule) []byte
+	// SerializeScheduleRuns serializes payment schedule run list.
+	SerializeScheduleRuns([]wallet.ScheduleRun) []byte
+	// SerializeAdjustment serializes balance adjustment.
+	SerializeAdjustment(wallet.Adjustment) []byte
+	// SerializeAdjustments serializes balance adjustment list.
+	SerializeAdjustments([]wallet.Adjustment) []byte
+	// SerializeJournalReport serializes transaction journal verification
+	// report.
+	SerializeJournalReport(wallet.JournalReport) []byte
+	// SerializeError serializes error description.
+	SerializeError(code, message string) []byte
 }
 
 type protocol struct{}
@@ -26,7 +146,38 @@ func (protocol) GetContentType() string {
 	return "application/json; charset=utf-8"
 }
 
-func (p protocol) SerializeTransList(trans []wallet.Trans) []byte {
+// ParseRequest parses the document strictly, unknown fields are not allowed to
+// not ignore misspelled optional fields, data after the document is not allowed
+// to not ignore concatenated documents.
+func (protocol) ParseRequest(data []byte, request interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(request); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("Request has data after the document")
+	}
+	return nil
+}
+
+func (p protocol) SerializeAccount(account wallet.Account) []byte {
+	result, err := json.Marshal(account)
+	if err != nil {
+		log.Panicf(`Failed to marshal account: "%s".`, err)
+	}
+	return result
+}
+
+func (p protocol) SerializeTransResult(trans wallet.TransResult) []byte {
+	result, err := json.Marshal(trans)
+	if err != nil {
+		log.Panicf(`Failed to marshal transaction result: "%s".`, err)
+	}
+	return result
+}
+
+func (p protocol) SerializeTransList(trans []wallet.Transaction) []byte {
 	result, err := json.Marshal(trans)
 	if err != nil {
 		log.Panicf(`Failed to marshal transaction list: "%s".`, err)
@@ -41,3 +192,108 @@ func (p protocol) SerializeAccounts(accounts []wallet.Account) []byte {
 	}
 	return result
 }
+
+func (p protocol) SerializeStatement(statement wallet.Statement) []byte {
+	result, err := json.Marshal(statement)
+	if err != nil {
+		log.Panicf(`Failed to marshal account statement: "%s".`, err)
+	}
+	return result
+}
+
+func (p protocol) SerializeHold(hold wallet.Hold) []byte {
+	result, err := json.Marshal(hold)
+	if err != nil {
+		log.Panicf(`Failed to marshal hold: "%s".`, err)
+	}
+	return result
+}
+
+func (p protocol) SerializeLimits(limits wallet.Limits) []byte {
+	result, err := json.Marshal(limits)
+	if err != nil {
+		log.Panicf(`Failed to marshal account limits: "%s".`, err)
+	}
+	return result
+}
+
+func (p protocol) SerializeAccountStatusChange(
+	change wallet.AccountStatusChange) []byte {
+
+	result, err := json.Marshal(change)
+	if err != nil {
+		log.Panicf(`Failed to marshal account status change: "%s".`, err)
+	}
+	return result
+}
+
+func (p protocol) SerializeAccountStatusChanges(
+	changes []wallet.AccountStatusChange) []byte {
+
+	result, err := json.Marshal(changes)
+	if err != nil {
+		log.Panicf(`Failed to marshal account status change list: "%s".`, err)
+	}
+	return result
+}
+
+func (p protocol) SerializeSchedule(schedule wallet.Schedule) []byte {
+	result, err := json.Marshal(schedule)
+	if err != nil {
+		log.Panicf(`Failed to marshal schedule: "%s".`, err)
+	}
+	return result
+}
+
+func (p protocol) SerializeSchedules(schedules []wallet.Schedule) []byte {
+	result, err := json.Marshal(schedules)
+	if err != nil {
+		log.Panicf(`Failed to marshal schedule list: "%s".`, err)
+	}
+	return result
+}
+
+func (p protocol) SerializeScheduleRuns(runs []wallet.ScheduleRun) []byte {
+	result, err := json.Marshal(runs)
+	if err != nil {
+		log.Panicf(`Failed to marshal schedule run list: "%s".`, err)
+	}
+	return result
+}
+
+func (p protocol) SerializeAdjustment(adjustment wallet.Adjustment) []byte {
+	result, err := json.Marshal(adjustment)
+	if err != nil {
+		log.Panicf(`Failed to marshal adjustment: "%s".`, err)
+	}
+	return result
+}
+
+func (p protocol) SerializeAdjustments(
+	adjustments []wallet.Adjustment) []byte {
+
+	result, err := json.Marshal(adjustments)
+	if err != nil {
+		log.Panicf(`Failed to marshal adjustment list: "%s".`, err)
+	}
+	return result
+}
+
+func (p protocol) SerializeJournalReport(report wallet.JournalReport) []byte {
+	result, err := json.Marshal(report)
+	if err != nil {
+		log.Panicf(`Failed to marshal journal report: "%s".`, err)
+	}
+	return result
+}
+
+func (p protocol) SerializeError(code, message string) []byte {
+	result, err := json.Marshal(struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}{Code: code, Message: message})
+	if err != nil {
+		log.Panicf(`Failed to marshal error: "%s".`, err)
+	}
+	return result
+}
@@ -2,6 +2,7 @@ package main_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	w "github.com/palchukovsky/wallet"
@@ -20,23 +21,33 @@ func Test_Protocol(test *testing.T) {
 	}
 
 	{
-		source := []w.Trans{
+		reversalOf := 12
+		source := []w.Transaction{
 			{
-				w.BalanceAction{
-					Account: w.AccountID{ID: "accId1", Currency: "currencyCode1"},
-					Volume:  123.123},
-				w.BalanceAction{
-					Account: w.AccountID{ID: "accId2", Currency: "currencyCode2"},
-					Volume:  234.234}},
+				ID:     12,
+				Time:   time.Date(2019, 5, 1, 10, 20, 30, 0, time.UTC),
+				Author: "client",
+				Actions: w.Trans{
+					w.BalanceAction{
+						Account: w.AccountID{ID: "accId1", Currency: "currencyCode1"},
+						Volume:  w.NewAmount(123123, 3)},
+					w.BalanceAction{
+						Account: w.AccountID{ID: "accId2", Currency: "currencyCode2"},
+						Volume:  w.NewAmount(234234, 3)}}},
 			{
-				w.BalanceAction{
-					Account: w.AccountID{ID: "accId3", Currency: "currencyCode3"},
-					Volume:  567.567},
-				w.BalanceAction{
-					Account: w.AccountID{ID: "accId4", Currency: "currencyCode4"},
-					Volume:  890.89}}}
+				ID:     13,
+				Time:   time.Date(2019, 5, 1, 10, 20, 31, 500000000, time.UTC),
+				Author: "manager",
+				Actions: w.Trans{
+					w.BalanceAction{
+						Account: w.AccountID{ID: "accId3", Currency: "currencyCode3"},
+						Volume:  w.NewAmount(567567, 3)},
+					w.BalanceAction{
+						Account: w.AccountID{ID: "accId4", Currency: "currencyCode4"},
+						Volume:  w.NewAmount(89089, 2)}},
+				ReversalOf: &reversalOf}}
 		result := protocol.SerializeTransList(source)
-		template := `[[{"account":{"id":"accId1","currency":"currencyCode1"},"volume":123.123},{"account":{"id":"accId2","currency":"currencyCode2"},"volume":234.234}],[{"account":{"id":"accId3","currency":"currencyCode3"},"volume":567.567},{"account":{"id":"accId4","currency":"currencyCode4"},"volume":890.89}]]`
+		template := `[{"id":12,"time":"2019-05-01T10:20:30Z","author":"client","actions":[{"account":{"id":"accId1","currency":"currencyCode1"},"volume":"123.123"},{"account":{"id":"accId2","currency":"currencyCode2"},"volume":"234.234"}]},{"id":13,"time":"2019-05-01T10:20:31.5Z","author":"manager","actions":[{"account":{"id":"accId3","currency":"currencyCode3"},"volume":"567.567"},{"account":{"id":"accId4","currency":"currencyCode4"},"volume":"890.89"}],"reversal_of":12}]`
 		if template != string(result) {
 			test.Errorf("Wrong JSON: %s.", string(result))
 		}
@@ -45,15 +56,341 @@ func Test_Protocol(test *testing.T) {
 	{
 		source := []w.Account{
 			{
-				ID: w.AccountID{ID: "accId1", Currency: "currencyCode1"},
-				Balance: 123.123},
+				ID:      w.AccountID{ID: "accId1", Currency: "currencyCode1"},
+				Balance: w.NewAmount(123123, 3)},
 			{
-				ID: w.AccountID{ID: "accId2", Currency: "currencyCode2"},
-				Balance: 2222.3333}}
+				ID:      w.AccountID{ID: "accId2", Currency: "currencyCode2"},
+				Balance: w.NewAmount(22223333, 4)}}
 		result := protocol.SerializeAccounts(source)
-		template := `[{"id":{"id":"accId1","currency":"currencyCode1"},"balance":123.123},{"id":{"id":"accId2","currency":"currencyCode2"},"balance":2222.3333}]`
+		template := `[{"id":{"id":"accId1","currency":"currencyCode1"},"balance":"123.123","held":"0","status":"active","overdraft":"0","available":"123.123"},{"id":{"id":"accId2","currency":"currencyCode2"},"balance":"2222.3333","held":"0","status":"active","overdraft":"0","available":"2222.3333"}]`
 		if template != string(result) {
 			test.Errorf("Wrong JSON: %s.", string(result))
 		}
 	}
+	{
+		source := w.Statement{
+			Account:        w.AccountID{ID: "accId1", Currency: "USD"},
+			From:           time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC),
+			To:             time.Date(2019, 5, 2, 0, 0, 0, 0, time.UTC),
+			OpeningBalance: w.NewAmount(10, 0),
+			Items: []w.StatementItem{
+				{
+					TransID: 12,
+					Time:    time.Date(2019, 5, 1, 10, 20, 30, 0, time.UTC),
+					Author:  "client",
+					Volume:  w.NewAmount(-250, 2),
+					Counterparties: []w.AccountID{
+						{ID: "accId2", Currency: "USD"}},
+					Balance: w.NewAmount(750, 2)}},
+			ClosingBalance: w.NewAmount(750, 2)}
+		result := protocol.SerializeStatement(source)
+		template := `{"account":{"id":"accId1","currency":"USD"},"from":"2019-05-01T00:00:00Z","to":"2019-05-02T00:00:00Z","opening_balance":"10","items":[{"trans_id":12,"time":"2019-05-01T10:20:30Z","author":"client","volume":"-2.5","counterparties":[{"id":"accId2","currency":"USD"}],"balance":"7.5"}],"closing_balance":"7.5"}`
+		if template != string(result) {
+			test.Errorf("Wrong JSON: %s.", string(result))
+		}
+	}
+	{
+		result := protocol.SerializeError("account_not_found", `Account "a" (USD)`)
+		template := `{"code":"account_not_found","message":"Account \"a\" (USD)"}`
+		if template != string(result) {
+			test.Errorf("Wrong JSON: %s.", string(result))
+		}
+	}
+	{
+		result := protocol.SerializeAccount(w.Account{
+			ID:        w.AccountID{ID: "accId1", Currency: "USD"},
+			Balance:   w.NewAmount(10, 0),
+			Held:      w.NewAmount(250, 2),
+			Overdraft: w.NewAmount(50, 0)})
+		template := `{"id":{"id":"accId1","currency":"USD"},"balance":"10","held":"2.5","status":"active","overdraft":"50","available":"7.5"}`
+		if template != string(result) {
+			test.Errorf("Wrong JSON: %s.", string(result))
+		}
+	}
+	{
+		result := protocol.SerializeTransResult(w.TransResult{
+			TransID: 12,
+			Accounts: []w.Account{
+				{
+					ID:      w.AccountID{ID: "accId1", Currency: "USD"},
+					Balance: w.NewAmount(750, 2)},
+				{
+					ID:      w.AccountID{ID: "accId2", Currency: "USD"},
+					Balance: w.NewAmount(250, 2)}}})
+		template := `{"trans_id":12,"accounts":[{"id":{"id":"accId1","currency":"USD"},"balance":"7.5","held":"0","status":"active","overdraft":"0","available":"7.5"},{"id":{"id":"accId2","currency":"USD"},"balance":"2.5","held":"0","status":"active","overdraft":"0","available":"2.5"}]}`
+		if template != string(result) {
+			test.Errorf("Wrong JSON: %s.", string(result))
+		}
+	}
+	{
+		result := protocol.SerializeHold(w.Hold{
+			ID:          3,
+			Account:     w.AccountID{ID: "accId1", Currency: "USD"},
+			Destination: w.AccountID{ID: "accId2", Currency: "USD"},
+			Amount:      w.NewAmount(250, 2),
+			Author:      "client",
+			Time:        time.Date(2019, 5, 1, 10, 20, 30, 0, time.UTC),
+			Expiration:  time.Date(2019, 5, 8, 10, 20, 30, 0, time.UTC),
+			Status:      w.HoldActive})
+		template := `{"id":3,"account":{"id":"accId1","currency":"USD"},"destination":{"id":"accId2","currency":"USD"},"amount":"2.5","author":"client","time":"2019-05-01T10:20:30Z","expiration":"2019-05-08T10:20:30Z","status":"active"}`
+		if template != string(result) {
+			test.Errorf("Wrong JSON: %s.", string(result))
+		}
+	}
+	{
+		result := protocol.SerializeLimits(w.Limits{
+			MaxPayment:  w.NewAmount(1000, 0),
+			DailyAmount: w.NewAmount(25005, 1),
+			DailyCount:  10})
+		template := `{"max_payment":"1000","daily_amount":"2500.5","monthly_amount":"0","daily_count":10,"monthly_count":0}`
+		if template != string(result) {
+			test.Errorf("Wrong JSON: %s.", string(result))
+		}
+	}
+	{
+		result := protocol.SerializeAccountStatusChanges([]w.AccountStatusChange{
+			{
+				TransID: 5,
+				Time:    time.Date(2019, 5, 1, 10, 20, 30, 0, time.UTC),
+				Author:  "manager",
+				Status:  w.AccountClosed}})
+		template := `[{"trans_id":5,"time":"2019-05-01T10:20:30Z","author":"manager","status":"closed"}]`
+		if template != string(result) {
+			test.Errorf("Wrong JSON: %s.", string(result))
+		}
+	}
+	{
+		nextRun := time.Date(2019, 6, 30, 10, 0, 0, 0, time.UTC)
+		result := protocol.SerializeSchedule(w.Schedule{
+			ID:          4,
+			Account:     w.AccountID{ID: "accId1", Currency: "USD"},
+			Destination: w.AccountID{ID: "accId2", Currency: "USD"},
+			Amount:      w.NewAmount(100, 0),
+			Author:      "client",
+			Start:       time.Date(2019, 5, 31, 10, 0, 0, 0, time.UTC),
+			Period:      w.ScheduleMonthly,
+			Runs:        1,
+			NextRun:     &nextRun})
+		template := `{"id":4,"account":{"id":"accId1","currency":"USD"},"destination":{"id":"accId2","currency":"USD"},"amount":"100","author":"client","start":"2019-05-31T10:00:00Z","period":"monthly","runs":1,"attempts":0,"next_run":"2019-06-30T10:00:00Z"}`
+		if template != string(result) {
+			test.Errorf("Wrong JSON: %s.", string(result))
+		}
+	}
+	{
+		transID := 7
+		result := protocol.SerializeScheduleRuns([]w.ScheduleRun{
+			{
+				ScheduleID: 4,
+				Attempt:    1,
+				Time:       time.Date(2019, 5, 31, 10, 0, 0, 0, time.UTC),
+				Error:      "Account is frozen"},
+			{
+				ScheduleID: 4,
+				Attempt:    2,
+				Time:       time.Date(2019, 5, 31, 11, 0, 0, 0, time.UTC),
+				TransID:    &transID}})
+		template := `[{"schedule_id":4,"run":0,"attempt":1,"time":"2019-05-31T10:00:00Z","error":"Account is frozen"},{"schedule_id":4,"run":0,"attempt":2,"time":"2019-05-31T11:00:00Z","trans_id":7}]`
+		if template != string(result) {
+			test.Errorf("Wrong JSON: %s.", string(result))
+		}
+	}
+	{
+		decisionTime := time.Date(2019, 6, 1, 11, 0, 0, 0, time.UTC)
+		transID := 8
+		result := protocol.SerializeAdjustments([]w.Adjustment{
+			{
+				ID:       5,
+				Account:  w.AccountID{ID: "accId1", Currency: "USD"},
+				Amount:   w.NewAmount(-100, 0),
+				Proposer: "manager1",
+				Time:     time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC),
+				Status:   w.AdjustmentPending},
+			{
+				ID:           6,
+				Account:      w.AccountID{ID: "accId1", Currency: "USD"},
+				Amount:       w.NewAmount(50, 0),
+				Proposer:     "manager1",
+				Time:         time.Date(2019, 6, 1, 10, 30, 0, 0, time.UTC),
+				Status:       w.AdjustmentApproved,
+				Checker:      "manager2",
+				DecisionTime: &decisionTime,
+				TransID:      &transID}})
+		template := `[{"id":5,"account":{"id":"accId1","currency":"USD"},"amount":"-100","proposer":"manager1","time":"2019-06-01T10:00:00Z","status":"pending"},{"id":6,"account":{"id":"accId1","currency":"USD"},"amount":"50","proposer":"manager1","time":"2019-06-01T10:30:00Z","status":"approved","checker":"manager2","decision_time":"2019-06-01T11:00:00Z","trans_id":8}]`
+		if template != string(result) {
+			test.Errorf("Wrong JSON: %s.", string(result))
+		}
+	}
+	{
+		brokenTransID := 9
+		result := protocol.SerializeJournalReport(w.JournalReport{
+			Transactions:  9,
+			BrokenTransID: &brokenTransID,
+			Error:         "Transaction 9 does not match its hash"})
+		template := `{"valid":false,"transactions":9,"broken_trans_id":9,"error":"Transaction 9 does not match its hash"}`
+		if template != string(result) {
+			test.Errorf("Wrong JSON: %s.", string(result))
+		}
+	}
+}
+
+// Test_Protocol_Requests tests JSON request documents parsing.
+func Test_Protocol_Requests(test *testing.T) {
+	protocol := rs.CreateProtocol()
+
+	{
+		var result rs.AccountRequest
+		err := protocol.ParseRequest(
+			[]byte(`{"id":"accId1","currency":"USD"}`), &result)
+		if err != nil {
+			test.Errorf(`Failed to parse account request: "%s".`, err)
+		}
+		if result != (rs.AccountRequest{ID: "accId1", Currency: "USD"}) {
+			test.Errorf(`Wrong account request: "%v".`, result)
+		}
+	}
+	{
+		var result rs.BalanceRequest
+		err := protocol.ParseRequest(
+			[]byte(`{"id":"accId1","currency":"USD","amount":"-12.5"}`), &result)
+		if err != nil {
+			test.Errorf(`Failed to parse balance request: "%s".`, err)
+		}
+		reference := rs.BalanceRequest{
+			ID: "accId1", Currency: "USD", Amount: w.NewAmount(-125, 1)}
+		if result != reference {
+			test.Errorf(`Wrong balance request: "%v".`, result)
+		}
+	}
+	{
+		var result rs.PaymentRequest
+		err := protocol.ParseRequest([]byte(
+			`{"from_account":"a","to_account":"b","from_currency":"USD",`+
+				`"to_currency":"EUR","amount":10.25}`), &result)
+		if err != nil {
+			test.Errorf(`Failed to parse payment request: "%s".`, err)
+		}
+		reference := rs.PaymentRequest{
+			FromAccount:  "a",
+			ToAccount:    "b",
+			FromCurrency: "USD",
+			ToCurrency:   "EUR",
+			Amount:       w.NewAmount(1025, 2)}
+		if result != reference {
+			test.Errorf(`Wrong payment request: "%v".`, result)
+		}
+	}
+	{
+		var result rs.BatchPaymentRequest
+		err := protocol.ParseRequest([]byte(
+			`{"actions":[{"account":{"id":"a","currency":"USD"},"volume":"-3"},`+
+				`{"account":{"id":"b","currency":"USD"},"volume":"1"},`+
+				`{"account":{"id":"c","currency":"USD"},"volume":"2"}]}`), &result)
+		if err != nil {
+			test.Errorf(`Failed to parse batch payment request: "%s".`, err)
+		}
+		if len(result.Actions) != 3 ||
+			result.Actions[0] != (w.BalanceAction{
+				Account: w.AccountID{ID: "a", Currency: "USD"},
+				Volume:  w.NewAmount(-3, 0)}) ||
+			result.Actions[2] != (w.BalanceAction{
+				Account: w.AccountID{ID: "c", Currency: "USD"},
+				Volume:  w.NewAmount(2, 0)}) {
+
+			test.Errorf(`Wrong batch payment request: "%v".`, result)
+		}
+	}
+	{
+		var result rs.HoldRequest
+		err := protocol.ParseRequest([]byte(
+			`{"account":"a","destination":"b","currency":"USD","amount":"10.25",`+
+				`"expiration":"2019-05-08T10:20:30Z"}`), &result)
+		if err != nil {
+			test.Errorf(`Failed to parse hold request: "%s".`, err)
+		}
+		if result.Account != "a" || result.Destination != "b" ||
+			result.Currency != "USD" || result.Amount != w.NewAmount(1025, 2) ||
+			!result.Expiration.Equal(
+				time.Date(2019, 5, 8, 10, 20, 30, 0, time.UTC)) {
+
+			test.Errorf(`Wrong hold request: "%v".`, result)
+		}
+	}
+	{
+		var result rs.AccountStatusRequest
+		err := protocol.ParseRequest([]byte(`{"status":"frozen"}`), &result)
+		if err != nil {
+			test.Errorf(`Failed to parse account status request: "%s".`, err)
+		}
+		if result.Status != w.AccountFrozen {
+			test.Errorf(`Wrong account status request: "%v".`, result)
+		}
+	}
+	{
+		var result rs.ScheduleRequest
+		err := protocol.ParseRequest([]byte(
+			`{"account":"a","destination":"b","currency":"USD","amount":"100",`+
+				`"start":"2019-05-31T10:00:00Z","period":"monthly"}`), &result)
+		if err != nil {
+			test.Errorf(`Failed to parse schedule request: "%s".`, err)
+		}
+		reference := rs.ScheduleRequest{
+			Account:     "a",
+			Destination: "b",
+			Currency:    "USD",
+			Amount:      w.NewAmount(100, 0),
+			Start:       time.Date(2019, 5, 31, 10, 0, 0, 0, time.UTC),
+			Period:      w.ScheduleMonthly}
+		if result != reference {
+			test.Errorf(`Wrong schedule request: "%v".`, result)
+		}
+	}
+	{
+		var result rs.OverdraftRequest
+		err := protocol.ParseRequest([]byte(`{"overdraft":"500"}`), &result)
+		if err != nil {
+			test.Errorf(`Failed to parse overdraft request: "%s".`, err)
+		}
+		if result.Overdraft != w.NewAmount(500, 0) {
+			test.Errorf(`Wrong overdraft request: "%v".`, result)
+		}
+	}
+
+	if err := protocol.ParseRequest(
+		[]byte(`{"id":"accId1","curency":"USD"}`),
+		&rs.AccountRequest{}); err == nil {
+
+		test.Error("Unknown field is accepted.")
+	}
+	if err := protocol.ParseRequest(
+		[]byte(`{"from_account":"a","to_account":"b","currency":"USD",`+
+			`"amount":"1e3"}`),
+		&rs.PaymentRequest{}); err == nil {
+
+		test.Error("Amount in exponential notation is accepted.")
+	}
+	if err := protocol.ParseRequest(
+		[]byte(`[]`), &rs.BalanceRequest{}); err == nil {
+
+		test.Error("Wrong document is accepted.")
+	}
+	for _, data := range []string{
+		`{"amount":"10"}{"amount":"20"}`, `{"amount":"10"}}`,
+		`{"amount":"10"} x`} {
+
+		if err := protocol.ParseRequest(
+			[]byte(data), &rs.BalanceRequest{}); err == nil {
+
+			test.Errorf(`Data after the document is accepted: "%s".`, data)
+		}
+	}
+	if err := protocol.ParseRequest(
+		[]byte("{\"amount\":\"10\"}\n"), &rs.BalanceRequest{}); err != nil {
+
+		test.Errorf(`Failed to parse document with trailing space: "%s".`, err)
+	}
+	if err := protocol.ParseRequest(
+		[]byte(`{"status":"deleted"}`), &rs.AccountStatusRequest{}); err == nil {
+
+		test.Error("Unknown account status is accepted.")
+	}
 }
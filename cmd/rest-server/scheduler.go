@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/palchukovsky/wallet"
+)
+
+// Clock provides the current time and timers, so the scheduler time could be
+// controlled by tests.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the current time on
+	// the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+// CreateSystemClock creates clock with the system time.
+func CreateSystemClock() Clock { return systemClock{} }
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Scheduler executes payments of payment schedules in the background.
+type Scheduler interface {
+	// Close stops the scheduler, it waits for payments which are executed at
+	// the moment.
+	Close()
+}
+
+type scheduler struct {
+	service       wallet.Service
+	clock         Clock
+	interval      time.Duration
+	retryInterval time.Duration
+	maxAttempts   int
+	stopChan      chan struct{}
+	stopWaiter    sync.WaitGroup
+}
+
+// CreateScheduler creates and starts the scheduler, which checks due schedules
+// with the interval and executes their payments by the client policy. A failed
+// payment is retried after the retry interval until the number of attempts
+// reaches the maximum, then the run is skipped. To stop Close must be called.
+func CreateScheduler(
+	service wallet.Service,
+	clock Clock,
+	interval time.Duration,
+	retryInterval time.Duration,
+	maxAttempts int) Scheduler {
+
+	result := &scheduler{
+		service:       service,
+		clock:         clock,
+		interval:      interval,
+		retryInterval: retryInterval,
+		maxAttempts:   maxAttempts,
+		stopChan:      make(chan struct{})}
+	result.stopWaiter.Add(1)
+	go func() {
+		defer result.stopWaiter.Done()
+		for {
+			select {
+			case <-result.stopChan:
+				return
+			case <-clock.After(interval):
+				result.executeDueSchedules()
+			}
+		}
+	}()
+	return result
+}
+
+func (s *scheduler) Close() {
+	close(s.stopChan)
+	s.stopWaiter.Wait()
+}
+
+// executeDueSchedules executes one attempt of the next run for each schedule
+// which is due at the current time.
+func (s *scheduler) executeDueSchedules() {
+	now := s.clock.Now()
+	schedules, err := s.service.GetDueSchedules(now)
+	if err != nil {
+		log.Printf(`Failed to query due schedules: "%s".`, err)
+		return
+	}
+	for _, schedule := range schedules {
+		s.executeSchedule(schedule, now)
+	}
+}
+
+func (s *scheduler) executeSchedule(schedule wallet.Schedule, now time.Time) {
+	// The idempotency key is unique for each run, so the payment is not
+	// executed twice if the run result was not stored.
+	idempotencyKey := fmt.Sprintf(
+		wallet.InternalIdempotencyKeyPrefix+"schedule-%d-%d",
+		schedule.ID, schedule.GetRunTime(schedule.Runs).Unix())
+	result, err := s.service.MakePayment(
+		wallet.BalanceAction{
+			Account: schedule.Account, Volume: schedule.Amount.Neg()},
+		wallet.BalanceAction{
+			Account: schedule.Destination, Volume: schedule.Amount},
+		schedule.Author,
+		idempotencyKey)
+
+	run := wallet.ScheduleRun{
+		ScheduleID: schedule.ID, Run: schedule.Runs, Time: now}
+	var retry *time.Time
+	if err != nil {
+		log.Printf(`Failed to execute schedule %d run %d: "%s".`,
+			schedule.ID, schedule.Runs, err)
+		run.Error = err.Error()
+		if schedule.Attempts+1 < s.maxAttempts {
+			retryTime := now.Add(s.retryInterval)
+			retry = &retryTime
+		}
+	} else {
+		run.TransID = &result.TransID
+	}
+
+	if _, err := s.service.CompleteScheduleRun(run, retry); err != nil {
+		log.Printf(`Failed to store schedule %d run %d: "%s".`,
+			schedule.ID, schedule.Runs, err)
+		return
+	}
+	if run.TransID != nil {
+		log.Printf(`Schedule %d run %d is paid.`, schedule.ID, schedule.Runs)
+	}
+}
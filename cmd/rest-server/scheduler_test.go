@@ -0,0 +1,109 @@
+package main_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	w "github.com/palchukovsky/wallet"
+	rs "github.com/palchukovsky/wallet/cmd/rest-server"
+	mw "github.com/palchukovsky/wallet/mock"
+)
+
+// testClock is the clock with the fixed time, its timers are fired by the
+// test.
+type testClock struct {
+	now   time.Time
+	ticks chan time.Time
+}
+
+func (c *testClock) Now() time.Time { return c.now }
+
+func (c *testClock) After(time.Duration) <-chan time.Time { return c.ticks }
+
+// Test_Scheduler tests execution of due schedules.
+func Test_Scheduler(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	service := mw.NewMockService(ctrl)
+	clock := &testClock{
+		now:   time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC),
+		ticks: make(chan time.Time)}
+
+	src := w.AccountID{ID: "tenant", Currency: "USD"}
+	dst := w.AccountID{ID: "landlord", Currency: "USD"}
+	schedules := []w.Schedule{
+		{
+			ID:          1,
+			Account:     src,
+			Destination: dst,
+			Amount:      w.NewAmount(100, 0),
+			Author:      "alice",
+			Start:       time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC),
+			Period:      w.ScheduleMonthly,
+			Runs:        5},
+		{
+			ID:          2,
+			Account:     src,
+			Destination: dst,
+			Amount:      w.NewAmount(5, 0),
+			Author:      "alice",
+			Start:       time.Date(2019, 6, 1, 9, 0, 0, 0, time.UTC),
+			Period:      w.ScheduleOnce},
+		{
+			ID:          3,
+			Account:     src,
+			Destination: dst,
+			Amount:      w.NewAmount(10, 0),
+			Author:      "bob",
+			Start:       time.Date(2019, 5, 31, 9, 0, 0, 0, time.UTC),
+			Period:      w.ScheduleDaily,
+			Runs:        1,
+			Attempts:    2}}
+	for i := range schedules {
+		nextRun := schedules[i].GetRunTime(schedules[i].Runs)
+		schedules[i].NextRun = &nextRun
+	}
+
+	service.EXPECT().GetDueSchedules(clock.now).Return(schedules, nil)
+	expectPayment := func(schedule w.Schedule, key string) *gomock.Call {
+		return service.EXPECT().MakePayment(
+			w.BalanceAction{Account: src, Volume: schedule.Amount.Neg()},
+			w.BalanceAction{Account: dst, Volume: schedule.Amount},
+			schedule.Author,
+			key)
+	}
+	transID := 12
+	expectPayment(schedules[0], "wallet/schedule-1-1559383200").
+		Return(&w.TransResult{TransID: transID}, nil)
+	service.EXPECT().CompleteScheduleRun(
+		w.ScheduleRun{ScheduleID: 1, Run: 5, Time: clock.now, TransID: &transID},
+		gomock.Nil())
+	// The failed attempt is retried.
+	expectPayment(schedules[1], "wallet/schedule-2-1559379600").
+		Return(nil, errors.New("Payment error 1"))
+	retry := clock.now.Add(time.Hour)
+	service.EXPECT().CompleteScheduleRun(
+		w.ScheduleRun{
+			ScheduleID: 2, Time: clock.now, Error: "Payment error 1"},
+		&retry)
+	// The last attempt is not retried.
+	expectPayment(schedules[2], "wallet/schedule-3-1559379600").
+		Return(nil, errors.New("Payment error 2"))
+	service.EXPECT().CompleteScheduleRun(
+		w.ScheduleRun{
+			ScheduleID: 3, Run: 1, Time: clock.now, Error: "Payment error 2"},
+		gomock.Nil()).
+		Return(nil, errors.New("Complete error"))
+
+	scheduler := rs.CreateScheduler(service, clock, time.Minute, time.Hour, 3)
+	clock.ticks <- clock.now
+
+	service.EXPECT().GetDueSchedules(clock.now).
+		Return(nil, errors.New("Query error"))
+	clock.ticks <- clock.now
+
+	scheduler.Close()
+}
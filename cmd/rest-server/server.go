@@ -2,166 +2,1292 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
+	"mime"
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/palchukovsky/wallet"
 
 	"github.com/gorilla/mux"
 )
 
+// idempotencyKeyHeader is the request header with a client-generated unique
+// key, which allows to retry the request without the second execution.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// nextCursorHeader is the response header with the cursor of the next page of
+// the list, it is set only if the page is full.
+const nextCursorHeader = "Next-Cursor"
+
+const (
+	defaultPaymentListLimit = 100
+	maxPaymentListLimit     = 1000
+)
+
+// defaultHoldDuration is the hold duration if the request has no expiration
+// time.
+const defaultHoldDuration = 7 * 24 * time.Hour
+
+// maxRequestBodySize is the maximum size of the request document.
+const maxRequestBodySize = 1 << 20
+
+// apiVersion defines formats of requests and responses.
+type apiVersion int
+
+const (
+	// legacyAPI accepts form values.
+	legacyAPI apiVersion = iota
+	// v1API accepts JSON documents.
+	v1API
+)
+
+// Error codes of errors, which are detected by the server, other error codes
+// are codes of wallet errors.
+const (
+	badRequestErrorCode           = "bad_request"
+	unauthorizedErrorCode         = "unauthorized"
+	forbiddenErrorCode            = "forbidden"
+	unknownMethodErrorCode        = "unknown_method"
+	unsupportedMediaTypeErrorCode = "unsupported_media_type"
+	invalidIdempotencyKeyCode     = "invalid_idempotency_key"
+	internalErrorCode             = "internal_error"
+)
+
+// errorStatuses maps wallet error codes to HTTP response statuses, other wallet
+// errors are sent with the status "Unprocessable Entity".
+var errorStatuses = map[string]int{
+	wallet.ErrAccountNotFound.Code:        http.StatusNotFound,
+	wallet.ErrAccountExists.Code:          http.StatusConflict,
+	wallet.ErrIdempotencyKeyConflict.Code: http.StatusConflict,
+	wallet.ErrInvalidQuery.Code:           http.StatusBadRequest,
+	wallet.ErrTransNotFound.Code:          http.StatusNotFound,
+	wallet.ErrHoldNotFound.Code:           http.StatusNotFound,
+	wallet.ErrHoldNotActive.Code:          http.StatusConflict,
+	wallet.ErrAccountNotActive.Code:       http.StatusConflict,
+	wallet.ErrInvalidStatusChange.Code:    http.StatusConflict,
+	wallet.ErrScheduleNotFound.Code:       http.StatusNotFound,
+	wallet.ErrScheduleRunCompleted.Code:   http.StatusConflict,
+	wallet.ErrAdjustmentNotFound.Code:     http.StatusNotFound,
+	wallet.ErrAdjustmentNotPending.Code:   http.StatusConflict,
+	wallet.ErrSelfApproval.Code:           http.StatusForbidden}
+
 type server struct {
-	service    wallet.Service
-	protocol   Protocol
+	service  wallet.Service
+	protocol Protocol
+	auth     Authenticator
+	// clock provides the request time to check schedule plans.
+	clock Clock
+	// requireApproval forbids the direct account setup, so each balance
+	// modification by a manager has to be approved by another manager.
+	requireApproval bool
+}
+
+// CreateHandler creates the handler of REST-requests of all API versions.
+func CreateHandler(
+	service wallet.Service,
+	protocol Protocol,
+	auth Authenticator,
+	clock Clock,
+	requireApproval bool) http.Handler {
+
+	result := &server{
+		service:         service,
+		protocol:        protocol,
+		auth:            auth,
+		clock:           clock,
+		requireApproval: requireApproval}
+
+	router := mux.NewRouter()
+	router.StrictSlash(true)
+	router.Use(result.checkIdempotencyKey)
+	for prefix, api := range map[string]apiVersion{"": legacyAPI, "/v1": v1API} {
+		router.HandleFunc(prefix+"/account", result.handleAccountRequest(api))
+		router.HandleFunc(prefix+"/account/{currency}/{id}",
+			result.sendAccount).Methods("GET")
+		router.HandleFunc(prefix+"/account/{currency}/{id}/statement",
+			result.sendStatement).Methods("GET")
+		router.HandleFunc(prefix+"/payment", result.handlePaymentRequest(api))
+	}
+	// Batches, reversals, holds, limits, account statuses, overdrafts,
+	// schedules, adjustments and the journal verification are not supported
+	// by the legacy API.
+	router.HandleFunc("/v1/payment/batch",
+		result.processBatchPayment).Methods("POST")
+	router.HandleFunc("/v1/payment/{id:[0-9]+}/reversal",
+		result.reversePayment).Methods("POST")
+	router.HandleFunc("/v1/hold", result.createHold).Methods("POST")
+	router.HandleFunc("/v1/hold/{id:[0-9]+}", result.sendHold).Methods("GET")
+	router.HandleFunc("/v1/hold/{id:[0-9]+}/capture",
+		result.captureHold).Methods("POST")
+	router.HandleFunc("/v1/hold/{id:[0-9]+}/release",
+		result.releaseHold).Methods("POST")
+	router.HandleFunc("/v1/account/{currency}/{id}/limits",
+		result.sendLimits).Methods("GET")
+	router.HandleFunc("/v1/account/{currency}/{id}/limits",
+		result.setLimits).Methods("PUT")
+	router.HandleFunc("/v1/account/{currency}/{id}/status",
+		result.sendAccountStatusChanges).Methods("GET")
+	router.HandleFunc("/v1/account/{currency}/{id}/status",
+		result.setAccountStatus).Methods("PUT")
+	router.HandleFunc("/v1/account/{currency}/{id}/overdraft",
+		result.setOverdraft).Methods("PUT")
+	router.HandleFunc("/v1/schedule", result.createSchedule).Methods("POST")
+	router.HandleFunc("/v1/schedule", result.sendSchedules).Methods("GET")
+	router.HandleFunc("/v1/schedule/{id:[0-9]+}",
+		result.sendSchedule).Methods("GET")
+	router.HandleFunc("/v1/schedule/{id:[0-9]+}",
+		result.updateSchedule).Methods("PUT")
+	router.HandleFunc("/v1/schedule/{id:[0-9]+}",
+		result.deleteSchedule).Methods("DELETE")
+	router.HandleFunc("/v1/schedule/{id:[0-9]+}/run",
+		result.sendScheduleRuns).Methods("GET")
+	router.HandleFunc("/v1/adjustment",
+		result.proposeAdjustment).Methods("POST")
+	router.HandleFunc("/v1/adjustment", result.sendAdjustments).Methods("GET")
+	router.HandleFunc("/v1/adjustment/{id:[0-9]+}",
+		result.sendAdjustment).Methods("GET")
+	router.HandleFunc("/v1/adjustment/{id:[0-9]+}/approve",
+		result.approveAdjustment).Methods("POST")
+	router.HandleFunc("/v1/adjustment/{id:[0-9]+}/reject",
+		result.rejectAdjustment).Methods("POST")
+	router.HandleFunc("/v1/journal/verification",
+		result.verifyJournal).Methods("GET")
+
+	return router
+}
+
+// listener serves requests on the local port.
+type listener struct {
 	server     *http.Server
 	stopWaiter sync.WaitGroup
 }
 
-// createServerOrExit creates and start local server to handle REST-requests.
-// To stop close must be called.
-func createServerOrExit(
-	service wallet.Service, protocol Protocol, port uint) *server {
-
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+// createListenerOrExit creates and start local server to handle requests by
+// the handler. To stop close must be called.
+func createListenerOrExit(handler http.Handler, port uint) *listener {
+	endpoint, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		log.Panicf(`Failed to open server endpooint: "%s".`, err)
 	}
 
-	result := &server{service: service, protocol: protocol}
-
-	router := mux.NewRouter()
-	router.StrictSlash(true)
-	router.HandleFunc("/account", result.handleAccountRequest)
-	router.HandleFunc("/payment", result.handlePaymentRequest)
-
-	result.server = &http.Server{Handler: router}
+	result := &listener{server: &http.Server{Handler: handler}}
 
+	result.stopWaiter.Add(1)
 	go func() {
-		result.stopWaiter.Add(1)
 		defer result.stopWaiter.Done()
-		defer listener.Close()
-		result.server.Serve(listener)
+		defer endpoint.Close()
+		result.server.Serve(endpoint)
 	}()
 
 	return result
 }
 
 // close stops the server and frees resources.
-func (s *server) close() {
-	s.server.Close()
-	s.stopWaiter.Wait()
+func (l *listener) close() {
+	l.server.Close()
+	l.stopWaiter.Wait()
 }
 
-func (s *server) handleAccountRequest(
-	resp http.ResponseWriter, req *http.Request) {
+// writeError sends the error response with the error code and the message.
+func (s *server) writeError(
+	resp http.ResponseWriter, status int, code, message string) {
+
+	s.writeDocument(resp, status, s.protocol.SerializeError(code, message))
+}
+
+// writeDocument sends the response with the serialized document.
+func (s *server) writeDocument(
+	resp http.ResponseWriter, status int, document []byte) {
+
+	resp.Header().Set("Content-Type", s.protocol.GetContentType())
+	resp.WriteHeader(status)
+	resp.Write(document)
+}
+
+// readRequest parses the request body into the request structure, or sends
+// the error response and returns false if the request content type is not
+// supported by the protocol or if the body could not be parsed.
+func (s *server) readRequest(
+	resp http.ResponseWriter,
+	req *http.Request,
+	request interface{},
+	name string) bool {
+
+	contentType := req.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		var expected string
+		expected, _, err = mime.ParseMediaType(s.protocol.GetContentType())
+		if err == nil && mediaType != expected {
+			err = fmt.Errorf(`Content type has to be "%s"`, expected)
+		}
+	}
+	if err != nil {
+		log.Printf(`Request has unsupported content type "%s": "%s".`+
+			` Request: %v.`, contentType, err, *req)
+		s.writeError(resp, http.StatusUnsupportedMediaType,
+			unsupportedMediaTypeErrorCode,
+			fmt.Sprintf(`Unsupported content type "%s"`, contentType))
+		return false
+	}
+	body, err := ioutil.ReadAll(
+		http.MaxBytesReader(resp, req.Body, maxRequestBodySize))
+	if err == nil {
+		err = s.protocol.ParseRequest(body, request)
+	}
+	if err != nil {
+		log.Printf(`Failed to parse %s: "%s". Request: %v.`, name, err, *req)
+		s.writeError(resp, http.StatusBadRequest, badRequestErrorCode,
+			"Failed to parse "+name)
+		return false
+	}
+	return true
+}
+
+// checkIdempotencyKey rejects requests with idempotency keys from the internal
+// namespace, so a client key could not match a key of an adjustment approval
+// or of a scheduled payment.
+func (s *server) checkIdempotencyKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get(idempotencyKeyHeader)
+		if strings.HasPrefix(key, wallet.InternalIdempotencyKeyPrefix) {
+			log.Printf(`Request has reserved idempotency key "%s". Request: %v.`,
+				key, *req)
+			s.writeError(resp, http.StatusBadRequest, invalidIdempotencyKeyCode,
+				fmt.Sprintf(`Idempotency key could not start with "%s"`,
+					wallet.InternalIdempotencyKeyPrefix))
+			return
+		}
+		next.ServeHTTP(resp, req)
+	})
+}
 
-	switch req.Method {
-	case "POST":
-		s.createAccount(resp, req)
-	case "PUT":
-		s.UpdateAccount(resp, req)
-	case "GET":
-		s.sendAccountList(resp, req)
-	default:
-		log.Printf(`Requested unknown methods "%s" for account.`, req.Method)
-		resp.WriteHeader(http.StatusNotFound)
-		resp.Write([]byte("Unknown method"))
+// writeServiceError sends the error response for the service error. Wallet
+// errors are sent with their codes and messages, other errors are internal and
+// they are sent with the default message.
+func (s *server) writeServiceError(
+	resp http.ResponseWriter, err error, defaultMessage string) {
+
+	walletErr, isWalletErr := err.(*wallet.Error)
+	if !isWalletErr {
+		s.writeError(resp, http.StatusInternalServerError, internalErrorCode,
+			defaultMessage)
+		return
+	}
+	status, has := errorStatuses[walletErr.Code]
+	if !has {
+		status = http.StatusUnprocessableEntity
 	}
+	s.writeError(resp, status, walletErr.Code, walletErr.Message)
 }
 
-func (s *server) createAccount(resp http.ResponseWriter, req *http.Request) {
+// authorize returns the request initiator if it has one of the roles,
+// otherwise it sends the error response and returns nil.
+func (s *server) authorize(
+	resp http.ResponseWriter, req *http.Request, roles ...Role) *Principal {
+
+	principal := s.auth.Authenticate(req)
+	if principal == nil {
+		log.Printf(`Request is not authenticated. Request: %v.`, *req)
+		s.writeError(resp, http.StatusUnauthorized, unauthorizedErrorCode,
+			"Request is not authenticated")
+		return nil
+	}
+	for _, role := range roles {
+		if principal.Role == role {
+			return principal
+		}
+	}
+	log.Printf(`Request is forbidden for "%s" (%s). Request: %v.`,
+		principal.Name, principal.Role, *req)
+	s.writeError(resp, http.StatusForbidden, forbiddenErrorCode,
+		fmt.Sprintf(`Request is forbidden for role "%s"`, principal.Role))
+	return nil
+}
+
+// authorizeDebit returns true if the account could be debited by the client,
+// otherwise it sends the error response and returns false.
+func (s *server) authorizeDebit(
+	resp http.ResponseWriter,
+	req *http.Request,
+	principal *Principal,
+	account wallet.AccountID) bool {
+
+	if principal.Owns(account) {
+		return true
+	}
+	log.Printf(`Account "%s" (%s) is not owned by "%s". Request: %v.`,
+		account.ID, account.Currency, principal.Name, *req)
+	s.writeError(resp, http.StatusForbidden, forbiddenErrorCode,
+		fmt.Sprintf(`Account "%s" is not owned by the client`, account.ID))
+	return false
+}
+
+// handleAccountRequest returns handler for account requests of the API
+// version.
+func (s *server) handleAccountRequest(api apiVersion) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case "POST":
+			s.createAccount(resp, req, api)
+		case "PUT":
+			s.UpdateAccount(resp, req, api)
+		case "GET":
+			s.sendAccountList(resp, req)
+		default:
+			log.Printf(`Requested unknown methods "%s" for account.`, req.Method)
+			s.writeError(resp, http.StatusNotFound, unknownMethodErrorCode,
+				"Unknown method")
+		}
+	}
+}
+
+func (s *server) createAccount(
+	resp http.ResponseWriter, req *http.Request, api apiVersion) {
+
 	log.Printf(`Creating new account...`)
-	err := s.service.CreateAccount(wallet.AccountID{
-		ID: req.FormValue("id"), Currency: req.FormValue("currency")})
-	if err != nil {
+	if s.authorize(resp, req, ManagerRole) == nil {
+		return
+	}
+	var request AccountRequest
+	if api == legacyAPI {
+		request = AccountRequest{
+			ID: req.FormValue("id"), Currency: req.FormValue("currency")}
+	} else {
+		if !s.readRequest(resp, req, &request, "account request") {
+			return
+		}
+	}
+	account := wallet.AccountID{ID: request.ID, Currency: request.Currency}
+	if err := s.service.CreateAccount(account); err != nil {
 		log.Printf(`Failed to create account: "%s". Request: %v.`, err, *req)
-		resp.WriteHeader(http.StatusInternalServerError)
-		resp.Write([]byte("Failed to create account"))
+		s.writeServiceError(resp, err, "Failed to create account")
 		return
 	}
-	resp.WriteHeader(http.StatusCreated)
+	s.writeDocument(resp, http.StatusCreated,
+		s.protocol.SerializeAccount(wallet.Account{ID: account}))
 	log.Println(`New account created.`)
 }
 
 func (s *server) UpdateAccount(
-	resp http.ResponseWriter, req *http.Request) {
+	resp http.ResponseWriter, req *http.Request, api apiVersion) {
 
 	log.Println(`Updating account...`)
-	action := wallet.BalanceAction{Account: wallet.AccountID{
-		ID: req.FormValue("id"), Currency: req.FormValue("currency")}}
-	var err error
-	action.Volume, err = strconv.ParseFloat(req.FormValue("amount"), 64)
-	if err != nil {
-		log.Printf(`Failed to parse account setup amount: "%s". Request: %v.`,
-			err, req.FormValue("amount"))
-		resp.WriteHeader(http.StatusBadRequest)
-		resp.Write([]byte("Failed to parse account setup amount"))
+	principal := s.authorize(resp, req, ManagerRole)
+	if principal == nil {
+		return
+	}
+	if s.requireApproval {
+		log.Printf(`Account setup requires approval. Request: %v.`, *req)
+		s.writeError(resp, http.StatusForbidden, forbiddenErrorCode,
+			"Account setup requires approval of another manager,"+
+				" propose an adjustment instead")
 		return
 	}
-	if err := s.service.SetupAccount(action); err != nil {
+	var request BalanceRequest
+	if api == legacyAPI {
+		request = BalanceRequest{
+			ID: req.FormValue("id"), Currency: req.FormValue("currency")}
+		var err error
+		request.Amount, err = wallet.ParseAmount(req.FormValue("amount"))
+		if err != nil {
+			log.Printf(`Failed to parse account setup amount: "%s". Request: %v.`,
+				err, req.FormValue("amount"))
+			s.writeError(resp, http.StatusBadRequest, badRequestErrorCode,
+				"Failed to parse account setup amount")
+			return
+		}
+	} else {
+		if !s.readRequest(resp, req, &request, "account setup request") {
+			return
+		}
+	}
+	action := wallet.BalanceAction{
+		Account: wallet.AccountID{ID: request.ID, Currency: request.Currency},
+		Volume:  request.Amount}
+	result, err := s.service.SetupAccount(
+		action, principal.Name, req.Header.Get(idempotencyKeyHeader))
+	if err != nil {
 		log.Printf(`Failed to setup account: "%s". Request: %v.`, err, *req)
-		resp.WriteHeader(http.StatusInternalServerError)
-		resp.Write([]byte("Failed to setup account"))
+		s.writeServiceError(resp, err, "Failed to setup account")
 		return
 	}
-	resp.WriteHeader(http.StatusOK)
+	s.writeDocument(resp, http.StatusOK, s.protocol.SerializeTransResult(*result))
 	log.Println(`Account updated.`)
 }
 
 func (s *server) sendAccountList(resp http.ResponseWriter, req *http.Request) {
 	log.Println(`Account list requested...`)
-	resp.WriteHeader(http.StatusOK)
-	resp.Write(s.protocol.SerializeAccounts(s.service.GetAccounts()))
-	resp.Header().Set("Content-Type", s.protocol.GetContentType())
+	if s.authorize(resp, req, ManagerRole, AuditorRole) == nil {
+		return
+	}
+	value := req.FormValue("as_of")
+	if value == "" {
+		s.writeDocument(resp, http.StatusOK,
+			s.protocol.SerializeAccounts(s.service.GetAccounts()))
+		return
+	}
+	at, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		log.Printf(`Failed to parse balance time: "%s". Request: %v.`, err, *req)
+		s.writeError(resp, http.StatusBadRequest, badRequestErrorCode,
+			"Failed to parse balance time")
+		return
+	}
+	accounts, err := s.service.GetAccountsAt(at)
+	if err != nil {
+		log.Printf(`Failed to get account list: "%s". Request: %v.`, err, *req)
+		s.writeServiceError(resp, err, "Failed to get account list")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK, s.protocol.SerializeAccounts(accounts))
 }
 
-func (s *server) handlePaymentRequest(
-	resp http.ResponseWriter, req *http.Request) {
+func (s *server) sendAccount(resp http.ResponseWriter, req *http.Request) {
+	log.Println(`Account requested...`)
+	if s.authorize(resp, req, ManagerRole, AuditorRole) == nil {
+		return
+	}
+	vars := mux.Vars(req)
+	account, err := s.service.GetAccount(
+		wallet.AccountID{ID: vars["id"], Currency: vars["currency"]})
+	if err != nil {
+		log.Printf(`Failed to get account: "%s". Request: %v.`, err, *req)
+		s.writeServiceError(resp, err, "Failed to get account")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK, s.protocol.SerializeAccount(*account))
+}
+
+func (s *server) sendStatement(resp http.ResponseWriter, req *http.Request) {
+	log.Println(`Account statement requested...`)
+	if s.authorize(resp, req, ManagerRole, AuditorRole) == nil {
+		return
+	}
+	vars := mux.Vars(req)
+	account := wallet.AccountID{ID: vars["id"], Currency: vars["currency"]}
+	var from, to time.Time
+	var err error
+	if value := req.FormValue("from"); value != "" {
+		from, err = time.Parse(time.RFC3339Nano, value)
+	}
+	if value := req.FormValue("to"); err == nil && value != "" {
+		to, err = time.Parse(time.RFC3339Nano, value)
+	}
+	if err != nil {
+		log.Printf(`Failed to parse statement time range: "%s". Request: %v.`,
+			err, *req)
+		s.writeError(resp, http.StatusBadRequest, badRequestErrorCode,
+			"Failed to parse statement time range")
+		return
+	}
+	statement, err := s.service.GetStatement(account, from, to)
+	if err != nil {
+		log.Printf(`Failed to get account statement: "%s". Request: %v.`,
+			err, *req)
+		s.writeServiceError(resp, err, "Failed to get account statement")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK,
+		s.protocol.SerializeStatement(*statement))
+}
 
-	switch req.Method {
-	case "POST":
-		s.processPayment(resp, req)
-	case "GET":
-		s.sendPaymentList(resp, req)
-	default:
-		log.Printf(`Requested unknown methods "%s" for payment.`, req.Method)
-		resp.WriteHeader(http.StatusNotFound)
-		resp.Write([]byte("Unknown method"))
+// handlePaymentRequest returns handler for payment requests of the API
+// version.
+func (s *server) handlePaymentRequest(api apiVersion) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case "POST":
+			s.processPayment(resp, req, api)
+		case "GET":
+			s.sendPaymentList(resp, req)
+		default:
+			log.Printf(`Requested unknown methods "%s" for payment.`, req.Method)
+			s.writeError(resp, http.StatusNotFound, unknownMethodErrorCode,
+				"Unknown method")
+		}
 	}
 }
 
-func (s *server) processPayment(resp http.ResponseWriter, req *http.Request) {
+func (s *server) processPayment(
+	resp http.ResponseWriter, req *http.Request, api apiVersion) {
+
 	log.Println(`Processing payment...`)
-	currency := req.FormValue("currency")
-	amount, err := strconv.ParseFloat(req.FormValue("amount"), 64)
-	if err != nil || amount < 0 {
-		log.Printf(`Failed to parse payment amount: "%s". Request: %v.`, err, *req)
-		resp.WriteHeader(http.StatusBadRequest)
-		resp.Write([]byte("Failed to parse payment amount"))
+	principal := s.authorize(resp, req, ClientRole)
+	if principal == nil {
+		return
+	}
+	var request PaymentRequest
+	if api == legacyAPI {
+		request = PaymentRequest{
+			FromAccount:  req.FormValue("from_account"),
+			ToAccount:    req.FormValue("to_account"),
+			Currency:     req.FormValue("currency"),
+			FromCurrency: req.FormValue("from_currency"),
+			ToCurrency:   req.FormValue("to_currency")}
+		var err error
+		request.Amount, err = wallet.ParseAmount(req.FormValue("amount"))
+		if err != nil {
+			log.Printf(`Failed to parse payment amount: "%s". Request: %v.`,
+				err, *req)
+			s.writeError(resp, http.StatusBadRequest, badRequestErrorCode,
+				"Failed to parse payment amount")
+			return
+		}
+	} else {
+		if !s.readRequest(resp, req, &request, "payment request") {
+			return
+		}
+	}
+	if request.Amount.IsNegative() {
+		log.Printf(`Payment amount is negative. Request: %v.`, *req)
+		s.writeError(resp, http.StatusBadRequest, badRequestErrorCode,
+			"Payment amount is negative")
+		return
+	}
+	if request.Currency == "" && request.FromCurrency == request.ToCurrency {
+		request.Currency = request.FromCurrency
+	}
+	if request.Currency == "" {
+		s.processConversionPayment(resp, req, principal, request)
 		return
 	}
 	src := wallet.BalanceAction{Account: wallet.AccountID{
-		ID: req.FormValue("from_account"), Currency: currency},
-		Volume: -amount}
+		ID: request.FromAccount, Currency: request.Currency},
+		Volume: request.Amount.Neg()}
+	if !s.authorizeDebit(resp, req, principal, src.Account) {
+		return
+	}
 	dst := wallet.BalanceAction{Account: wallet.AccountID{
-		ID: req.FormValue("to_account"), Currency: currency},
-		Volume: amount}
-	if err := s.service.MakePayment(src, dst); err != nil {
+		ID: request.ToAccount, Currency: request.Currency},
+		Volume: request.Amount}
+	result, err := s.service.MakePayment(
+		src, dst, principal.Name, req.Header.Get(idempotencyKeyHeader))
+	if err != nil {
 		log.Printf(`Failed to make payment: "%s". Request: %v.`, err, *req)
-		resp.WriteHeader(http.StatusInternalServerError)
-		resp.Write([]byte("Failed to make payment"))
+		s.writeServiceError(resp, err, "Failed to make payment")
 		return
 	}
-	resp.WriteHeader(http.StatusOK)
+	s.writeDocument(resp, http.StatusOK, s.protocol.SerializeTransResult(*result))
 	log.Println(`Payment successfully processed.`)
 }
 
+func (s *server) processConversionPayment(
+	resp http.ResponseWriter,
+	req *http.Request,
+	principal *Principal,
+	request PaymentRequest) {
+
+	src := wallet.BalanceAction{Account: wallet.AccountID{
+		ID: request.FromAccount, Currency: request.FromCurrency},
+		Volume: request.Amount.Neg()}
+	if !s.authorizeDebit(resp, req, principal, src.Account) {
+		return
+	}
+	dst := wallet.AccountID{ID: request.ToAccount, Currency: request.ToCurrency}
+	result, err := s.service.MakeConversionPayment(
+		src, dst, principal.Name, req.Header.Get(idempotencyKeyHeader))
+	if err != nil {
+		log.Printf(`Failed to make conversion payment: "%s". Request: %v.`,
+			err, *req)
+		s.writeServiceError(resp, err, "Failed to make conversion payment")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK, s.protocol.SerializeTransResult(*result))
+	log.Println(`Conversion payment successfully processed.`)
+}
+
+func (s *server) processBatchPayment(
+	resp http.ResponseWriter, req *http.Request) {
+
+	log.Println(`Processing batch payment...`)
+	principal := s.authorize(resp, req, ClientRole)
+	if principal == nil {
+		return
+	}
+	var request BatchPaymentRequest
+	if !s.readRequest(resp, req, &request, "batch payment request") {
+		return
+	}
+	for _, action := range request.Actions {
+		if action.Volume.IsNegative() &&
+			!s.authorizeDebit(resp, req, principal, action.Account) {
+
+			return
+		}
+	}
+	result, err := s.service.MakeBatchPayment(request.Actions, principal.Name,
+		req.Header.Get(idempotencyKeyHeader))
+	if err != nil {
+		log.Printf(`Failed to make batch payment: "%s". Request: %v.`, err, *req)
+		s.writeServiceError(resp, err, "Failed to make batch payment")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK, s.protocol.SerializeTransResult(*result))
+	log.Println(`Batch payment successfully processed.`)
+}
+
+func (s *server) reversePayment(resp http.ResponseWriter, req *http.Request) {
+	log.Println(`Reversing payment...`)
+	principal := s.authorize(resp, req, ClientRole, ManagerRole)
+	if principal == nil {
+		return
+	}
+	id, ok := s.readID(resp, req, "payment")
+	if !ok {
+		return
+	}
+	if principal.Role == ClientRole {
+		// The reversal debits the payee, so the client has to own an account
+		// which is credited by the payment.
+		payment, err := s.service.GetPayment(id)
+		if err != nil {
+			log.Printf(`Failed to get payment: "%s". Request: %v.`, err, *req)
+			s.writeServiceError(resp, err, "Failed to get payment")
+			return
+		}
+		isPayee := false
+		for _, action := range payment.Actions {
+			if action.Volume.IsPositive() && principal.Owns(action.Account) {
+				isPayee = true
+			}
+		}
+		if !isPayee {
+			log.Printf(`Payment %d is not paid to "%s". Request: %v.`,
+				id, principal.Name, *req)
+			s.writeError(resp, http.StatusForbidden, forbiddenErrorCode,
+				fmt.Sprintf(`Payment %d is not paid to the client`, id))
+			return
+		}
+	}
+	var request ReversalRequest
+	if !s.readRequest(resp, req, &request, "reversal request") {
+		return
+	}
+	result, err := s.service.ReversePayment(id, request.Amount, principal.Name,
+		req.Header.Get(idempotencyKeyHeader))
+	if err != nil {
+		log.Printf(`Failed to reverse payment: "%s". Request: %v.`, err, *req)
+		s.writeServiceError(resp, err, "Failed to reverse payment")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK, s.protocol.SerializeTransResult(*result))
+	log.Println(`Payment reversed.`)
+}
+
 func (s *server) sendPaymentList(resp http.ResponseWriter, req *http.Request) {
 	log.Println(`Payment list requested...`)
-	resp.WriteHeader(http.StatusOK)
-	resp.Write(s.protocol.SerializeTransList(s.service.GetPayments()))
-	resp.Header().Set("Content-Type", s.protocol.GetContentType())
+	if s.authorize(resp, req, ManagerRole, AuditorRole) == nil {
+		return
+	}
+	query, err := parsePaymentQuery(req)
+	if err != nil {
+		log.Printf(`Failed to parse payment list query: "%s". Request: %v.`,
+			err, *req)
+		s.writeError(resp, http.StatusBadRequest, badRequestErrorCode,
+			"Failed to parse payment list query")
+		return
+	}
+	payments, err := s.service.GetPayments(query)
+	if err != nil {
+		log.Printf(`Failed to query payment list: "%s". Request: %v.`, err, *req)
+		s.writeServiceError(resp, err, "Failed to query payment list")
+		return
+	}
+	if len(payments) == query.Limit {
+		resp.Header().Set(
+			nextCursorHeader, payments[len(payments)-1].Cursor().String())
+	}
+	s.writeDocument(resp, http.StatusOK, s.protocol.SerializeTransList(payments))
+}
+
+func (s *server) createHold(resp http.ResponseWriter, req *http.Request) {
+	log.Println(`Creating hold...`)
+	principal := s.authorize(resp, req, ClientRole)
+	if principal == nil {
+		return
+	}
+	var request HoldRequest
+	if !s.readRequest(resp, req, &request, "hold request") {
+		return
+	}
+	if request.Expiration.IsZero() {
+		request.Expiration = time.Now().Add(defaultHoldDuration)
+	}
+	account := wallet.AccountID{ID: request.Account, Currency: request.Currency}
+	if !s.authorizeDebit(resp, req, principal, account) {
+		return
+	}
+	hold, err := s.service.Authorize(
+		account,
+		wallet.AccountID{ID: request.Destination, Currency: request.Currency},
+		request.Amount,
+		request.Expiration,
+		principal.Name)
+	if err != nil {
+		log.Printf(`Failed to create hold: "%s". Request: %v.`, err, *req)
+		s.writeServiceError(resp, err, "Failed to create hold")
+		return
+	}
+	s.writeDocument(resp, http.StatusCreated, s.protocol.SerializeHold(*hold))
+	log.Println(`Hold created.`)
+}
+
+// getHold returns the hold of the request path, or sends the error response
+// and returns nil if the hold could not be got or if the hold is not created
+// by the client and its account is not owned by the client.
+func (s *server) getHold(
+	resp http.ResponseWriter,
+	req *http.Request,
+	principal *Principal) *wallet.Hold {
+
+	id, ok := s.readID(resp, req, "hold")
+	if !ok {
+		return nil
+	}
+	hold, err := s.service.GetHold(id)
+	if err != nil {
+		log.Printf(`Failed to get hold: "%s". Request: %v.`, err, *req)
+		s.writeServiceError(resp, err, "Failed to get hold")
+		return nil
+	}
+	if principal.Role == ClientRole && hold.Author != principal.Name &&
+		!principal.Owns(hold.Account) {
+
+		log.Printf(`Hold %d is not available for "%s". Request: %v.`,
+			id, principal.Name, *req)
+		s.writeError(resp, http.StatusForbidden, forbiddenErrorCode,
+			fmt.Sprintf(`Hold %d is not created by the client`, id))
+		return nil
+	}
+	return hold
+}
+
+func (s *server) sendHold(resp http.ResponseWriter, req *http.Request) {
+	log.Println(`Hold requested...`)
+	principal := s.authorize(resp, req, ClientRole, ManagerRole, AuditorRole)
+	if principal == nil {
+		return
+	}
+	hold := s.getHold(resp, req, principal)
+	if hold == nil {
+		return
+	}
+	s.writeDocument(resp, http.StatusOK, s.protocol.SerializeHold(*hold))
+}
+
+func (s *server) captureHold(resp http.ResponseWriter, req *http.Request) {
+	log.Println(`Capturing hold...`)
+	principal := s.authorize(resp, req, ClientRole)
+	if principal == nil {
+		return
+	}
+	hold := s.getHold(resp, req, principal)
+	if hold == nil {
+		return
+	}
+	var request CaptureRequest
+	if !s.readRequest(resp, req, &request, "capture request") {
+		return
+	}
+	result, err := s.service.Capture(hold.ID, request.Amount, principal.Name,
+		req.Header.Get(idempotencyKeyHeader))
+	if err != nil {
+		log.Printf(`Failed to capture hold: "%s". Request: %v.`, err, *req)
+		s.writeServiceError(resp, err, "Failed to capture hold")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK, s.protocol.SerializeTransResult(*result))
+	log.Println(`Hold captured.`)
+}
+
+func (s *server) releaseHold(resp http.ResponseWriter, req *http.Request) {
+	log.Println(`Releasing hold...`)
+	principal := s.authorize(resp, req, ClientRole)
+	if principal == nil {
+		return
+	}
+	hold := s.getHold(resp, req, principal)
+	if hold == nil {
+		return
+	}
+	hold, err := s.service.Release(hold.ID, principal.Name)
+	if err != nil {
+		log.Printf(`Failed to release hold: "%s". Request: %v.`, err, *req)
+		s.writeServiceError(resp, err, "Failed to release hold")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK, s.protocol.SerializeHold(*hold))
+	log.Println(`Hold released.`)
+}
+
+func (s *server) sendLimits(resp http.ResponseWriter, req *http.Request) {
+	log.Println(`Account limits requested...`)
+	if s.authorize(resp, req, ManagerRole, AuditorRole) == nil {
+		return
+	}
+	vars := mux.Vars(req)
+	limits, err := s.service.GetLimits(
+		wallet.AccountID{ID: vars["id"], Currency: vars["currency"]})
+	if err != nil {
+		log.Printf(`Failed to get account limits: "%s". Request: %v.`, err, *req)
+		s.writeServiceError(resp, err, "Failed to get account limits")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK, s.protocol.SerializeLimits(*limits))
+}
+
+func (s *server) setLimits(resp http.ResponseWriter, req *http.Request) {
+	log.Println(`Setting account limits...`)
+	if s.authorize(resp, req, ManagerRole) == nil {
+		return
+	}
+	var request wallet.Limits
+	if !s.readRequest(resp, req, &request, "account limits") {
+		return
+	}
+	vars := mux.Vars(req)
+	err := s.service.SetLimits(
+		wallet.AccountID{ID: vars["id"], Currency: vars["currency"]}, request)
+	if err != nil {
+		log.Printf(`Failed to set account limits: "%s". Request: %v.`, err, *req)
+		s.writeServiceError(resp, err, "Failed to set account limits")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK, s.protocol.SerializeLimits(request))
+	log.Println(`Account limits set.`)
+}
+
+func (s *server) sendAccountStatusChanges(
+	resp http.ResponseWriter, req *http.Request) {
+
+	log.Println(`Account status changes requested...`)
+	if s.authorize(resp, req, ManagerRole, AuditorRole) == nil {
+		return
+	}
+	vars := mux.Vars(req)
+	changes, err := s.service.GetAccountStatusChanges(
+		wallet.AccountID{ID: vars["id"], Currency: vars["currency"]})
+	if err != nil {
+		log.Printf(`Failed to get account status changes: "%s". Request: %v.`,
+			err, *req)
+		s.writeServiceError(resp, err, "Failed to get account status changes")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK,
+		s.protocol.SerializeAccountStatusChanges(changes))
+}
+
+func (s *server) setAccountStatus(
+	resp http.ResponseWriter, req *http.Request) {
+
+	log.Println(`Changing account status...`)
+	principal := s.authorize(resp, req, ManagerRole)
+	if principal == nil {
+		return
+	}
+	var request AccountStatusRequest
+	if !s.readRequest(resp, req, &request, "account status request") {
+		return
+	}
+	vars := mux.Vars(req)
+	change, err := s.service.SetAccountStatus(
+		wallet.AccountID{ID: vars["id"], Currency: vars["currency"]},
+		request.Status,
+		principal.Name)
+	if err != nil {
+		log.Printf(`Failed to change account status: "%s". Request: %v.`,
+			err, *req)
+		s.writeServiceError(resp, err, "Failed to change account status")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK,
+		s.protocol.SerializeAccountStatusChange(*change))
+	log.Println(`Account status changed.`)
+}
+
+func (s *server) setOverdraft(resp http.ResponseWriter, req *http.Request) {
+	log.Println(`Setting account overdraft...`)
+	if s.authorize(resp, req, ManagerRole) == nil {
+		return
+	}
+	var request OverdraftRequest
+	if !s.readRequest(resp, req, &request, "overdraft request") {
+		return
+	}
+	vars := mux.Vars(req)
+	account, err := s.service.SetOverdraft(
+		wallet.AccountID{ID: vars["id"], Currency: vars["currency"]},
+		request.Overdraft)
+	if err != nil {
+		log.Printf(`Failed to set account overdraft: "%s". Request: %v.`,
+			err, *req)
+		s.writeServiceError(resp, err, "Failed to set account overdraft")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK, s.protocol.SerializeAccount(*account))
+	log.Println(`Account overdraft set.`)
+}
+
+func (s *server) createSchedule(resp http.ResponseWriter, req *http.Request) {
+	log.Println(`Creating schedule...`)
+	principal := s.authorize(resp, req, ClientRole)
+	if principal == nil {
+		return
+	}
+	var request ScheduleRequest
+	if !s.readRequest(resp, req, &request, "schedule request") {
+		return
+	}
+	account := wallet.AccountID{ID: request.Account, Currency: request.Currency}
+	if !s.authorizeDebit(resp, req, principal, account) {
+		return
+	}
+	schedule, err := s.service.CreateSchedule(
+		wallet.Schedule{
+			Account: account,
+			Destination: wallet.AccountID{
+				ID: request.Destination, Currency: request.Currency},
+			Amount: request.Amount,
+			Author: principal.Name,
+			Start:  request.Start,
+			Period: request.Period},
+		s.clock.Now())
+	if err != nil {
+		log.Printf(`Failed to create schedule: "%s". Request: %v.`, err, *req)
+		s.writeServiceError(resp, err, "Failed to create schedule")
+		return
+	}
+	s.writeDocument(resp, http.StatusCreated,
+		s.protocol.SerializeSchedule(*schedule))
+	log.Println(`Schedule created.`)
+}
+
+func (s *server) sendSchedules(resp http.ResponseWriter, req *http.Request) {
+	log.Println(`Schedule list requested...`)
+	principal := s.authorize(resp, req, ClientRole, ManagerRole, AuditorRole)
+	if principal == nil {
+		return
+	}
+	schedules, err := s.service.GetSchedules()
+	if err != nil {
+		log.Printf(`Failed to query schedule list: "%s". Request: %v.`,
+			err, *req)
+		s.writeServiceError(resp, err, "Failed to query schedule list")
+		return
+	}
+	// Clients see only their own schedules.
+	if principal.Role == ClientRole {
+		own := []wallet.Schedule{}
+		for _, schedule := range schedules {
+			if schedule.Author == principal.Name {
+				own = append(own, schedule)
+			}
+		}
+		schedules = own
+	}
+	s.writeDocument(resp, http.StatusOK,
+		s.protocol.SerializeSchedules(schedules))
+}
+
+// getSchedule returns the schedule from the request path, or writes the error
+// and returns nil if the schedule is not found or if the client is not its
+// author.
+func (s *server) getSchedule(
+	resp http.ResponseWriter,
+	req *http.Request,
+	principal *Principal) *wallet.Schedule {
+
+	id, ok := s.readID(resp, req, "schedule")
+	if !ok {
+		return nil
+	}
+	schedule, err := s.service.GetSchedule(id)
+	if err != nil {
+		log.Printf(`Failed to get schedule: "%s". Request: %v.`, err, *req)
+		s.writeServiceError(resp, err, "Failed to get schedule")
+		return nil
+	}
+	if principal.Role == ClientRole && schedule.Author != principal.Name {
+		log.Printf(`Schedule %d is not available for "%s". Request: %v.`,
+			id, principal.Name, *req)
+		s.writeError(resp, http.StatusForbidden, forbiddenErrorCode,
+			fmt.Sprintf(`Schedule %d is not created by the client`, id))
+		return nil
+	}
+	return schedule
+}
+
+func (s *server) sendSchedule(resp http.ResponseWriter, req *http.Request) {
+	log.Println(`Schedule requested...`)
+	principal := s.authorize(resp, req, ClientRole, ManagerRole, AuditorRole)
+	if principal == nil {
+		return
+	}
+	schedule := s.getSchedule(resp, req, principal)
+	if schedule == nil {
+		return
+	}
+	s.writeDocument(resp, http.StatusOK,
+		s.protocol.SerializeSchedule(*schedule))
+}
+
+func (s *server) updateSchedule(resp http.ResponseWriter, req *http.Request) {
+	log.Println(`Updating schedule...`)
+	principal := s.authorize(resp, req, ClientRole)
+	if principal == nil {
+		return
+	}
+	schedule := s.getSchedule(resp, req, principal)
+	if schedule == nil {
+		return
+	}
+	var request ScheduleUpdateRequest
+	if !s.readRequest(resp, req, &request, "schedule update request") {
+		return
+	}
+	schedule, err := s.service.UpdateSchedule(
+		wallet.Schedule{
+			ID:     schedule.ID,
+			Amount: request.Amount,
+			Start:  request.Start,
+			Period: request.Period},
+		s.clock.Now())
+	if err != nil {
+		log.Printf(`Failed to update schedule: "%s". Request: %v.`, err, *req)
+		s.writeServiceError(resp, err, "Failed to update schedule")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK,
+		s.protocol.SerializeSchedule(*schedule))
+	log.Println(`Schedule updated.`)
+}
+
+func (s *server) deleteSchedule(resp http.ResponseWriter, req *http.Request) {
+	log.Println(`Deleting schedule...`)
+	principal := s.authorize(resp, req, ClientRole, ManagerRole)
+	if principal == nil {
+		return
+	}
+	schedule := s.getSchedule(resp, req, principal)
+	if schedule == nil {
+		return
+	}
+	schedule, err := s.service.DeleteSchedule(schedule.ID)
+	if err != nil {
+		log.Printf(`Failed to delete schedule: "%s". Request: %v.`, err, *req)
+		s.writeServiceError(resp, err, "Failed to delete schedule")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK,
+		s.protocol.SerializeSchedule(*schedule))
+	log.Println(`Schedule deleted.`)
+}
+
+func (s *server) sendScheduleRuns(
+	resp http.ResponseWriter, req *http.Request) {
+
+	log.Println(`Schedule runs requested...`)
+	principal := s.authorize(resp, req, ClientRole, ManagerRole, AuditorRole)
+	if principal == nil {
+		return
+	}
+	schedule := s.getSchedule(resp, req, principal)
+	if schedule == nil {
+		return
+	}
+	runs, err := s.service.GetScheduleRuns(schedule.ID)
+	if err != nil {
+		log.Printf(`Failed to get schedule runs: "%s". Request: %v.`, err, *req)
+		s.writeServiceError(resp, err, "Failed to get schedule runs")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK, s.protocol.SerializeScheduleRuns(runs))
+}
+
+func (s *server) proposeAdjustment(
+	resp http.ResponseWriter, req *http.Request) {
+
+	log.Println(`Proposing adjustment...`)
+	principal := s.authorize(resp, req, ManagerRole)
+	if principal == nil {
+		return
+	}
+	var request BalanceRequest
+	if !s.readRequest(resp, req, &request, "adjustment request") {
+		return
+	}
+	adjustment, err := s.service.ProposeAdjustment(
+		wallet.BalanceAction{
+			Account: wallet.AccountID{ID: request.ID, Currency: request.Currency},
+			Volume:  request.Amount},
+		principal.Name)
+	if err != nil {
+		log.Printf(`Failed to propose adjustment: "%s". Request: %v.`,
+			err, *req)
+		s.writeServiceError(resp, err, "Failed to propose adjustment")
+		return
+	}
+	s.writeDocument(resp, http.StatusCreated,
+		s.protocol.SerializeAdjustment(*adjustment))
+	log.Println(`Adjustment proposed.`)
+}
+
+func (s *server) sendAdjustments(resp http.ResponseWriter, req *http.Request) {
+	log.Println(`Adjustment list requested...`)
+	if s.authorize(resp, req, ManagerRole, AuditorRole) == nil {
+		return
+	}
+	adjustments, err := s.service.GetAdjustments(
+		wallet.AdjustmentStatus(req.FormValue("status")))
+	if err != nil {
+		log.Printf(`Failed to query adjustment list: "%s". Request: %v.`,
+			err, *req)
+		s.writeServiceError(resp, err, "Failed to query adjustment list")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK,
+		s.protocol.SerializeAdjustments(adjustments))
+}
+
+func (s *server) sendAdjustment(resp http.ResponseWriter, req *http.Request) {
+	log.Println(`Adjustment requested...`)
+	if s.authorize(resp, req, ManagerRole, AuditorRole) == nil {
+		return
+	}
+	id, ok := s.readID(resp, req, "adjustment")
+	if !ok {
+		return
+	}
+	adjustment, err := s.service.GetAdjustment(id)
+	if err != nil {
+		log.Printf(`Failed to get adjustment: "%s". Request: %v.`, err, *req)
+		s.writeServiceError(resp, err, "Failed to get adjustment")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK,
+		s.protocol.SerializeAdjustment(*adjustment))
+}
+
+func (s *server) approveAdjustment(
+	resp http.ResponseWriter, req *http.Request) {
+
+	log.Println(`Approving adjustment...`)
+	principal := s.authorize(resp, req, ManagerRole)
+	if principal == nil {
+		return
+	}
+	id, ok := s.readID(resp, req, "adjustment")
+	if !ok {
+		return
+	}
+	result, err := s.service.ApproveAdjustment(id, principal.Name)
+	if err != nil {
+		log.Printf(`Failed to approve adjustment: "%s". Request: %v.`,
+			err, *req)
+		s.writeServiceError(resp, err, "Failed to approve adjustment")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK, s.protocol.SerializeTransResult(*result))
+	log.Println(`Adjustment approved.`)
+}
+
+func (s *server) rejectAdjustment(
+	resp http.ResponseWriter, req *http.Request) {
+
+	log.Println(`Rejecting adjustment...`)
+	principal := s.authorize(resp, req, ManagerRole)
+	if principal == nil {
+		return
+	}
+	id, ok := s.readID(resp, req, "adjustment")
+	if !ok {
+		return
+	}
+	adjustment, err := s.service.RejectAdjustment(id, principal.Name)
+	if err != nil {
+		log.Printf(`Failed to reject adjustment: "%s". Request: %v.`, err, *req)
+		s.writeServiceError(resp, err, "Failed to reject adjustment")
+		return
+	}
+	s.writeDocument(resp, http.StatusOK,
+		s.protocol.SerializeAdjustment(*adjustment))
+	log.Println(`Adjustment rejected.`)
+}
+
+func (s *server) verifyJournal(resp http.ResponseWriter, req *http.Request) {
+	log.Println(`Journal verification requested...`)
+	if s.authorize(resp, req, ManagerRole, AuditorRole) == nil {
+		return
+	}
+	report, err := s.service.VerifyJournal()
+	if err != nil {
+		log.Printf(`Failed to verify journal: "%s". Request: %v.`, err, *req)
+		s.writeServiceError(resp, err, "Failed to verify journal")
+		return
+	}
+	if !report.Valid {
+		log.Printf(`Journal is broken: "%s".`, report.Error)
+	}
+	s.writeDocument(resp, http.StatusOK,
+		s.protocol.SerializeJournalReport(*report))
+}
+
+// readID returns the object ID from the request path, or sends the error
+// response and returns false if the ID could not be parsed.
+func (s *server) readID(
+	resp http.ResponseWriter, req *http.Request, name string) (int, bool) {
+
+	result, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		log.Printf(`Failed to parse %s ID: "%s". Request: %v.`, name, err, *req)
+		s.writeError(resp, http.StatusBadRequest, badRequestErrorCode,
+			fmt.Sprintf("Failed to parse %s ID", name))
+		return 0, false
+	}
+	return result, true
+}
+
+// parsePaymentQuery parses payment list filter and page from request
+// arguments.
+func parsePaymentQuery(req *http.Request) (wallet.TransQuery, error) {
+	result := wallet.TransQuery{
+		Account:  req.FormValue("account"),
+		Currency: req.FormValue("currency"),
+		Author:   req.FormValue("author"),
+		Limit:    defaultPaymentListLimit}
+	var err error
+	if value := req.FormValue("from"); value != "" {
+		if result.From, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return result, err
+		}
+	}
+	if value := req.FormValue("to"); value != "" {
+		if result.To, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return result, err
+		}
+	}
+	if value := req.FormValue("cursor"); value != "" {
+		cursor, err := wallet.ParseTransCursor(value)
+		if err != nil {
+			return result, err
+		}
+		result.After = &cursor
+	}
+	if value := req.FormValue("limit"); value != "" {
+		if result.Limit, err = strconv.Atoi(value); err != nil {
+			return result, err
+		}
+		if result.Limit <= 0 || result.Limit > maxPaymentListLimit {
+			return result, fmt.Errorf("Limit has to be from 1 to %d",
+				maxPaymentListLimit)
+		}
+	}
+	return result, nil
 }
@@ -0,0 +1,196 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	w "github.com/palchukovsky/wallet"
+	rs "github.com/palchukovsky/wallet/cmd/rest-server"
+	mw "github.com/palchukovsky/wallet/mock"
+)
+
+// testKeys are API keys of test principals.
+const testKeys = `
+aaaaaaaaaaaaaaaa1 alice client alice
+bbbbbbbbbbbbbbbb2 bob client bob
+cccccccccccccccc3 carol manager
+`
+
+// createTestHandler creates the handler with test API keys.
+func createTestHandler(test *testing.T, service w.Service) http.Handler {
+	auth, err := rs.CreateAPIKeyAuthenticator(strings.NewReader(testKeys))
+	if err != nil {
+		test.Fatalf(`Failed to create authenticator: "%s".`, err)
+	}
+	return rs.CreateHandler(
+		service, rs.CreateProtocol(), auth, rs.CreateSystemClock(), true)
+}
+
+// serve sends the request with the JSON document by the principal with the API
+// key and returns the response status.
+func serve(handler http.Handler, method, path, key, document string) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(document))
+	req.Header.Set("Api-Key", key)
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	return resp.Code
+}
+
+// Test_Server_Reversal tests that the payment could be reversed only by the
+// payee or by a manager.
+func Test_Server_Reversal(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	service := mw.NewMockService(ctrl)
+	handler := createTestHandler(test, service)
+
+	payment := &w.Transaction{
+		ID: 7,
+		Actions: w.Trans{
+			w.BalanceAction{
+				Account: w.AccountID{ID: "alice", Currency: "USD"},
+				Volume:  w.NewAmount(-10, 0)},
+			w.BalanceAction{
+				Account: w.AccountID{ID: "bob", Currency: "USD"},
+				Volume:  w.NewAmount(10, 0)}}}
+	service.EXPECT().GetPayment(7).Return(payment, nil).Times(2)
+	service.EXPECT().
+		ReversePayment(7, w.NewAmount(4, 0), gomock.Any(), "").
+		Return(&w.TransResult{TransID: 8}, nil).
+		Times(2)
+
+	for _, check := range []struct {
+		key    string
+		status int
+	}{
+		{key: "aaaaaaaaaaaaaaaa1", status: http.StatusForbidden},
+		{key: "bbbbbbbbbbbbbbbb2", status: http.StatusOK},
+		{key: "cccccccccccccccc3", status: http.StatusOK}} {
+
+		status := serve(handler, "POST", "/v1/payment/7/reversal", check.key,
+			`{"amount": "4"}`)
+		if status != check.status {
+			test.Errorf(`Wrong reversal status for key "%s": %d.`,
+				check.key, status)
+		}
+	}
+}
+
+// Test_Server_BatchPayment tests that the batch payment could debit only
+// accounts of the client.
+func Test_Server_BatchPayment(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	service := mw.NewMockService(ctrl)
+	handler := createTestHandler(test, service)
+
+	service.EXPECT().
+		MakeBatchPayment(gomock.Any(), "alice", "").
+		Return(&w.TransResult{TransID: 1}, nil)
+
+	for _, check := range []struct {
+		document string
+		status   int
+	}{
+		{
+			document: `{"actions": [
+				{"account": {"id": "alice", "currency": "USD"}, "volume": "-10"},
+				{"account": {"id": "bob", "currency": "USD"}, "volume": "10"}]}`,
+			status: http.StatusOK},
+		{
+			document: `{"actions": [
+				{"account": {"id": "alice", "currency": "USD"}, "volume": "-10"},
+				{"account": {"id": "bob", "currency": "USD"}, "volume": "-10"},
+				{"account": {"id": "carol", "currency": "USD"}, "volume": "20"}]}`,
+			status: http.StatusForbidden}} {
+
+		status := serve(handler, "POST", "/v1/payment/batch",
+			"aaaaaaaaaaaaaaaa1", check.document)
+		if status != check.status {
+			test.Errorf(`Wrong batch status for "%s": %d.`, check.document, status)
+		}
+	}
+}
+
+// Test_Server_Schedule tests that the client could create schedules only for
+// its accounts and could access only its schedules.
+func Test_Server_Schedule(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	service := mw.NewMockService(ctrl)
+	handler := createTestHandler(test, service)
+
+	service.EXPECT().GetSchedule(3).
+		Return(&w.Schedule{ID: 3, Author: "alice"}, nil).
+		Times(3)
+	service.EXPECT().DeleteSchedule(3).Return(&w.Schedule{ID: 3}, nil)
+
+	for _, check := range []struct {
+		method   string
+		path     string
+		key      string
+		document string
+		status   int
+	}{
+		{
+			method: "POST",
+			path:   "/v1/schedule",
+			key:    "aaaaaaaaaaaaaaaa1",
+			document: `{"account": "bob", "destination": "alice",
+				"currency": "USD", "amount": "10", "period": "daily"}`,
+			status: http.StatusForbidden},
+		{
+			method: "GET",
+			path:   "/v1/schedule/3",
+			key:    "bbbbbbbbbbbbbbbb2",
+			status: http.StatusForbidden},
+		{
+			method: "GET",
+			path:   "/v1/schedule/3",
+			key:    "aaaaaaaaaaaaaaaa1",
+			status: http.StatusOK},
+		{
+			method: "DELETE",
+			path:   "/v1/schedule/3",
+			key:    "cccccccccccccccc3",
+			status: http.StatusOK}} {
+
+		status := serve(handler, check.method, check.path, check.key,
+			check.document)
+		if status != check.status {
+			test.Errorf(`Wrong status for "%s %s" by key "%s": %d.`,
+				check.method, check.path, check.key, status)
+		}
+	}
+}
+
+// Test_Server_InternalIdempotencyKey tests that the client could not use an
+// idempotency key from the internal namespace.
+func Test_Server_InternalIdempotencyKey(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	handler := createTestHandler(test, mw.NewMockService(ctrl))
+
+	req := httptest.NewRequest("POST", "/v1/payment", strings.NewReader(`{
+		"src": {"id": "alice", "currency": "USD"},
+		"dst": {"id": "bob", "currency": "USD"},
+		"amount": "10"}`))
+	req.Header.Set("Api-Key", "aaaaaaaaaaaaaaaa1")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", w.InternalIdempotencyKeyPrefix+"adjustment-1")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusBadRequest ||
+		!strings.Contains(resp.Body.String(), "invalid_idempotency_key") {
+
+		test.Errorf(`Wrong response: %d "%s".`, resp.Code, resp.Body.String())
+	}
+}
@@ -1,37 +1,47 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
-	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
-	"strings"
 )
 
 var (
-	host     = flag.String("host", "localhost:80", "service host and port")
-	id       = flag.String("id", "", "account ID")
-	currency = flag.String("currency", "USD", "account currency")
-	amount   = flag.Float64("amount", .0, "transaction amount")
+	host           = flag.String("host", "localhost:80", "service host and port")
+	id             = flag.String("id", "", "account ID")
+	currency       = flag.String("currency", "USD", "account currency")
+	amount         = flag.String("amount", "0", "transaction amount")
+	idempotencyKey = flag.String("idempotency_key", "",
+		"unique request key to retry the request without the second execution")
+	apiKey = flag.String("api_key", "", "API key of the manager")
 )
 
 func main() {
 	flag.Parse()
 
-	reqBody := url.Values{
-		"id":       {*id},
-		"currency": {*currency},
-		"amount":   {fmt.Sprintf("%f", *amount)}}
+	reqBody, err := json.Marshal(map[string]string{
+		"id":       *id,
+		"currency": *currency,
+		"amount":   *amount})
+	if err != nil {
+		log.Panicf(`Failed to marshal request: "%s".`, err)
+	}
 
-	reqURL := url.URL{Scheme: "http", Host: *host, Path: "/account"}
+	reqURL := url.URL{Scheme: "http", Host: *host, Path: "/v1/account"}
 	req, err := http.NewRequest(
-		"PUT", reqURL.String(), strings.NewReader(reqBody.Encode()))
+		"PUT", reqURL.String(), bytes.NewReader(reqBody))
 	if err != nil {
 		log.Panicf(`Failed to request: "%s".`, err)
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Api-Key", *apiKey)
+	if *idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", *idempotencyKey)
+	}
 
 	client := &http.Client{}
 	var resp *http.Response
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/url"
+	"os"
+
+	"github.com/palchukovsky/wallet"
+)
+
+var (
+	dbDriver = flag.String("db_driver", "postgres",
+		`database driver: "postgres" or "sqlite3"`)
+	dbDataSourceName = flag.String("db_dsn", "",
+		`database data source name, for "postgres" it is built from other`+
+			` database flags if it is not set, for "sqlite3" it is a file path`)
+	dbHost     = flag.String("db_host", "localhost", "database host")
+	dbName     = flag.String("db_name", "wallet", "database name")
+	dbLogin    = flag.String("db_login", "wallet", "database user login name")
+	dbPassword = flag.String(
+		"db_password", "WaLlEtSeCrEtPaSsWoRd4", "database user login password")
+	output = flag.String("output", "",
+		"report file path, the report is written to the standard output if it"+
+			" is not set")
+)
+
+// audit writes the ledger integrity report and returns true if the ledger is
+// valid.
+func audit() bool {
+	dataSourceName := *dbDataSourceName
+	switch {
+	case *dbDriver == "postgres" && dataSourceName == "":
+		dataSourceName = (&url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(*dbLogin, *dbPassword),
+			Host:     *dbHost,
+			Path:     "/" + *dbName,
+			RawQuery: "sslmode=disable"}).String()
+	case *dbDriver == "sqlite3" && dataSourceName == "":
+		dataSourceName = "wallet.db"
+	case *dbDriver == "memory":
+		log.Fatalln(`In-memory database could not be audited.`)
+	}
+
+	db, err := wallet.CreateDB(*dbDriver, dataSourceName)
+	if err != nil {
+		log.Panicf(`Failed to connect to the database: "%s".`, err)
+	}
+	defer db.Close()
+
+	repo := wallet.CreateRepo(db)
+	defer repo.Close()
+
+	// The audit doesn't execute transactions, so executors are not set.
+	service := wallet.CreateService(repo, nil, nil, nil, nil)
+	defer service.Close()
+
+	report, err := service.VerifyIntegrity()
+	if err != nil {
+		log.Panicf(`Failed to verify integrity: "%s".`, err)
+	}
+
+	var writer io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Panicf(`Failed to create report file: "%s".`, err)
+		}
+		defer file.Close()
+		writer = file
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Panicf(`Failed to write report: "%s".`, err)
+	}
+
+	if !report.Valid {
+		log.Printf(`Ledger has %d balance discrepancies and %d transaction`+
+			` imbalances.`,
+			len(report.BalanceDiscrepancies), len(report.TransImbalances))
+	}
+	return report.Valid
+}
+
+func main() {
+	flag.Parse()
+	if !audit() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,1592 @@
+package wallet_test
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	w "github.com/palchukovsky/wallet"
+)
+
+// Test_DB_Service tests the service with all production components and each
+// embedded database.
+func Test_DB_Service(test *testing.T) {
+	for _, driver := range []string{"memory", "sqlite3"} {
+		db, err := w.CreateDB(driver, ":memory:")
+		if err != nil {
+			test.Fatalf(`Failed to create "%s" database: "%s".`, driver, err)
+		}
+		testService(test, db)
+		db.Close()
+	}
+}
+
+// Test_DB_SQLiteMigration tests the upgrade of SQLite database which was
+// created by an earlier version.
+func Test_DB_SQLiteMigration(test *testing.T) {
+	dir, err := ioutil.TempDir("", "wallet")
+	if err != nil {
+		test.Fatalf(`Failed to create directory: "%s".`, err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wallet.db")
+
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		test.Fatalf(`Failed to open database: "%s".`, err)
+	}
+	_, err = conn.Exec(`
+CREATE TABLE account (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	name text,
+	currency text,
+	balance text NOT NULL DEFAULT '0',
+	CONSTRAINT account_unique UNIQUE(currency, name));
+CREATE TABLE trans (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	time timestamp NOT NULL,
+	author text NOT NULL,
+	idempotency_key text,
+	CONSTRAINT trans_idempotency_key_unique UNIQUE(idempotency_key));
+CREATE TABLE action (
+	account integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	trans integer NOT NULL REFERENCES trans(id) ON DELETE CASCADE,
+	volume text NOT NULL,
+	PRIMARY KEY(account, trans));
+INSERT INTO account(name, currency, balance) VALUES('legacy', 'USD', '12.5');
+INSERT INTO trans(time, author) VALUES('2019-05-01 10:00:00', 'bob');
+INSERT INTO action(account, trans, volume) VALUES(1, 1, '12.5');
+INSERT INTO trans(time, author) VALUES('2019-05-02 10:00:00', 'bob');`)
+	conn.Close()
+	if err != nil {
+		test.Fatalf(`Failed to create legacy schema: "%s".`, err)
+	}
+
+	// The second opening finds the database upgraded.
+	for i := 0; i < 2; i++ {
+		db, err := w.CreateDB("sqlite3", path)
+		if err != nil {
+			test.Fatalf(`Failed to open legacy database: "%s".`, err)
+		}
+		accounts, err := db.GetAccounts()
+		if err != nil || len(accounts) != 1 ||
+			accounts[0] != (w.Account{
+				ID:      w.AccountID{ID: "legacy", Currency: "USD"},
+				Balance: w.NewAmount(125, 1)}) {
+
+			test.Errorf(`Wrong legacy accounts: "%v", "%v".`, accounts, err)
+		}
+		report, err := w.CreateRepo(db).VerifyJournal()
+		if err != nil || !report.Valid || report.Transactions != 2 {
+			test.Errorf(`Wrong legacy journal report: "%v", "%v".`, report, err)
+		}
+		db.Close()
+	}
+}
+
+func testService(test *testing.T, db w.DB) {
+	repo := w.CreateRepo(db)
+	defer repo.Close()
+	rates, err := w.ParseRates("USD/EUR=0.9")
+	if err != nil {
+		test.Fatalf(`Failed to parse rates: "%s".`, err)
+	}
+	service := w.CreateService(
+		repo,
+		w.CreateClientExecutor(),
+		w.CreateManagerExecutor(),
+		w.CreateConversionExecutor(w.CreateFixedRateProvider(rates), "fx"),
+		w.CreateBatchExecutor())
+	defer service.Close()
+
+	src := w.AccountID{ID: "src", Currency: "USD"}
+	dst := w.AccountID{ID: "dst", Currency: "USD"}
+	for _, id := range []w.AccountID{src, dst} {
+		if err := service.CreateAccount(id); err != nil {
+			test.Fatalf(`Failed to create account: "%s".`, err)
+		}
+	}
+	if err := service.CreateAccount(src); !w.IsError(err, w.ErrAccountExists) {
+		test.Errorf(`Account is created twice: "%v".`, err)
+	}
+
+	setupResult, err := service.SetupAccount(
+		w.BalanceAction{Account: src, Volume: w.NewAmount(10010, 2)}, "bob", "")
+	if err != nil {
+		test.Fatalf(`Failed to setup account: "%s".`, err)
+	}
+	if len(setupResult.Accounts) != 1 || setupResult.Accounts[0] !=
+		(w.Account{ID: src, Balance: w.NewAmount(10010, 2)}) {
+
+		test.Errorf(`Wrong setup result: "%v".`, *setupResult)
+	}
+	// The second call with the same idempotency key is a retry.
+	var paymentResults [2]*w.TransResult
+	for i := range paymentResults {
+		paymentResults[i], err = service.MakePayment(
+			w.BalanceAction{Account: src, Volume: w.NewAmount(-3333, 2)},
+			w.BalanceAction{Account: dst, Volume: w.NewAmount(3333, 2)},
+			"alice", "payment-1")
+		if err != nil {
+			test.Fatalf(`Failed to make payment: "%s".`, err)
+		}
+	}
+	if paymentResults[0].TransID == setupResult.TransID ||
+		len(paymentResults[0].Accounts) != 2 ||
+		paymentResults[0].Accounts[0] !=
+			(w.Account{ID: src, Balance: w.NewAmount(6677, 2)}) ||
+		paymentResults[0].Accounts[1] !=
+			(w.Account{ID: dst, Balance: w.NewAmount(3333, 2)}) {
+
+		test.Errorf(`Wrong payment result: "%v".`, *paymentResults[0])
+	}
+	// The retry returns the result of the first request.
+	if paymentResults[1].TransID != paymentResults[0].TransID ||
+		len(paymentResults[1].Accounts) != 2 ||
+		paymentResults[1].Accounts[0] != paymentResults[0].Accounts[0] ||
+		paymentResults[1].Accounts[1] != paymentResults[0].Accounts[1] {
+
+		test.Errorf(`Wrong repeated payment result: "%v".`, *paymentResults[1])
+	}
+	_, err = service.MakePayment(
+		w.BalanceAction{Account: src, Volume: w.NewAmount(-1, 0)},
+		w.BalanceAction{Account: dst, Volume: w.NewAmount(1, 0)},
+		"alice", "payment-1")
+	if !w.IsError(err, w.ErrIdempotencyKeyConflict) || err.Error() !=
+		`Idempotency key "payment-1" is already used for another transaction` {
+
+		test.Errorf(`Wrong error: "%v".`, err)
+	}
+	_, err = service.MakePayment(
+		w.BalanceAction{Account: src, Volume: w.NewAmount(-100, 0)},
+		w.BalanceAction{Account: dst, Volume: w.NewAmount(100, 0)},
+		"alice", "")
+	if !w.IsError(err, w.ErrInsufficientFunds) {
+		test.Errorf(`Payment without enough funds is executed: "%v".`, err)
+	}
+	_, err = service.MakePayment(
+		w.BalanceAction{Account: src, Volume: w.NewAmount(-1, 0)},
+		w.BalanceAction{
+			Account: w.AccountID{ID: "unknown", Currency: "USD"},
+			Volume:  w.NewAmount(1, 0)},
+		"alice", "")
+	if !w.IsError(err, w.ErrAccountNotFound) ||
+		err.Error() != `Account "unknown" (USD) doesn't exist` {
+
+		test.Errorf(`Wrong error: "%v".`, err)
+	}
+
+	if account, err := service.GetAccount(src); err != nil ||
+		*account != (w.Account{ID: src, Balance: w.NewAmount(6677, 2)}) {
+
+		test.Errorf(`Wrong account: "%v", "%v".`, account, err)
+	}
+	account, err := service.GetAccount(w.AccountID{ID: "src", Currency: "EUR"})
+	if account != nil || !w.IsError(err, w.ErrAccountNotFound) {
+		test.Errorf(`Wrong unknown account: "%v", "%v".`, account, err)
+	}
+
+	accounts := service.GetAccounts()
+	if len(accounts) != 2 ||
+		accounts[0] != (w.Account{ID: dst, Balance: w.NewAmount(3333, 2)}) ||
+		accounts[1] != (w.Account{ID: src, Balance: w.NewAmount(6677, 2)}) {
+
+		test.Errorf(`Wrong accounts: "%v".`, accounts)
+	}
+	payments, err := service.GetPayments(w.TransQuery{})
+	if err != nil || len(payments) != 2 ||
+		len(payments[0].Actions) != 1 || payments[0].Author != "bob" ||
+		len(payments[1].Actions) != 2 || payments[1].Author != "alice" ||
+		payments[0].ID == payments[1].ID ||
+		payments[1].Time.Before(payments[0].Time) ||
+		payments[1].Time.Location() != time.UTC {
+
+		test.Errorf(`Wrong payments: "%v".`, payments)
+	}
+
+	// Conversion payment from USD to EUR through house accounts.
+	eur := w.AccountID{ID: "dst", Currency: "EUR"}
+	for _, id := range []w.AccountID{
+		eur,
+		{ID: "fx", Currency: "USD"},
+		{ID: "fx", Currency: "EUR"}} {
+
+		if err := service.CreateAccount(id); err != nil {
+			test.Fatalf(`Failed to create account: "%s".`, err)
+		}
+	}
+	conversionResult, err := service.MakeConversionPayment(
+		w.BalanceAction{Account: src, Volume: w.NewAmount(-1111, 2)}, eur,
+		"alice", "")
+	if err != nil {
+		test.Fatalf(`Failed to make conversion payment: "%s".`, err)
+	}
+	if len(conversionResult.Accounts) != 4 || conversionResult.Accounts[3] !=
+		(w.Account{ID: eur, Balance: w.NewAmount(999, 2)}) {
+
+		test.Errorf(`Wrong conversion payment result: "%v".`, *conversionResult)
+	}
+	_, err = service.MakeConversionPayment(
+		w.BalanceAction{Account: src, Volume: w.NewAmount(-100, 0)}, eur,
+		"alice", "")
+	if !w.IsError(err, w.ErrInsufficientFunds) {
+		test.Errorf(`Conversion payment without enough funds is executed: "%v".`,
+			err)
+	}
+	accounts = service.GetAccounts()
+	if len(accounts) != 5 ||
+		accounts[0] != (w.Account{ID: eur, Balance: w.NewAmount(999, 2)}) ||
+		accounts[2] != (w.Account{
+			ID: w.AccountID{ID: "fx", Currency: "EUR"}, Balance: w.NewAmount(-999, 2)}) ||
+		accounts[3] != (w.Account{
+			ID: w.AccountID{ID: "fx", Currency: "USD"}, Balance: w.NewAmount(1111, 2)}) ||
+		accounts[4] != (w.Account{ID: src, Balance: w.NewAmount(5566, 2)}) {
+
+		test.Errorf(`Wrong accounts: "%v".`, accounts)
+	}
+	payments, err = service.GetPayments(w.TransQuery{})
+	if err != nil || len(payments) != 3 || len(payments[2].Actions) != 4 {
+		test.Fatalf(`Wrong payments: "%v", "%v".`, payments, err)
+	}
+
+	testPaymentQuery(test, service, payments)
+	testStatement(test, service, payments)
+	testHolds(test, service)
+	testReversals(test, service)
+	testBatch(test, service)
+	testFees(test, repo)
+	testLimits(test, service)
+	testAccountStatus(test, service)
+	testOverdraft(test, service)
+	testSchedules(test, service)
+	testAdjustments(test, service)
+	testAccountsAt(test, service)
+	testJournal(test, service)
+	testIntegrity(test, service)
+}
+
+func testPaymentQuery(
+	test *testing.T, service w.Service, payments []w.Transaction) {
+
+	for _, check := range []struct {
+		query  w.TransQuery
+		result []w.Transaction
+	}{
+		{w.TransQuery{Author: "bob"}, payments[:1]},
+		{w.TransQuery{Author: "unknown"}, payments[:0]},
+		{w.TransQuery{Account: "dst"}, payments[1:]},
+		{w.TransQuery{Account: "dst", Currency: "EUR"}, payments[2:]},
+		{w.TransQuery{Account: "fx", Currency: "USD"}, payments[2:]},
+		{w.TransQuery{Currency: "USD"}, payments},
+		{w.TransQuery{Currency: "JPY"}, payments[:0]},
+		{w.TransQuery{From: payments[1].Time}, payments[1:]},
+		{w.TransQuery{From: payments[1].Time, To: payments[2].Time}, payments[1:2]},
+		{w.TransQuery{To: payments[1].Time}, payments[:1]},
+		{w.TransQuery{Limit: 2}, payments[:2]},
+		{w.TransQuery{Limit: 2, Author: "alice"}, payments[1:]}} {
+
+		result, err := service.GetPayments(check.query)
+		if err != nil || len(result) != len(check.result) {
+			test.Errorf(`Wrong result for "%v": "%v", "%v".`,
+				check.query, result, err)
+			continue
+		}
+		for i, trans := range result {
+			if trans.ID != check.result[i].ID {
+				test.Errorf(`Wrong result for "%v": "%v".`, check.query, result)
+			}
+		}
+	}
+
+	// Pages by cursor have to return the full list.
+	query := w.TransQuery{Limit: 2}
+	result := []w.Transaction{}
+	for {
+		page, err := service.GetPayments(query)
+		if err != nil {
+			test.Fatalf(`Failed to query payments: "%s".`, err)
+		}
+		result = append(result, page...)
+		if len(page) < query.Limit {
+			break
+		}
+		cursor, err := w.ParseTransCursor(page[len(page)-1].Cursor().String())
+		if err != nil {
+			test.Fatalf(`Failed to parse cursor: "%s".`, err)
+		}
+		query.After = &cursor
+	}
+	if len(result) != len(payments) {
+		test.Fatalf(`Wrong pages: "%v".`, result)
+	}
+	for i, trans := range result {
+		if trans.ID != payments[i].ID {
+			test.Errorf(`Wrong pages: "%v".`, result)
+		}
+	}
+}
+
+func testStatement(
+	test *testing.T, service w.Service, payments []w.Transaction) {
+
+	src := w.AccountID{ID: "src", Currency: "USD"}
+	statement, err := service.GetStatement(src, time.Time{}, time.Time{})
+	if err != nil {
+		test.Fatalf(`Failed to get statement: "%s".`, err)
+	}
+	if !statement.OpeningBalance.IsZero() ||
+		statement.ClosingBalance != w.NewAmount(5566, 2) ||
+		len(statement.Items) != 3 ||
+		statement.Items[0].Balance != w.NewAmount(10010, 2) ||
+		statement.Items[1].Balance != w.NewAmount(6677, 2) ||
+		statement.Items[1].TransID != payments[1].ID ||
+		len(statement.Items[1].Counterparties) != 1 ||
+		statement.Items[2].Volume != w.NewAmount(-1111, 2) ||
+		len(statement.Items[2].Counterparties) != 3 {
+
+		test.Errorf(`Wrong statement: "%v".`, statement)
+	}
+
+	statement, err = service.GetStatement(src, payments[1].Time, payments[2].Time)
+	if err != nil {
+		test.Fatalf(`Failed to get statement: "%s".`, err)
+	}
+	if statement.OpeningBalance != w.NewAmount(10010, 2) ||
+		statement.ClosingBalance != w.NewAmount(6677, 2) ||
+		len(statement.Items) != 1 ||
+		statement.Items[0].Volume != w.NewAmount(-3333, 2) {
+
+		test.Errorf(`Wrong statement: "%v".`, statement)
+	}
+
+	_, err = service.GetStatement(
+		w.AccountID{ID: "unknown", Currency: "USD"}, time.Time{}, time.Time{})
+	if !w.IsError(err, w.ErrAccountNotFound) ||
+		err.Error() != `Account "unknown" (USD) doesn't exist` {
+
+		test.Errorf(`Wrong error: "%v".`, err)
+	}
+}
+
+func testHolds(test *testing.T, service w.Service) {
+	src := w.AccountID{ID: "holder", Currency: "USD"}
+	dst := w.AccountID{ID: "merchant", Currency: "USD"}
+	for _, id := range []w.AccountID{src, dst} {
+		if err := service.CreateAccount(id); err != nil {
+			test.Fatalf(`Failed to create account: "%s".`, err)
+		}
+	}
+	_, err := service.SetupAccount(
+		w.BalanceAction{Account: src, Volume: w.NewAmount(100, 0)}, "bob", "")
+	if err != nil {
+		test.Fatalf(`Failed to setup account: "%s".`, err)
+	}
+	expiration := time.Now().Add(time.Hour)
+
+	hold, err := service.Authorize(
+		src, dst, w.NewAmount(60, 0), expiration, "alice")
+	if err != nil {
+		test.Fatalf(`Failed to authorize: "%s".`, err)
+	}
+	if hold.Status != w.HoldActive || hold.Account != src ||
+		hold.Destination != dst || hold.Amount != w.NewAmount(60, 0) ||
+		hold.Author != "alice" {
+
+		test.Errorf(`Wrong hold: "%v".`, *hold)
+	}
+	if account, err := service.GetAccount(src); err != nil ||
+		account.Balance != w.NewAmount(100, 0) ||
+		account.Held != w.NewAmount(60, 0) ||
+		account.Available() != w.NewAmount(40, 0) {
+
+		test.Errorf(`Wrong account with hold: "%v", "%v".`, account, err)
+	}
+	_, err = service.MakePayment(
+		w.BalanceAction{Account: src, Volume: w.NewAmount(-50, 0)},
+		w.BalanceAction{Account: dst, Volume: w.NewAmount(50, 0)},
+		"alice", "")
+	if !w.IsError(err, w.ErrInsufficientFunds) {
+		test.Errorf(`Payment of held funds is executed: "%v".`, err)
+	}
+	_, err = service.Authorize(src, dst, w.NewAmount(50, 0), expiration, "alice")
+	if !w.IsError(err, w.ErrInsufficientFunds) {
+		test.Errorf(`Held funds are authorized twice: "%v".`, err)
+	}
+	_, err = service.Capture(hold.ID, w.NewAmount(61, 0), "alice", "")
+	if !w.IsError(err, w.ErrInvalidAmount) {
+		test.Errorf(`Amount greater than hold is captured: "%v".`, err)
+	}
+
+	// The second call with the same idempotency key is a retry.
+	var captureResults [2]*w.TransResult
+	for i := range captureResults {
+		captureResults[i], err = service.Capture(
+			hold.ID, w.NewAmount(25, 0), "alice", "capture-1")
+		if err != nil {
+			test.Fatalf(`Failed to capture: "%s".`, err)
+		}
+	}
+	if len(captureResults[0].Accounts) != 2 ||
+		captureResults[0].Accounts[0] !=
+			(w.Account{ID: src, Balance: w.NewAmount(75, 0)}) ||
+		captureResults[0].Accounts[1] !=
+			(w.Account{ID: dst, Balance: w.NewAmount(25, 0)}) {
+
+		test.Errorf(`Wrong capture result: "%v".`, *captureResults[0])
+	}
+	if captureResults[1].TransID != captureResults[0].TransID {
+		test.Errorf(`Wrong repeated capture result: "%v".`, *captureResults[1])
+	}
+	if _, err = service.Capture(hold.ID, w.Amount{}, "alice", ""); !w.IsError(
+		err, w.ErrHoldNotActive) {
+
+		test.Errorf(`Hold is captured twice: "%v".`, err)
+	}
+	if _, err = service.Release(hold.ID, "bob"); !w.IsError(
+		err, w.ErrHoldNotActive) {
+
+		test.Errorf(`Captured hold is released: "%v".`, err)
+	}
+	if hold, err = service.GetHold(hold.ID); err != nil ||
+		hold.Status != w.HoldCaptured {
+
+		test.Errorf(`Wrong captured hold: "%v", "%v".`, hold, err)
+	}
+
+	hold, err = service.Authorize(
+		src, dst, w.NewAmount(75, 0), expiration, "alice")
+	if err != nil {
+		test.Fatalf(`Failed to authorize: "%s".`, err)
+	}
+	if hold, err = service.Release(hold.ID, "bob"); err != nil ||
+		hold.Status != w.HoldReleased || hold.ReleasedBy != "bob" {
+
+		test.Errorf(`Wrong released hold: "%v", "%v".`, hold, err)
+	}
+	if account, err := service.GetAccount(src); err != nil ||
+		account.Available() != w.NewAmount(75, 0) {
+
+		test.Errorf(`Wrong account after release: "%v", "%v".`, account, err)
+	}
+
+	hold, err = service.Authorize(src, dst, w.NewAmount(75, 0),
+		time.Now().Add(100*time.Millisecond), "alice")
+	if err != nil {
+		test.Fatalf(`Failed to authorize: "%s".`, err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if hold, err = service.GetHold(hold.ID); err != nil ||
+		hold.Status != w.HoldExpired {
+
+		test.Errorf(`Wrong expired hold: "%v", "%v".`, hold, err)
+	}
+	accounts := service.GetAccounts()
+	for _, account := range accounts {
+		if account.ID == src && account.Available() != w.NewAmount(75, 0) {
+			test.Errorf(`Wrong account after expiration: "%v".`, account)
+		}
+	}
+	if _, err = service.Capture(hold.ID, w.Amount{}, "alice", ""); !w.IsError(
+		err, w.ErrHoldNotActive) {
+
+		test.Errorf(`Expired hold is captured: "%v".`, err)
+	}
+
+	_, err = service.Authorize(
+		src, dst, w.NewAmount(1, 0), time.Now().Add(-time.Second), "alice")
+	if !w.IsError(err, w.ErrInvalidTrans) {
+		test.Errorf(`Expired hold is authorized: "%v".`, err)
+	}
+	_, err = service.Authorize(
+		src, w.AccountID{ID: "merchant", Currency: "EUR"},
+		w.NewAmount(1, 0), expiration, "alice")
+	if !w.IsError(err, w.ErrCurrencyMismatch) {
+		test.Errorf(`Hold with various currencies is authorized: "%v".`, err)
+	}
+	_, err = service.Authorize(
+		src, w.AccountID{ID: "unknown", Currency: "USD"},
+		w.NewAmount(1, 0), expiration, "alice")
+	if !w.IsError(err, w.ErrAccountNotFound) {
+		test.Errorf(`Hold to unknown account is authorized: "%v".`, err)
+	}
+	if _, err = service.GetHold(-1); !w.IsError(err, w.ErrHoldNotFound) ||
+		err.Error() != `Hold -1 doesn't exist` {
+
+		test.Errorf(`Wrong error: "%v".`, err)
+	}
+}
+
+func testReversals(test *testing.T, service w.Service) {
+	src := w.AccountID{ID: "buyer", Currency: "USD"}
+	dst := w.AccountID{ID: "seller", Currency: "USD"}
+	for _, id := range []w.AccountID{src, dst} {
+		if err := service.CreateAccount(id); err != nil {
+			test.Fatalf(`Failed to create account: "%s".`, err)
+		}
+	}
+	setup, err := service.SetupAccount(
+		w.BalanceAction{Account: src, Volume: w.NewAmount(100, 0)}, "bob", "")
+	if err != nil {
+		test.Fatalf(`Failed to setup account: "%s".`, err)
+	}
+	payment, err := service.MakePayment(
+		w.BalanceAction{Account: src, Volume: w.NewAmount(-40, 0)},
+		w.BalanceAction{Account: dst, Volume: w.NewAmount(40, 0)},
+		"alice", "")
+	if err != nil {
+		test.Fatalf(`Failed to make payment: "%s".`, err)
+	}
+
+	reversal, err := service.ReversePayment(
+		payment.TransID, w.NewAmount(15, 0), "carol", "")
+	if err != nil {
+		test.Fatalf(`Failed to reverse payment: "%s".`, err)
+	}
+	if reversal.TransID == payment.TransID || len(reversal.Accounts) != 2 ||
+		reversal.Accounts[0] !=
+			(w.Account{ID: dst, Balance: w.NewAmount(25, 0)}) ||
+		reversal.Accounts[1] !=
+			(w.Account{ID: src, Balance: w.NewAmount(75, 0)}) {
+
+		test.Errorf(`Wrong reversal result: "%v".`, *reversal)
+	}
+	_, err = service.ReversePayment(
+		payment.TransID, w.NewAmount(2501, 2), "carol", "")
+	if !w.IsError(err, w.ErrInvalidAmount) {
+		test.Errorf(`Reversal of more than paid is executed: "%v".`, err)
+	}
+	// The second call with the same idempotency key is a retry.
+	var results [2]*w.TransResult
+	for i := range results {
+		results[i], err = service.ReversePayment(
+			payment.TransID, w.NewAmount(25, 0), "carol", "reversal-1")
+		if err != nil {
+			test.Fatalf(`Failed to reverse payment: "%s".`, err)
+		}
+	}
+	if results[1].TransID != results[0].TransID {
+		test.Errorf(`Wrong repeated reversal result: "%v".`, *results[1])
+	}
+	_, err = service.ReversePayment(
+		payment.TransID, w.NewAmount(1, 2), "carol", "")
+	if !w.IsError(err, w.ErrInvalidAmount) {
+		test.Errorf(`Reversal of reversed payment is executed: "%v".`, err)
+	}
+
+	_, err = service.ReversePayment(
+		reversal.TransID, w.NewAmount(1, 0), "carol", "")
+	if !w.IsError(err, w.ErrInvalidTrans) {
+		test.Errorf(`Reversal is reversed: "%v".`, err)
+	}
+	_, err = service.ReversePayment(
+		setup.TransID, w.NewAmount(1, 0), "carol", "")
+	if !w.IsError(err, w.ErrInvalidTrans) {
+		test.Errorf(`Balance setup is reversed: "%v".`, err)
+	}
+	_, err = service.ReversePayment(-1, w.NewAmount(1, 0), "carol", "")
+	if !w.IsError(err, w.ErrTransNotFound) ||
+		err.Error() != `Transaction -1 doesn't exist` {
+
+		test.Errorf(`Wrong error: "%v".`, err)
+	}
+
+	payments, err := service.GetPayments(w.TransQuery{Account: "buyer"})
+	if err != nil || len(payments) != 4 || payments[1].ReversalOf != nil ||
+		payments[2].ReversalOf == nil ||
+		*payments[2].ReversalOf != payment.TransID ||
+		payments[3].ReversalOf == nil ||
+		*payments[3].ReversalOf != payment.TransID {
+
+		test.Errorf(`Wrong payments: "%v", "%v".`, payments, err)
+	}
+	statement, err := service.GetStatement(src, time.Time{}, time.Time{})
+	if err != nil || len(statement.Items) != 4 ||
+		statement.Items[2].ReversalOf == nil ||
+		*statement.Items[2].ReversalOf != payment.TransID ||
+		statement.ClosingBalance != w.NewAmount(100, 0) {
+
+		test.Errorf(`Wrong statement: "%v", "%v".`, statement, err)
+	}
+}
+
+func testBatch(test *testing.T, service w.Service) {
+	payer := w.AccountID{ID: "payer", Currency: "USD"}
+	payees := []w.AccountID{
+		{ID: "payee1", Currency: "USD"}, {ID: "payee2", Currency: "USD"}}
+	for _, id := range append([]w.AccountID{payer}, payees...) {
+		if err := service.CreateAccount(id); err != nil {
+			test.Fatalf(`Failed to create account: "%s".`, err)
+		}
+	}
+	_, err := service.SetupAccount(
+		w.BalanceAction{Account: payer, Volume: w.NewAmount(10, 0)}, "bob", "")
+	if err != nil {
+		test.Fatalf(`Failed to setup account: "%s".`, err)
+	}
+
+	result, err := service.MakeBatchPayment(
+		w.Trans{
+			w.BalanceAction{Account: payer, Volume: w.NewAmount(-7, 0)},
+			w.BalanceAction{Account: payees[0], Volume: w.NewAmount(3, 0)},
+			w.BalanceAction{Account: payees[1], Volume: w.NewAmount(4, 0)}},
+		"alice", "")
+	if err != nil {
+		test.Fatalf(`Failed to make batch payment: "%s".`, err)
+	}
+	if len(result.Accounts) != 3 ||
+		result.Accounts[0] != (w.Account{ID: payer, Balance: w.NewAmount(3, 0)}) ||
+		result.Accounts[2] !=
+			(w.Account{ID: payees[1], Balance: w.NewAmount(4, 0)}) {
+
+		test.Errorf(`Wrong batch payment result: "%v".`, *result)
+	}
+
+	// The batch is atomic, so the first payee keeps the balance.
+	_, err = service.MakeBatchPayment(
+		w.Trans{
+			w.BalanceAction{Account: payer, Volume: w.NewAmount(-3, 0)},
+			w.BalanceAction{Account: payees[0], Volume: w.NewAmount(-4, 0)},
+			w.BalanceAction{Account: payees[1], Volume: w.NewAmount(7, 0)}},
+		"alice", "")
+	if !w.IsError(err, w.ErrInsufficientFunds) {
+		test.Errorf(`Batch payment without enough funds is executed: "%v".`, err)
+	}
+	if account, err := service.GetAccount(payer); err != nil ||
+		account.Balance != w.NewAmount(3, 0) {
+
+		test.Errorf(`Wrong account: "%v", "%v".`, account, err)
+	}
+}
+
+func testFees(test *testing.T, repo w.Repo) {
+	schedules, err := w.ParseFees("USD=0.3+2.9%")
+	if err != nil {
+		test.Fatalf(`Failed to parse fees: "%s".`, err)
+	}
+	policy := w.CreateFeePolicy(schedules)
+	rates := map[w.CurrencyPair]*big.Rat{
+		w.CurrencyPair{From: "USD", To: "EUR"}: big.NewRat(9, 10)}
+	service := w.CreateService(
+		repo,
+		w.CreateClientExecutorWithFees(policy, "fee"),
+		w.CreateManagerExecutor(),
+		w.CreateConversionExecutorWithFees(
+			w.CreateFixedRateProvider(rates), "fee-fx", policy, "fee"),
+		w.CreateBatchExecutorWithFees(policy, "fee"))
+	defer service.Close()
+
+	src := w.AccountID{ID: "shopper", Currency: "USD"}
+	dst := w.AccountID{ID: "shop", Currency: "USD"}
+	fee := w.AccountID{ID: "fee", Currency: "USD"}
+	eur := w.AccountID{ID: "shop", Currency: "EUR"}
+	fxUSD := w.AccountID{ID: "fee-fx", Currency: "USD"}
+	fxEUR := w.AccountID{ID: "fee-fx", Currency: "EUR"}
+	for _, id := range []w.AccountID{src, dst, fee, eur, fxUSD, fxEUR} {
+		if err := service.CreateAccount(id); err != nil {
+			test.Fatalf(`Failed to create account: "%s".`, err)
+		}
+	}
+	_, err = service.SetupAccount(
+		w.BalanceAction{Account: src, Volume: w.NewAmount(100, 0)}, "bob", "")
+	if err != nil {
+		test.Fatalf(`Failed to setup account: "%s".`, err)
+	}
+
+	// The second call with the same idempotency key is a retry.
+	var results [2]*w.TransResult
+	for i := range results {
+		results[i], err = service.MakePayment(
+			w.BalanceAction{Account: src, Volume: w.NewAmount(-10, 0)},
+			w.BalanceAction{Account: dst, Volume: w.NewAmount(10, 0)},
+			"alice", "fee-payment")
+		if err != nil {
+			test.Fatalf(`Failed to make payment: "%s".`, err)
+		}
+	}
+	if len(results[0].Accounts) != 3 ||
+		results[0].Accounts[0] !=
+			(w.Account{ID: src, Balance: w.NewAmount(8941, 2)}) ||
+		results[0].Accounts[1] != (w.Account{ID: dst, Balance: w.NewAmount(10, 0)}) ||
+		results[0].Accounts[2] !=
+			(w.Account{ID: fee, Balance: w.NewAmount(59, 2)}) ||
+		results[0].Fee == nil ||
+		*results[0].Fee !=
+			(w.BalanceAction{Account: fee, Volume: w.NewAmount(59, 2), Fee: true}) {
+
+		test.Errorf(`Wrong payment result: "%v".`, *results[0])
+	}
+	if results[1].TransID != results[0].TransID ||
+		len(results[1].Accounts) != 3 ||
+		results[1].Accounts[2] != results[0].Accounts[2] ||
+		results[1].Fee == nil || *results[1].Fee != *results[0].Fee {
+
+		test.Errorf(`Wrong repeated payment result: "%v".`, *results[1])
+	}
+
+	// The payment is available, but not with the fee.
+	_, err = service.MakePayment(
+		w.BalanceAction{Account: src, Volume: w.NewAmount(-89, 0)},
+		w.BalanceAction{Account: dst, Volume: w.NewAmount(89, 0)},
+		"alice", "")
+	if !w.IsError(err, w.ErrInsufficientFunds) {
+		test.Errorf(`Payment without funds for fee is executed: "%v".`, err)
+	}
+	_, err = service.MakePayment(
+		w.BalanceAction{Account: fee, Volume: w.NewAmount(-1, 2)},
+		w.BalanceAction{Account: dst, Volume: w.NewAmount(1, 2)},
+		"alice", "")
+	if !w.IsError(err, w.ErrInvalidTrans) {
+		test.Errorf(`Payment from fee account is executed: "%v".`, err)
+	}
+	// Holds are captured by payments, so they have the same parties.
+	for _, id := range []w.AccountID{fee, fxUSD} {
+		_, err = service.Authorize(src, id, w.NewAmount(1, 0),
+			time.Now().Add(time.Hour), "alice")
+		if !w.IsError(err, w.ErrInvalidTrans) {
+			test.Errorf(`Hold to "%s" is created: "%v".`, id.ID, err)
+		}
+	}
+
+	// The reversal is free and the fee is not returned. The fee action is
+	// marked by the stored payment, so the payment is reversible after fees are
+	// disabled.
+	freeService := w.CreateService(
+		repo,
+		w.CreateClientExecutor(),
+		w.CreateManagerExecutor(),
+		w.CreateConversionExecutor(w.CreateFixedRateProvider(rates), "fee-fx"),
+		w.CreateBatchExecutor())
+	defer freeService.Close()
+	reversal, err := freeService.ReversePayment(
+		results[0].TransID, w.NewAmount(10, 0), "carol", "")
+	if err != nil {
+		test.Fatalf(`Failed to reverse payment: "%s".`, err)
+	}
+	if len(reversal.Accounts) != 2 || reversal.Fee != nil ||
+		reversal.Accounts[0] != (w.Account{ID: dst}) ||
+		reversal.Accounts[1] !=
+			(w.Account{ID: src, Balance: w.NewAmount(9941, 2)}) {
+
+		test.Errorf(`Wrong reversal result: "%v".`, *reversal)
+	}
+	if account, err := service.GetAccount(fee); err != nil ||
+		account.Balance != w.NewAmount(59, 2) {
+
+		test.Errorf(`Wrong fee account: "%v", "%v".`, account, err)
+	}
+
+	// Batch debits are charged as payments.
+	batch, err := service.MakeBatchPayment(
+		w.Trans{
+			w.BalanceAction{Account: src, Volume: w.NewAmount(-10, 0)},
+			w.BalanceAction{Account: dst, Volume: w.NewAmount(10, 0)}},
+		"alice", "")
+	if err != nil {
+		test.Fatalf(`Failed to make batch payment: "%s".`, err)
+	}
+	if len(batch.Accounts) != 3 ||
+		batch.Accounts[0] != (w.Account{ID: src, Balance: w.NewAmount(8882, 2)}) ||
+		batch.Accounts[2] != (w.Account{ID: fee, Balance: w.NewAmount(118, 2)}) {
+
+		test.Errorf(`Wrong batch payment result: "%v".`, *batch)
+	}
+	_, err = service.MakeBatchPayment(
+		w.Trans{
+			w.BalanceAction{Account: fee, Volume: w.NewAmount(-1, 2)},
+			w.BalanceAction{Account: dst, Volume: w.NewAmount(1, 2)}},
+		"alice", "")
+	if !w.IsError(err, w.ErrInvalidTrans) {
+		test.Errorf(`Batch payment from fee account is executed: "%v".`, err)
+	}
+
+	// Cross-currency payments are charged in the source currency.
+	conversion, err := service.MakeConversionPayment(
+		w.BalanceAction{Account: src, Volume: w.NewAmount(-10, 0)}, eur,
+		"alice", "")
+	if err != nil {
+		test.Fatalf(`Failed to make cross-currency payment: "%s".`, err)
+	}
+	if len(conversion.Accounts) != 5 ||
+		conversion.Accounts[0] !=
+			(w.Account{ID: src, Balance: w.NewAmount(7823, 2)}) ||
+		conversion.Accounts[3] != (w.Account{ID: eur, Balance: w.NewAmount(9, 0)}) ||
+		conversion.Accounts[4] !=
+			(w.Account{ID: fee, Balance: w.NewAmount(177, 2)}) ||
+		conversion.Fee == nil ||
+		*conversion.Fee !=
+			(w.BalanceAction{Account: fee, Volume: w.NewAmount(59, 2), Fee: true}) {
+
+		test.Errorf(`Wrong cross-currency payment result: "%v".`, *conversion)
+	}
+}
+
+func testLimits(test *testing.T, service w.Service) {
+	src := w.AccountID{ID: "spender", Currency: "USD"}
+	dst := w.AccountID{ID: "receiver", Currency: "USD"}
+	for _, id := range []w.AccountID{src, dst} {
+		if err := service.CreateAccount(id); err != nil {
+			test.Fatalf(`Failed to create account: "%s".`, err)
+		}
+	}
+	_, err := service.SetupAccount(
+		w.BalanceAction{Account: src, Volume: w.NewAmount(100, 0)}, "bob", "")
+	if err != nil {
+		test.Fatalf(`Failed to setup account: "%s".`, err)
+	}
+	pay := func(amount int64) error {
+		_, err := service.MakePayment(
+			w.BalanceAction{Account: src, Volume: w.NewAmount(-amount, 0)},
+			w.BalanceAction{Account: dst, Volume: w.NewAmount(amount, 0)},
+			"alice", "")
+		return err
+	}
+
+	if limits, err := service.GetLimits(src); err != nil ||
+		*limits != (w.Limits{}) {
+
+		test.Errorf(`Wrong default limits: "%v", "%v".`, limits, err)
+	}
+	limits := w.Limits{MaxPayment: w.NewAmount(30, 0), DailyCount: 2}
+	if err := service.SetLimits(src, limits); err != nil {
+		test.Fatalf(`Failed to set limits: "%s".`, err)
+	}
+	if stored, err := service.GetLimits(src); err != nil || *stored != limits {
+		test.Errorf(`Wrong limits: "%v", "%v".`, stored, err)
+	}
+
+	if err := pay(31); !w.IsError(err, w.ErrLimitExceeded) {
+		test.Errorf(`Payment over the maximal amount is executed: "%v".`, err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := pay(10); err != nil {
+			test.Fatalf(`Failed to make payment: "%s".`, err)
+		}
+	}
+	if err := pay(1); !w.IsError(err, w.ErrLimitExceeded) {
+		test.Errorf(`Payment over the daily count is executed: "%v".`, err)
+	}
+
+	// Limits are replaced, so the count limit is not set anymore.
+	limits = w.Limits{DailyAmount: w.NewAmount(25, 0)}
+	if err := service.SetLimits(src, limits); err != nil {
+		test.Fatalf(`Failed to set limits: "%s".`, err)
+	}
+	if err := pay(6); !w.IsError(err, w.ErrLimitExceeded) {
+		test.Errorf(`Payment over the daily amount is executed: "%v".`, err)
+	}
+	if err := pay(5); err != nil {
+		test.Errorf(`Failed to make payment: "%s".`, err)
+	}
+	if account, err := service.GetAccount(src); err != nil ||
+		account.Balance != w.NewAmount(75, 0) {
+
+		test.Errorf(`Wrong account: "%v", "%v".`, account, err)
+	}
+
+	// Manager balance updates are not payments, but batch debits are.
+	_, err = service.SetupAccount(
+		w.BalanceAction{Account: src, Volume: w.NewAmount(-5, 0)}, "bob", "")
+	if err != nil {
+		test.Fatalf(`Failed to setup account: "%s".`, err)
+	}
+	limits = w.Limits{DailyAmount: w.NewAmount(26, 0)}
+	if err := service.SetLimits(src, limits); err != nil {
+		test.Fatalf(`Failed to set limits: "%s".`, err)
+	}
+	if err := pay(1); err != nil {
+		test.Errorf(`Failed to make payment: "%s".`, err)
+	}
+	_, err = service.MakeBatchPayment(
+		w.Trans{
+			w.BalanceAction{Account: src, Volume: w.NewAmount(-1, 0)},
+			w.BalanceAction{Account: dst, Volume: w.NewAmount(1, 0)}},
+		"alice", "")
+	if !w.IsError(err, w.ErrLimitExceeded) {
+		test.Errorf(`Batch over the daily amount is executed: "%v".`, err)
+	}
+
+	// The reversal is not a payment, so the payee refunds at its limit.
+	if err := service.SetLimits(src, w.Limits{}); err != nil {
+		test.Fatalf(`Failed to set limits: "%s".`, err)
+	}
+	payment, err := service.MakePayment(
+		w.BalanceAction{Account: src, Volume: w.NewAmount(-2, 0)},
+		w.BalanceAction{Account: dst, Volume: w.NewAmount(2, 0)},
+		"alice", "")
+	if err != nil {
+		test.Fatalf(`Failed to make payment: "%s".`, err)
+	}
+	err = service.SetLimits(dst, w.Limits{DailyAmount: w.NewAmount(1, 0)})
+	if err != nil {
+		test.Fatalf(`Failed to set limits: "%s".`, err)
+	}
+	_, err = service.MakePayment(
+		w.BalanceAction{Account: dst, Volume: w.NewAmount(-1, 0)},
+		w.BalanceAction{Account: src, Volume: w.NewAmount(1, 0)},
+		"bob", "")
+	if err != nil {
+		test.Fatalf(`Failed to make payment: "%s".`, err)
+	}
+	_, err = service.ReversePayment(
+		payment.TransID, w.NewAmount(2, 0), "bob", "")
+	if err != nil {
+		test.Errorf(`Failed to reverse payment at the limit: "%s".`, err)
+	}
+
+	err = service.SetLimits(src, w.Limits{MonthlyAmount: w.NewAmount(-1, 0)})
+	if !w.IsError(err, w.ErrInvalidAmount) {
+		test.Errorf(`Negative limit is set: "%v".`, err)
+	}
+	err = service.SetLimits(src, w.Limits{MaxPayment: w.NewAmount(1, 3)})
+	if !w.IsError(err, w.ErrInvalidAmount) {
+		test.Errorf(`Limit with wrong scale is set: "%v".`, err)
+	}
+	unknown := w.AccountID{ID: "unknown", Currency: "USD"}
+	if err := service.SetLimits(unknown, limits); !w.IsError(
+		err, w.ErrAccountNotFound) {
+
+		test.Errorf(`Limits of unknown account are set: "%v".`, err)
+	}
+	if _, err := service.GetLimits(unknown); !w.IsError(
+		err, w.ErrAccountNotFound) {
+
+		test.Errorf(`Limits of unknown account are returned: "%v".`, err)
+	}
+}
+
+func testAccountStatus(test *testing.T, service w.Service) {
+	account := w.AccountID{ID: "suspect", Currency: "USD"}
+	partner := w.AccountID{ID: "partner", Currency: "USD"}
+	for _, id := range []w.AccountID{account, partner} {
+		if err := service.CreateAccount(id); err != nil {
+			test.Fatalf(`Failed to create account: "%s".`, err)
+		}
+	}
+	for _, id := range []w.AccountID{account, partner} {
+		_, err := service.SetupAccount(
+			w.BalanceAction{Account: id, Volume: w.NewAmount(10, 0)}, "bob", "")
+		if err != nil {
+			test.Fatalf(`Failed to setup account: "%s".`, err)
+		}
+	}
+	pay := func(src, dst w.AccountID, amount int64) error {
+		_, err := service.MakePayment(
+			w.BalanceAction{Account: src, Volume: w.NewAmount(-amount, 0)},
+			w.BalanceAction{Account: dst, Volume: w.NewAmount(amount, 0)},
+			"alice", "")
+		return err
+	}
+
+	change, err := service.SetAccountStatus(account, w.AccountFrozen, "bob")
+	if err != nil {
+		test.Fatalf(`Failed to freeze account: "%s".`, err)
+	}
+	if change.TransID == 0 || change.Author != "bob" ||
+		change.Status != w.AccountFrozen {
+
+		test.Errorf(`Wrong status change: "%v".`, *change)
+	}
+	if err := pay(account, partner, 1); !w.IsError(err, w.ErrAccountNotActive) {
+		test.Errorf(`Payment from frozen account is executed: "%v".`, err)
+	}
+	_, err = service.Authorize(account, partner, w.NewAmount(1, 0),
+		time.Now().Add(time.Hour), "alice")
+	if !w.IsError(err, w.ErrAccountNotActive) {
+		test.Errorf(`Hold on frozen account is created: "%v".`, err)
+	}
+	_, err = service.SetAccountStatus(account, w.AccountFrozen, "bob")
+	if !w.IsError(err, w.ErrInvalidStatusChange) {
+		test.Errorf(`Account is frozen twice: "%v".`, err)
+	}
+	// The frozen account still could be credited.
+	if err := pay(partner, account, 5); err != nil {
+		test.Errorf(`Failed to make payment to frozen account: "%s".`, err)
+	}
+	_, err = service.SetAccountStatus(account, w.AccountActive, "bob")
+	if err != nil {
+		test.Fatalf(`Failed to unfreeze account: "%s".`, err)
+	}
+
+	_, err = service.SetAccountStatus(account, w.AccountClosed, "bob")
+	if !w.IsError(err, w.ErrInvalidStatusChange) {
+		test.Errorf(`Account with not zero balance is closed: "%v".`, err)
+	}
+	if err := pay(account, partner, 15); err != nil {
+		test.Fatalf(`Failed to make payment: "%s".`, err)
+	}
+	_, err = service.SetAccountStatus(account, w.AccountClosed, "bob")
+	if err != nil {
+		test.Fatalf(`Failed to close account: "%s".`, err)
+	}
+	if err := pay(partner, account, 1); !w.IsError(err, w.ErrAccountNotActive) {
+		test.Errorf(`Payment to closed account is executed: "%v".`, err)
+	}
+	_, err = service.Authorize(partner, account, w.NewAmount(1, 0),
+		time.Now().Add(time.Hour), "alice")
+	if !w.IsError(err, w.ErrAccountNotActive) {
+		test.Errorf(`Hold to closed account is created: "%v".`, err)
+	}
+	_, err = service.SetupAccount(
+		w.BalanceAction{Account: account, Volume: w.NewAmount(1, 0)}, "bob", "")
+	if !w.IsError(err, w.ErrAccountNotActive) {
+		test.Errorf(`Closed account balance is updated: "%v".`, err)
+	}
+	_, err = service.SetAccountStatus(account, w.AccountActive, "bob")
+	if !w.IsError(err, w.ErrInvalidStatusChange) {
+		test.Errorf(`Closed account is opened: "%v".`, err)
+	}
+	if state, err := service.GetAccount(account); err != nil ||
+		*state != (w.Account{ID: account, Status: w.AccountClosed}) {
+
+		test.Errorf(`Wrong account: "%v", "%v".`, state, err)
+	}
+
+	changes, err := service.GetAccountStatusChanges(account)
+	if err != nil || len(changes) != 3 || changes[0] != *change ||
+		changes[1].Status != w.AccountActive ||
+		changes[2].Status != w.AccountClosed ||
+		changes[2].Author != "bob" ||
+		changes[2].TransID <= changes[1].TransID {
+
+		test.Errorf(`Wrong status changes: "%v", "%v".`, changes, err)
+	}
+	// Status changes are not payments.
+	payments, err := service.GetPayments(
+		w.TransQuery{Account: account.ID, Currency: account.Currency})
+	if err != nil || len(payments) != 3 {
+		test.Errorf(`Wrong payments: "%v", "%v".`, payments, err)
+	}
+	_, err = service.GetAccountStatusChanges(
+		w.AccountID{ID: "unknown", Currency: "USD"})
+	if !w.IsError(err, w.ErrAccountNotFound) {
+		test.Errorf(`Wrong error: "%v".`, err)
+	}
+}
+
+func testOverdraft(test *testing.T, service w.Service) {
+	borrower := w.AccountID{ID: "borrower", Currency: "USD"}
+	lender := w.AccountID{ID: "lender", Currency: "USD"}
+	for _, id := range []w.AccountID{borrower, lender} {
+		if err := service.CreateAccount(id); err != nil {
+			test.Fatalf(`Failed to create account: "%s".`, err)
+		}
+	}
+	pay := func(amount int64) error {
+		_, err := service.MakePayment(
+			w.BalanceAction{Account: borrower, Volume: w.NewAmount(-amount, 0)},
+			w.BalanceAction{Account: lender, Volume: w.NewAmount(amount, 0)},
+			"alice", "")
+		return err
+	}
+
+	if err := pay(1); !w.IsError(err, w.ErrInsufficientFunds) {
+		test.Errorf(`Payment without overdraft is executed: "%v".`, err)
+	}
+	for _, overdraft := range []w.Amount{
+		w.NewAmount(-1, 0), w.NewAmount(1, 3)} {
+
+		_, err := service.SetOverdraft(borrower, overdraft)
+		if !w.IsError(err, w.ErrInvalidAmount) {
+			test.Errorf(`Overdraft %s is set: "%v".`, overdraft, err)
+		}
+	}
+	_, err := service.SetOverdraft(
+		w.AccountID{ID: "unknown", Currency: "USD"}, w.NewAmount(1, 0))
+	if !w.IsError(err, w.ErrAccountNotFound) {
+		test.Errorf(`Wrong error: "%v".`, err)
+	}
+
+	account, err := service.SetOverdraft(borrower, w.NewAmount(20, 0))
+	if err != nil {
+		test.Fatalf(`Failed to set overdraft: "%s".`, err)
+	}
+	if *account != (w.Account{ID: borrower, Overdraft: w.NewAmount(20, 0)}) {
+		test.Errorf(`Wrong account: "%v".`, *account)
+	}
+	if err := pay(15); err != nil {
+		test.Fatalf(`Failed to make payment by overdraft: "%s".`, err)
+	}
+	_, err = service.Authorize(borrower, lender, w.NewAmount(6, 0),
+		time.Now().Add(time.Hour), "alice")
+	if !w.IsError(err, w.ErrInsufficientFunds) {
+		test.Errorf(`Hold over overdraft is created: "%v".`, err)
+	}
+	_, err = service.Authorize(borrower, lender, w.NewAmount(5, 0),
+		time.Now().Add(time.Hour), "alice")
+	if err != nil {
+		test.Errorf(`Failed to create hold by overdraft: "%s".`, err)
+	}
+	if err := pay(1); !w.IsError(err, w.ErrInsufficientFunds) {
+		test.Errorf(`Payment over overdraft is executed: "%v".`, err)
+	}
+
+	// The overdraft could be decreased below the used credit.
+	if _, err := service.SetOverdraft(borrower, w.Amount{}); err != nil {
+		test.Fatalf(`Failed to reset overdraft: "%s".`, err)
+	}
+	if state, err := service.GetAccount(borrower); err != nil ||
+		*state != (w.Account{
+			ID: borrower, Balance: w.NewAmount(-15, 0), Held: w.NewAmount(5, 0)}) {
+
+		test.Errorf(`Wrong account: "%v", "%v".`, state, err)
+	}
+}
+
+func testSchedules(test *testing.T, service w.Service) {
+	src := w.AccountID{ID: "tenant", Currency: "USD"}
+	dst := w.AccountID{ID: "landlord", Currency: "USD"}
+	for _, id := range []w.AccountID{src, dst} {
+		if err := service.CreateAccount(id); err != nil {
+			test.Fatalf(`Failed to create account: "%s".`, err)
+		}
+	}
+	_, err := service.SetupAccount(
+		w.BalanceAction{Account: src, Volume: w.NewAmount(150, 0)}, "bob", "")
+	if err != nil {
+		test.Fatalf(`Failed to setup account: "%s".`, err)
+	}
+
+	start := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	request := w.Schedule{
+		Account:     src,
+		Destination: dst,
+		Amount:      w.NewAmount(100, 0),
+		Author:      "alice",
+		Start:       start,
+		Period:      w.ScheduleMonthly}
+	for _, check := range []struct {
+		modify func(*w.Schedule)
+		err    error
+	}{
+		{func(s *w.Schedule) { s.Period = "yearly" }, w.ErrInvalidSchedule},
+		{func(s *w.Schedule) { s.Destination = src }, w.ErrInvalidSchedule},
+		{func(s *w.Schedule) { s.Amount = w.Amount{} }, w.ErrInvalidAmount},
+		{func(s *w.Schedule) { s.Amount = w.NewAmount(1, 3) }, w.ErrInvalidAmount},
+		{
+			func(s *w.Schedule) { s.Start = start.Add(-2 * time.Hour) },
+			w.ErrInvalidSchedule},
+		{
+			func(s *w.Schedule) { s.Destination.Currency = "EUR" },
+			w.ErrCurrencyMismatch},
+		{
+			func(s *w.Schedule) { s.Destination.ID = "unknown" },
+			w.ErrAccountNotFound}} {
+
+		schedule := request
+		check.modify(&schedule)
+		_, err := service.CreateSchedule(schedule, time.Now())
+		if !w.IsError(err, check.err) {
+			test.Errorf(`Wrong schedule "%v" is created: "%v".`, schedule, err)
+		}
+	}
+
+	schedule, err := service.CreateSchedule(request, time.Now())
+	if err != nil {
+		test.Fatalf(`Failed to create schedule: "%s".`, err)
+	}
+	if schedule.ID == 0 || schedule.Runs != 0 || schedule.NextRun == nil ||
+		!schedule.NextRun.Equal(start) {
+
+		test.Errorf(`Wrong schedule: "%v".`, *schedule)
+	}
+	isDue := func(now time.Time) bool {
+		schedules, err := service.GetDueSchedules(now)
+		if err != nil {
+			test.Fatalf(`Failed to get due schedules: "%s".`, err)
+		}
+		for _, due := range schedules {
+			if due.ID == schedule.ID {
+				return true
+			}
+		}
+		return false
+	}
+	if isDue(time.Now()) || !isDue(start) {
+		test.Error("Wrong due schedule list.")
+	}
+
+	// The run is completed by the payment.
+	payment, err := service.MakePayment(
+		w.BalanceAction{Account: src, Volume: request.Amount.Neg()},
+		w.BalanceAction{Account: dst, Volume: request.Amount},
+		"alice", "")
+	if err != nil {
+		test.Fatalf(`Failed to make payment: "%s".`, err)
+	}
+	schedule, err = service.CompleteScheduleRun(
+		w.ScheduleRun{ScheduleID: schedule.ID, Time: start,
+			TransID: &payment.TransID},
+		nil)
+	if err != nil {
+		test.Fatalf(`Failed to complete schedule run: "%s".`, err)
+	}
+	if schedule.Runs != 1 || schedule.Attempts != 0 ||
+		!schedule.NextRun.Equal(start.AddDate(0, 1, 0)) {
+
+		test.Errorf(`Wrong schedule after payment: "%v".`, *schedule)
+	}
+	_, err = service.CompleteScheduleRun(
+		w.ScheduleRun{ScheduleID: schedule.ID, Time: start}, nil)
+	if !w.IsError(err, w.ErrScheduleRunCompleted) {
+		test.Errorf(`Completed run is completed twice: "%v".`, err)
+	}
+
+	// The failed attempt is retried, the run is completed by the last attempt.
+	retry := start.Add(time.Hour)
+	schedule, err = service.CompleteScheduleRun(
+		w.ScheduleRun{
+			ScheduleID: schedule.ID, Run: 1, Time: start, Error: "Failure 1"},
+		&retry)
+	if err != nil {
+		test.Fatalf(`Failed to complete schedule run: "%s".`, err)
+	}
+	if schedule.Runs != 1 || schedule.Attempts != 1 ||
+		!schedule.NextRun.Equal(retry) {
+
+		test.Errorf(`Wrong schedule after failed attempt: "%v".`, *schedule)
+	}
+	schedule, err = service.CompleteScheduleRun(
+		w.ScheduleRun{
+			ScheduleID: schedule.ID, Run: 1, Time: retry, Error: "Failure 2"},
+		nil)
+	if err != nil {
+		test.Fatalf(`Failed to complete schedule run: "%s".`, err)
+	}
+	if schedule.Runs != 2 || schedule.Attempts != 0 ||
+		!schedule.NextRun.Equal(start.AddDate(0, 2, 0)) {
+
+		test.Errorf(`Wrong schedule after last attempt: "%v".`, *schedule)
+	}
+
+	runs, err := service.GetScheduleRuns(schedule.ID)
+	if err != nil || len(runs) != 3 ||
+		runs[0].TransID == nil || *runs[0].TransID != payment.TransID ||
+		runs[0].Attempt != 1 ||
+		runs[1].TransID != nil || runs[1].Attempt != 1 ||
+		runs[1].Error != "Failure 1" ||
+		runs[2].Run != 1 || runs[2].Attempt != 2 || !runs[2].Time.Equal(retry) {
+
+		test.Errorf(`Wrong schedule runs: "%v", "%v".`, runs, err)
+	}
+
+	// The updated schedule is started again.
+	start = start.Add(time.Hour)
+	update := w.Schedule{
+		ID: schedule.ID, Amount: w.NewAmount(50, 0), Start: start,
+		Period: w.ScheduleOnce}
+	_, err = service.UpdateSchedule(update, start.Add(time.Second))
+	if !w.IsError(err, w.ErrInvalidSchedule) {
+		test.Errorf(`Schedule is started in the past: "%v".`, err)
+	}
+	schedule, err = service.UpdateSchedule(update, time.Now())
+	if err != nil {
+		test.Fatalf(`Failed to update schedule: "%s".`, err)
+	}
+	if schedule.Runs != 0 || schedule.Account != src ||
+		schedule.Destination != dst || schedule.Author != "alice" ||
+		schedule.Amount != w.NewAmount(50, 0) || !schedule.NextRun.Equal(start) {
+
+		test.Errorf(`Wrong updated schedule: "%v".`, *schedule)
+	}
+	schedule, err = service.CompleteScheduleRun(
+		w.ScheduleRun{ScheduleID: schedule.ID, Time: start, Error: "Failure"},
+		nil)
+	if err != nil || schedule.Runs != 1 || schedule.NextRun != nil {
+		test.Errorf(`Wrong completed schedule: "%v", "%v".`, schedule, err)
+	}
+	if isDue(start.AddDate(1, 0, 0)) {
+		test.Error("Completed schedule is due.")
+	}
+	if stored, err := service.GetSchedule(schedule.ID); err != nil ||
+		stored.Runs != 1 || stored.NextRun != nil || !stored.Start.Equal(start) {
+
+		test.Errorf(`Wrong schedule: "%v", "%v".`, stored, err)
+	}
+	if schedules, err := service.GetSchedules(); err != nil ||
+		len(schedules) != 1 || schedules[0].ID != schedule.ID {
+
+		test.Errorf(`Wrong schedules: "%v", "%v".`, schedules, err)
+	}
+
+	deleted, err := service.DeleteSchedule(schedule.ID)
+	if err != nil || deleted.ID != schedule.ID {
+		test.Errorf(`Failed to delete schedule: "%v", "%v".`, deleted, err)
+	}
+	if _, err := service.GetSchedule(schedule.ID); !w.IsError(
+		err, w.ErrScheduleNotFound) {
+
+		test.Errorf(`Deleted schedule is returned: "%v".`, err)
+	}
+	if _, err := service.GetScheduleRuns(schedule.ID); !w.IsError(
+		err, w.ErrScheduleNotFound) {
+
+		test.Errorf(`Deleted schedule runs are returned: "%v".`, err)
+	}
+	for _, err := range []error{
+		func() error { _, err := service.DeleteSchedule(schedule.ID); return err }(),
+		func() error {
+			_, err := service.UpdateSchedule(
+				w.Schedule{
+					ID: schedule.ID, Amount: w.NewAmount(1, 0),
+					Period: w.ScheduleOnce},
+				time.Now())
+			return err
+		}()} {
+
+		if !w.IsError(err, w.ErrScheduleNotFound) {
+			test.Errorf(`Wrong error: "%v".`, err)
+		}
+	}
+}
+
+func testAdjustments(test *testing.T, service w.Service) {
+	account := w.AccountID{ID: "payroll", Currency: "USD"}
+	if err := service.CreateAccount(account); err != nil {
+		test.Fatalf(`Failed to create account: "%s".`, err)
+	}
+	for _, check := range []struct {
+		action w.BalanceAction
+		err    error
+	}{
+		{w.BalanceAction{Account: account}, w.ErrInvalidAmount},
+		{
+			w.BalanceAction{Account: account, Volume: w.NewAmount(1, 3)},
+			w.ErrInvalidAmount},
+		{
+			w.BalanceAction{
+				Account: w.AccountID{ID: "unknown", Currency: "USD"},
+				Volume:  w.NewAmount(1, 0)},
+			w.ErrAccountNotFound}} {
+
+		_, err := service.ProposeAdjustment(check.action, "bob")
+		if !w.IsError(err, check.err) {
+			test.Errorf(`Wrong adjustment "%v" is proposed: "%v".`,
+				check.action, err)
+		}
+	}
+
+	propose := func(amount int64) *w.Adjustment {
+		result, err := service.ProposeAdjustment(
+			w.BalanceAction{Account: account, Volume: w.NewAmount(amount, 0)},
+			"bob")
+		if err != nil {
+			test.Fatalf(`Failed to propose adjustment: "%s".`, err)
+		}
+		return result
+	}
+	approved := propose(100)
+	if approved.ID == 0 || approved.Status != w.AdjustmentPending ||
+		approved.Proposer != "bob" || approved.Checker != "" ||
+		approved.TransID != nil {
+
+		test.Errorf(`Wrong adjustment: "%v".`, *approved)
+	}
+	rejected := propose(-10)
+	if state, err := service.GetAccount(account); err != nil ||
+		!state.Balance.IsZero() {
+
+		test.Errorf(`Pending adjustment is executed: "%v", "%v".`, state, err)
+	}
+
+	// The proposer could not decide on the own adjustment.
+	if _, err := service.ApproveAdjustment(approved.ID, "bob"); !w.IsError(
+		err, w.ErrSelfApproval) {
+
+		test.Errorf(`Adjustment is approved by the proposer: "%v".`, err)
+	}
+	if _, err := service.RejectAdjustment(rejected.ID, "bob"); !w.IsError(
+		err, w.ErrSelfApproval) {
+
+		test.Errorf(`Adjustment is rejected by the proposer: "%v".`, err)
+	}
+
+	result, err := service.ApproveAdjustment(approved.ID, "carol")
+	if err != nil {
+		test.Fatalf(`Failed to approve adjustment: "%s".`, err)
+	}
+	if len(result.Accounts) != 1 ||
+		result.Accounts[0].Balance != w.NewAmount(100, 0) {
+
+		test.Errorf(`Wrong approval result: "%v".`, *result)
+	}
+	// The repeated approval doesn't modify the account.
+	repeated, err := service.ApproveAdjustment(approved.ID, "carol")
+	if err != nil || repeated.TransID != result.TransID {
+
+		test.Errorf(`Wrong repeated approval: "%v", "%v".`, repeated, err)
+	}
+	if _, err := service.RejectAdjustment(approved.ID, "carol"); !w.IsError(
+		err, w.ErrAdjustmentNotPending) {
+
+		test.Errorf(`Approved adjustment is rejected: "%v".`, err)
+	}
+	if stored, err := service.GetAdjustment(approved.ID); err != nil ||
+		stored.Status != w.AdjustmentApproved || stored.Checker != "carol" ||
+		stored.DecisionTime == nil || stored.TransID == nil ||
+		*stored.TransID != result.TransID {
+
+		test.Errorf(`Wrong approved adjustment: "%v", "%v".`, stored, err)
+	}
+	if payments, err := service.GetPayments(
+		w.TransQuery{Account: account.ID, Limit: 10}); err != nil ||
+		len(payments) != 1 || payments[0].Author != "bob" {
+
+		test.Errorf(`Wrong adjustment transaction: "%v", "%v".`, payments, err)
+	}
+
+	adjustment, err := service.RejectAdjustment(rejected.ID, "carol")
+	if err != nil {
+		test.Fatalf(`Failed to reject adjustment: "%s".`, err)
+	}
+	if adjustment.Status != w.AdjustmentRejected ||
+		adjustment.Checker != "carol" || adjustment.DecisionTime == nil ||
+		adjustment.TransID != nil {
+
+		test.Errorf(`Wrong rejected adjustment: "%v".`, *adjustment)
+	}
+	if _, err := service.ApproveAdjustment(rejected.ID, "carol"); !w.IsError(
+		err, w.ErrAdjustmentNotPending) {
+
+		test.Errorf(`Rejected adjustment is approved: "%v".`, err)
+	}
+	if state, err := service.GetAccount(account); err != nil ||
+		state.Balance != w.NewAmount(100, 0) {
+
+		test.Errorf(`Wrong account: "%v", "%v".`, state, err)
+	}
+
+	if adjustments, err := service.GetAdjustments(""); err != nil ||
+		len(adjustments) != 2 || adjustments[0].ID != approved.ID ||
+		adjustments[1].ID != rejected.ID {
+
+		test.Errorf(`Wrong adjustments: "%v", "%v".`, adjustments, err)
+	}
+	if adjustments, err := service.GetAdjustments(
+		w.AdjustmentRejected); err != nil ||
+		len(adjustments) != 1 || adjustments[0].ID != rejected.ID {
+
+		test.Errorf(`Wrong rejected adjustments: "%v", "%v".`, adjustments, err)
+	}
+	if _, err := service.GetAdjustments("unknown"); !w.IsError(
+		err, w.ErrInvalidQuery) {
+
+		test.Errorf(`Wrong error: "%v".`, err)
+	}
+	if _, err := service.GetAdjustment(rejected.ID + 100); !w.IsError(
+		err, w.ErrAdjustmentNotFound) {
+
+		test.Errorf(`Wrong error: "%v".`, err)
+	}
+}
+
+func testAccountsAt(test *testing.T, service w.Service) {
+	account := w.AccountID{ID: "history", Currency: "USD"}
+	if err := service.CreateAccount(account); err != nil {
+		test.Fatalf(`Failed to create account: "%s".`, err)
+	}
+	if _, err := service.SetupAccount(
+		w.BalanceAction{Account: account, Volume: w.NewAmount(100, 0)},
+		"bob", ""); err != nil {
+
+		test.Fatalf(`Failed to setup account: "%s".`, err)
+	}
+	change, err := service.SetAccountStatus(account, w.AccountFrozen, "bob")
+	if err != nil {
+		test.Fatalf(`Failed to freeze account: "%s".`, err)
+	}
+	if _, err := service.SetupAccount(
+		w.BalanceAction{Account: account, Volume: w.NewAmount(-30, 0)},
+		"bob", ""); err != nil {
+
+		test.Fatalf(`Failed to setup account: "%s".`, err)
+	}
+	payments, err := service.GetPayments(
+		w.TransQuery{Account: account.ID, Currency: account.Currency})
+	if err != nil || len(payments) != 2 {
+		test.Fatalf(`Wrong payments: "%v", "%v".`, payments, err)
+	}
+
+	for _, check := range []struct {
+		at      time.Time
+		balance w.Amount
+		status  w.AccountStatus
+	}{
+		{
+			at:      payments[0].Time,
+			balance: w.NewAmount(100, 0),
+			status:  w.AccountActive},
+		{
+			at:      change.Time,
+			balance: w.NewAmount(100, 0),
+			status:  w.AccountFrozen},
+		{
+			at:      payments[1].Time,
+			balance: w.NewAmount(70, 0),
+			status:  w.AccountFrozen}} {
+
+		accounts, err := service.GetAccountsAt(check.at)
+		if err != nil {
+			test.Fatalf(`Failed to get accounts: "%s".`, err)
+		}
+		var state *w.Account
+		for i := range accounts {
+			if accounts[i].ID == account {
+				state = &accounts[i]
+			}
+		}
+		if state == nil ||
+			*state != (w.Account{
+				ID: account, Balance: check.balance, Status: check.status}) {
+
+			test.Errorf(`Wrong account at %s: "%v".`, check.at, state)
+		}
+	}
+
+	// The account has no transactions yet, so it is not known.
+	accounts, err := service.GetAccountsAt(
+		payments[0].Time.Add(-time.Microsecond))
+	if err != nil {
+		test.Fatalf(`Failed to get accounts: "%s".`, err)
+	}
+	for _, state := range accounts {
+		if state.ID == account {
+			test.Errorf(`Account is returned before its transactions: "%v".`,
+				state)
+		}
+	}
+
+	if _, err := service.GetAccountsAt(time.Time{}); !w.IsError(
+		err, w.ErrInvalidQuery) {
+
+		test.Errorf(`Accounts without time are returned: "%v".`, err)
+	}
+}
+
+func testJournal(test *testing.T, service w.Service) {
+	report, err := service.VerifyJournal()
+	if err != nil {
+		test.Fatalf(`Failed to verify journal: "%s".`, err)
+	}
+	if !report.Valid || report.Transactions == 0 ||
+		report.BrokenTransID != nil || report.Error != "" {
+
+		test.Errorf(`Wrong journal report: "%v".`, *report)
+	}
+}
+
+func testIntegrity(test *testing.T, service w.Service) {
+	report, err := service.VerifyIntegrity()
+	if err != nil {
+		test.Fatalf(`Failed to verify integrity: "%s".`, err)
+	}
+	if !report.Valid || report.Accounts == 0 || report.Transactions == 0 ||
+		len(report.BalanceDiscrepancies) != 0 ||
+		len(report.TransImbalances) != 0 {
+
+		test.Errorf(`Wrong integrity report: "%v".`, *report)
+	}
+}
@@ -1,5 +1,12 @@
 package wallet
 
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
 // AccountID describes account identification - account address (or unique key).
 type AccountID struct {
 	ID       string `json:"id"`
@@ -8,16 +15,433 @@ type AccountID struct {
 
 // Account represents account state in the system.
 type Account struct {
-	ID      AccountID `json:"id"`
-	Balance float64   `json:"balance"`
+	ID AccountID `json:"id"`
+	// Balance is the ledger balance, the sum of all executed transactions.
+	Balance Amount `json:"balance"`
+	// Held is the sum of active holds, which are reserved for future payments.
+	Held Amount `json:"held"`
+	// Status is the account lifecycle status.
+	Status AccountStatus `json:"status"`
+	// Overdraft is the credit line of the account, client payments could
+	// decrease the available balance down to the negative overdraft value.
+	Overdraft Amount `json:"overdraft"`
+}
+
+// Available returns the balance which could be used for new payments and
+// holds. The difference of amounts in range could not overflow, so it is not
+// checked.
+func (a Account) Available() Amount {
+	return Amount{value: a.Balance.value - a.Held.value}
+}
+
+// MarshalJSON implements json.Marshaler, it adds the available balance to the
+// account fields.
+func (a Account) MarshalJSON() ([]byte, error) {
+	type account Account
+	return json.Marshal(struct {
+		account
+		Available Amount `json:"available"`
+	}{account: account(a), Available: a.Available()})
+}
+
+// AccountStatus is the account lifecycle status. The zero value is the status
+// "active", so new accounts are active.
+type AccountStatus int
+
+const (
+	// AccountActive means that the account accepts all operations.
+	AccountActive AccountStatus = iota
+	// AccountFrozen means that the account is blocked, it could not be debited
+	// by clients till unfreezing, but it still could be credited.
+	AccountFrozen
+	// AccountClosed means that the account could not be modified anymore. Only
+	// account with zero balance and without holds could be closed.
+	AccountClosed
+)
+
+var accountStatusNames = []string{"active", "frozen", "closed"}
+
+func (s AccountStatus) String() string {
+	if s < 0 || int(s) >= len(accountStatusNames) {
+		return fmt.Sprintf("AccountStatus(%d)", int(s))
+	}
+	return accountStatusNames[s]
+}
+
+// ParseAccountStatus parses the account status name, like "frozen".
+func ParseAccountStatus(source string) (AccountStatus, error) {
+	for i, name := range accountStatusNames {
+		if name == source {
+			return AccountStatus(i), nil
+		}
+	}
+	return AccountActive, fmt.Errorf(`Unknown account status "%s"`, source)
+}
+
+// MarshalText implements encoding.TextMarshaler, the status is serialized by
+// its name.
+func (s AccountStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *AccountStatus) UnmarshalText(text []byte) error {
+	var err error
+	*s, err = ParseAccountStatus(string(text))
+	return err
+}
+
+// Value implements driver.Valuer, the status is stored by its name.
+func (s AccountStatus) Value() (driver.Value, error) { return s.String(), nil }
+
+// Scan implements sql.Scanner.
+func (s *AccountStatus) Scan(source interface{}) error {
+	var err error
+	switch value := source.(type) {
+	case []byte:
+		*s, err = ParseAccountStatus(string(value))
+	case string:
+		*s, err = ParseAccountStatus(value)
+	default:
+		err = fmt.Errorf(`Failed to scan account status from %T`, source)
+	}
+	return err
+}
+
+// AccountStatusChange is the stored change of the account status. Each change
+// is stored as a transaction without actions.
+type AccountStatusChange struct {
+	// TransID is the ID of the transaction which has changed the status.
+	TransID int `json:"trans_id"`
+	// Time is the change time in UTC.
+	Time time.Time `json:"time"`
+	// Author is the name of the change initiator.
+	Author string `json:"author"`
+	// Status is the new account status.
+	Status AccountStatus `json:"status"`
+}
+
+// Limits restricts outgoing client payments of the account. Daily and monthly
+// windows are calendar days and months in UTC, all debits of the account in
+// the window are counted. Zero value means that the limit is not set.
+type Limits struct {
+	// MaxPayment is the maximal amount of one payment.
+	MaxPayment Amount `json:"max_payment"`
+	// DailyAmount is the maximal sum of debits in a day.
+	DailyAmount Amount `json:"daily_amount"`
+	// MonthlyAmount is the maximal sum of debits in a month.
+	MonthlyAmount Amount `json:"monthly_amount"`
+	// DailyCount is the maximal number of debits in a day.
+	DailyCount int `json:"daily_count"`
+	// MonthlyCount is the maximal number of debits in a month.
+	MonthlyCount int `json:"monthly_count"`
 }
 
 // BalanceAction describes one iteration of account balance modification.
 type BalanceAction struct {
 	Account AccountID `json:"account"`
-	Volume  float64   `json:"volume"`
+	Volume  Amount    `json:"volume"`
+	// Fee is true if the action credits a fee account with the payment fee.
+	// Fee actions are added by executors, so a stored payment is reversed
+	// without them whatever fees are charged at the reversal time.
+	Fee bool `json:"fee,omitempty"`
 }
 
 // Trans is a bussiness transaction, an atomic set of balance modifications for
 // various accounts.
 type Trans = []BalanceAction
+
+// Transaction is a stored bussiness transaction with its identification and
+// origin.
+type Transaction struct {
+	ID int `json:"id"`
+	// Time is the transaction execution time in UTC.
+	Time time.Time `json:"time"`
+	// Author is the name of the transaction initiator.
+	Author  string `json:"author"`
+	Actions Trans  `json:"actions"`
+	// ReversalOf is the ID of the payment which is reversed by the
+	// transaction, or nil if the transaction is not a reversal.
+	ReversalOf *int `json:"reversal_of,omitempty"`
+}
+
+// HoldStatus is the state of the hold.
+type HoldStatus string
+
+const (
+	// HoldActive means that the hold amount is reserved on the account.
+	HoldActive HoldStatus = "active"
+	// HoldCaptured means that the hold is turned into a payment.
+	HoldCaptured HoldStatus = "captured"
+	// HoldReleased means that the hold amount is not reserved anymore.
+	HoldReleased HoldStatus = "released"
+	// HoldExpired means that the hold was not captured or released before the
+	// expiration time, the amount is not reserved anymore. Expired holds are
+	// stored as active, the status is set at reading.
+	HoldExpired HoldStatus = "expired"
+)
+
+// Hold is a reservation of funds on the account for the future payment to the
+// destination account. Active hold reduces the account available balance, but
+// not the ledger balance.
+type Hold struct {
+	ID          int        `json:"id"`
+	Account     AccountID  `json:"account"`
+	Destination AccountID  `json:"destination"`
+	Amount      Amount     `json:"amount"`
+	Author      string     `json:"author"`
+	Time        time.Time  `json:"time"`
+	Expiration  time.Time  `json:"expiration"`
+	Status      HoldStatus `json:"status"`
+	// ReleasedBy is the name of the release initiator, it is empty if the hold
+	// is not released.
+	ReleasedBy string `json:"released_by,omitempty"`
+}
+
+// isActive returns true if the hold is active and it is not expired at the
+// time.
+func (h Hold) isActive(now time.Time) bool {
+	return h.Status == HoldActive && h.Expiration.After(now)
+}
+
+// setActualStatus sets the status "expired" if the stored status is "active",
+// but the hold is expired at the time.
+func (h *Hold) setActualStatus(now time.Time) {
+	if h.Status == HoldActive && !h.isActive(now) {
+		h.Status = HoldExpired
+	}
+}
+
+// TransResult describes the executed business transaction.
+type TransResult struct {
+	// TransID is the ID of the stored transaction.
+	TransID int `json:"trans_id"`
+	// Accounts are states of the transaction accounts after the execution. The
+	// list is empty if the transaction was executed before with the same
+	// idempotency key, as accounts are not modified by the repeated call.
+	Accounts []Account `json:"accounts"`
+	// Fee is the fee action of the transaction, it is nil if the transaction
+	// is free or if it was executed before with the same idempotency key. It
+	// is not set for batch payments, which could have fees in several
+	// currencies, fee accounts of a batch are listed in Accounts.
+	Fee *BalanceAction `json:"fee,omitempty"`
+}
+
+// StatementItem describes one account balance modification in the account
+// statement.
+type StatementItem struct {
+	TransID int       `json:"trans_id"`
+	Time    time.Time `json:"time"`
+	Author  string    `json:"author"`
+	Volume  Amount    `json:"volume"`
+	// Counterparties are other accounts of the transaction.
+	Counterparties []AccountID `json:"counterparties"`
+	// Balance is the account balance after the modification.
+	Balance Amount `json:"balance"`
+	// ReversalOf is the ID of the reversed payment, see Transaction.
+	ReversalOf *int `json:"reversal_of,omitempty"`
+}
+
+// Statement describes account balance modifications for the time range.
+type Statement struct {
+	Account AccountID `json:"account"`
+	// From is the inclusive beginning of the time range, or zero time if the
+	// statement starts from the account opening.
+	From time.Time `json:"from"`
+	// To is the exclusive end of the time range.
+	To             time.Time       `json:"to"`
+	OpeningBalance Amount          `json:"opening_balance"`
+	Items          []StatementItem `json:"items"`
+	ClosingBalance Amount          `json:"closing_balance"`
+}
+
+// SchedulePeriod is the period of scheduled payments.
+type SchedulePeriod string
+
+const (
+	// ScheduleOnce means that the payment is executed only once, at the
+	// schedule start time.
+	ScheduleOnce SchedulePeriod = "once"
+	// ScheduleDaily means that the payment is executed each day.
+	ScheduleDaily SchedulePeriod = "daily"
+	// ScheduleWeekly means that the payment is executed each week.
+	ScheduleWeekly SchedulePeriod = "weekly"
+	// ScheduleMonthly means that the payment is executed each month at the
+	// same day of the month as the schedule start.
+	ScheduleMonthly SchedulePeriod = "monthly"
+)
+
+// Schedule is a standing order, the payment from the account to the
+// destination account, which is executed by the client policy at the start
+// time and then repeatedly with the period.
+type Schedule struct {
+	ID          int       `json:"id"`
+	Account     AccountID `json:"account"`
+	Destination AccountID `json:"destination"`
+	Amount      Amount    `json:"amount"`
+	// Author is the name of the schedule initiator, it is stored as the author
+	// of scheduled payments.
+	Author string         `json:"author"`
+	Start  time.Time      `json:"start"`
+	Period SchedulePeriod `json:"period"`
+	// Runs is the number of completed runs, each run is completed by the
+	// payment or by the last failed attempt.
+	Runs int `json:"runs"`
+	// Attempts is the number of failed attempts of the next run.
+	Attempts int `json:"attempts"`
+	// NextRun is the time of the next payment attempt, it is nil if the
+	// schedule has no runs anymore.
+	NextRun *time.Time `json:"next_run,omitempty"`
+}
+
+// GetRunTime returns the planned time of the run by its number, the first run
+// has the number 0. A monthly run is planned for the same day of the month as
+// the start, or for the last day of a shorter month.
+func (s Schedule) GetRunTime(run int) time.Time {
+	switch s.Period {
+	case ScheduleDaily:
+		return s.Start.AddDate(0, 0, run)
+	case ScheduleWeekly:
+		return s.Start.AddDate(0, 0, 7*run)
+	case ScheduleMonthly:
+		result := s.Start.AddDate(0, run, 0)
+		// AddDate normalizes the day, which is out of the month, to the next
+		// month, so it is turned back to the last day of the month.
+		if result.Day() != s.Start.Day() {
+			result = result.AddDate(0, 0, -result.Day())
+		}
+		return result
+	}
+	return s.Start
+}
+
+// ScheduleRun is the stored result of the scheduled payment attempt.
+type ScheduleRun struct {
+	ScheduleID int `json:"schedule_id"`
+	// Run is the number of the schedule run, see Schedule.GetRunTime.
+	Run int `json:"run"`
+	// Attempt is the attempt number of the run, starting from 1.
+	Attempt int `json:"attempt"`
+	// Time is the attempt time in UTC.
+	Time time.Time `json:"time"`
+	// TransID is the ID of the payment, it is nil if the attempt has failed.
+	TransID *int `json:"trans_id,omitempty"`
+	// Error is the description of the attempt failure.
+	Error string `json:"error,omitempty"`
+}
+
+// AdjustmentStatus is the state of the balance adjustment.
+type AdjustmentStatus string
+
+const (
+	// AdjustmentPending means that the adjustment waits for the decision of
+	// another manager.
+	AdjustmentPending AdjustmentStatus = "pending"
+	// AdjustmentApproved means that the adjustment is executed.
+	AdjustmentApproved AdjustmentStatus = "approved"
+	// AdjustmentRejected means that the adjustment is declined without the
+	// execution.
+	AdjustmentRejected AdjustmentStatus = "rejected"
+)
+
+// Adjustment is the account balance modification by the manager, which is
+// proposed by one manager and executed only after the approval of another
+// manager.
+type Adjustment struct {
+	ID      int       `json:"id"`
+	Account AccountID `json:"account"`
+	// Amount is the volume of the manager action, it is added to the account
+	// balance.
+	Amount Amount `json:"amount"`
+	// Proposer is the name of the adjustment initiator, it is stored as the
+	// author of the transaction.
+	Proposer string           `json:"proposer"`
+	Time     time.Time        `json:"time"`
+	Status   AdjustmentStatus `json:"status"`
+	// Checker is the name of the manager who approved or rejected the
+	// adjustment.
+	Checker string `json:"checker,omitempty"`
+	// DecisionTime is the time of the approval or the rejection.
+	DecisionTime *time.Time `json:"decision_time,omitempty"`
+	// TransID is the ID of the transaction of the approved adjustment.
+	TransID *int `json:"trans_id,omitempty"`
+}
+
+// JournalEntry is the stored transaction with the records which are linked to
+// it: the reversed payment, the account status change and the approved
+// adjustment. The entry hash covers the transaction and all its links.
+type JournalEntry struct {
+	Transaction
+	// Hash is the stored hash of the entry in the hash chain.
+	Hash string
+	// StatusAccount is the account which status is changed by the
+	// transaction, or nil if the transaction doesn't change a status.
+	StatusAccount *AccountID
+	// Status is the new status of StatusAccount.
+	Status AccountStatus
+	// AdjustmentID is the ID of the adjustment which is approved by the
+	// transaction, or nil if the transaction is not an adjustment.
+	AdjustmentID *int
+}
+
+// JournalReport is the result of the transaction journal verification. Each
+// transaction is stored with the hash of the previous transaction hash, the
+// transaction content and its links, so a modified, inserted or deleted
+// transaction, status change, reversal or adjustment link breaks the hash
+// chain. Adjustment proposals and decision details are not covered, only the
+// link of the approved adjustment to its transaction is.
+type JournalReport struct {
+	// Valid is true if all transactions match their hashes.
+	Valid bool `json:"valid"`
+	// Transactions is the number of verified transactions.
+	Transactions int `json:"transactions"`
+	// BrokenTransID is the ID of the first transaction which does not match
+	// its hash, it is nil if the journal is valid.
+	BrokenTransID *int `json:"broken_trans_id,omitempty"`
+	// Error describes the broken link.
+	Error string `json:"error,omitempty"`
+}
+
+// setBroken marks the report as invalid by the transaction.
+func (r *JournalReport) setBroken(
+	transID int, format string, args ...interface{}) {
+
+	r.Valid = false
+	r.BrokenTransID = &transID
+	r.Error = fmt.Sprintf(format, args...)
+}
+
+// BalanceCheck is the stored account balance and the sum of volumes of all
+// account actions, they have to be equal.
+type BalanceCheck struct {
+	Account AccountID `json:"account"`
+	Balance Amount    `json:"balance"`
+	// ActionSum is the account balance recomputed from actions.
+	ActionSum Amount `json:"action_sum"`
+}
+
+// TransImbalance is the net volume of the client transaction in the
+// currency, which has to be zero as client transactions only move funds
+// between accounts.
+type TransImbalance struct {
+	TransID  int    `json:"trans_id"`
+	Currency string `json:"currency"`
+	Net      Amount `json:"net"`
+}
+
+// IntegrityReport is the result of the ledger integrity check. Account
+// balances are stored separately from actions, so they are recomputed from
+// actions and compared.
+type IntegrityReport struct {
+	// Valid is true if there are no discrepancies.
+	Valid bool `json:"valid"`
+	// Accounts is the number of checked accounts.
+	Accounts int `json:"accounts"`
+	// Transactions is the number of checked transactions.
+	Transactions int `json:"transactions"`
+	// BalanceDiscrepancies are accounts with a balance which differs from the
+	// sum of account action volumes.
+	BalanceDiscrepancies []BalanceCheck `json:"balance_discrepancies"`
+	// TransImbalances are client transactions which don't net to zero.
+	TransImbalances []TransImbalance `json:"trans_imbalances"`
+}
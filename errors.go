@@ -0,0 +1,115 @@
+package wallet
+
+import "fmt"
+
+// Error is a wallet error with the code of the error kind. Errors of the same
+// kind have the same code, but could have various messages, so errors have to
+// be compared by IsError.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Is returns true if the target error has the same kind, it allows to compare
+// wallet errors by errors.Is.
+func (e *Error) Is(target error) bool { return IsError(e, target) }
+
+var (
+	// ErrAccountNotFound means that the requested account doesn't exist.
+	ErrAccountNotFound = &Error{
+		Code: "account_not_found", Message: "Account not found"}
+	// ErrAccountExists means that the account could not be created as it
+	// already exists.
+	ErrAccountExists = &Error{
+		Code: "account_exists", Message: "Account already exists"}
+	// ErrInsufficientFunds means that the account does not have enough funds
+	// for the operation.
+	ErrInsufficientFunds = &Error{
+		Code: "insufficient_funds", Message: "Insufficient funds"}
+	// ErrCurrencyMismatch means that account currencies are not allowed for the
+	// operation.
+	ErrCurrencyMismatch = &Error{
+		Code: "currency_mismatch", Message: "Currency mismatch"}
+	// ErrInvalidAmount means that the amount is not allowed for the currency or
+	// for the operation.
+	ErrInvalidAmount = &Error{
+		Code: "invalid_amount", Message: "Invalid amount"}
+	// ErrInvalidTrans means that the transaction actions are not allowed by the
+	// executor policy.
+	ErrInvalidTrans = &Error{
+		Code: "invalid_transaction", Message: "Invalid transaction"}
+	// ErrNoExchangeRate means that there is no exchange rate for the currency
+	// pair.
+	ErrNoExchangeRate = &Error{
+		Code: "no_exchange_rate", Message: "No exchange rate"}
+	// ErrIdempotencyKeyConflict means that the idempotency key is already used
+	// for another transaction.
+	ErrIdempotencyKeyConflict = &Error{
+		Code:    "idempotency_key_conflict",
+		Message: "Idempotency key is already used"}
+	// ErrInvalidQuery means that the query has inconsistent arguments.
+	ErrInvalidQuery = &Error{
+		Code: "invalid_query", Message: "Invalid query"}
+	// ErrTransNotFound means that the requested transaction doesn't exist.
+	ErrTransNotFound = &Error{
+		Code: "transaction_not_found", Message: "Transaction not found"}
+	// ErrHoldNotFound means that the requested hold doesn't exist.
+	ErrHoldNotFound = &Error{Code: "hold_not_found", Message: "Hold not found"}
+	// ErrHoldNotActive means that the hold is already captured, released or
+	// expired.
+	ErrHoldNotActive = &Error{
+		Code: "hold_not_active", Message: "Hold is not active"}
+	// ErrAccountNotActive means that the account status does not allow the
+	// operation, as the account is frozen or closed.
+	ErrAccountNotActive = &Error{
+		Code: "account_not_active", Message: "Account is not active"}
+	// ErrInvalidStatusChange means that the account status could not be
+	// changed to the requested status.
+	ErrInvalidStatusChange = &Error{
+		Code: "invalid_status_change", Message: "Invalid status change"}
+	// ErrLimitExceeded means that the payment exceeds the account limits.
+	ErrLimitExceeded = &Error{
+		Code: "limit_exceeded", Message: "Limit exceeded"}
+	// ErrScheduleNotFound means that the requested payment schedule doesn't
+	// exist.
+	ErrScheduleNotFound = &Error{
+		Code: "schedule_not_found", Message: "Schedule not found"}
+	// ErrInvalidSchedule means that the payment schedule plan is not allowed.
+	ErrInvalidSchedule = &Error{
+		Code: "invalid_schedule", Message: "Invalid schedule"}
+	// ErrScheduleRunCompleted means that the schedule run is already completed,
+	// or the schedule was changed during the run.
+	ErrScheduleRunCompleted = &Error{
+		Code:    "schedule_run_completed",
+		Message: "Schedule run is already completed"}
+	// ErrAdjustmentNotFound means that the requested balance adjustment
+	// doesn't exist.
+	ErrAdjustmentNotFound = &Error{
+		Code: "adjustment_not_found", Message: "Adjustment not found"}
+	// ErrAdjustmentNotPending means that the balance adjustment is already
+	// approved or rejected.
+	ErrAdjustmentNotPending = &Error{
+		Code: "adjustment_not_pending", Message: "Adjustment is not pending"}
+	// ErrSelfApproval means that the manager decides on the balance adjustment
+	// which was proposed by the same manager.
+	ErrSelfApproval = &Error{
+		Code: "self_approval", Message: "Self-approval is not allowed"}
+)
+
+// IsError returns true if the error is a wallet error of the same kind as the
+// error kind.
+func IsError(err error, kind error) bool {
+	source, isWalletError := err.(*Error)
+	if !isWalletError || source == nil {
+		return false
+	}
+	target, isWalletError := kind.(*Error)
+	return isWalletError && target != nil && source.Code == target.Code
+}
+
+// newError creates an error of the kind with the specific message.
+func newError(kind *Error, format string, args ...interface{}) error {
+	return &Error{Code: kind.Code, Message: fmt.Sprintf(format, args...)}
+}
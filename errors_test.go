@@ -0,0 +1,34 @@
+package wallet_test
+
+import (
+	"errors"
+	"testing"
+
+	w "github.com/palchukovsky/wallet"
+)
+
+// Test_Error tests wallet error kinds.
+func Test_Error(test *testing.T) {
+	err := w.NewAmount(1, 3).CheckCurrency("USD")
+	if !w.IsError(err, w.ErrInvalidAmount) {
+		test.Errorf(`Wrong error kind: "%v".`, err)
+	}
+	if w.IsError(err, w.ErrAccountNotFound) {
+		test.Errorf(`Wrong error kind: "%v".`, err)
+	}
+	if err.Error() == w.ErrInvalidAmount.Error() {
+		test.Errorf(`Error has no details: "%v".`, err)
+	}
+	if walletErr, isWalletErr := err.(*w.Error); !isWalletErr ||
+		walletErr.Code != "invalid_amount" || !walletErr.Is(w.ErrInvalidAmount) {
+
+		test.Errorf(`Wrong error: "%v".`, err)
+	}
+
+	if w.IsError(errors.New("Invalid amount"), w.ErrInvalidAmount) ||
+		w.IsError(nil, w.ErrInvalidAmount) ||
+		w.IsError(err, errors.New("Invalid amount")) {
+
+		test.Error("Not wallet error has a kind.")
+	}
+}
@@ -1,48 +1,372 @@
 package wallet
 
-import (
-	"errors"
-	"fmt"
-)
+import "time"
 
 // Executor executes account modifications by implementation rules.
 type Executor interface {
 	// Close closes executor and frees resources.
 	Close()
-	// Execute accepts and executes a business transaction. Returns the actual
-	// state of accounts that were affected at success.
-	Execute(trans Trans, repo Repo) ([]Account, error)
+	// Execute accepts and executes a business transaction from the author.
+	// Returns the stored transaction ID and the actual state of accounts that
+	// were affected at success. Not empty idempotency key makes the execution
+	// idempotent, see Repo.Modify.
+	Execute(
+		trans Trans,
+		author string,
+		idempotencyKey string,
+		repo Repo) (*TransResult, error)
+}
+
+// partyChecker is an executor which does not allow service accounts, like fee
+// and house accounts, to be payment parties.
+type partyChecker interface {
+	// checkParties returns ErrInvalidTrans if the transaction has an action of
+	// a service account.
+	checkParties(trans Trans) error
+}
+
+// checkAccountStatus returns ErrAccountNotActive if the account status does
+// not allow the action by client policies: a frozen account could not be
+// debited, a closed account could not be modified.
+func checkAccountStatus(account *Account, volume Amount) error {
+	switch {
+	case account.Status == AccountClosed:
+		return newAccountClosedError(account.ID)
+	case account.Status == AccountFrozen && volume.IsNegative():
+		return newError(ErrAccountNotActive, `Account "%s" (%s) is frozen`,
+			account.ID.ID, account.ID.Currency)
+	}
+	return nil
+}
+
+// checkFunds returns ErrInsufficientFunds if the available balance of the
+// account is below the account overdraft.
+func checkFunds(account *Account) error {
+	// The sum of amounts in range could not overflow, so it is not checked.
+	if account.Available().value+account.Overdraft.value < 0 {
+		return newError(ErrInsufficientFunds,
+			`Account "%s" (%s) does not have enough funds`,
+			account.ID.ID, account.ID.Currency)
+	}
+	return nil
+}
+
+// replayResult returns the Modify replay callback which sets the result of the
+// stored transaction like the first execution: accounts of the transaction in
+// the order of its actions and, if hasFee is true, the stored fee action.
+func replayResult(
+	result *TransResult,
+	trans Trans,
+	hasFee bool) func(stored Trans, repoTrans RepoTrans) error {
+
+	return func(stored Trans, repoTrans RepoTrans) error {
+		result.Accounts = make([]Account, 0, len(trans))
+		for _, action := range trans {
+			account, err := repoTrans.GetAccount(action.Account)
+			if err != nil {
+				return err
+			}
+			result.Accounts = append(result.Accounts, *account)
+		}
+		if !hasFee {
+			return nil
+		}
+		for _, action := range stored {
+			if action.Fee {
+				fee := action
+				result.Fee = &fee
+			}
+		}
+		return nil
+	}
+}
+
+// newAccountClosedError creates an error about the closed account.
+func newAccountClosedError(id AccountID) error {
+	return newError(ErrAccountNotActive, `Account "%s" (%s) is closed`,
+		id.ID, id.Currency)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 
-type clientExecutor struct{}
+// clientFees charges fees of client payments by the fee policy to fee
+// accounts, the zero value charges no fees.
+type clientFees struct {
+	fees         FeePolicy
+	feeAccountID string
+}
+
+func (f *clientFees) isFeeAccount(id AccountID) bool {
+	return f.fees != nil && id.ID == f.feeAccountID
+}
+
+// checkParties returns ErrInvalidTrans if the transaction has an action of
+// a fee account or an action marked as a fee.
+func (f *clientFees) checkParties(trans Trans) error {
+	for _, action := range trans {
+		if action.Fee {
+			return newError(ErrInvalidTrans,
+				`Action of account "%s" (%s) could not be marked as a fee`,
+				action.Account.ID, action.Account.Currency)
+		}
+		if f.isFeeAccount(action.Account) {
+			return newError(ErrInvalidTrans,
+				`Fee account "%s" could not be a payment party`, f.feeAccountID)
+		}
+	}
+	return nil
+}
+
+// calcFee returns the fee action for the paid amount in the currency, or nil
+// if the payment is free.
+func (f *clientFees) calcFee(
+	amount Amount, currency string) (*BalanceAction, error) {
+
+	if f.fees == nil {
+		return nil, nil
+	}
+	if err := amount.CheckCurrency(currency); err != nil {
+		return nil, err
+	}
+	fee, err := f.fees.GetFee(amount, currency)
+	if err != nil {
+		return nil, err
+	}
+	if !fee.IsPositive() {
+		return nil, nil
+	}
+	return &BalanceAction{
+			Account: AccountID{ID: f.feeAccountID, Currency: currency},
+			Volume:  fee,
+			Fee:     true},
+		nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type clientExecutor struct{ clientFees }
 
 // CreateClientExecutor creates executor with policy for normal client. The
 // policy allows to move funds only from one account to another. The policy does
-// not allow to decrease account balance to the negative value but allows to add
-// funds, even if the final result still is negative. It also does not allow to
-// execute a transaction with various currencies in the action list - currency
-// must be only one.
+// not allow to decrease account available balance below the negative account
+// overdraft but allows to add funds, even if the final result still is below
+// it. It also does not allow to execute a transaction with various currencies
+// in the action list - currency must be only one. Payments which exceed limits
+// of the source account are not allowed too. Frozen accounts could not be
+// debited and closed accounts could not be modified by this and other client
+// policies.
 func CreateClientExecutor() Executor { return &clientExecutor{} }
 
+// CreateClientExecutorWithFees creates executor with the normal client policy,
+// which also charges the payment fee by the fee policy. The fee is paid by the
+// source account in addition to the payment amount, it is moved to the fee
+// account in the payment currency by the same transaction. Fee accounts have
+// the same ID for each currency, they have to exist and they could not be
+// payment parties.
+func CreateClientExecutorWithFees(
+	fees FeePolicy, feeAccountID string) Executor {
+
+	return &clientExecutor{
+		clientFees: clientFees{fees: fees, feeAccountID: feeAccountID}}
+}
+
 func (e clientExecutor) Close() {}
 
-func (e *clientExecutor) Execute(trans Trans, repo Repo) ([]Account, error) {
+func (e *clientExecutor) Execute(
+	trans Trans,
+	author string,
+	idempotencyKey string,
+	repo Repo) (*TransResult, error) {
+
 	if len(trans) != 2 {
 		return nil,
-			errors.New(
-				"The transaction is not a transaction" +
+			newError(ErrInvalidTrans,
+				"The transaction is not a transaction"+
 					" to move funds from one account to another")
 	}
-	var result []Account
-	return result,
-		repo.Modify(trans, "client",
-			func(repoTrans RepoTrans) error {
+	fee, err := e.getFee(trans)
+	if err != nil {
+		return nil, err
+	}
+	// The fee is a part of the stored transaction, so the repeated call with
+	// the same idempotency key is accepted only if the fee is the same.
+	fullTrans := trans
+	if fee != nil {
+		fullTrans = Trans{trans[0], trans[1], *fee}
+		for i, action := range trans {
+			if !action.Volume.IsNegative() {
+				continue
+			}
+			fullTrans[i].Volume, err = action.Volume.Sub(fee.Volume)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	result := &TransResult{Accounts: []Account{}}
+	result.TransID, err = repo.Modify(fullTrans, author, idempotencyKey,
+		func(repoTrans RepoTrans) error {
+			var feeAccount *Account
+			if fee != nil {
 				var err error
-				result, err = e.execTrans(trans, repoTrans)
+				feeAccount, err = e.chargeFee(trans, *fee, repoTrans)
+				if err != nil {
+					return err
+				}
+			}
+			var err error
+			result.Accounts, err = e.execTrans(trans, repoTrans)
+			if err != nil {
 				return err
-			})
+			}
+			if err = e.checkLimits(trans, fee, repoTrans); err != nil {
+				return err
+			}
+			if feeAccount == nil {
+				return nil
+			}
+			result.Accounts = append(result.Accounts, *feeAccount)
+			result.Fee = fee
+			return nil
+		},
+		replayResult(result, fullTrans, true))
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// getFee returns the fee action for the payment, or nil if the payment is free.
+func (e *clientExecutor) getFee(trans Trans) (*BalanceAction, error) {
+	if err := e.checkParties(trans); err != nil {
+		return nil, err
+	}
+	// Other checks are made after accounts locking, the fee is calculated only
+	// for the well-formed payment.
+	src, dst := trans[0], trans[1]
+	if src.Volume.IsPositive() {
+		src, dst = dst, src
+	}
+	amount := dst.Volume
+	if !amount.IsPositive() ||
+		src.Volume != amount.Neg() ||
+		src.Account.Currency != dst.Account.Currency {
+
+		return nil, nil
+	}
+	return e.calcFee(amount, dst.Account.Currency)
+}
+
+// chargeFee moves the fee from the payment source account to the fee account.
+// Returns the new state of the fee account. The source account available
+// balance is checked with the payment amount.
+func (*clientExecutor) chargeFee(
+	trans Trans, fee BalanceAction, repoTrans RepoTrans) (*Account, error) {
+
+	srcID := trans[0].Account
+	if trans[0].Volume.IsPositive() {
+		srcID = trans[1].Account
+	}
+	src, err := repoTrans.GetAccount(srcID)
+	if err != nil {
+		return nil, err
+	}
+	feeAccount, err := repoTrans.GetAccount(fee.Account)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAccountStatus(feeAccount, fee.Volume); err != nil {
+		return nil, err
+	}
+	src.Balance, err = src.Balance.Sub(fee.Volume)
+	if err != nil {
+		return nil, err
+	}
+	feeAccount.Balance, err = feeAccount.Balance.Add(fee.Volume)
+	if err != nil {
+		return nil, err
+	}
+	return feeAccount, nil
+}
+
+// checkLimits returns ErrLimitExceeded if the payment exceeds limits of the
+// source account.
+func (*clientExecutor) checkLimits(
+	trans Trans, fee *BalanceAction, repoTrans RepoTrans) error {
+
+	src := trans[0]
+	if src.Volume.IsPositive() {
+		src = trans[1]
+	}
+	amount := src.Volume.Neg()
+	debit := amount
+	if fee != nil {
+		var err error
+		if debit, err = debit.Add(fee.Volume); err != nil {
+			return err
+		}
+	}
+	return checkLimits(src.Account, amount, debit, repoTrans)
+}
+
+// checkLimits returns ErrLimitExceeded if the payment from the account exceeds
+// its limits. The maximal payment amount is compared with the payment amount,
+// window limits are compared with the debit including the fee.
+func checkLimits(
+	account AccountID, amount, debit Amount, repoTrans RepoTrans) error {
+
+	limits, err := repoTrans.GetLimits(account)
+	if err != nil {
+		return err
+	}
+	if !limits.MaxPayment.IsZero() && amount.Cmp(limits.MaxPayment) > 0 {
+		return newError(ErrLimitExceeded,
+			`Payment amount %s exceeds the maximal payment amount %s`+
+				` of account "%s" (%s)`,
+			amount, limits.MaxPayment, account.ID, account.Currency)
+	}
+
+	year, month, day := time.Now().UTC().Date()
+	for _, window := range []struct {
+		name   string
+		since  time.Time
+		amount Amount
+		count  int
+	}{
+		{
+			name:   "daily",
+			since:  time.Date(year, month, day, 0, 0, 0, 0, time.UTC),
+			amount: limits.DailyAmount,
+			count:  limits.DailyCount},
+		{
+			name:   "monthly",
+			since:  time.Date(year, month, 1, 0, 0, 0, 0, time.UTC),
+			amount: limits.MonthlyAmount,
+			count:  limits.MonthlyCount}} {
+
+		if window.amount.IsZero() && window.count == 0 {
+			continue
+		}
+		sum, count, err := repoTrans.GetDebits(account, window.since)
+		if err != nil {
+			return err
+		}
+		if sum, err = sum.Add(debit); err != nil {
+			return err
+		}
+		if !window.amount.IsZero() && sum.Cmp(window.amount) > 0 {
+			return newError(ErrLimitExceeded,
+				`Payment exceeds the %s amount limit %s of account "%s" (%s)`,
+				window.name, window.amount, account.ID, account.Currency)
+		}
+		if window.count != 0 && count >= window.count {
+			return newError(ErrLimitExceeded,
+				`Payment exceeds the %s payment count limit %d`+
+					` of account "%s" (%s)`,
+				window.name, window.count, account.ID, account.Currency)
+		}
+	}
+	return nil
 }
 
 func (*clientExecutor) execTrans(
@@ -55,38 +379,51 @@ func (*clientExecutor) execTrans(
 			prevTrans := transData[i-1]
 			if prevTrans.Account == action.Account {
 				return nil,
-					fmt.Errorf(`Transaction has only one account "%s" (%s)`,
+					newError(ErrInvalidTrans,
+						`Transaction has only one account "%s" (%s)`,
 						action.Account.ID, action.Account.Currency)
 			}
 			if prevTrans.Account.Currency != action.Account.Currency {
 				return nil,
-					fmt.Errorf(`Account "%s" (%s) has a different currency from "%s"`,
+					newError(ErrCurrencyMismatch,
+						`Account "%s" (%s) has a different currency from "%s"`,
 						action.Account.ID, action.Account.Currency,
 						prevTrans.Account.Currency)
 			}
-			if (prevTrans.Volume < 0) == (action.Volume < 0) ||
-				prevTrans.Volume != action.Volume*-1 {
+			if prevTrans.Volume.IsNegative() == action.Volume.IsNegative() ||
+				prevTrans.Volume != action.Volume.Neg() {
 
 				return nil,
-					errors.New("Transaction does not move" +
-						" the same volume of funds for each account")
+					newError(ErrInvalidTrans,
+						"Transaction does not move"+
+							" the same volume of funds for each account")
 			}
 		}
 
+		if err := action.Volume.CheckCurrency(action.Account.Currency); err != nil {
+			return nil, err
+		}
+
 		account, err := repoTrans.GetAccount(action.Account)
 		if err != nil {
 			return nil, err
 		}
+		if err := checkAccountStatus(account, action.Volume); err != nil {
+			return nil, err
+		}
 
-		account.Balance += action.Volume
+		account.Balance, err = account.Balance.Add(action.Volume)
+		if err != nil {
+			return nil, err
+		}
 
-		// The policy does not allow to decrease account balance to the negative
-		// value but allows to add funds, even if the final result still is
-		// negative.
-		if account.Balance < 0 && action.Volume < 0 {
-			return nil,
-				fmt.Errorf(`Account "%s" (%s) does not have enough funds`,
-					account.ID.ID, action.Account.Currency)
+		// The policy does not allow to decrease account available balance below
+		// the negative overdraft but allows to add funds, even if the final
+		// result still is below it. Held funds are reserved for other payments.
+		if action.Volume.IsNegative() {
+			if err := checkFunds(account); err != nil {
+				return nil, err
+			}
 		}
 
 		result = append(result, *account)
@@ -105,27 +442,397 @@ func CreateManagerExecutor() Executor { return &managerExecutor{} }
 func (e managerExecutor) Close() {}
 
 func (e *managerExecutor) Execute(
-	trans Trans, repo Repo) ([]Account, error) {
+	trans Trans,
+	author string,
+	idempotencyKey string,
+	repo Repo) (*TransResult, error) {
 
-	result := []Account{}
-	err := repo.Modify(
+	result := &TransResult{Accounts: []Account{}}
+	var err error
+	result.TransID, err = repo.Modify(
 		trans,
-		"manager",
+		author,
+		idempotencyKey,
 		func(repoTrans RepoTrans) error {
 			for _, action := range trans {
+				if action.Fee {
+					return newError(ErrInvalidTrans,
+						`Action of account "%s" (%s) could not be marked as a fee`,
+						action.Account.ID, action.Account.Currency)
+				}
+				err := action.Volume.CheckCurrency(action.Account.Currency)
+				if err != nil {
+					return err
+				}
 				account, err := repoTrans.GetAccount(action.Account)
 				if err != nil {
 					return err
 				}
-				account.Balance += action.Volume
-				result = append(result, *account)
+				// The manager could modify a frozen account, but a closed account
+				// has to keep zero balance.
+				if account.Status == AccountClosed {
+					return newAccountClosedError(account.ID)
+				}
+				account.Balance, err = account.Balance.Add(action.Volume)
+				if err != nil {
+					return err
+				}
+				result.Accounts = append(result.Accounts, *account)
 			}
 			return nil
-		})
+		},
+		replayResult(result, trans, false))
 	if err != nil {
-		return []Account{}, err
+		return nil, err
 	}
 	return result, nil
 }
 
 ////////////////////////////////////////////////////////////////////////////////
+
+type conversionExecutor struct {
+	clientFees
+	rates          RateProvider
+	houseAccountID string
+}
+
+// CreateConversionExecutor creates executor with policy for client payments
+// between accounts in different currencies. The executor accepts a transaction
+// of two actions: the source action with negative volume in the source
+// currency and the destination action with zero volume, the destination
+// volume is calculated by the exchange rate from the rate provider and is
+// rounded towards zero. The executor stores a transaction with four actions,
+// so each currency is balanced: the source account sends funds to the house
+// account in the source currency, the house account in the destination
+// currency sends converted funds to the destination account. House accounts
+// have the same ID for each currency, they have to exist and they are allowed
+// to have a negative balance. The source account is not allowed to have an
+// available balance below its negative overdraft after the payment.
+func CreateConversionExecutor(
+	rates RateProvider, houseAccountID string) Executor {
+
+	return &conversionExecutor{rates: rates, houseAccountID: houseAccountID}
+}
+
+// CreateConversionExecutorWithFees creates executor with the cross-currency
+// payment policy, which also charges the payment fee by the fee policy as the
+// client policy with fees does. The fee is calculated for the source amount in
+// the source currency, it is paid by the source account in addition to the
+// amount and it is moved to the fee account by the fifth action.
+func CreateConversionExecutorWithFees(
+	rates RateProvider,
+	houseAccountID string,
+	fees FeePolicy,
+	feeAccountID string) Executor {
+
+	return &conversionExecutor{
+		clientFees:     clientFees{fees: fees, feeAccountID: feeAccountID},
+		rates:          rates,
+		houseAccountID: houseAccountID}
+}
+
+func (e conversionExecutor) Close() {}
+
+// checkParties returns ErrInvalidTrans if the transaction has an action of
+// the house account or of a fee account.
+func (e *conversionExecutor) checkParties(trans Trans) error {
+	for _, action := range trans {
+		if action.Account.ID == e.houseAccountID {
+			return newError(ErrInvalidTrans,
+				`House account "%s" could not be a payment party`,
+				e.houseAccountID)
+		}
+	}
+	return e.clientFees.checkParties(trans)
+}
+
+func (e *conversionExecutor) Execute(
+	trans Trans,
+	author string,
+	idempotencyKey string,
+	repo Repo) (*TransResult, error) {
+
+	if len(trans) != 2 {
+		return nil,
+			newError(ErrInvalidTrans,
+				"The transaction is not a transaction"+
+					" to move funds from one account to another")
+	}
+	src := trans[0]
+	dst := trans[1]
+	if src.Account.Currency == dst.Account.Currency {
+		return nil,
+			newError(ErrCurrencyMismatch,
+				`Account "%s" (%s) has the same currency as "%s"`,
+				dst.Account.ID, dst.Account.Currency, src.Account.ID)
+	}
+	if !src.Volume.IsNegative() || !dst.Volume.IsZero() {
+		return nil,
+			newError(ErrInvalidTrans,
+				"Conversion transaction has to have negative source volume"+
+					" and zero destination volume")
+	}
+	if err := e.checkParties(trans); err != nil {
+		return nil, err
+	}
+	if err := src.Volume.CheckCurrency(src.Account.Currency); err != nil {
+		return nil, err
+	}
+	fee, err := e.calcFee(src.Volume.Neg(), src.Account.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	// The rate is requested before accounts locking as it could take time.
+	rate, err := e.rates.GetRate(
+		CurrencyPair{From: src.Account.Currency, To: dst.Account.Currency})
+	if err != nil {
+		return nil, err
+	}
+	dstVolume, err := src.Volume.Neg().Convert(
+		rate, GetCurrencyScale(dst.Account.Currency))
+	if err != nil {
+		return nil, err
+	}
+	if !dstVolume.IsPositive() {
+		return nil,
+			newError(ErrInvalidAmount,
+				`Amount %s (%s) is too small to convert to "%s"`,
+				src.Volume.Neg(), src.Account.Currency, dst.Account.Currency)
+	}
+
+	houseDst := AccountID{ID: e.houseAccountID, Currency: dst.Account.Currency}
+	fullTrans := Trans{
+		src,
+		BalanceAction{
+			Account: AccountID{
+				ID: e.houseAccountID, Currency: src.Account.Currency},
+			Volume: src.Volume.Neg()},
+		BalanceAction{Account: houseDst, Volume: dstVolume.Neg()},
+		BalanceAction{Account: dst.Account, Volume: dstVolume}}
+	if fee != nil {
+		fullTrans[0].Volume, err = src.Volume.Sub(fee.Volume)
+		if err != nil {
+			return nil, err
+		}
+		fullTrans = append(fullTrans, *fee)
+	}
+
+	// Destination volumes depend on the actual rate, so they are not compared
+	// by the idempotency key check.
+	result := &TransResult{Accounts: []Account{}}
+	result.TransID, err = repo.CalculateVolumes(houseDst, dst.Account).Modify(
+		fullTrans, author, idempotencyKey,
+		func(repoTrans RepoTrans) error {
+			result.Accounts = make([]Account, 0, len(fullTrans))
+			for _, action := range fullTrans {
+				account, err := repoTrans.GetAccount(action.Account)
+				if err != nil {
+					return err
+				}
+				if err := checkAccountStatus(account, action.Volume); err != nil {
+					return err
+				}
+				account.Balance, err = account.Balance.Add(action.Volume)
+				if err != nil {
+					return err
+				}
+				if action.Account == src.Account {
+					if err := checkFunds(account); err != nil {
+						return err
+					}
+				}
+				result.Accounts = append(result.Accounts, *account)
+			}
+			err := checkLimits(
+				src.Account, src.Volume.Neg(), fullTrans[0].Volume.Neg(), repoTrans)
+			if err != nil {
+				return err
+			}
+			result.Fee = fee
+			return nil
+		},
+		replayResult(result, fullTrans, true))
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type batchExecutor struct {
+	clientFees
+	// isReversal exempts debits from limits, as reversals are not payments.
+	isReversal bool
+}
+
+// CreateBatchExecutor creates executor with policy for client payments between
+// several accounts, like one debit to many credits, in one atomic transaction.
+// Each account could have only one action with not zero volume, the sum of
+// volumes has to be zero for each currency. The policy does not allow to
+// decrease available balance of any debited account below its negative
+// overdraft.
+func CreateBatchExecutor() Executor { return &batchExecutor{} }
+
+// createReversalExecutor creates executor with the batch payment policy for
+// reversals, which are free and not checked by limits.
+func createReversalExecutor() Executor {
+	return &batchExecutor{isReversal: true}
+}
+
+// CreateBatchExecutorWithFees creates executor with the batch payment policy,
+// which also charges fees by the fee policy as the client policy with fees
+// does. Each debit of the batch is charged as a payment of the debited amount,
+// the fee is paid by the debited account in addition to the amount. Fees are
+// moved to fee accounts by actions after the batch actions, one action for
+// each currency.
+func CreateBatchExecutorWithFees(
+	fees FeePolicy, feeAccountID string) Executor {
+
+	return &batchExecutor{
+		clientFees: clientFees{fees: fees, feeAccountID: feeAccountID}}
+}
+
+func (e batchExecutor) Close() {}
+
+func (e *batchExecutor) Execute(
+	trans Trans,
+	author string,
+	idempotencyKey string,
+	repo Repo) (*TransResult, error) {
+
+	if err := e.checkTrans(trans); err != nil {
+		return nil, err
+	}
+	fullTrans, err := e.addFees(trans)
+	if err != nil {
+		return nil, err
+	}
+	result := &TransResult{Accounts: []Account{}}
+	result.TransID, err = repo.Modify(fullTrans, author, idempotencyKey,
+		func(repoTrans RepoTrans) error {
+			result.Accounts = make([]Account, 0, len(fullTrans))
+			for _, action := range fullTrans {
+				account, err := repoTrans.GetAccount(action.Account)
+				if err != nil {
+					return err
+				}
+				if err := checkAccountStatus(account, action.Volume); err != nil {
+					return err
+				}
+				account.Balance, err = account.Balance.Add(action.Volume)
+				if err != nil {
+					return err
+				}
+				if action.Volume.IsNegative() {
+					if err := checkFunds(account); err != nil {
+						return err
+					}
+				}
+				result.Accounts = append(result.Accounts, *account)
+			}
+			// Each debit is a payment from the account, fee actions follow the
+			// batch actions.
+			for i, action := range trans {
+				if e.isReversal || !action.Volume.IsNegative() {
+					continue
+				}
+				err := checkLimits(action.Account, action.Volume.Neg(),
+					fullTrans[i].Volume.Neg(), repoTrans)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		replayResult(result, fullTrans, false))
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// addFees returns the batch with debits increased by fees and with actions of
+// fee accounts.
+func (e *batchExecutor) addFees(trans Trans) (Trans, error) {
+	if err := e.checkParties(trans); err != nil {
+		return nil, err
+	}
+	result := make(Trans, len(trans))
+	copy(result, trans)
+	fees := map[string]*BalanceAction{}
+	currencies := []string{}
+	for i, action := range trans {
+		if !action.Volume.IsNegative() {
+			continue
+		}
+		fee, err := e.calcFee(action.Volume.Neg(), action.Account.Currency)
+		if err != nil {
+			return nil, err
+		}
+		if fee == nil {
+			continue
+		}
+		result[i].Volume, err = action.Volume.Sub(fee.Volume)
+		if err != nil {
+			return nil, err
+		}
+		currency := action.Account.Currency
+		if sum, has := fees[currency]; has {
+			sum.Volume, err = sum.Volume.Add(fee.Volume)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		fees[currency] = fee
+		currencies = append(currencies, currency)
+	}
+	for _, currency := range currencies {
+		result = append(result, *fees[currency])
+	}
+	return result, nil
+}
+
+// checkTrans checks the batch before accounts locking.
+func (*batchExecutor) checkTrans(trans Trans) error {
+	if len(trans) < 2 {
+		return newError(ErrInvalidTrans,
+			"Batch has to have at least two actions")
+	}
+	accounts := map[AccountID]interface{}{}
+	sums := map[string]Amount{}
+	for _, action := range trans {
+		if _, has := accounts[action.Account]; has {
+			return newError(ErrInvalidTrans,
+				`Batch has several actions for account "%s" (%s)`,
+				action.Account.ID, action.Account.Currency)
+		}
+		accounts[action.Account] = nil
+		if action.Volume.IsZero() {
+			return newError(ErrInvalidTrans,
+				`Batch action for account "%s" (%s) has zero volume`,
+				action.Account.ID, action.Account.Currency)
+		}
+		err := action.Volume.CheckCurrency(action.Account.Currency)
+		if err != nil {
+			return err
+		}
+		sums[action.Account.Currency], err =
+			sums[action.Account.Currency].Add(action.Volume)
+		if err != nil {
+			return err
+		}
+	}
+	for currency, sum := range sums {
+		if !sum.IsZero() {
+			return newError(ErrInvalidTrans,
+				`Batch volumes in "%s" are not balanced, the sum is %s`,
+				currency, sum)
+		}
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -3,7 +3,7 @@ package wallet_test
 import (
 	"errors"
 	"fmt"
-	"strconv"
+	"math/big"
 	"testing"
 
 	"github.com/golang/mock/gomock"
@@ -19,18 +19,21 @@ func Test_Executor_Manager_Success(test *testing.T) {
 
 	trans := []w.BalanceAction{
 		w.BalanceAction{
-			Account: w.AccountID{ID: "0", Currency: "USD"}, Volume: 1},
+			Account: w.AccountID{ID: "0", Currency: "USD"}, Volume: w.NewAmount(1, 0)},
 		w.BalanceAction{
-			Account: w.AccountID{ID: "0", Currency: "EUR"}, Volume: -1},
+			Account: w.AccountID{ID: "0", Currency: "EUR"}, Volume: w.NewAmount(-1, 0)},
 		w.BalanceAction{
-			Account: w.AccountID{ID: "-50", Currency: "RUB"}, Volume: 100}}
+			Account: w.AccountID{ID: "-50", Currency: "RUB"}, Volume: w.NewAmount(100, 0)}}
 
 	repo := mw.NewMockRepo(ctrl)
-	repo.EXPECT().Modify(trans, "manager", gomock.Any()).Do(
-		func(_ w.Trans, _ string, f func(repoTrans w.RepoTrans) error) {
+	repo.EXPECT().Modify(trans, "manager", "", gomock.Any(), gomock.Any()).Do(
+		func(
+			_ w.Trans, _ string, _ string, f func(repoTrans w.RepoTrans) error,
+			_ func(w.Trans, w.RepoTrans) error) {
+
 			repoTrans := mw.NewMockRepoTrans(ctrl)
 			for _, action := range trans {
-				balance, err := strconv.ParseFloat(action.Account.ID, 64)
+				balance, err := w.ParseAmount(action.Account.ID)
 				if err != nil {
 					test.Fatalf(`Test code has errors: "%s",`, err)
 				}
@@ -39,15 +42,19 @@ func Test_Executor_Manager_Success(test *testing.T) {
 					nil)
 			}
 			f(repoTrans)
-		})
+		}).Return(12, nil)
 
 	executor := w.CreateManagerExecutor()
 	defer executor.Close()
 
-	affected, err := executor.Execute(trans, repo)
+	result, err := executor.Execute(trans, "manager", "", repo)
 	if err != nil {
 		test.Fatalf(`Failed to execute: "%s".`, err)
 	}
+	if result.TransID != 12 {
+		test.Errorf(`Wrong transaction ID: %d.`, result.TransID)
+	}
+	affected := result.Accounts
 	if len(affected) != 3 {
 		test.Fatalf(`Wrong affected list size: "%v".`, affected)
 	}
@@ -58,11 +65,13 @@ func Test_Executor_Manager_Success(test *testing.T) {
 			if action.Account != account.ID {
 				continue
 			}
-			startBalance, err := strconv.ParseFloat(action.Account.ID, 64)
+			startBalance, err := w.ParseAmount(action.Account.ID)
 			if err != nil {
 				test.Fatalf(`Test code has errors: "%s",`, err)
 			}
-			if account.Balance != startBalance+action.Volume {
+			if balance, err := startBalance.Add(action.Volume); err != nil ||
+				account.Balance != balance {
+
 				test.Errorf(`Wrong affected account: "%v".`, account)
 			}
 			ok = true
@@ -86,36 +95,39 @@ func Test_Executor_Manager_RepoError(test *testing.T) {
 
 	trans := []w.BalanceAction{
 		w.BalanceAction{
-			Account: w.AccountID{ID: "qwerty1", Currency: "USD"}, Volume: 1},
+			Account: w.AccountID{ID: "qwerty1", Currency: "USD"}, Volume: w.NewAmount(1, 0)},
 		w.BalanceAction{
-			Account: w.AccountID{ID: "qwerty2", Currency: "USD"}, Volume: 2},
+			Account: w.AccountID{ID: "qwerty2", Currency: "USD"}, Volume: w.NewAmount(2, 0)},
 		w.BalanceAction{
-			Account: w.AccountID{ID: "qwerty3", Currency: "USD"}, Volume: 3}}
+			Account: w.AccountID{ID: "qwerty3", Currency: "USD"}, Volume: w.NewAmount(3, 0)}}
 	firstRequest := w.AccountID{ID: "qwerty1", Currency: "USD"}
 
 	repo := mw.NewMockRepo(ctrl)
-	repo.EXPECT().Modify(trans, "manager", gomock.Any()).Do(
-		func(_ w.Trans, _ string, f func(repoTrans w.RepoTrans) error) {
+	repo.EXPECT().Modify(trans, "manager", "", gomock.Any(), gomock.Any()).Do(
+		func(
+			_ w.Trans, _ string, _ string, f func(repoTrans w.RepoTrans) error,
+			_ func(w.Trans, w.RepoTrans) error) {
+
 			secondRequest := w.AccountID{ID: "qwerty2", Currency: "USD"}
 			// Second account retrieving attempt ends with a  predefined error.
 			repoTrans := mw.NewMockRepoTrans(ctrl)
 			repoTrans.EXPECT().
 				GetAccount(secondRequest).Return(nil, errors.New("Test error 1")).
 				After(repoTrans.EXPECT().GetAccount(firstRequest).
-					Return(&w.Account{ID: firstRequest, Balance: 100}, nil))
+					Return(&w.Account{ID: firstRequest, Balance: w.NewAmount(100, 0)}, nil))
 			err := f(repoTrans)
 			if err == nil || err.Error() != "Test error 1" {
 				test.Errorf(`Callback has returned wrong error: "%v".`, err)
 			}
-		}).Return(errors.New("Test error 2"))
+		}).Return(0, errors.New("Test error 2"))
 
-	affected, err := executor.Execute(trans, repo)
+	result, err := executor.Execute(trans, "manager", "", repo)
 	if err == nil || err.Error() != "Test error 2" {
 		test.Errorf(`Error handling is wrong: "%v".`, err)
 	}
 
-	if len(affected) != 0 {
-		test.Errorf(`Affected account list has to be empty: "%v".`, affected)
+	if result != nil {
+		test.Errorf(`Result has to be nil: "%v".`, *result)
 	}
 }
 
@@ -127,24 +139,24 @@ func Test_Executor_Client_Success(test *testing.T) {
 	transList := [][]w.BalanceAction{
 		{
 			w.BalanceAction{
-				Account: w.AccountID{ID: "0", Currency: "USD"}, Volume: 1},
+				Account: w.AccountID{ID: "0", Currency: "USD"}, Volume: w.NewAmount(1, 0)},
 			w.BalanceAction{
-				Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: -1}},
+				Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: w.NewAmount(-1, 0)}},
 		{
 			w.BalanceAction{
-				Account: w.AccountID{ID: "0", Currency: "USD"}, Volume: 1},
+				Account: w.AccountID{ID: "0", Currency: "USD"}, Volume: w.NewAmount(1, 0)},
 			w.BalanceAction{
-				Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: -1}},
+				Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: w.NewAmount(-1, 0)}},
 		{
 			w.BalanceAction{
-				Account: w.AccountID{ID: "-1", Currency: "USD"}, Volume: 1},
+				Account: w.AccountID{ID: "-1", Currency: "USD"}, Volume: w.NewAmount(1, 0)},
 			w.BalanceAction{
-				Account: w.AccountID{ID: "2", Currency: "USD"}, Volume: -1}},
+				Account: w.AccountID{ID: "2", Currency: "USD"}, Volume: w.NewAmount(-1, 0)}},
 		{
 			w.BalanceAction{
-				Account: w.AccountID{ID: "-3", Currency: "USD"}, Volume: 4},
+				Account: w.AccountID{ID: "-3", Currency: "USD"}, Volume: w.NewAmount(4, 0)},
 			w.BalanceAction{
-				Account: w.AccountID{ID: "5", Currency: "USD"}, Volume: -4}}}
+				Account: w.AccountID{ID: "5", Currency: "USD"}, Volume: w.NewAmount(-4, 0)}}}
 
 	executor := w.CreateClientExecutor()
 	defer executor.Close()
@@ -152,25 +164,36 @@ func Test_Executor_Client_Success(test *testing.T) {
 	for _, trans := range transList {
 
 		repo := mw.NewMockRepo(ctrl)
-		repo.EXPECT().Modify(trans, "client", gomock.Any()).Do(
-			func(_ w.Trans, _ string, f func(repoTrans w.RepoTrans) error) {
+		repo.EXPECT().Modify(trans, "client", "", gomock.Any(), gomock.Any()).Do(
+			func(
+				_ w.Trans, _ string, _ string, f func(repoTrans w.RepoTrans) error,
+				_ func(w.Trans, w.RepoTrans) error) {
+
 				repoTrans := mw.NewMockRepoTrans(ctrl)
 				for _, action := range trans {
-					balance, err := strconv.ParseFloat(action.Account.ID, 64)
+					balance, err := w.ParseAmount(action.Account.ID)
 					if err != nil {
 						test.Fatalf(`Test code has errors: "%s",`, err)
 					}
 					repoTrans.EXPECT().GetAccount(action.Account).Return(
 						&w.Account{ID: action.Account, Balance: balance},
 						nil)
+					if action.Volume.IsNegative() {
+						repoTrans.EXPECT().GetLimits(action.Account).Return(
+							&w.Limits{}, nil)
+					}
 				}
 				f(repoTrans)
-			})
+			}).Return(12, nil)
 
-		affected, err := executor.Execute(trans, repo)
+		result, err := executor.Execute(trans, "client", "", repo)
 		if err != nil {
 			test.Fatalf(`Failed to execute: "%s".`, err)
 		}
+		if result.TransID != 12 {
+			test.Errorf(`Wrong transaction ID: %d.`, result.TransID)
+		}
+		affected := result.Accounts
 
 		for _, account := range affected {
 			ok := false
@@ -192,11 +215,13 @@ func Test_Executor_Client_Success(test *testing.T) {
 				if action.Account != account.ID {
 					continue
 				}
-				startBalance, err := strconv.ParseFloat(action.Account.ID, 64)
+				startBalance, err := w.ParseAmount(action.Account.ID)
 				if err != nil {
 					test.Fatalf(`Test code has errors: "%s",`, err)
 				}
-				if account.Balance != startBalance+action.Volume {
+				if balance, err := startBalance.Add(action.Volume); err != nil ||
+					account.Balance != balance {
+
 					test.Errorf(`Wrong affected account: "%v".`, account)
 				}
 				ok = true
@@ -220,19 +245,19 @@ func Test_Executor_Client_DoesNotHaveEnoughFundsError(test *testing.T) {
 	transList := [][]w.BalanceAction{
 		{
 			w.BalanceAction{
-				Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: 4},
+				Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: w.NewAmount(4, 0)},
 			w.BalanceAction{
-				Account: w.AccountID{ID: "2", Currency: "USD"}, Volume: -4}},
+				Account: w.AccountID{ID: "2", Currency: "USD"}, Volume: w.NewAmount(-4, 0)}},
 		{
 			w.BalanceAction{
-				Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: 1},
+				Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: w.NewAmount(1, 0)},
 			w.BalanceAction{
-				Account: w.AccountID{ID: "0", Currency: "USD"}, Volume: -1}},
+				Account: w.AccountID{ID: "0", Currency: "USD"}, Volume: w.NewAmount(-1, 0)}},
 		{
 			w.BalanceAction{
-				Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: 1},
+				Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: w.NewAmount(1, 0)},
 			w.BalanceAction{
-				Account: w.AccountID{ID: "-1", Currency: "USD"}, Volume: -1}}}
+				Account: w.AccountID{ID: "-1", Currency: "USD"}, Volume: w.NewAmount(-1, 0)}}}
 
 	executor := w.CreateClientExecutor()
 	defer executor.Close()
@@ -244,13 +269,14 @@ func Test_Executor_Client_DoesNotHaveEnoughFundsError(test *testing.T) {
 		}
 
 		repo := mw.NewMockRepo(ctrl)
-		repo.EXPECT().Modify(trans, "client", gomock.Any()).DoAndReturn(
+		repo.EXPECT().Modify(trans, "client", "", gomock.Any(), gomock.Any()).DoAndReturn(
 			func(
-				_ w.Trans, _ string, f func(repoTrans w.RepoTrans) error) error {
+				_ w.Trans, _ string, _ string, f func(repoTrans w.RepoTrans) error,
+				_ func(w.Trans, w.RepoTrans) error) (int, error) {
 
 				repoTrans := mw.NewMockRepoTrans(ctrl)
 				for _, action := range trans {
-					balance, err := strconv.ParseFloat(action.Account.ID, 64)
+					balance, err := w.ParseAmount(action.Account.ID)
 					if err != nil {
 						test.Fatalf(`Test code has errors: "%s",`, err)
 					}
@@ -258,10 +284,10 @@ func Test_Executor_Client_DoesNotHaveEnoughFundsError(test *testing.T) {
 						&w.Account{ID: action.Account, Balance: balance},
 						nil)
 				}
-				return f(repoTrans)
+				return 0, f(repoTrans)
 			})
 
-		affected, err := executor.Execute(trans, repo)
+		result, err := executor.Execute(trans, "client", "", repo)
 		if err == nil ||
 			err.Error() != fmt.Sprintf(
 				`Account "%s" (%s) does not have enough funds`,
@@ -270,12 +296,121 @@ func Test_Executor_Client_DoesNotHaveEnoughFundsError(test *testing.T) {
 			test.Errorf(`Error handling is wrong: "%v".`, err)
 		}
 
-		if len(affected) != 0 {
-			test.Errorf(`Affected account list has to be empty: "%v".`, affected)
+		if result != nil {
+			test.Errorf(`Result has to be nil: "%v".`, *result)
+		}
+	}
+}
+
+// Test_Executor_Client_Overdraft tests payments by the account credit line.
+func Test_Executor_Client_Overdraft(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	src := w.AccountID{ID: "src", Currency: "USD"}
+	dst := w.AccountID{ID: "dst", Currency: "USD"}
+
+	executor := w.CreateClientExecutor()
+	defer executor.Close()
+
+	for _, check := range []struct {
+		amount  int64
+		balance w.Amount
+		err     bool
+	}{
+		{amount: 15, balance: w.NewAmount(-8, 0)},
+		{amount: 17, balance: w.NewAmount(-10, 0)},
+		{amount: 18, err: true}} {
+
+		trans := []w.BalanceAction{
+			w.BalanceAction{Account: src, Volume: w.NewAmount(-check.amount, 0)},
+			w.BalanceAction{Account: dst, Volume: w.NewAmount(check.amount, 0)}}
+		repo := mw.NewMockRepo(ctrl)
+		repo.EXPECT().Modify(trans, "client", "", gomock.Any(), gomock.Any()).DoAndReturn(
+			func(
+				_ w.Trans, _ string, _ string, f func(repoTrans w.RepoTrans) error,
+				_ func(w.Trans, w.RepoTrans) error) (int, error) {
+
+				repoTrans := mw.NewMockRepoTrans(ctrl)
+				repoTrans.EXPECT().GetAccount(src).Return(
+					&w.Account{
+						ID:        src,
+						Balance:   w.NewAmount(10, 0),
+						Held:      w.NewAmount(3, 0),
+						Overdraft: w.NewAmount(10, 0)},
+					nil)
+				repoTrans.EXPECT().GetAccount(dst).Return(&w.Account{ID: dst}, nil).
+					MaxTimes(1)
+				repoTrans.EXPECT().GetLimits(src).Return(&w.Limits{}, nil).
+					MaxTimes(1)
+				return 1, f(repoTrans)
+			})
+
+		result, err := executor.Execute(trans, "client", "", repo)
+		if check.err {
+			if !w.IsError(err, w.ErrInsufficientFunds) || result != nil {
+				test.Errorf(`Payment of %d is executed: "%v", "%v".`,
+					check.amount, result, err)
+			}
+			continue
+		}
+		if err != nil || len(result.Accounts) != 2 ||
+			result.Accounts[0].Available() != check.balance {
+
+			test.Errorf(`Payment of %d is failed: "%v", "%v".`,
+				check.amount, result, err)
 		}
 	}
 }
 
+// Test_Executor_Client_ClosedFeeAccount tests that the fee could not be
+// credited to the closed fee account.
+func Test_Executor_Client_ClosedFeeAccount(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	schedules, err := w.ParseFees("USD=1")
+	if err != nil {
+		test.Fatalf(`Failed to parse fees: "%s".`, err)
+	}
+	executor := w.CreateClientExecutorWithFees(
+		w.CreateFeePolicy(schedules), "fee")
+	defer executor.Close()
+
+	src := w.AccountID{ID: "src", Currency: "USD"}
+	dst := w.AccountID{ID: "dst", Currency: "USD"}
+	fee := w.AccountID{ID: "fee", Currency: "USD"}
+	trans := w.Trans{
+		w.BalanceAction{Account: src, Volume: w.NewAmount(-10, 0)},
+		w.BalanceAction{Account: dst, Volume: w.NewAmount(10, 0)}}
+	fullTrans := w.Trans{
+		w.BalanceAction{Account: src, Volume: w.NewAmount(-11, 0)},
+		trans[1],
+		w.BalanceAction{Account: fee, Volume: w.NewAmount(1, 0), Fee: true}}
+
+	repo := mw.NewMockRepo(ctrl)
+	repo.EXPECT().Modify(fullTrans, "client", "", gomock.Any(), gomock.Any()).DoAndReturn(
+		func(
+			_ w.Trans, _ string, _ string, f func(repoTrans w.RepoTrans) error,
+			_ func(w.Trans, w.RepoTrans) error) (int, error) {
+
+			repoTrans := mw.NewMockRepoTrans(ctrl)
+			repoTrans.EXPECT().GetAccount(src).Return(
+				&w.Account{ID: src, Balance: w.NewAmount(100, 0)}, nil)
+			repoTrans.EXPECT().GetAccount(fee).Return(
+				&w.Account{ID: fee, Status: w.AccountClosed}, nil)
+			return 0, f(repoTrans)
+		})
+
+	result, err := executor.Execute(trans, "client", "", repo)
+	if !w.IsError(err, w.ErrAccountNotActive) {
+		test.Errorf(`Error handling is wrong: "%v".`, err)
+	}
+	if result != nil {
+		test.Errorf(`Result has to be nil: "%v".`, *result)
+	}
+}
+
 // Test_Executor_Client_DiffrentCurrencies tests error with diffrent currencies.
 func Test_Executor_Client_DiffrentCurrencies(test *testing.T) {
 	ctrl := gomock.NewController(test)
@@ -283,35 +418,36 @@ func Test_Executor_Client_DiffrentCurrencies(test *testing.T) {
 
 	trans := []w.BalanceAction{
 		w.BalanceAction{
-			Account: w.AccountID{ID: "1", Currency: "EUR"}, Volume: 4},
+			Account: w.AccountID{ID: "1", Currency: "EUR"}, Volume: w.NewAmount(4, 0)},
 		w.BalanceAction{
-			Account: w.AccountID{ID: "2", Currency: "USD"}, Volume: -4}}
+			Account: w.AccountID{ID: "2", Currency: "USD"}, Volume: w.NewAmount(-4, 0)}}
 
 	executor := w.CreateClientExecutor()
 	defer executor.Close()
 
 	repo := mw.NewMockRepo(ctrl)
-	repo.EXPECT().Modify(trans, "client", gomock.Any()).DoAndReturn(
+	repo.EXPECT().Modify(trans, "client", "", gomock.Any(), gomock.Any()).DoAndReturn(
 		func(
-			_ w.Trans, _ string, f func(repoTrans w.RepoTrans) error) error {
+			_ w.Trans, _ string, _ string, f func(repoTrans w.RepoTrans) error,
+			_ func(w.Trans, w.RepoTrans) error) (int, error) {
 
 			repoTrans := mw.NewMockRepoTrans(ctrl)
 			repoTrans.EXPECT().GetAccount(w.AccountID{ID: "1", Currency: "EUR"}).
 				Return(
-					&w.Account{ID: w.AccountID{ID: "1", Currency: "EUR"}, Balance: 0},
+					&w.Account{ID: w.AccountID{ID: "1", Currency: "EUR"}, Balance: w.NewAmount(0, 0)},
 					nil)
-			return f(repoTrans)
+			return 0, f(repoTrans)
 		})
 
-	affected, err := executor.Execute(trans, repo)
+	result, err := executor.Execute(trans, "client", "", repo)
 	if err == nil ||
 		err.Error() != `Account "2" (USD) has a different currency from "EUR"` {
 
 		test.Errorf(`Error handling is wrong: "%v".`, err)
 	}
 
-	if len(affected) != 0 {
-		test.Errorf(`Affected account list has to be empty: "%v".`, affected)
+	if result != nil {
+		test.Errorf(`Result has to be nil: "%v".`, *result)
 	}
 }
 
@@ -322,35 +458,36 @@ func Test_Executor_Client_DirectionError(test *testing.T) {
 
 	trans := []w.BalanceAction{
 		w.BalanceAction{
-			Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: 4},
+			Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: w.NewAmount(4, 0)},
 		w.BalanceAction{
-			Account: w.AccountID{ID: "2", Currency: "USD"}, Volume: 4}}
+			Account: w.AccountID{ID: "2", Currency: "USD"}, Volume: w.NewAmount(4, 0)}}
 
 	executor := w.CreateClientExecutor()
 	defer executor.Close()
 
 	repo := mw.NewMockRepo(ctrl)
-	repo.EXPECT().Modify(trans, "client", gomock.Any()).DoAndReturn(
+	repo.EXPECT().Modify(trans, "client", "", gomock.Any(), gomock.Any()).DoAndReturn(
 		func(
-			_ w.Trans, _ string, f func(repoTrans w.RepoTrans) error) error {
+			_ w.Trans, _ string, _ string, f func(repoTrans w.RepoTrans) error,
+			_ func(w.Trans, w.RepoTrans) error) (int, error) {
 
 			repoTrans := mw.NewMockRepoTrans(ctrl)
 			repoTrans.EXPECT().GetAccount(w.AccountID{ID: "1", Currency: "USD"}).
 				Return(
-					&w.Account{ID: w.AccountID{ID: "1", Currency: "USD"}, Balance: 0},
+					&w.Account{ID: w.AccountID{ID: "1", Currency: "USD"}, Balance: w.NewAmount(0, 0)},
 					nil)
-			return f(repoTrans)
+			return 0, f(repoTrans)
 		})
 
-	affected, err := executor.Execute(trans, repo)
+	result, err := executor.Execute(trans, "client", "", repo)
 	if err == nil ||
 		err.Error() != `Transaction does not move the same volume of funds for each account` {
 
 		test.Errorf(`Error handling is wrong: "%v".`, err)
 	}
 
-	if len(affected) != 0 {
-		test.Errorf(`Affected account list has to be empty: "%v".`, affected)
+	if result != nil {
+		test.Errorf(`Result has to be nil: "%v".`, *result)
 	}
 }
 
@@ -361,35 +498,36 @@ func Test_Executor_Client_OneAccountError(test *testing.T) {
 
 	trans := []w.BalanceAction{
 		w.BalanceAction{
-			Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: 4},
+			Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: w.NewAmount(4, 0)},
 		w.BalanceAction{
-			Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: -4}}
+			Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: w.NewAmount(-4, 0)}}
 
 	executor := w.CreateClientExecutor()
 	defer executor.Close()
 
 	repo := mw.NewMockRepo(ctrl)
-	repo.EXPECT().Modify(trans, "client", gomock.Any()).DoAndReturn(
+	repo.EXPECT().Modify(trans, "client", "", gomock.Any(), gomock.Any()).DoAndReturn(
 		func(
-			_ w.Trans, _ string, f func(repoTrans w.RepoTrans) error) error {
+			_ w.Trans, _ string, _ string, f func(repoTrans w.RepoTrans) error,
+			_ func(w.Trans, w.RepoTrans) error) (int, error) {
 
 			repoTrans := mw.NewMockRepoTrans(ctrl)
 			repoTrans.EXPECT().GetAccount(w.AccountID{ID: "1", Currency: "USD"}).
 				Return(
-					&w.Account{ID: w.AccountID{ID: "1", Currency: "USD"}, Balance: 0},
+					&w.Account{ID: w.AccountID{ID: "1", Currency: "USD"}, Balance: w.NewAmount(0, 0)},
 					nil)
-			return f(repoTrans)
+			return 0, f(repoTrans)
 		})
 
-	affected, err := executor.Execute(trans, repo)
+	result, err := executor.Execute(trans, "client", "", repo)
 	if err == nil ||
 		err.Error() != `Transaction has only one account "1" (USD)` {
 
 		test.Errorf(`Error handling is wrong: "%v".`, err)
 	}
 
-	if len(affected) != 0 {
-		test.Errorf(`Affected account list has to be empty: "%v".`, affected)
+	if result != nil {
+		test.Errorf(`Result has to be nil: "%v".`, *result)
 	}
 }
 
@@ -400,35 +538,36 @@ func Test_Executor_Client_VolumeError(test *testing.T) {
 
 	trans := []w.BalanceAction{
 		w.BalanceAction{
-			Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: 4},
+			Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: w.NewAmount(4, 0)},
 		w.BalanceAction{
-			Account: w.AccountID{ID: "2", Currency: "USD"}, Volume: -5}}
+			Account: w.AccountID{ID: "2", Currency: "USD"}, Volume: w.NewAmount(-5, 0)}}
 
 	executor := w.CreateClientExecutor()
 	defer executor.Close()
 
 	repo := mw.NewMockRepo(ctrl)
-	repo.EXPECT().Modify(trans, "client", gomock.Any()).DoAndReturn(
+	repo.EXPECT().Modify(trans, "client", "", gomock.Any(), gomock.Any()).DoAndReturn(
 		func(
-			_ w.Trans, _ string, f func(repoTrans w.RepoTrans) error) error {
+			_ w.Trans, _ string, _ string, f func(repoTrans w.RepoTrans) error,
+			_ func(w.Trans, w.RepoTrans) error) (int, error) {
 
 			repoTrans := mw.NewMockRepoTrans(ctrl)
 			repoTrans.EXPECT().GetAccount(w.AccountID{ID: "1", Currency: "USD"}).
 				Return(
-					&w.Account{ID: w.AccountID{ID: "1", Currency: "USD"}, Balance: 0},
+					&w.Account{ID: w.AccountID{ID: "1", Currency: "USD"}, Balance: w.NewAmount(0, 0)},
 					nil)
-			return f(repoTrans)
+			return 0, f(repoTrans)
 		})
 
-	affected, err := executor.Execute(trans, repo)
+	result, err := executor.Execute(trans, "client", "", repo)
 	if err == nil ||
 		err.Error() != `Transaction does not move the same volume of funds for each account` {
 
 		test.Errorf(`Error handling is wrong: "%v".`, err)
 	}
 
-	if len(affected) != 0 {
-		test.Errorf(`Affected account list has to be empty: "%v".`, affected)
+	if result != nil {
+		test.Errorf(`Result has to be nil: "%v".`, *result)
 	}
 }
 
@@ -441,28 +580,28 @@ func Test_Executor_Client_NumberOfAccountsError(test *testing.T) {
 	transList := [][]w.BalanceAction{
 		{
 			w.BalanceAction{
-				Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: 4},
+				Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: w.NewAmount(4, 0)},
 			w.BalanceAction{
-				Account: w.AccountID{ID: "2", Currency: "USD"}, Volume: -4},
+				Account: w.AccountID{ID: "2", Currency: "USD"}, Volume: w.NewAmount(-4, 0)},
 			w.BalanceAction{
-				Account: w.AccountID{ID: "2", Currency: "USD"}, Volume: -4}},
+				Account: w.AccountID{ID: "2", Currency: "USD"}, Volume: w.NewAmount(-4, 0)}},
 		{
 			w.BalanceAction{
-				Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: 4}}}
+				Account: w.AccountID{ID: "1", Currency: "USD"}, Volume: w.NewAmount(4, 0)}}}
 
 	executor := w.CreateClientExecutor()
 	defer executor.Close()
 
 	for _, trans := range transList {
 		repo := mw.NewMockRepo(ctrl)
-		affected, err := executor.Execute(trans, repo)
+		result, err := executor.Execute(trans, "client", "", repo)
 		if err == nil ||
 			err.Error() != "The transaction is not a transaction to move funds from one account to another" {
 
 			test.Errorf(`Error handling is wrong: "%v".`, err)
 		}
-		if len(affected) != 0 {
-			test.Errorf(`Affected account list has to be empty: "%v".`, affected)
+		if result != nil {
+			test.Errorf(`Result has to be nil: "%v".`, *result)
 		}
 	}
 }
@@ -478,33 +617,426 @@ func Test_Executor_Client_RepoError(test *testing.T) {
 
 	trans := []w.BalanceAction{
 		w.BalanceAction{
-			Account: w.AccountID{ID: "qwerty1", Currency: "USD"}, Volume: 1},
+			Account: w.AccountID{ID: "qwerty1", Currency: "USD"}, Volume: w.NewAmount(1, 0)},
 		w.BalanceAction{
-			Account: w.AccountID{ID: "qwerty2", Currency: "USD"}, Volume: -1}}
+			Account: w.AccountID{ID: "qwerty2", Currency: "USD"}, Volume: w.NewAmount(-1, 0)}}
 	firstRequest := w.AccountID{ID: "qwerty1", Currency: "USD"}
 
 	repo := mw.NewMockRepo(ctrl)
-	repo.EXPECT().Modify(trans, "client", gomock.Any()).Do(
-		func(_ w.Trans, _ string, f func(repoTrans w.RepoTrans) error) {
+	repo.EXPECT().Modify(trans, "client", "", gomock.Any(), gomock.Any()).Do(
+		func(
+			_ w.Trans, _ string, _ string, f func(repoTrans w.RepoTrans) error,
+			_ func(w.Trans, w.RepoTrans) error) {
+
 			secondRequest := w.AccountID{ID: "qwerty2", Currency: "USD"}
 			// Second account retrieving attempt ends with a  predefined error.
 			repoTrans := mw.NewMockRepoTrans(ctrl)
 			repoTrans.EXPECT().
 				GetAccount(secondRequest).Return(nil, errors.New("Test error 1")).
 				After(repoTrans.EXPECT().GetAccount(firstRequest).
-					Return(&w.Account{ID: firstRequest, Balance: 100}, nil))
+					Return(&w.Account{ID: firstRequest, Balance: w.NewAmount(100, 0)}, nil))
 			err := f(repoTrans)
 			if err == nil || err.Error() != "Test error 1" {
 				test.Errorf(`Callback has returned wrong error: "%v".`, err)
 			}
-		}).Return(errors.New("Test error 2"))
+		}).Return(0, errors.New("Test error 2"))
 
-	affected, err := executor.Execute(trans, repo)
+	result, err := executor.Execute(trans, "client", "", repo)
 	if err == nil || err.Error() != "Test error 2" {
 		test.Errorf(`Error handling is wrong: "%v".`, err)
 	}
 
-	if len(affected) != 0 {
-		test.Errorf(`Affected account list has to be empty: "%v".`, affected)
+	if result != nil {
+		test.Errorf(`Result has to be nil: "%v".`, *result)
+	}
+}
+
+// Test_Executor_Client_CurrencyScaleError tests error with an amount which is
+// more precise than the currency allows.
+func Test_Executor_Client_CurrencyScaleError(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	trans := []w.BalanceAction{
+		w.BalanceAction{
+			Account: w.AccountID{ID: "1", Currency: "USD"},
+			Volume:  w.NewAmount(-1, 3)},
+		w.BalanceAction{
+			Account: w.AccountID{ID: "2", Currency: "USD"},
+			Volume:  w.NewAmount(1, 3)}}
+
+	executor := w.CreateClientExecutor()
+	defer executor.Close()
+
+	repo := mw.NewMockRepo(ctrl)
+	repo.EXPECT().Modify(trans, "client", "", gomock.Any(), gomock.Any()).DoAndReturn(
+		func(
+			_ w.Trans, _ string, _ string, f func(repoTrans w.RepoTrans) error,
+			_ func(w.Trans, w.RepoTrans) error) (int, error) {
+
+			return 0, f(mw.NewMockRepoTrans(ctrl))
+		})
+
+	result, err := executor.Execute(trans, "client", "", repo)
+	if err == nil ||
+		err.Error() != `Amount -0.001 has more than 2 digits after the point for currency "USD"` {
+
+		test.Errorf(`Error handling is wrong: "%v".`, err)
+	}
+
+	if result != nil {
+		test.Errorf(`Result has to be nil: "%v".`, *result)
+	}
+}
+
+// Test_Executor_Client_LimitError tests account limit errors while
+// transaction execution by client.
+func Test_Executor_Client_LimitError(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	src := w.AccountID{ID: "src", Currency: "USD"}
+	dst := w.AccountID{ID: "dst", Currency: "USD"}
+	trans := []w.BalanceAction{
+		w.BalanceAction{Account: src, Volume: w.NewAmount(-10, 0)},
+		w.BalanceAction{Account: dst, Volume: w.NewAmount(10, 0)}}
+
+	executor := w.CreateClientExecutor()
+	defer executor.Close()
+
+	for _, check := range []struct {
+		limits      w.Limits
+		debitAmount w.Amount
+		debitCount  int
+		err         string
+	}{
+		{
+			limits: w.Limits{MaxPayment: w.NewAmount(5, 0)},
+			err: `Payment amount 10 exceeds the maximal payment amount 5` +
+				` of account "src" (USD)`},
+		{
+			limits:      w.Limits{DailyAmount: w.NewAmount(50, 0)},
+			debitAmount: w.NewAmount(4001, 2),
+			debitCount:  1,
+			err:         `Payment exceeds the daily amount limit 50 of account "src" (USD)`},
+		{
+			limits:      w.Limits{DailyCount: 2},
+			debitAmount: w.NewAmount(1, 0),
+			debitCount:  2,
+			err: `Payment exceeds the daily payment count limit 2` +
+				` of account "src" (USD)`},
+		{
+			limits: w.Limits{
+				DailyAmount: w.NewAmount(50, 0), MonthlyAmount: w.NewAmount(100, 0)},
+			debitAmount: w.NewAmount(95, 0),
+			debitCount:  3,
+			err: `Payment exceeds the daily amount limit 50` +
+				` of account "src" (USD)`},
+		{
+			limits:      w.Limits{MonthlyAmount: w.NewAmount(100, 0)},
+			debitAmount: w.NewAmount(95, 0),
+			debitCount:  3,
+			err: `Payment exceeds the monthly amount limit 100` +
+				` of account "src" (USD)`}} {
+
+		repo := mw.NewMockRepo(ctrl)
+		repo.EXPECT().Modify(trans, "client", "", gomock.Any(), gomock.Any()).DoAndReturn(
+			func(
+				_ w.Trans, _ string, _ string, f func(repoTrans w.RepoTrans) error,
+				_ func(w.Trans, w.RepoTrans) error) (int, error) {
+
+				repoTrans := mw.NewMockRepoTrans(ctrl)
+				repoTrans.EXPECT().GetAccount(src).Return(
+					&w.Account{ID: src, Balance: w.NewAmount(1000, 0)}, nil)
+				repoTrans.EXPECT().GetAccount(dst).Return(&w.Account{ID: dst}, nil)
+				repoTrans.EXPECT().GetLimits(src).Return(&check.limits, nil)
+				repoTrans.EXPECT().GetDebits(src, gomock.Any()).
+					Return(check.debitAmount, check.debitCount, nil).
+					MaxTimes(1)
+				return 0, f(repoTrans)
+			})
+
+		result, err := executor.Execute(trans, "client", "", repo)
+		if !w.IsError(err, w.ErrLimitExceeded) || err.Error() != check.err {
+			test.Errorf(`Error handling is wrong: "%v".`, err)
+		}
+		if result != nil {
+			test.Errorf(`Result has to be nil: "%v".`, *result)
+		}
+	}
+}
+
+// Test_Executor_Conversion_Success tests conversion payment execution.
+func Test_Executor_Conversion_Success(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	rates := map[w.CurrencyPair]*big.Rat{
+		w.CurrencyPair{From: "USD", To: "JPY"}: big.NewRat(10915, 100)}
+	executor := w.CreateConversionExecutor(
+		w.CreateFixedRateProvider(rates), "house")
+	defer executor.Close()
+
+	src := w.BalanceAction{
+		Account: w.AccountID{ID: "src", Currency: "USD"},
+		Volume:  w.NewAmount(-1001, 2)}
+	dst := w.AccountID{ID: "dst", Currency: "JPY"}
+	fullTrans := w.Trans{
+		src,
+		w.BalanceAction{
+			Account: w.AccountID{ID: "house", Currency: "USD"},
+			Volume:  w.NewAmount(1001, 2)},
+		w.BalanceAction{
+			Account: w.AccountID{ID: "house", Currency: "JPY"},
+			Volume:  w.NewAmount(-1092, 0)},
+		w.BalanceAction{Account: dst, Volume: w.NewAmount(1092, 0)}}
+
+	repo := mw.NewMockRepo(ctrl)
+	calculatedRepo := mw.NewMockRepo(ctrl)
+	repo.EXPECT().CalculateVolumes(fullTrans[2].Account, dst).
+		Return(calculatedRepo)
+	calculatedRepo.EXPECT().Modify(fullTrans, "alice", "key", gomock.Any(), gomock.Any()).
+		DoAndReturn(
+			func(
+				_ w.Trans, _ string, _ string,
+				f func(repoTrans w.RepoTrans) error,
+				_ func(w.Trans, w.RepoTrans) error) (int, error) {
+
+				repoTrans := mw.NewMockRepoTrans(ctrl)
+				for _, action := range fullTrans {
+					repoTrans.EXPECT().GetAccount(action.Account).Return(
+						&w.Account{ID: action.Account, Balance: w.NewAmount(1001, 2)},
+						nil)
+				}
+				repoTrans.EXPECT().GetLimits(src.Account).Return(&w.Limits{}, nil)
+				return 12, f(repoTrans)
+			})
+
+	result, err := executor.Execute(
+		w.Trans{src, w.BalanceAction{Account: dst}}, "alice", "key", repo)
+	if err != nil {
+		test.Fatalf(`Failed to execute: "%s".`, err)
+	}
+	if result.TransID != 12 {
+		test.Errorf(`Wrong transaction ID: %d.`, result.TransID)
+	}
+	affected := result.Accounts
+	if len(affected) != 4 ||
+		affected[0].Balance != (w.Amount{}) ||
+		affected[1].Balance != w.NewAmount(2002, 2) ||
+		affected[2].Balance != w.NewAmount(1001-109200, 2) ||
+		affected[3].Balance != w.NewAmount(110201, 2) {
+
+		test.Errorf(`Wrong affected list: "%v".`, affected)
+	}
+}
+
+// Test_Executor_Conversion_Error tests conversion payment errors.
+func Test_Executor_Conversion_Error(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	rates := map[w.CurrencyPair]*big.Rat{
+		w.CurrencyPair{From: "USD", To: "EUR"}: big.NewRat(9, 10)}
+	executor := w.CreateConversionExecutor(
+		w.CreateFixedRateProvider(rates), "house")
+	defer executor.Close()
+
+	usd := w.AccountID{ID: "src", Currency: "USD"}
+	eur := w.AccountID{ID: "dst", Currency: "EUR"}
+	for _, check := range []struct {
+		trans w.Trans
+		err   string
+	}{
+		{
+			trans: w.Trans{w.BalanceAction{Account: usd, Volume: w.NewAmount(-1, 0)}},
+			err:   "The transaction is not a transaction to move funds from one account to another"},
+		{
+			trans: w.Trans{
+				w.BalanceAction{Account: usd, Volume: w.NewAmount(-1, 0)},
+				w.BalanceAction{Account: w.AccountID{ID: "dst", Currency: "USD"}}},
+			err: `Account "dst" (USD) has the same currency as "src"`},
+		{
+			trans: w.Trans{
+				w.BalanceAction{Account: usd, Volume: w.NewAmount(1, 0)},
+				w.BalanceAction{Account: eur}},
+			err: "Conversion transaction has to have negative source volume and zero destination volume"},
+		{
+			trans: w.Trans{
+				w.BalanceAction{Account: usd, Volume: w.NewAmount(-1, 0)},
+				w.BalanceAction{Account: w.AccountID{ID: "house", Currency: "EUR"}}},
+			err: `House account "house" could not be a payment party`},
+		{
+			trans: w.Trans{
+				w.BalanceAction{Account: usd, Volume: w.NewAmount(-1, 0)},
+				w.BalanceAction{Account: w.AccountID{ID: "dst", Currency: "RUB"}}},
+			err: `There is no exchange rate for "USD" to "RUB"`},
+		{
+			trans: w.Trans{
+				w.BalanceAction{Account: usd, Volume: w.NewAmount(-1, 3)},
+				w.BalanceAction{Account: eur}},
+			err: `Amount -0.001 has more than 2 digits after the point for currency "USD"`},
+		{
+			trans: w.Trans{
+				w.BalanceAction{Account: usd, Volume: w.NewAmount(-1, 2)},
+				w.BalanceAction{Account: eur}},
+			err: `Amount 0.01 (USD) is too small to convert to "EUR"`}} {
+
+		repo := mw.NewMockRepo(ctrl)
+		result, err := executor.Execute(check.trans, "client", "", repo)
+		if err == nil || err.Error() != check.err {
+			test.Errorf(`Error handling is wrong: "%v".`, err)
+		}
+		if result != nil {
+			test.Errorf(`Result has to be nil: "%v".`, *result)
+		}
+	}
+}
+
+// Test_Executor_Batch_Success tests batch execution with one debit to many
+// credits.
+func Test_Executor_Batch_Success(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	trans := w.Trans{
+		w.BalanceAction{
+			Account: w.AccountID{ID: "a", Currency: "USD"}, Volume: w.NewAmount(-10, 0)},
+		w.BalanceAction{
+			Account: w.AccountID{ID: "b", Currency: "USD"}, Volume: w.NewAmount(3, 0)},
+		w.BalanceAction{
+			Account: w.AccountID{ID: "c", Currency: "USD"}, Volume: w.NewAmount(7, 0)},
+		w.BalanceAction{
+			Account: w.AccountID{ID: "a", Currency: "EUR"}, Volume: w.NewAmount(1, 0)},
+		w.BalanceAction{
+			Account: w.AccountID{ID: "b", Currency: "EUR"}, Volume: w.NewAmount(-1, 0)}}
+
+	executor := w.CreateBatchExecutor()
+	defer executor.Close()
+
+	repo := mw.NewMockRepo(ctrl)
+	repo.EXPECT().Modify(trans, "client", "key", gomock.Any(), gomock.Any()).DoAndReturn(
+		func(
+			_ w.Trans, _ string, _ string, f func(repoTrans w.RepoTrans) error,
+			_ func(w.Trans, w.RepoTrans) error) (int, error) {
+
+			repoTrans := mw.NewMockRepoTrans(ctrl)
+			for _, action := range trans {
+				repoTrans.EXPECT().GetAccount(action.Account).Return(
+					&w.Account{ID: action.Account, Balance: w.NewAmount(10, 0)},
+					nil)
+				if action.Volume.IsNegative() {
+					repoTrans.EXPECT().GetLimits(action.Account).
+						Return(&w.Limits{}, nil)
+				}
+			}
+			return 12, f(repoTrans)
+		})
+
+	result, err := executor.Execute(trans, "client", "key", repo)
+	if err != nil {
+		test.Fatalf(`Failed to execute: "%s".`, err)
+	}
+	if result.TransID != 12 || len(result.Accounts) != len(trans) {
+		test.Fatalf(`Wrong result: "%v".`, *result)
+	}
+	for i, action := range trans {
+		balance, err := w.NewAmount(10, 0).Add(action.Volume)
+		if err != nil || result.Accounts[i].ID != action.Account ||
+			result.Accounts[i].Balance != balance {
+
+			test.Errorf(`Wrong affected account: "%v".`, result.Accounts[i])
+		}
+	}
+}
+
+// Test_Executor_Batch_Error tests batch checks.
+func Test_Executor_Batch_Error(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	a := w.AccountID{ID: "a", Currency: "USD"}
+	b := w.AccountID{ID: "b", Currency: "USD"}
+	c := w.AccountID{ID: "c", Currency: "EUR"}
+
+	executor := w.CreateBatchExecutor()
+	defer executor.Close()
+
+	for _, check := range []struct {
+		trans w.Trans
+		err   string
+	}{
+		{
+			trans: w.Trans{w.BalanceAction{Account: a, Volume: w.NewAmount(0, 0)}},
+			err:   "Batch has to have at least two actions"},
+		{
+			trans: w.Trans{
+				w.BalanceAction{Account: a, Volume: w.NewAmount(-1, 0)},
+				w.BalanceAction{Account: a, Volume: w.NewAmount(1, 0)}},
+			err: `Batch has several actions for account "a" (USD)`},
+		{
+			trans: w.Trans{
+				w.BalanceAction{Account: a, Volume: w.NewAmount(0, 0)},
+				w.BalanceAction{Account: b, Volume: w.NewAmount(0, 0)}},
+			err: `Batch action for account "a" (USD) has zero volume`},
+		{
+			trans: w.Trans{
+				w.BalanceAction{Account: a, Volume: w.NewAmount(-1, 0)},
+				w.BalanceAction{Account: b, Volume: w.NewAmount(1, 0)},
+				w.BalanceAction{Account: c, Volume: w.NewAmount(1, 0)}},
+			err: `Batch volumes in "EUR" are not balanced, the sum is 1`},
+		{
+			trans: w.Trans{
+				w.BalanceAction{Account: a, Volume: w.NewAmount(-1, 3)},
+				w.BalanceAction{Account: b, Volume: w.NewAmount(1, 3)}},
+			err: `Amount -0.001 has more than 2 digits after the point for currency "USD"`}} {
+
+		result, err := executor.Execute(check.trans, "client", "", nil)
+		if result != nil || err == nil || err.Error() != check.err {
+			test.Errorf(`Wrong result: "%v", "%v".`, result, err)
+		}
+	}
+
+	trans := w.Trans{
+		w.BalanceAction{Account: a, Volume: w.NewAmount(-5, 0)},
+		w.BalanceAction{Account: b, Volume: w.NewAmount(5, 0)}}
+	repo := mw.NewMockRepo(ctrl)
+	repo.EXPECT().Modify(trans, "client", "", gomock.Any(), gomock.Any()).DoAndReturn(
+		func(
+			_ w.Trans, _ string, _ string, f func(repoTrans w.RepoTrans) error,
+			_ func(w.Trans, w.RepoTrans) error) (int, error) {
+
+			repoTrans := mw.NewMockRepoTrans(ctrl)
+			repoTrans.EXPECT().GetAccount(a).Return(
+				&w.Account{
+					ID:      a,
+					Balance: w.NewAmount(10, 0),
+					Held:    w.NewAmount(6, 0)},
+				nil)
+			return 0, f(repoTrans)
+		})
+	result, err := executor.Execute(trans, "client", "", repo)
+	if result != nil || !w.IsError(err, w.ErrInsufficientFunds) {
+		test.Errorf(`Wrong result: "%v", "%v".`, result, err)
+	}
+
+	// Each debit is checked by limits of the debited account.
+	repo = mw.NewMockRepo(ctrl)
+	repo.EXPECT().Modify(trans, "client", "", gomock.Any(), gomock.Any()).DoAndReturn(
+		func(
+			_ w.Trans, _ string, _ string, f func(repoTrans w.RepoTrans) error,
+			_ func(w.Trans, w.RepoTrans) error) (int, error) {
+
+			repoTrans := mw.NewMockRepoTrans(ctrl)
+			repoTrans.EXPECT().GetAccount(a).Return(
+				&w.Account{ID: a, Balance: w.NewAmount(10, 0)}, nil)
+			repoTrans.EXPECT().GetAccount(b).Return(&w.Account{ID: b}, nil)
+			repoTrans.EXPECT().GetLimits(a).
+				Return(&w.Limits{MaxPayment: w.NewAmount(4, 0)}, nil)
+			return 0, f(repoTrans)
+		})
+	result, err = executor.Execute(trans, "client", "", repo)
+	if result != nil || !w.IsError(err, w.ErrLimitExceeded) {
+		test.Errorf(`Wrong result: "%v", "%v".`, result, err)
 	}
 }
@@ -0,0 +1,173 @@
+package wallet
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// FeeTier describes the fee of payments with amounts from the tier threshold.
+type FeeTier struct {
+	// From is the minimal payment amount of the tier.
+	From Amount `json:"from"`
+	// Fixed is the fixed part of the fee.
+	Fixed Amount `json:"fixed"`
+	// Percent is the percentage of the payment amount, like "2.9" for 2.9%.
+	Percent Amount `json:"percent"`
+}
+
+// FeeSchedule describes fees of payments in one currency.
+type FeeSchedule struct {
+	// Tiers are fees for payment amount ranges, the payment fee is calculated
+	// by the tier with the greatest threshold which is not greater than the
+	// payment amount.
+	Tiers []FeeTier `json:"tiers"`
+	// Min is the minimal fee.
+	Min Amount `json:"min"`
+	// Max is the maximal fee, zero means no limit.
+	Max Amount `json:"max"`
+}
+
+// FeePolicy calculates fees of client payments.
+type FeePolicy interface {
+	// GetFee returns the fee of the payment amount in the currency, zero fee
+	// means that the payment is free. Returns ErrInvalidAmount if the fee is
+	// out of range.
+	GetFee(amount Amount, currency string) (Amount, error)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type scheduleFeePolicy struct {
+	schedules map[string]FeeSchedule
+}
+
+// CreateFeePolicy creates fee policy with fee schedules by currency, payments
+// in currencies without schedule are free. The percentage part of the fee is
+// rounded towards zero to the currency minor units.
+func CreateFeePolicy(schedules map[string]FeeSchedule) FeePolicy {
+	result := &scheduleFeePolicy{schedules: map[string]FeeSchedule{}}
+	for currency, schedule := range schedules {
+		tiers := make([]FeeTier, len(schedule.Tiers))
+		copy(tiers, schedule.Tiers)
+		sort.SliceStable(tiers, func(i, j int) bool {
+			return tiers[i].From.Cmp(tiers[j].From) < 0
+		})
+		schedule.Tiers = tiers
+		result.schedules[currency] = schedule
+	}
+	return result
+}
+
+func (p *scheduleFeePolicy) GetFee(
+	amount Amount, currency string) (Amount, error) {
+
+	schedule, has := p.schedules[currency]
+	if !has {
+		return Amount{}, nil
+	}
+	var result Amount
+	for _, tier := range schedule.Tiers {
+		if tier.From.Cmp(amount) > 0 {
+			break
+		}
+		percent, err := amount.Convert(
+			big.NewRat(tier.Percent.value, 100*amountFactor),
+			GetCurrencyScale(currency))
+		if err != nil {
+			return Amount{}, err
+		}
+		if result, err = tier.Fixed.Add(percent); err != nil {
+			return Amount{}, err
+		}
+	}
+	if result.Cmp(schedule.Min) < 0 {
+		result = schedule.Min
+	}
+	if !schedule.Max.IsZero() && result.Cmp(schedule.Max) > 0 {
+		result = schedule.Max
+	}
+	return result, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ParseFees parses the fee list in format "USD=0.3+2.9%,EUR=1%,JPY=30", where
+// each fee has the fixed part, the percentage of the payment amount, or both.
+func ParseFees(source string) (map[string]FeeSchedule, error) {
+	result := map[string]FeeSchedule{}
+	if source == "" {
+		return result, nil
+	}
+	for _, item := range strings.Split(source, ",") {
+		parts := strings.Split(item, "=")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf(`Failed to parse fee "%s"`, item)
+		}
+		var tier FeeTier
+		for _, part := range strings.Split(parts[1], "+") {
+			var err error
+			if strings.HasSuffix(part, "%") {
+				tier.Percent, err = ParseAmount(strings.TrimSuffix(part, "%"))
+			} else {
+				tier.Fixed, err = ParseAmount(part)
+			}
+			if err != nil {
+				return nil, fmt.Errorf(`Failed to parse fee value "%s": %s`,
+					part, err)
+			}
+		}
+		result[parts[0]] = FeeSchedule{Tiers: []FeeTier{tier}}
+	}
+	if err := checkFees(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// LoadFees reads the JSON document with fee schedules by currency, like:
+// {"USD": {"tiers": [{"fixed": "0.3", "percent": "2.9"}], "max": "10"}}.
+func LoadFees(source io.Reader) (map[string]FeeSchedule, error) {
+	result := map[string]FeeSchedule{}
+	decoder := json.NewDecoder(source)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&result); err != nil {
+		return nil, err
+	}
+	if err := checkFees(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// checkFees returns an error if a fee schedule has negative values or values
+// which are not allowed by the currency.
+func checkFees(schedules map[string]FeeSchedule) error {
+	for currency, schedule := range schedules {
+		values := []Amount{schedule.Min, schedule.Max}
+		for _, tier := range schedule.Tiers {
+			if tier.Percent.IsNegative() {
+				return fmt.Errorf(`Fee percentage for "%s" is negative`, currency)
+			}
+			values = append(values, tier.From, tier.Fixed)
+		}
+		for _, value := range values {
+			if value.IsNegative() {
+				return fmt.Errorf(`Fee for "%s" has negative value`, currency)
+			}
+			if err := value.CheckCurrency(currency); err != nil {
+				return err
+			}
+		}
+		if !schedule.Max.IsZero() && schedule.Max.Cmp(schedule.Min) < 0 {
+			return fmt.Errorf(`Maximal fee for "%s" is less than minimal`,
+				currency)
+		}
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,87 @@
+package wallet_test
+
+import (
+	"strings"
+	"testing"
+
+	w "github.com/palchukovsky/wallet"
+)
+
+// Test_Fee_Policy tests fee policy by fee schedules.
+func Test_Fee_Policy(test *testing.T) {
+	fees, err := w.LoadFees(strings.NewReader(`{
+		"USD": {
+			"tiers": [
+				{"from": "1000", "fixed": "1", "percent": "1"},
+				{"fixed": "0.3", "percent": "2.9"}],
+			"min": "0.5",
+			"max": "15"},
+		"JPY": {"tiers": [{"fixed": "30"}]}}`))
+	if err != nil {
+		test.Fatalf(`Failed to load fees: "%s".`, err)
+	}
+	policy := w.CreateFeePolicy(fees)
+
+	for _, check := range []struct {
+		amount   w.Amount
+		currency string
+		fee      w.Amount
+	}{
+		{w.NewAmount(1, 0), "USD", w.NewAmount(5, 1)},
+		{w.NewAmount(10, 0), "USD", w.NewAmount(59, 2)},
+		{w.NewAmount(999, 0), "USD", w.NewAmount(15, 0)},
+		{w.NewAmount(1000, 0), "USD", w.NewAmount(11, 0)},
+		{w.NewAmount(5000, 0), "USD", w.NewAmount(15, 0)},
+		{w.NewAmount(123456, 4), "USD", w.NewAmount(65, 2)},
+		{w.NewAmount(1, 0), "JPY", w.NewAmount(30, 0)},
+		{w.NewAmount(1, 0), "EUR", w.Amount{}}} {
+
+		fee, err := policy.GetFee(check.amount, check.currency)
+		if err != nil || fee != check.fee {
+			test.Errorf(`Wrong fee for %s (%s): "%s", "%v".`,
+				check.amount, check.currency, fee, err)
+		}
+	}
+
+	fees, err = w.ParseFees("USD=0.3+2.9%,EUR=1%,JPY=30")
+	if err != nil {
+		test.Fatalf(`Failed to parse fees: "%s".`, err)
+	}
+	policy = w.CreateFeePolicy(fees)
+	for _, check := range []struct {
+		currency string
+		fee      w.Amount
+	}{
+		{"USD", w.NewAmount(59, 2)},
+		{"EUR", w.NewAmount(1, 1)},
+		{"JPY", w.NewAmount(30, 0)}} {
+
+		fee, err := policy.GetFee(w.NewAmount(10, 0), check.currency)
+		if err != nil || fee != check.fee {
+			test.Errorf(`Wrong fee for "%s": "%s", "%v".`,
+				check.currency, fee, err)
+		}
+	}
+
+	for _, source := range []string{
+		"USD", "USD=", "=1", "USD=abc", "USD=1+abc%", "USD=-1", "USD=-1%",
+		"USD=0.001", "JPY=0.5", "USD=1,"} {
+
+		if _, err := w.ParseFees(source); err == nil {
+			test.Errorf(`Parsing "%s" has to fail.`, source)
+		}
+	}
+	for _, source := range []string{
+		`{"USD": {"min": "2", "max": "1"}}`,
+		`{"USD": {"tiers": [{"from": "-1"}]}}`,
+		`{"USD": {"fixed": "1"}}`,
+		`{"USD": 1}`} {
+
+		if _, err := w.LoadFees(strings.NewReader(source)); err == nil {
+			test.Errorf(`Loading "%s" has to fail.`, source)
+		}
+	}
+	if fees, err := w.ParseFees(""); err != nil || len(fees) != 0 {
+		test.Errorf(`Wrong empty fee list: "%v", "%v".`, fees, err)
+	}
+}
@@ -0,0 +1,1063 @@
+package wallet
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+type memDBAccount struct {
+	account Account
+	pk      int
+	// lock is the row lock, it is held by a transaction which has queried the
+	// account for update, till the transaction end.
+	lock sync.Mutex
+}
+
+type memDBTransRecord struct {
+	pk             int
+	time           time.Time
+	author         string
+	idempotencyKey string
+	hash           string
+}
+
+func (r memDBTransRecord) toTransaction(actions Trans) Transaction {
+	return Transaction{
+		ID:      r.pk,
+		Time:    r.time.UTC(),
+		Author:  r.author,
+		Actions: actions}
+}
+
+type memDBAction struct {
+	accountPk int
+	transPk   int
+	volume    Amount
+	isFee     bool
+}
+
+type memDBHold struct {
+	hold      Hold
+	accountPk int
+}
+
+// memDBHoldUpdate is the changed state of the stored hold.
+type memDBHoldUpdate struct {
+	status     HoldStatus
+	releasedBy string
+}
+
+type memDBChainHead struct {
+	transPk *int
+	hash    string
+}
+
+type memDBStatusChange struct {
+	transPk   int
+	accountPk int
+	status    AccountStatus
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type memDBTrans struct {
+	db *memDB
+	// isActive is false after commit or rollback.
+	isActive bool
+	// locked is the set of rows which are locked by the transaction.
+	locked map[int]*memDBAccount
+	// New and changed data is visible only for this transaction till commit.
+	newAccounts []*memDBAccount
+	updates     map[int]Account
+	trans       []memDBTransRecord
+	actions     []memDBAction
+	newHolds    []memDBHold
+	holdUpdates map[int]memDBHoldUpdate
+	// reversals are original transaction keys by reversal transaction keys.
+	reversals map[int]int
+	// limits are account limits by account keys.
+	limits        map[int]Limits
+	statusChanges []memDBStatusChange
+	// schedules are new and changed schedules by IDs, deleted schedules are
+	// nil.
+	schedules    map[int]*Schedule
+	scheduleRuns []ScheduleRun
+	// adjustments are new and changed adjustments by IDs.
+	adjustments map[int]Adjustment
+	// isChainLocked is true if the transaction holds the chain head lock,
+	// chainHead is the new chain head if it is moved by the transaction.
+	isChainLocked bool
+	chainHead     *memDBChainHead
+}
+
+func (t *memDBTrans) Commit() error {
+	if !t.isActive {
+		return nil
+	}
+	t.db.mutex.Lock()
+	for _, account := range t.newAccounts {
+		if _, has := t.db.accountIndex[account.account.ID]; has {
+			t.db.mutex.Unlock()
+			return newError(ErrAccountExists, `Account "%s" (%s) already exists`,
+				account.account.ID.ID, account.account.ID.Currency)
+		}
+	}
+	for _, trans := range t.trans {
+		if trans.idempotencyKey == "" {
+			continue
+		}
+		if _, has := t.db.idempotencyKeys[trans.idempotencyKey]; has {
+			t.db.mutex.Unlock()
+			return newError(ErrIdempotencyKeyConflict,
+				`Idempotency key "%s" is already used`,
+				trans.idempotencyKey)
+		}
+	}
+	for _, account := range t.newAccounts {
+		t.db.accountIndex[account.account.ID] = account
+		t.db.accounts[account.pk] = account
+	}
+	for pk, account := range t.updates {
+		t.db.accounts[pk].account = account
+	}
+	for _, trans := range t.trans {
+		if trans.idempotencyKey != "" {
+			t.db.idempotencyKeys[trans.idempotencyKey] = trans.pk
+		}
+	}
+	t.db.trans = append(t.db.trans, t.trans...)
+	t.db.actions = append(t.db.actions, t.actions...)
+	for _, hold := range t.newHolds {
+		hold := hold
+		t.db.holds[hold.hold.ID] = &hold
+	}
+	for id, update := range t.holdUpdates {
+		t.db.holds[id].hold.Status = update.status
+		t.db.holds[id].hold.ReleasedBy = update.releasedBy
+	}
+	for transPk, originalPk := range t.reversals {
+		t.db.reversals[transPk] = originalPk
+	}
+	for accountPk, limits := range t.limits {
+		t.db.limits[accountPk] = limits
+	}
+	t.db.statusChanges = append(t.db.statusChanges, t.statusChanges...)
+	t.db.scheduleRuns = append(t.db.scheduleRuns, t.scheduleRuns...)
+	for id, schedule := range t.schedules {
+		if schedule != nil {
+			t.db.schedules[id] = *schedule
+			continue
+		}
+		delete(t.db.schedules, id)
+		// The same as SQL foreign key with cascade deletion.
+		runs := t.db.scheduleRuns[:0]
+		for _, run := range t.db.scheduleRuns {
+			if run.ScheduleID != id {
+				runs = append(runs, run)
+			}
+		}
+		t.db.scheduleRuns = runs
+	}
+	for id, adjustment := range t.adjustments {
+		t.db.adjustments[id] = adjustment
+	}
+	if t.chainHead != nil {
+		t.db.chainHead = *t.chainHead
+	}
+	t.db.mutex.Unlock()
+	t.finish()
+	return nil
+}
+
+func (t *memDBTrans) Rollback() {
+	if !t.isActive {
+		return
+	}
+	t.finish()
+}
+
+func (t *memDBTrans) finish() {
+	for _, account := range t.locked {
+		account.lock.Unlock()
+	}
+	t.locked = nil
+	if t.isChainLocked {
+		t.db.chainLock.Unlock()
+		t.isChainLocked = false
+	}
+	t.newAccounts = nil
+	t.updates = nil
+	t.trans = nil
+	t.actions = nil
+	t.newHolds = nil
+	t.holdUpdates = nil
+	t.reversals = nil
+	t.limits = nil
+	t.statusChanges = nil
+	t.schedules = nil
+	t.scheduleRuns = nil
+	t.adjustments = nil
+	t.chainHead = nil
+	t.isActive = false
+}
+
+// findAccount returns an account record, visible for the transaction, or nil
+// if the account doesn't exist.
+func (t *memDBTrans) findAccount(id AccountID) *memDBAccount {
+	for _, account := range t.newAccounts {
+		if account.account.ID == id {
+			return account
+		}
+	}
+	t.db.mutex.Lock()
+	defer t.db.mutex.Unlock()
+	return t.db.accountIndex[id]
+}
+
+func (t *memDBTrans) lockAccount(account *memDBAccount) {
+	if _, has := t.locked[account.pk]; has {
+		return
+	}
+	// The lock has to be taken without the database mutex as it could wait for
+	// another transaction end.
+	account.lock.Lock()
+	t.locked[account.pk] = account
+}
+
+func (t *memDBTrans) QueryAccount(
+	request AccountID, lock bool) (*Account, *int, error) {
+
+	record := t.findAccount(request)
+	if record == nil {
+		return nil, nil, sql.ErrNoRows
+	}
+	if lock {
+		t.lockAccount(record)
+	}
+	var result Account
+	if update, has := t.updates[record.pk]; has {
+		result = update
+	} else {
+		t.db.mutex.Lock()
+		result = record.account
+		t.db.mutex.Unlock()
+	}
+	result.Held = Amount{}
+	now := time.Now()
+	for _, hold := range t.getHolds() {
+		if hold.accountPk == record.pk && hold.hold.isActive(now) {
+			var err error
+			if result.Held, err = result.Held.Add(hold.hold.Amount); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	pk := record.pk
+	return &result, &pk, nil
+}
+
+func (t *memDBTrans) AddAccount(account Account) error {
+	if t.findAccount(account.ID) != nil {
+		return newError(ErrAccountExists, `Account "%s" (%s) already exists`,
+			account.ID.ID, account.ID.Currency)
+	}
+	t.newAccounts = append(t.newAccounts,
+		&memDBAccount{account: account, pk: t.db.nextPk()})
+	return nil
+}
+
+func (t *memDBTrans) UpdateAccount(account Account, pk int) error {
+	t.db.mutex.Lock()
+	record, has := t.db.accounts[pk]
+	t.db.mutex.Unlock()
+	if !has {
+		for _, newAccount := range t.newAccounts {
+			if newAccount.pk == pk {
+				newAccount.account = account
+				return nil
+			}
+		}
+		// The same as SQL UPDATE for not existent row.
+		return nil
+	}
+	// Update implicitly locks the row as it does SQL UPDATE.
+	t.lockAccount(record)
+	t.updates[pk] = account
+	return nil
+}
+
+func (t *memDBTrans) QueryTransByIdempotencyKey(
+	key string) (*int, Trans, error) {
+
+	for _, trans := range t.trans {
+		if trans.idempotencyKey == key {
+			pk := trans.pk
+			return &pk, t.getTransActions(pk), nil
+		}
+	}
+	t.db.mutex.Lock()
+	defer t.db.mutex.Unlock()
+	pk, has := t.db.idempotencyKeys[key]
+	if !has {
+		return nil, nil, nil
+	}
+	return &pk, t.db.getTransActions(pk), nil
+}
+
+// getTransActions returns actions of the transaction which is not committed
+// yet.
+func (t *memDBTrans) getTransActions(transPk int) Trans {
+	result := Trans{}
+	for _, action := range t.actions {
+		if action.transPk != transPk {
+			continue
+		}
+		var id AccountID
+		for _, account := range t.newAccounts {
+			if account.pk == action.accountPk {
+				id = account.account.ID
+			}
+		}
+		if id == (AccountID{}) {
+			t.db.mutex.Lock()
+			id = t.db.accounts[action.accountPk].account.ID
+			t.db.mutex.Unlock()
+		}
+		result = append(result,
+			BalanceAction{Account: id, Volume: action.volume, Fee: action.isFee})
+	}
+	return result
+}
+
+func (t *memDBTrans) InsertTrans(
+	time time.Time, author string, idempotencyKey string) (*int, error) {
+
+	if idempotencyKey != "" {
+		if pk, _, _ := t.QueryTransByIdempotencyKey(idempotencyKey); pk != nil {
+			return nil,
+				newError(ErrIdempotencyKeyConflict,
+					`Idempotency key "%s" is already used`, idempotencyKey)
+		}
+	}
+	pk := t.db.nextPk()
+	t.trans = append(t.trans,
+		memDBTransRecord{
+			pk:             pk,
+			time:           time,
+			author:         author,
+			idempotencyKey: idempotencyKey})
+	return &pk, nil
+}
+
+func (t *memDBTrans) LockChainHead() (string, error) {
+	if !t.isChainLocked {
+		t.db.chainLock.Lock()
+		t.isChainLocked = true
+	}
+	if t.chainHead != nil {
+		return t.chainHead.hash, nil
+	}
+	t.db.mutex.Lock()
+	defer t.db.mutex.Unlock()
+	return t.db.chainHead.hash, nil
+}
+
+func (t *memDBTrans) SetTransHash(transPk int, hash string) error {
+	for i := range t.trans {
+		if t.trans[i].pk == transPk {
+			t.trans[i].hash = hash
+		}
+	}
+	t.chainHead = &memDBChainHead{transPk: &transPk, hash: hash}
+	return nil
+}
+
+func (t *memDBTrans) InsertAction(
+	accountPk, transPk int, actionVolume Amount, isFee bool) error {
+
+	t.actions = append(t.actions, memDBAction{
+		accountPk: accountPk,
+		transPk:   transPk,
+		volume:    actionVolume,
+		isFee:     isFee})
+	return nil
+}
+
+func (t *memDBTrans) QueryDebits(
+	accountPk int, since time.Time) (Amount, int, error) {
+
+	var sum Amount
+	count := 0
+	addActions := func(
+		trans []memDBTransRecord,
+		actions []memDBAction,
+		reversals map[int]int) error {
+
+		transTime := make(map[int]time.Time, len(trans))
+		for _, record := range trans {
+			transTime[record.pk] = record.time
+		}
+		parties := map[int]int{}
+		for _, action := range actions {
+			parties[action.transPk]++
+		}
+		for _, action := range actions {
+			if action.accountPk != accountPk || !action.volume.IsNegative() {
+				continue
+			}
+			// Only payments are counted, not manager balance updates and
+			// reversals.
+			if _, has := reversals[action.transPk]; has ||
+				parties[action.transPk] < 2 {
+
+				continue
+			}
+			if actionTime, has := transTime[action.transPk]; has &&
+				!actionTime.Before(since) {
+
+				var err error
+				if sum, err = sum.Sub(action.volume); err != nil {
+					return err
+				}
+				count++
+			}
+		}
+		return nil
+	}
+	if err := addActions(t.trans, t.actions, t.reversals); err != nil {
+		return Amount{}, 0, err
+	}
+	t.db.mutex.Lock()
+	err := addActions(t.db.trans, t.db.actions, t.db.reversals)
+	t.db.mutex.Unlock()
+	if err != nil {
+		return Amount{}, 0, err
+	}
+	return sum, count, nil
+}
+
+func (t *memDBTrans) QueryTrans(transPk int) (*Transaction, error) {
+	for _, trans := range t.trans {
+		if trans.pk == transPk {
+			result := trans.toTransaction(t.getTransActions(transPk))
+			if originalPk, has := t.reversals[transPk]; has {
+				result.ReversalOf = &originalPk
+			}
+			return &result, nil
+		}
+	}
+	t.db.mutex.Lock()
+	defer t.db.mutex.Unlock()
+	for _, trans := range t.db.trans {
+		if trans.pk == transPk {
+			result := t.db.getTransaction(trans)
+			return &result, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (t *memDBTrans) QueryReversalActions(transPk int) (Trans, error) {
+	result := Trans{}
+	for reversalPk, originalPk := range t.reversals {
+		if originalPk == transPk {
+			result = append(result, t.getTransActions(reversalPk)...)
+		}
+	}
+	t.db.mutex.Lock()
+	defer t.db.mutex.Unlock()
+	for reversalPk, originalPk := range t.db.reversals {
+		if originalPk == transPk {
+			result = append(result, t.db.getTransActions(reversalPk)...)
+		}
+	}
+	return result, nil
+}
+
+func (t *memDBTrans) InsertReversal(transPk, originalPk int) error {
+	t.reversals[transPk] = originalPk
+	return nil
+}
+
+// getHolds returns holds which are visible for the transaction.
+func (t *memDBTrans) getHolds() []memDBHold {
+	t.db.mutex.Lock()
+	result := make([]memDBHold, 0, len(t.db.holds)+len(t.newHolds))
+	for _, hold := range t.db.holds {
+		result = append(result, *hold)
+	}
+	t.db.mutex.Unlock()
+	result = append(result, t.newHolds...)
+	for i := range result {
+		if update, has := t.holdUpdates[result[i].hold.ID]; has {
+			result[i].hold.Status = update.status
+			result[i].hold.ReleasedBy = update.releasedBy
+		}
+	}
+	return result
+}
+
+func (t *memDBTrans) QueryHold(id int) (*Hold, error) {
+	for _, hold := range t.getHolds() {
+		if hold.hold.ID == id {
+			result := hold.hold
+			return &result, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (t *memDBTrans) InsertHold(
+	hold Hold, accountPk, destinationPk int) (*int, error) {
+
+	hold.ID = t.db.nextPk()
+	t.newHolds = append(t.newHolds, memDBHold{hold: hold, accountPk: accountPk})
+	return &hold.ID, nil
+}
+
+func (t *memDBTrans) UpdateHoldStatus(
+	id int, status HoldStatus, releasedBy string) error {
+
+	for i := range t.newHolds {
+		if t.newHolds[i].hold.ID == id {
+			t.newHolds[i].hold.Status = status
+			t.newHolds[i].hold.ReleasedBy = releasedBy
+			return nil
+		}
+	}
+	t.db.mutex.Lock()
+	_, has := t.db.holds[id]
+	t.db.mutex.Unlock()
+	if has {
+		t.holdUpdates[id] = memDBHoldUpdate{
+			status: status, releasedBy: releasedBy}
+	}
+	// The same as SQL UPDATE for not existent row if the hold is not found.
+	return nil
+}
+
+func (t *memDBTrans) QueryLimits(accountPk int) (*Limits, error) {
+	if limits, has := t.limits[accountPk]; has {
+		return &limits, nil
+	}
+	t.db.mutex.Lock()
+	limits := t.db.limits[accountPk]
+	t.db.mutex.Unlock()
+	return &limits, nil
+}
+
+func (t *memDBTrans) SetLimits(accountPk int, limits Limits) error {
+	t.limits[accountPk] = limits
+	return nil
+}
+
+func (t *memDBTrans) InsertStatusChange(
+	transPk, accountPk int, status AccountStatus) error {
+
+	t.statusChanges = append(t.statusChanges,
+		memDBStatusChange{transPk: transPk, accountPk: accountPk, status: status})
+	return nil
+}
+
+func (t *memDBTrans) QuerySchedule(id int) (*Schedule, error) {
+	schedule, has := t.schedules[id]
+	if !has {
+		t.db.mutex.Lock()
+		if stored, has := t.db.schedules[id]; has {
+			schedule = &stored
+		}
+		t.db.mutex.Unlock()
+	}
+	if schedule == nil {
+		return nil, sql.ErrNoRows
+	}
+	result := *schedule
+	return &result, nil
+}
+
+func (t *memDBTrans) InsertSchedule(
+	schedule Schedule, accountPk, destinationPk int) (*int, error) {
+
+	schedule.ID = t.db.nextPk()
+	t.schedules[schedule.ID] = &schedule
+	return &schedule.ID, nil
+}
+
+func (t *memDBTrans) UpdateSchedule(schedule Schedule) error {
+	stored, err := t.QuerySchedule(schedule.ID)
+	if err == sql.ErrNoRows {
+		// The same as SQL UPDATE for not existent row.
+		return nil
+	}
+	schedule.Account = stored.Account
+	schedule.Destination = stored.Destination
+	schedule.Author = stored.Author
+	t.schedules[schedule.ID] = &schedule
+	return nil
+}
+
+func (t *memDBTrans) DeleteSchedule(id int) error {
+	t.schedules[id] = nil
+	return nil
+}
+
+func (t *memDBTrans) InsertScheduleRun(run ScheduleRun) error {
+	t.scheduleRuns = append(t.scheduleRuns, run)
+	return nil
+}
+
+func (t *memDBTrans) QueryAdjustment(id int) (*Adjustment, error) {
+	adjustment, has := t.adjustments[id]
+	if !has {
+		t.db.mutex.Lock()
+		adjustment, has = t.db.adjustments[id]
+		t.db.mutex.Unlock()
+	}
+	if !has {
+		return nil, sql.ErrNoRows
+	}
+	return &adjustment, nil
+}
+
+func (t *memDBTrans) InsertAdjustment(
+	adjustment Adjustment, accountPk int) (*int, error) {
+
+	adjustment.ID = t.db.nextPk()
+	t.adjustments[adjustment.ID] = adjustment
+	return &adjustment.ID, nil
+}
+
+func (t *memDBTrans) UpdateAdjustment(adjustment Adjustment) error {
+	stored, err := t.QueryAdjustment(adjustment.ID)
+	if err == sql.ErrNoRows {
+		// The same as SQL UPDATE for not existent row.
+		return nil
+	}
+	stored.Status = adjustment.Status
+	stored.Checker = adjustment.Checker
+	stored.DecisionTime = adjustment.DecisionTime
+	stored.TransID = adjustment.TransID
+	t.adjustments[adjustment.ID] = *stored
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type memDB struct {
+	mutex           sync.Mutex
+	lastPk          int
+	accounts        map[int]*memDBAccount
+	accountIndex    map[AccountID]*memDBAccount
+	trans           []memDBTransRecord
+	actions         []memDBAction
+	idempotencyKeys map[string]int
+	holds           map[int]*memDBHold
+	reversals       map[int]int
+	limits          map[int]Limits
+	statusChanges   []memDBStatusChange
+	schedules       map[int]Schedule
+	scheduleRuns    []ScheduleRun
+	adjustments     map[int]Adjustment
+	// chainLock is the lock of the chain head row, chainHead is protected by
+	// the mutex.
+	chainLock sync.Mutex
+	chainHead memDBChainHead
+}
+
+// CreateMemoryDB creates a wallet database which keeps all data in the process
+// memory. The data is lost at the process exit, so it is designed for tests and
+// demonstrations. Transactions are isolated till commit and locked accounts are
+// locked till the transaction end as it does a SQL database.
+func CreateMemoryDB() DB {
+	return &memDB{
+		accounts:        map[int]*memDBAccount{},
+		accountIndex:    map[AccountID]*memDBAccount{},
+		idempotencyKeys: map[string]int{},
+		holds:           map[int]*memDBHold{},
+		reversals:       map[int]int{},
+		limits:          map[int]Limits{},
+		schedules:       map[int]Schedule{},
+		adjustments:     map[int]Adjustment{}}
+}
+
+func (db *memDB) Close() {}
+
+// nextPk generates a new primary key. Keys are unique for all tables and are
+// not reused after rollback, as it does a database sequence.
+func (db *memDB) nextPk() int {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.lastPk++
+	return db.lastPk
+}
+
+func (db *memDB) Begin() (DBTrans, error) {
+	return &memDBTrans{
+			db:          db,
+			isActive:    true,
+			locked:      map[int]*memDBAccount{},
+			updates:     map[int]Account{},
+			holdUpdates: map[int]memDBHoldUpdate{},
+			reversals:   map[int]int{},
+			limits:      map[int]Limits{},
+			schedules:   map[int]*Schedule{},
+			adjustments: map[int]Adjustment{}},
+		nil
+}
+
+func (db *memDB) GetAccounts() ([]Account, error) {
+	db.mutex.Lock()
+	held := map[int]Amount{}
+	now := time.Now()
+	for _, hold := range db.holds {
+		if !hold.hold.isActive(now) {
+			continue
+		}
+		var err error
+		held[hold.accountPk], err = held[hold.accountPk].Add(hold.hold.Amount)
+		if err != nil {
+			db.mutex.Unlock()
+			return nil, err
+		}
+	}
+	result := make([]Account, 0, len(db.accounts))
+	for pk, account := range db.accounts {
+		account := account.account
+		account.Held = held[pk]
+		result = append(result, account)
+	}
+	db.mutex.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		l := result[i].ID
+		r := result[j].ID
+		return l.ID < r.ID || (l.ID == r.ID && l.Currency < r.Currency)
+	})
+	return result, nil
+}
+
+func (db *memDB) GetTransList(query TransQuery) ([]Transaction, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return db.getTransList(query), nil
+}
+
+// getTransList executes GetTransList, it has to be called under the mutex.
+func (db *memDB) getTransList(query TransQuery) []Transaction {
+	transList := make([]memDBTransRecord, len(db.trans))
+	copy(transList, db.trans)
+	sort.Slice(transList, func(i, j int) bool {
+		l := transList[i]
+		r := transList[j]
+		return l.time.Before(r.time) || (l.time.Equal(r.time) && l.pk < r.pk)
+	})
+
+	actions := map[int]Trans{}
+	for _, action := range db.actions {
+		actions[action.transPk] = append(actions[action.transPk],
+			db.getAction(action))
+	}
+
+	result := []Transaction{}
+	for _, trans := range transList {
+		transActions, has := actions[trans.pk]
+		if !has {
+			continue
+		}
+		transaction := trans.toTransaction(transActions)
+		if originalPk, has := db.reversals[trans.pk]; has {
+			transaction.ReversalOf = &originalPk
+		}
+		if !query.isMatched(transaction) {
+			continue
+		}
+		result = append(result, transaction)
+		if len(result) == query.Limit {
+			break
+		}
+	}
+	return result
+}
+
+func (db *memDB) GetStatementTrans(
+	account AccountID, query TransQuery) (Amount, []Transaction, error) {
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	record, has := db.accountIndex[account]
+	if !has {
+		return Amount{}, nil, sql.ErrNoRows
+	}
+	transTime := make(map[int]time.Time, len(db.trans))
+	for _, trans := range db.trans {
+		transTime[trans.pk] = trans.time
+	}
+	balance := Amount{}
+	for _, action := range db.actions {
+		if action.accountPk != record.pk ||
+			!transTime[action.transPk].Before(query.From) {
+
+			continue
+		}
+		var err error
+		if balance, err = balance.Add(action.volume); err != nil {
+			return Amount{}, nil, err
+		}
+	}
+	return balance, db.getTransList(query), nil
+}
+
+func (db *memDB) GetAccountsAt(at time.Time) ([]Account, error) {
+	db.mutex.Lock()
+	transList := make(map[int]memDBTransRecord, len(db.trans))
+	for _, trans := range db.trans {
+		transList[trans.pk] = trans
+	}
+	balances := map[int]Amount{}
+	for _, action := range db.actions {
+		if transList[action.transPk].time.After(at) {
+			continue
+		}
+		var err error
+		balances[action.accountPk], err = balances[action.accountPk].Add(
+			action.volume)
+		if err != nil {
+			db.mutex.Unlock()
+			return nil, err
+		}
+	}
+	// Status changes are stored in the execution order.
+	statuses := map[int]AccountStatus{}
+	for _, change := range db.statusChanges {
+		if !transList[change.transPk].time.After(at) {
+			statuses[change.accountPk] = change.status
+		}
+	}
+	result := make([]Account, 0, len(db.accounts))
+	for pk, account := range db.accounts {
+		balance, hasBalance := balances[pk]
+		status, hasStatus := statuses[pk]
+		if !hasBalance && !hasStatus {
+			continue
+		}
+		result = append(result,
+			Account{ID: account.account.ID, Balance: balance, Status: status})
+	}
+	db.mutex.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		l := result[i].ID
+		r := result[j].ID
+		return l.ID < r.ID || (l.ID == r.ID && l.Currency < r.Currency)
+	})
+	return result, nil
+}
+
+func (db *memDB) GetStatusChanges(
+	account AccountID) ([]AccountStatusChange, error) {
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	record, has := db.accountIndex[account]
+	if !has {
+		return nil, sql.ErrNoRows
+	}
+	transList := make(map[int]memDBTransRecord, len(db.trans))
+	for _, trans := range db.trans {
+		transList[trans.pk] = trans
+	}
+	result := []AccountStatusChange{}
+	for _, change := range db.statusChanges {
+		if change.accountPk != record.pk {
+			continue
+		}
+		trans := transList[change.transPk]
+		result = append(result, AccountStatusChange{
+			TransID: trans.pk,
+			Time:    trans.time.UTC(),
+			Author:  trans.author,
+			Status:  change.status})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		l := result[i]
+		r := result[j]
+		return l.Time.Before(r.Time) ||
+			(l.Time.Equal(r.Time) && l.TransID < r.TransID)
+	})
+	return result, nil
+}
+
+func (db *memDB) GetSchedules() ([]Schedule, error) {
+	db.mutex.Lock()
+	result := make([]Schedule, 0, len(db.schedules))
+	for _, schedule := range db.schedules {
+		result = append(result, schedule)
+	}
+	db.mutex.Unlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+func (db *memDB) GetDueSchedules(now time.Time) ([]Schedule, error) {
+	db.mutex.Lock()
+	result := []Schedule{}
+	for _, schedule := range db.schedules {
+		if schedule.NextRun != nil && !schedule.NextRun.After(now) {
+			result = append(result, schedule)
+		}
+	}
+	db.mutex.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		l := result[i]
+		r := result[j]
+		return l.NextRun.Before(*r.NextRun) ||
+			(l.NextRun.Equal(*r.NextRun) && l.ID < r.ID)
+	})
+	return result, nil
+}
+
+func (db *memDB) GetScheduleRuns(scheduleID int) ([]ScheduleRun, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if _, has := db.schedules[scheduleID]; !has {
+		return nil, sql.ErrNoRows
+	}
+	result := []ScheduleRun{}
+	for _, run := range db.scheduleRuns {
+		if run.ScheduleID == scheduleID {
+			result = append(result, run)
+		}
+	}
+	return result, nil
+}
+
+func (db *memDB) GetAdjustments(
+	status AdjustmentStatus) ([]Adjustment, error) {
+
+	db.mutex.Lock()
+	result := []Adjustment{}
+	for _, adjustment := range db.adjustments {
+		if status == "" || adjustment.Status == status {
+			result = append(result, adjustment)
+		}
+	}
+	db.mutex.Unlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+func (db *memDB) GetChainHead() (*int, string, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return db.chainHead.transPk, db.chainHead.hash, nil
+}
+
+func (db *memDB) GetJournal(f func(entry JournalEntry) bool) error {
+	db.mutex.Lock()
+	entries := make([]JournalEntry, 0, len(db.trans))
+	index := map[int]int{}
+	for _, trans := range db.trans {
+		entry := JournalEntry{
+			Transaction: trans.toTransaction(Trans{}),
+			Hash:        trans.hash}
+		if originalPk, has := db.reversals[trans.pk]; has {
+			entry.ReversalOf = &originalPk
+		}
+		index[trans.pk] = len(entries)
+		entries = append(entries, entry)
+	}
+	for _, action := range db.actions {
+		entry := &entries[index[action.transPk]]
+		entry.Actions = append(entry.Actions, db.getAction(action))
+	}
+	for _, change := range db.statusChanges {
+		entry := &entries[index[change.transPk]]
+		account := db.accounts[change.accountPk].account.ID
+		entry.StatusAccount = &account
+		entry.Status = change.status
+	}
+	for _, adjustment := range db.adjustments {
+		if adjustment.TransID != nil {
+			adjustmentID := adjustment.ID
+			entries[index[*adjustment.TransID]].AdjustmentID = &adjustmentID
+		}
+	}
+	db.mutex.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+	for _, entry := range entries {
+		if !f(entry) {
+			break
+		}
+	}
+	return nil
+}
+
+func (db *memDB) GetBalanceChecks() ([]BalanceCheck, error) {
+	db.mutex.Lock()
+	sums := map[int]Amount{}
+	for _, action := range db.actions {
+		var err error
+		sums[action.accountPk], err = sums[action.accountPk].Add(action.volume)
+		if err != nil {
+			db.mutex.Unlock()
+			return nil, err
+		}
+	}
+	result := make([]BalanceCheck, 0, len(db.accounts))
+	for pk, account := range db.accounts {
+		result = append(result, BalanceCheck{
+			Account:   account.account.ID,
+			Balance:   account.account.Balance,
+			ActionSum: sums[pk]})
+	}
+	db.mutex.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		l := result[i].Account
+		r := result[j].Account
+		return l.ID < r.ID || (l.ID == r.ID && l.Currency < r.Currency)
+	})
+	return result, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// getTransaction returns the committed transaction, it has to be called under
+// the database mutex.
+func (db *memDB) getTransaction(trans memDBTransRecord) Transaction {
+	result := trans.toTransaction(db.getTransActions(trans.pk))
+	if originalPk, has := db.reversals[trans.pk]; has {
+		result.ReversalOf = &originalPk
+	}
+	return result
+}
+
+// getTransActions returns actions of the committed transaction, it has to be
+// called under the database mutex.
+func (db *memDB) getTransActions(transPk int) Trans {
+	result := Trans{}
+	for _, action := range db.actions {
+		if action.transPk == transPk {
+			result = append(result, db.getAction(action))
+		}
+	}
+	return result
+}
+
+// getAction converts the action record, it has to be called under the database
+// mutex.
+func (db *memDB) getAction(action memDBAction) BalanceAction {
+	return BalanceAction{
+		Account: db.accounts[action.accountPk].account.ID,
+		Volume:  action.volume,
+		Fee:     action.isFee}
+}
+
+////////////////////////////////////////////////////////////////////////////////
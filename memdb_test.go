@@ -0,0 +1,157 @@
+package wallet_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	w "github.com/palchukovsky/wallet"
+)
+
+// Test_MemoryDB_Isolation tests that changes are visible for other
+// transactions only after commit.
+func Test_MemoryDB_Isolation(test *testing.T) {
+	db := w.CreateMemoryDB()
+	defer db.Close()
+
+	id := w.AccountID{ID: "qwerty", Currency: "USD"}
+	{
+		trans, err := db.Begin()
+		if err != nil {
+			test.Fatalf(`Failed to begin: "%s".`, err)
+		}
+		if err := trans.AddAccount(w.Account{ID: id}); err != nil {
+			test.Fatalf(`Failed to add account: "%s".`, err)
+		}
+		if err := trans.AddAccount(w.Account{ID: id}); err == nil ||
+			err.Error() != `Account "qwerty" (USD) already exists` {
+
+			test.Errorf(`Wrong error: "%v".`, err)
+		}
+		if _, _, err := trans.QueryAccount(id, false); err != nil {
+			test.Errorf(`Own account is not visible: "%s".`, err)
+		}
+		if list, _ := db.GetAccounts(); len(list) != 0 {
+			test.Errorf(`Not committed account is visible: "%v".`, list)
+		}
+		trans.Rollback()
+		if list, _ := db.GetAccounts(); len(list) != 0 {
+			test.Errorf(`Rolled back account is visible: "%v".`, list)
+		}
+	}
+
+	var pk int
+	{
+		trans, _ := db.Begin()
+		if err := trans.AddAccount(w.Account{ID: id}); err != nil {
+			test.Fatalf(`Failed to add account: "%s".`, err)
+		}
+		if err := trans.Commit(); err != nil {
+			test.Fatalf(`Failed to commit: "%s".`, err)
+		}
+		trans.Rollback()
+		trans, _ = db.Begin()
+		account, accountPk, err := trans.QueryAccount(id, false)
+		if err != nil || account.ID != id || !account.Balance.IsZero() {
+			test.Fatalf(`Wrong account: "%v", "%v".`, account, err)
+		}
+		pk = *accountPk
+		trans.Rollback()
+	}
+
+	{
+		trans, _ := db.Begin()
+		balance := w.NewAmount(10, 0)
+		if err := trans.UpdateAccount(w.Account{ID: id, Balance: balance}, pk); err != nil {
+			test.Fatalf(`Failed to update account: "%s".`, err)
+		}
+		transPk, err := trans.InsertTrans(time.Now(), "tester", "")
+		if err != nil {
+			test.Fatalf(`Failed to insert transaction: "%s".`, err)
+		}
+		if err := trans.InsertAction(pk, *transPk, balance, false); err != nil {
+			test.Fatalf(`Failed to insert action: "%s".`, err)
+		}
+		if account, _, _ := trans.QueryAccount(id, false); account.Balance != balance {
+			test.Errorf(`Own update is not visible: "%v".`, account)
+		}
+		if list, _ := db.GetAccounts(); len(list) != 1 || !list[0].Balance.IsZero() {
+			test.Errorf(`Not committed update is visible: "%v".`, list)
+		}
+		if list, _ := db.GetTransList(w.TransQuery{}); len(list) != 0 {
+			test.Errorf(`Not committed transaction is visible: "%v".`, list)
+		}
+		if err := trans.Commit(); err != nil {
+			test.Fatalf(`Failed to commit: "%s".`, err)
+		}
+		if list, _ := db.GetAccounts(); len(list) != 1 || list[0].Balance != balance {
+			test.Errorf(`Committed update is not visible: "%v".`, list)
+		}
+		list, _ := db.GetTransList(w.TransQuery{})
+		if len(list) != 1 || list[0].ID != *transPk ||
+			list[0].Author != "tester" || list[0].Time.Location() != time.UTC ||
+			len(list[0].Actions) != 1 ||
+			list[0].Actions[0] != (w.BalanceAction{Account: id, Volume: balance}) {
+
+			test.Errorf(`Committed transaction is not visible: "%v".`, list)
+		}
+	}
+
+	{
+		trans, _ := db.Begin()
+		_, _, err := trans.QueryAccount(w.AccountID{ID: "unknown"}, true)
+		if err != sql.ErrNoRows {
+			test.Errorf(`Wrong error: "%v".`, err)
+		}
+		trans.Rollback()
+	}
+}
+
+// Test_MemoryDB_Lock tests that an account locked by a transaction could not
+// be locked by another one till the first transaction end.
+func Test_MemoryDB_Lock(test *testing.T) {
+	db := w.CreateMemoryDB()
+	defer db.Close()
+
+	id := w.AccountID{ID: "qwerty", Currency: "USD"}
+	{
+		trans, _ := db.Begin()
+		trans.AddAccount(w.Account{ID: id})
+		trans.Commit()
+	}
+
+	first, _ := db.Begin()
+	account, pk, err := first.QueryAccount(id, true)
+	if err != nil {
+		test.Fatalf(`Failed to query account: "%s".`, err)
+	}
+
+	locked := make(chan w.Account)
+	go func() {
+		second, _ := db.Begin()
+		defer second.Rollback()
+		account, _, _ := second.QueryAccount(id, true)
+		locked <- *account
+	}()
+
+	select {
+	case <-locked:
+		test.Fatal("Account is locked twice.")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	account.Balance = w.NewAmount(123, 0)
+	first.UpdateAccount(*account, *pk)
+	if err := first.Commit(); err != nil {
+		test.Fatalf(`Failed to commit: "%s".`, err)
+	}
+
+	select {
+	case account := <-locked:
+		if account.Balance != w.NewAmount(123, 0) {
+			test.Errorf(`Wrong account state after lock: "%v".`, account)
+		}
+	case <-time.After(time.Second):
+		test.Fatal("Account is not unlocked at commit.")
+	}
+}
@@ -0,0 +1,112 @@
+package wallet
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TransQuery describes a filter and a page of the transaction list. Zero
+// values of fields don't filter transactions.
+type TransQuery struct {
+	// Account selects transactions which have an action for the account ID in
+	// any currency, or in the currency Currency if it is set.
+	Account string
+	// Currency selects transactions which have an action in the currency.
+	Currency string
+	// Author selects transactions by the transaction initiator.
+	Author string
+	// From is the inclusive beginning of the transaction time range.
+	From time.Time
+	// To is the exclusive end of the transaction time range.
+	To time.Time
+	// After is the position of the last transaction of the previous page, the
+	// result starts from the next transaction.
+	After *TransCursor
+	// Limit is the maximum number of transactions in the result, 0 means no
+	// limit.
+	Limit int
+}
+
+// TransCursor is a position in the transaction list, which is ordered by
+// transaction time and ID.
+type TransCursor struct {
+	Time time.Time
+	ID   int
+}
+
+// Cursor returns the position of the transaction in the transaction list.
+func (t Transaction) Cursor() TransCursor {
+	return TransCursor{Time: t.Time, ID: t.ID}
+}
+
+// String returns text representation of the cursor, which could be parsed by
+// ParseTransCursor.
+func (c TransCursor) String() string {
+	return fmt.Sprintf("%d-%d", c.Time.UnixNano(), c.ID)
+}
+
+// IsAfter returns true if the transaction follows the cursor position.
+func (c TransCursor) IsAfter(trans Transaction) bool {
+	return trans.Time.After(c.Time) ||
+		(trans.Time.Equal(c.Time) && trans.ID > c.ID)
+}
+
+// ParseTransCursor parses text representation of the cursor.
+func ParseTransCursor(source string) (TransCursor, error) {
+	parts := strings.Split(source, "-")
+	if len(parts) != 2 {
+		return TransCursor{}, fmt.Errorf(`Failed to parse cursor "%s"`, source)
+	}
+	nanoseconds, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return TransCursor{}, fmt.Errorf(`Failed to parse cursor "%s"`, source)
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return TransCursor{}, fmt.Errorf(`Failed to parse cursor "%s"`, source)
+	}
+	return TransCursor{Time: time.Unix(0, nanoseconds).UTC(), ID: id}, nil
+}
+
+// Check returns an error if the query has inconsistent arguments.
+func (q TransQuery) Check() error {
+	if q.Limit < 0 {
+		return newError(ErrInvalidQuery,
+			"Transaction list limit %d is negative", q.Limit)
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return newError(ErrInvalidQuery, "Transaction time range %s - %s is empty",
+			q.From.Format(time.RFC3339Nano), q.To.Format(time.RFC3339Nano))
+	}
+	return nil
+}
+
+// isMatched returns true if the transaction passes the query filter without
+// the page restrictions.
+func (q TransQuery) isMatched(trans Transaction) bool {
+	if q.Author != "" && trans.Author != q.Author {
+		return false
+	}
+	if !q.From.IsZero() && trans.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !trans.Time.Before(q.To) {
+		return false
+	}
+	if q.After != nil && !q.After.IsAfter(trans) {
+		return false
+	}
+	if q.Account == "" && q.Currency == "" {
+		return true
+	}
+	for _, action := range trans.Actions {
+		if (q.Account == "" || action.Account.ID == q.Account) &&
+			(q.Currency == "" || action.Account.Currency == q.Currency) {
+
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package wallet
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// CurrencyPair describes the direction of currency exchange.
+type CurrencyPair struct {
+	From string
+	To   string
+}
+
+// RateProvider provides currency exchange rates.
+type RateProvider interface {
+	// GetRate returns the amount of the currency "to" for one unit of the
+	// currency "from".
+	GetRate(pair CurrencyPair) (*big.Rat, error)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type fixedRateProvider struct {
+	rates map[CurrencyPair]*big.Rat
+}
+
+// CreateFixedRateProvider creates rate provider with the constant rate list. If
+// the list has no rate for the requested pair, but has the rate for the
+// reverse pair, the provider returns the reverse rate.
+func CreateFixedRateProvider(rates map[CurrencyPair]*big.Rat) RateProvider {
+	return &fixedRateProvider{rates: rates}
+}
+
+func (p *fixedRateProvider) GetRate(pair CurrencyPair) (*big.Rat, error) {
+	if result, has := p.rates[pair]; has {
+		return new(big.Rat).Set(result), nil
+	}
+	if result, has := p.rates[CurrencyPair{From: pair.To, To: pair.From}]; has {
+		return new(big.Rat).Inv(result), nil
+	}
+	return nil,
+		newError(ErrNoExchangeRate,
+			`There is no exchange rate for "%s" to "%s"`,
+			pair.From, pair.To)
+}
+
+// ParseRates parses the rate list in format "USD/EUR=0.9,EUR/RUB=70.5", where
+// each rate is the amount of the second currency for one unit of the first
+// currency.
+func ParseRates(source string) (map[CurrencyPair]*big.Rat, error) {
+	result := map[CurrencyPair]*big.Rat{}
+	if source == "" {
+		return result, nil
+	}
+	for _, item := range strings.Split(source, ",") {
+		parts := strings.Split(item, "=")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf(`Failed to parse rate "%s"`, item)
+		}
+		currencies := strings.Split(parts[0], "/")
+		if len(currencies) != 2 || currencies[0] == "" || currencies[1] == "" {
+			return nil, fmt.Errorf(`Failed to parse currency pair "%s"`, parts[0])
+		}
+		rate, ok := new(big.Rat).SetString(parts[1])
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf(`Failed to parse rate value "%s"`, parts[1])
+		}
+		result[CurrencyPair{From: currencies[0], To: currencies[1]}] = rate
+	}
+	return result, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,48 @@
+package wallet_test
+
+import (
+	"math/big"
+	"testing"
+
+	w "github.com/palchukovsky/wallet"
+)
+
+// Test_Rate_Fixed tests fixed rate provider.
+func Test_Rate_Fixed(test *testing.T) {
+	rates, err := w.ParseRates("USD/EUR=0.8,EUR/RUB=70.5")
+	if err != nil {
+		test.Fatalf(`Failed to parse rates: "%s".`, err)
+	}
+	provider := w.CreateFixedRateProvider(rates)
+
+	for _, check := range []struct {
+		pair w.CurrencyPair
+		rate *big.Rat
+	}{
+		{w.CurrencyPair{From: "USD", To: "EUR"}, big.NewRat(4, 5)},
+		{w.CurrencyPair{From: "EUR", To: "USD"}, big.NewRat(5, 4)},
+		{w.CurrencyPair{From: "EUR", To: "RUB"}, big.NewRat(141, 2)}} {
+
+		rate, err := provider.GetRate(check.pair)
+		if err != nil || rate.Cmp(check.rate) != 0 {
+			test.Errorf(`Wrong rate for "%v": "%v", "%v".`, check.pair, rate, err)
+		}
+	}
+
+	_, err = provider.GetRate(w.CurrencyPair{From: "USD", To: "RUB"})
+	if err == nil || err.Error() != `There is no exchange rate for "USD" to "RUB"` {
+		test.Errorf(`Wrong error: "%v".`, err)
+	}
+
+	for _, source := range []string{
+		"USD", "USD/EUR", "USD=1", "USD/=1", "USD/EUR=0", "USD/EUR=-1",
+		"USD/EUR=abc", "USD/EUR=1,"} {
+
+		if _, err := w.ParseRates(source); err == nil {
+			test.Errorf(`Parsing "%s" has to fail.`, source)
+		}
+	}
+	if rates, err := w.ParseRates(""); err != nil || len(rates) != 0 {
+		test.Errorf(`Wrong empty rate list: "%v", "%v".`, rates, err)
+	}
+}
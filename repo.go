@@ -1,6 +1,9 @@
 package wallet
 
 import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"sort"
 	"time"
@@ -11,6 +14,12 @@ type RepoTrans interface {
 	// GetAccount returns references to account state, or error if the account
 	// record was not prefetched.
 	GetAccount(id AccountID) (*Account, error)
+	// GetLimits returns limits of the prefetched account.
+	GetLimits(id AccountID) (*Limits, error)
+	// GetDebits returns the sum of debits, as a positive amount, and the number
+	// of debits of the prefetched account since the time. Debits of the
+	// current transaction are not counted.
+	GetDebits(id AccountID, since time.Time) (Amount, int, error)
 }
 
 // Repo describes repository interface.
@@ -21,12 +30,220 @@ type Repo interface {
 	AddAccount(account Account) error
 	// Modify takes bussiness transaction to prefetch data, then calls f with
 	// prefetched data and applies changes by a transaction if f has not
-	// returned an error.
-	Modify(trans Trans, author string, f func(tans RepoTrans) error) error
+	// returned an error. The transaction is stored after f call. Returns the
+	// stored transaction ID. If idempotency key is not empty and a transaction
+	// with this key already exists, Modify does not call f and returns the ID
+	// of the stored transaction if it has the same actions, or an error
+	// otherwise. For such replay Modify calls replay, if it is set, with the
+	// stored actions and prefetched data, so the result of the stored
+	// transaction could be built.
+	Modify(
+		trans Trans,
+		author string,
+		idempotencyKey string,
+		f func(tans RepoTrans) error,
+		replay func(stored Trans, repoTrans RepoTrans) error) (int, error)
+	// CalculateVolumes returns the repository which does not compare volumes
+	// of actions of the accounts with the stored transaction of the same
+	// idempotency key by each Modify call, so such volumes could be calculated
+	// at the execution, like converted amounts by the actual exchange rate.
+	CalculateVolumes(accounts ...AccountID) Repo
 	// GetAccounts returns full account list.
 	GetAccounts() ([]Account, error)
-	// GetTransList returns full transaction list.
-	GetTransList() ([]Trans, error)
+	// GetAccount returns the account state without locking, or an error if the
+	// account doesn't exist.
+	GetAccount(id AccountID) (*Account, error)
+	// GetTransList returns the transaction list page, ordered by transaction
+	// time and ID, with transactions which pass the query filter.
+	GetTransList(query TransQuery) ([]Transaction, error)
+	// GetStatementTrans returns the account balance at the query start time
+	// and the transaction list page of the query, which are read at the same
+	// moment, or an error if the account doesn't exist.
+	GetStatementTrans(
+		account AccountID, query TransQuery) (Amount, []Transaction, error)
+	// GetAccountsAt returns the account list with balances and statuses as of
+	// the time, including the transactions which were executed at the time.
+	// Accounts without transactions by the time are not listed.
+	GetAccountsAt(at time.Time) ([]Account, error)
+
+	// AddHold locks the hold account and the destination, calls f with their
+	// states and stores the new active hold if f has not returned an error. The
+	// hold ID, time and status are set by the repository. Returns the stored
+	// hold.
+	AddHold(hold Hold, f func(account, destination Account) error) (*Hold, error)
+	// GetHold returns the hold, or ErrHoldNotFound if the hold doesn't exist.
+	GetHold(id int) (*Hold, error)
+	// ReleaseHold sets the status "released" for the hold which is not captured
+	// or released yet, the author is stored as the release initiator. Returns
+	// the released hold.
+	ReleaseHold(id int, author string) (*Hold, error)
+	// CaptureHold returns the repository which captures the active hold by each
+	// Modify call, so the hold amount is not held anymore for f and the
+	// transaction is stored only with the status "captured" of the hold. The
+	// hold account has to be in the transaction.
+	CaptureHold(id int) Repo
+
+	// GetTrans returns the transaction, or ErrTransNotFound if the transaction
+	// doesn't exist.
+	GetTrans(id int) (*Transaction, error)
+	// ReversePayment returns the repository which stores each transaction of
+	// Modify as a reversal of the payment. Each transaction action has to
+	// return funds of the payment action for the same account, the sum of all
+	// reversals of the payment could not exceed the payment volume for each
+	// account.
+	ReversePayment(id int) Repo
+
+	// GetLimits returns limits of the account, or an error if the account
+	// doesn't exist.
+	GetLimits(id AccountID) (*Limits, error)
+	// SetLimits replaces limits of the account, or returns an error if the
+	// account doesn't exist.
+	SetLimits(id AccountID, limits Limits) error
+
+	// SetAccountStatus locks the account, calls f with the account state and
+	// sets the new account status if f has not returned an error. The change
+	// is stored as a transaction of the author without actions. Returns the
+	// stored change.
+	SetAccountStatus(
+		id AccountID,
+		status AccountStatus,
+		author string,
+		f func(account Account) error) (*AccountStatusChange, error)
+	// GetAccountStatusChanges returns status changes of the account ordered by
+	// time, or an error if the account doesn't exist.
+	GetAccountStatusChanges(id AccountID) ([]AccountStatusChange, error)
+
+	// SetOverdraft locks the account, calls f with the account state and sets
+	// the new account overdraft if f has not returned an error. Returns the new
+	// account state.
+	SetOverdraft(
+		id AccountID,
+		overdraft Amount,
+		f func(account Account) error) (*Account, error)
+
+	// AddSchedule locks the schedule account, calls f with the account state
+	// and stores the new schedule if f has not returned an error. The schedule
+	// ID is set by the repository. Returns the stored schedule.
+	AddSchedule(
+		schedule Schedule, f func(account Account) error) (*Schedule, error)
+	// GetSchedule returns the schedule, or ErrScheduleNotFound if the schedule
+	// doesn't exist.
+	GetSchedule(id int) (*Schedule, error)
+	// GetSchedules returns all schedules ordered by ID.
+	GetSchedules() ([]Schedule, error)
+	// GetDueSchedules returns schedules with the next run time which is not
+	// after the time, ordered by the next run time.
+	GetDueSchedules(now time.Time) ([]Schedule, error)
+	// ModifySchedule locks the schedule account, calls f with the schedule and
+	// stores the schedule, modified by f, with the run attempt if the run is
+	// not nil. Nothing is stored if f has returned an error. Schedule accounts
+	// could not be changed. Returns the stored schedule.
+	ModifySchedule(
+		id int,
+		run *ScheduleRun,
+		f func(schedule *Schedule) error) (*Schedule, error)
+	// DeleteSchedule deletes the schedule with its runs. Returns the deleted
+	// schedule.
+	DeleteSchedule(id int) (*Schedule, error)
+	// GetScheduleRuns returns run attempts of the schedule in the execution
+	// order, or ErrScheduleNotFound if the schedule doesn't exist.
+	GetScheduleRuns(id int) ([]ScheduleRun, error)
+
+	// AddAdjustment locks the adjustment account, calls f with the account
+	// state and stores the new pending adjustment if f has not returned an
+	// error. The adjustment ID, time and status are set by the repository.
+	// Returns the stored adjustment.
+	AddAdjustment(
+		adjustment Adjustment,
+		f func(account Account) error) (*Adjustment, error)
+	// GetAdjustment returns the adjustment, or ErrAdjustmentNotFound if the
+	// adjustment doesn't exist.
+	GetAdjustment(id int) (*Adjustment, error)
+	// GetAdjustments returns adjustments with the status ordered by ID, empty
+	// status means all adjustments.
+	GetAdjustments(status AdjustmentStatus) ([]Adjustment, error)
+	// ApproveAdjustment returns the repository which approves the pending
+	// adjustment by each Modify call, so the transaction is stored only with
+	// the status "approved" of the adjustment. The transaction has to have the
+	// only action of the adjustment.
+	ApproveAdjustment(id int, checker string) Repo
+	// RejectAdjustment sets the status "rejected" for the pending adjustment.
+	// Returns the rejected adjustment.
+	RejectAdjustment(id int, checker string) (*Adjustment, error)
+
+	// VerifyJournal checks the hash chain of all stored transactions and
+	// reports the first transaction which does not match its hash.
+	VerifyJournal() (*JournalReport, error)
+	// VerifyIntegrity recomputes account balances from actions and checks that
+	// each client transaction nets to zero in each currency. Client
+	// transactions move funds between accounts, so they have two or more
+	// actions, a manager transaction has one action.
+	VerifyIntegrity() (*IntegrityReport, error)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// isSameTrans returns true if the stored transaction has the same actions as
+// the requested transaction in any order. Volumes of calculated accounts are
+// calculated at execution, so they are not compared.
+func isSameTrans(requested, stored Trans, calculated []AccountID) bool {
+	if len(requested) != len(stored) {
+		return false
+	}
+	sortTrans := func(source Trans) Trans {
+		result := make(Trans, len(source))
+		copy(result, source)
+		sort.SliceStable(result, func(i, j int) bool {
+			return accountIDList{result[i].Account, result[j].Account}.Less(0, 1)
+		})
+		return result
+	}
+	requested = sortTrans(requested)
+	stored = sortTrans(stored)
+	isCalculated := func(id AccountID) bool {
+		for _, account := range calculated {
+			if account == id {
+				return true
+			}
+		}
+		return false
+	}
+	for i := range requested {
+		if requested[i].Account != stored[i].Account ||
+			(requested[i].Volume != stored[i].Volume &&
+				!isCalculated(requested[i].Account)) {
+
+			return false
+		}
+	}
+	return true
+}
+
+// newHoldNotFoundError creates an error about the not existent hold.
+func newHoldNotFoundError(id int) error {
+	return newError(ErrHoldNotFound, `Hold %d doesn't exist`, id)
+}
+
+// newScheduleNotFoundError creates an error about the not existent schedule.
+func newScheduleNotFoundError(id int) error {
+	return newError(ErrScheduleNotFound, `Schedule %d doesn't exist`, id)
+}
+
+// newAdjustmentNotFoundError creates an error about the not existent
+// adjustment.
+func newAdjustmentNotFoundError(id int) error {
+	return newError(ErrAdjustmentNotFound, `Adjustment %d doesn't exist`, id)
+}
+
+// newTransNotFoundError creates an error about the not existent transaction.
+func newTransNotFoundError(id int) error {
+	return newError(ErrTransNotFound, `Transaction %d doesn't exist`, id)
+}
+
+// newAccountNotFoundError creates an error about the not existent account.
+func newAccountNotFoundError(id AccountID) error {
+	return newError(ErrAccountNotFound,
+		`Account "%s" (%s) doesn't exist`, id.ID, id.Currency)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -80,6 +297,9 @@ func (t *repoTrans) load(trans Trans) error {
 	}{}
 	for _, id := range accountIDs {
 		account, pk, err := t.db.QueryAccount(id, true)
+		if err == sql.ErrNoRows {
+			return newAccountNotFoundError(id)
+		}
 		if err != nil {
 			return err
 		}
@@ -101,6 +321,33 @@ func (t *repoTrans) GetAccount(id AccountID) (*Account, error) {
 		fmt.Errorf(`Account "%s" (%s) was not prefetched`, id.ID, id.Currency)
 }
 
+func (t *repoTrans) GetLimits(id AccountID) (*Limits, error) {
+	pk, err := t.getAccountPk(id)
+	if err != nil {
+		return nil, err
+	}
+	return t.db.QueryLimits(pk)
+}
+
+func (t *repoTrans) GetDebits(
+	id AccountID, since time.Time) (Amount, int, error) {
+
+	pk, err := t.getAccountPk(id)
+	if err != nil {
+		return Amount{}, 0, err
+	}
+	return t.db.QueryDebits(pk, since)
+}
+
+// getAccountPk returns the primary key of the prefetched account.
+func (t *repoTrans) getAccountPk(id AccountID) (int, error) {
+	if result, ok := t.accounts[id]; ok {
+		return result.pk, nil
+	}
+	return 0,
+		fmt.Errorf(`Account "%s" (%s) was not prefetched`, id.ID, id.Currency)
+}
+
 func (t *repoTrans) commit() error {
 	for _, account := range t.accounts {
 		if err := t.db.UpdateAccount(*account.account, account.pk); err != nil {
@@ -112,25 +359,247 @@ func (t *repoTrans) commit() error {
 
 func (t *repoTrans) rollback() { t.db.Rollback() }
 
-func (t *repoTrans) storeTrans(trans Trans, author string) error {
-	transPk, err := t.db.InsertTrans(time.Now().UTC(), author)
+// checkIdempotencyKey returns the ID of the transaction with the idempotency
+// key if it is already stored, or error if the key was used for another
+// transaction. Volumes of calculated accounts are not compared.
+func (t *repoTrans) checkIdempotencyKey(
+	trans Trans,
+	idempotencyKey string,
+	calculated []AccountID) (*int, Trans, error) {
+
+	if idempotencyKey == "" {
+		return nil, nil, nil
+	}
+	transPk, storedTrans, err := t.db.QueryTransByIdempotencyKey(idempotencyKey)
+	if err != nil || transPk == nil {
+		return nil, nil, err
+	}
+	if !isSameTrans(trans, storedTrans, calculated) {
+		return nil, nil,
+			newError(ErrIdempotencyKeyConflict,
+				`Idempotency key "%s" is already used for another transaction`,
+				idempotencyKey)
+	}
+	return transPk, storedTrans, nil
+}
+
+func (t *repoTrans) queryTrans(id int) (*Transaction, error) {
+	result, err := t.db.QueryTrans(id)
+	if err == sql.ErrNoRows {
+		return nil, newTransNotFoundError(id)
+	}
+	return result, err
+}
+
+// checkReversal returns an error if the transaction is not a reversal of the
+// payment or if it returns more than the rest of the payment.
+func (t *repoTrans) checkReversal(trans Trans, paymentID int) error {
+	payment, err := t.queryTrans(paymentID)
+	if err != nil {
+		return err
+	}
+	if payment.ReversalOf != nil {
+		return newError(ErrInvalidTrans,
+			`Transaction %d is a reversal and it could not be reversed`, paymentID)
+	}
+	reversals, err := t.db.QueryReversalActions(paymentID)
 	if err != nil {
 		return err
 	}
 	for _, action := range trans {
-		account, ok := t.accounts[action.Account]
-		if !ok {
-			return fmt.Errorf(`Transaction account "%s" (%s) was not prefetched`,
-				action.Account.ID, action.Account.Currency)
+		var paid *Amount
+		for _, paymentAction := range payment.Actions {
+			if paymentAction.Account == action.Account {
+				paid = &paymentAction.Volume
+				break
+			}
 		}
-		err = t.db.InsertAction(account.pk, *transPk, action.Volume)
+		if paid == nil || paid.IsZero() ||
+			action.Volume.IsNegative() == paid.IsNegative() {
+
+			return newError(ErrInvalidTrans,
+				`Account "%s" (%s) action does not reverse transaction %d`,
+				action.Account.ID, action.Account.Currency, paymentID)
+		}
+		rest, err := paid.Add(action.Volume)
 		if err != nil {
 			return err
 		}
+		for _, reversal := range reversals {
+			if reversal.Account != action.Account {
+				continue
+			}
+			if rest, err = rest.Add(reversal.Volume); err != nil {
+				return err
+			}
+		}
+		if !rest.IsZero() && rest.IsNegative() != paid.IsNegative() {
+			return newError(ErrInvalidAmount,
+				`Reversal amount for account "%s" (%s) exceeds`+
+					` the rest of transaction %d`,
+				action.Account.ID, action.Account.Currency, paymentID)
+		}
 	}
 	return nil
 }
 
+func (t *repoTrans) queryHold(id int) (*Hold, error) {
+	result, err := t.db.QueryHold(id)
+	if err == sql.ErrNoRows {
+		return nil, newHoldNotFoundError(id)
+	}
+	return result, err
+}
+
+func (t *repoTrans) querySchedule(id int) (*Schedule, error) {
+	result, err := t.db.QuerySchedule(id)
+	if err == sql.ErrNoRows {
+		return nil, newScheduleNotFoundError(id)
+	}
+	return result, err
+}
+
+// lockSchedule locks the schedule account and returns the schedule, which
+// could be modified only with the locked account.
+func (t *repoTrans) lockSchedule(id int) (*Schedule, error) {
+	schedule, err := t.querySchedule(id)
+	if err != nil {
+		return nil, err
+	}
+	// The schedule has to be queried again after locking, as it could be
+	// modified by another transaction.
+	err = t.load(Trans{BalanceAction{Account: schedule.Account}})
+	if err != nil {
+		return nil, err
+	}
+	return t.querySchedule(id)
+}
+
+func (t *repoTrans) queryAdjustment(id int) (*Adjustment, error) {
+	result, err := t.db.QueryAdjustment(id)
+	if err == sql.ErrNoRows {
+		return nil, newAdjustmentNotFoundError(id)
+	}
+	return result, err
+}
+
+// queryPendingAdjustment returns the adjustment of the prefetched account, or
+// an error if the adjustment is already approved or rejected.
+func (t *repoTrans) queryPendingAdjustment(id int) (*Adjustment, error) {
+	adjustment, err := t.queryAdjustment(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = t.getAccountPk(adjustment.Account); err != nil {
+		return nil, err
+	}
+	if adjustment.Status != AdjustmentPending {
+		return nil,
+			newError(ErrAdjustmentNotPending, `Adjustment %d is already %s`,
+				id, adjustment.Status)
+	}
+	return adjustment, nil
+}
+
+// captureHold sets the status "captured" for the active hold and excludes the
+// hold amount from the held amount of the prefetched hold account.
+func (t *repoTrans) captureHold(id int) error {
+	hold, err := t.queryHold(id)
+	if err != nil {
+		return err
+	}
+	if !hold.isActive(time.Now()) {
+		return newError(ErrHoldNotActive, `Hold %d is not active`, id)
+	}
+	account, ok := t.accounts[hold.Account]
+	if !ok {
+		return fmt.Errorf(`Hold account "%s" (%s) was not prefetched`,
+			hold.Account.ID, hold.Account.Currency)
+	}
+	account.account.Held, err = account.account.Held.Sub(hold.Amount)
+	if err != nil {
+		return err
+	}
+	return t.db.UpdateHoldStatus(id, HoldCaptured, "")
+}
+
+// storeTrans stores the transaction of the entry with its actions and adds the
+// entry to the hash chain. The entry links have to be stored by the caller in
+// the same database transaction. Returns the stored transaction.
+func (t *repoTrans) storeTrans(
+	entry JournalEntry, idempotencyKey string) (*Transaction, error) {
+
+	// The chain head is locked before the transaction insertion, so
+	// transaction IDs grow in the chain order.
+	prevHash, err := t.db.LockChainHead()
+	if err != nil {
+		return nil, err
+	}
+	// PostgreSQL stores time with microseconds, so the hash is calculated for
+	// the time which is read back.
+	entry.Time = time.Now().UTC().Truncate(time.Microsecond)
+	transPk, err := t.db.InsertTrans(entry.Time, entry.Author, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	entry.ID = *transPk
+	for _, action := range entry.Actions {
+		account, ok := t.accounts[action.Account]
+		if !ok {
+			return nil,
+				fmt.Errorf(`Transaction account "%s" (%s) was not prefetched`,
+					action.Account.ID, action.Account.Currency)
+		}
+		err = t.db.InsertAction(
+			account.pk, entry.ID, action.Volume, action.Fee)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = t.db.SetTransHash(entry.ID, hashTrans(prevHash, entry))
+	if err != nil {
+		return nil, err
+	}
+	return &entry.Transaction, nil
+}
+
+// hashTrans returns the hex-encoded SHA-256 hash of the previous transaction
+// hash and the journal entry content: transaction ID, time, author, actions
+// ordered by accounts with fee marks and entry links. The stored entry hash is
+// not hashed.
+func hashTrans(prevHash string, entry JournalEntry) string {
+	actions := make(Trans, len(entry.Actions))
+	copy(actions, entry.Actions)
+	sort.Slice(actions, func(i, j int) bool {
+		l := actions[i].Account
+		r := actions[j].Account
+		return l.ID < r.ID || (l.ID == r.ID && l.Currency < r.Currency)
+	})
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%d\n%s\n%q\n", prevHash, entry.ID,
+		entry.Time.UTC().Format(time.RFC3339Nano), entry.Author)
+	for _, action := range actions {
+		fmt.Fprintf(hash, "%q %q %s",
+			action.Account.ID, action.Account.Currency, action.Volume)
+		// Actions without fees are hashed as before fee marks.
+		if action.Fee {
+			fmt.Fprint(hash, " fee")
+		}
+		fmt.Fprint(hash, "\n")
+	}
+	if entry.ReversalOf != nil {
+		fmt.Fprintf(hash, "reversal %d\n", *entry.ReversalOf)
+	}
+	if entry.StatusAccount != nil {
+		fmt.Fprintf(hash, "status %q %q %q\n", entry.StatusAccount.ID,
+			entry.StatusAccount.Currency, entry.Status)
+	}
+	if entry.AdjustmentID != nil {
+		fmt.Fprintf(hash, "adjustment %d\n", *entry.AdjustmentID)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
 type repo struct{ db DB }
@@ -153,27 +622,748 @@ func (r *repo) AddAccount(account Account) error {
 }
 
 func (r *repo) Modify(
-	trans Trans, author string, f func(tans RepoTrans) error) error {
+	trans Trans,
+	author string,
+	idempotencyKey string,
+	f func(tans RepoTrans) error,
+	replay func(stored Trans, repoTrans RepoTrans) error) (int, error) {
+
+	return r.modify(trans, author, idempotencyKey, modifyExtension{}, f, replay)
+}
+
+// modifyExtension checks and stores additional data of the modification, each
+// function is optional.
+type modifyExtension struct {
+	// prepare is called with prefetched data before f, if the transaction is
+	// not stored yet.
+	prepare func(trans Trans, dbTrans *repoTrans) error
+	// store is called after the transaction storing.
+	store func(transID int, dbTrans *repoTrans) error
+	// calculated lists accounts which action volumes are not compared by the
+	// idempotency key check.
+	calculated []AccountID
+	// links are the journal entry links of the transaction, which are stored
+	// by store.
+	links JournalEntry
+}
+
+// modify executes Modify with the extension.
+func (r *repo) modify(
+	trans Trans,
+	author string,
+	idempotencyKey string,
+	extension modifyExtension,
+	f func(tans RepoTrans) error,
+	replay func(stored Trans, repoTrans RepoTrans) error) (int, error) {
 
 	dbTrans, err := createRepoTrans(r.db)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer dbTrans.rollback()
 	if err = dbTrans.load(trans); err != nil {
-		return err
+		return 0, err
+	}
+	// The key is checked after accounts locking, so a concurrent request with
+	// the same transaction waits for the first request end and finds its key.
+	storedID, storedActions, err := dbTrans.checkIdempotencyKey(
+		trans, idempotencyKey, extension.calculated)
+	if err != nil {
+		return 0, err
+	}
+	if storedID != nil {
+		// Accounts of the stored transaction are the same as the requested and
+		// they are locked, so the replay reads their actual states.
+		if replay != nil {
+			if err = replay(storedActions, dbTrans); err != nil {
+				return 0, err
+			}
+		}
+		return *storedID, nil
+	}
+	if extension.prepare != nil {
+		if err = extension.prepare(trans, dbTrans); err != nil {
+			return 0, err
+		}
 	}
 	if err = f(dbTrans); err != nil {
+		return 0, err
+	}
+	entry := extension.links
+	entry.Author = author
+	entry.Actions = trans
+	stored, err := dbTrans.storeTrans(entry, idempotencyKey)
+	if err != nil {
+		return 0, err
+	}
+	id := stored.ID
+	if extension.store != nil {
+		if err = extension.store(id, dbTrans); err != nil {
+			return 0, err
+		}
+	}
+	if err = dbTrans.commit(); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (r *repo) CalculateVolumes(accounts ...AccountID) Repo {
+	return &calculatedVolumesRepo{repo: r, accounts: accounts}
+}
+
+func (r *repo) GetAccounts() ([]Account, error) { return r.db.GetAccounts() }
+
+func (r *repo) GetAccount(id AccountID) (*Account, error) {
+	trans, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer trans.Rollback()
+	result, _, err := trans.QueryAccount(id, false)
+	if err == sql.ErrNoRows {
+		return nil, newAccountNotFoundError(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (r *repo) GetTransList(query TransQuery) ([]Transaction, error) {
+	return r.db.GetTransList(query)
+}
+
+func (r *repo) GetStatementTrans(
+	account AccountID, query TransQuery) (Amount, []Transaction, error) {
+
+	balance, list, err := r.db.GetStatementTrans(account, query)
+	if err == sql.ErrNoRows {
+		return Amount{}, nil, newAccountNotFoundError(account)
+	}
+	return balance, list, err
+}
+
+func (r *repo) GetAccountsAt(at time.Time) ([]Account, error) {
+	return r.db.GetAccountsAt(at)
+}
+
+func (r *repo) AddHold(
+	hold Hold, f func(account, destination Account) error) (*Hold, error) {
+
+	trans, err := createRepoTrans(r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer trans.rollback()
+	// The destination is locked too, so it could not be removed or closed until
+	// the hold is stored.
+	err = trans.load(
+		Trans{
+			BalanceAction{Account: hold.Account},
+			BalanceAction{Account: hold.Destination}})
+	if err != nil {
+		return nil, err
+	}
+	account := trans.accounts[hold.Account]
+	destination := trans.accounts[hold.Destination]
+	if err = f(*account.account, *destination.account); err != nil {
+		return nil, err
+	}
+
+	hold.Time = time.Now().UTC()
+	hold.Status = HoldActive
+	id, err := trans.db.InsertHold(hold, account.pk, destination.pk)
+	if err != nil {
+		return nil, err
+	}
+	hold.ID = *id
+	if err = trans.db.Commit(); err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+func (r *repo) GetHold(id int) (*Hold, error) {
+	trans, err := createRepoTrans(r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer trans.rollback()
+	result, err := trans.queryHold(id)
+	if err != nil {
+		return nil, err
+	}
+	result.setActualStatus(time.Now())
+	return result, nil
+}
+
+func (r *repo) ReleaseHold(id int, author string) (*Hold, error) {
+	trans, err := createRepoTrans(r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer trans.rollback()
+	hold, err := trans.queryHold(id)
+	if err != nil {
+		return nil, err
+	}
+	// The hold is modified only with the locked account, so the hold has to be
+	// queried again after locking.
+	if err = trans.load(Trans{BalanceAction{Account: hold.Account}}); err != nil {
+		return nil, err
+	}
+	if hold, err = trans.queryHold(id); err != nil {
+		return nil, err
+	}
+	// Expired hold is stored as active, so it could be released too.
+	if hold.Status != HoldActive {
+		return nil, newError(ErrHoldNotActive, `Hold %d is not active`, id)
+	}
+	if err = trans.db.UpdateHoldStatus(id, HoldReleased, author); err != nil {
+		return nil, err
+	}
+	if err = trans.db.Commit(); err != nil {
+		return nil, err
+	}
+	hold.Status = HoldReleased
+	hold.ReleasedBy = author
+	return hold, nil
+}
+
+func (r *repo) CaptureHold(id int) Repo {
+	return &holdCaptureRepo{repo: r, holdID: id}
+}
+
+func (r *repo) GetTrans(id int) (*Transaction, error) {
+	trans, err := createRepoTrans(r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer trans.rollback()
+	return trans.queryTrans(id)
+}
+
+func (r *repo) ReversePayment(id int) Repo {
+	return &paymentReversalRepo{repo: r, paymentID: id}
+}
+
+func (r *repo) GetLimits(id AccountID) (*Limits, error) {
+	trans, err := createRepoTrans(r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer trans.rollback()
+	_, pk, err := trans.db.QueryAccount(id, false)
+	if err == sql.ErrNoRows {
+		return nil, newAccountNotFoundError(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return trans.db.QueryLimits(*pk)
+}
+
+func (r *repo) SetLimits(id AccountID, limits Limits) error {
+	trans, err := createRepoTrans(r.db)
+	if err != nil {
+		return err
+	}
+	defer trans.rollback()
+	// The account is locked, so limits are not changed while a payment checks
+	// them.
+	if err = trans.load(Trans{BalanceAction{Account: id}}); err != nil {
+		return err
+	}
+	pk, err := trans.getAccountPk(id)
+	if err != nil {
 		return err
 	}
-	if err = dbTrans.storeTrans(trans, author); err != nil {
+	if err = trans.db.SetLimits(pk, limits); err != nil {
 		return err
 	}
-	return dbTrans.commit()
+	return trans.db.Commit()
 }
 
-func (r *repo) GetAccounts() ([]Account, error) { return r.db.GetAccounts() }
+func (r *repo) SetAccountStatus(
+	id AccountID,
+	status AccountStatus,
+	author string,
+	f func(account Account) error) (*AccountStatusChange, error) {
+
+	trans, err := createRepoTrans(r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer trans.rollback()
+	if err = trans.load(Trans{BalanceAction{Account: id}}); err != nil {
+		return nil, err
+	}
+	account := trans.accounts[id]
+	if err = f(*account.account); err != nil {
+		return nil, err
+	}
+	account.account.Status = status
+
+	stored, err := trans.storeTrans(
+		JournalEntry{
+			Transaction:   Transaction{Author: author, Actions: Trans{}},
+			StatusAccount: &id,
+			Status:        status},
+		"")
+	if err != nil {
+		return nil, err
+	}
+	result := &AccountStatusChange{
+		TransID: stored.ID, Time: stored.Time, Author: author, Status: status}
+	err = trans.db.InsertStatusChange(result.TransID, account.pk, status)
+	if err != nil {
+		return nil, err
+	}
+	if err = trans.commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (r *repo) GetAccountStatusChanges(
+	id AccountID) ([]AccountStatusChange, error) {
+
+	result, err := r.db.GetStatusChanges(id)
+	if err == sql.ErrNoRows {
+		return nil, newAccountNotFoundError(id)
+	}
+	return result, err
+}
+
+func (r *repo) SetOverdraft(
+	id AccountID,
+	overdraft Amount,
+	f func(account Account) error) (*Account, error) {
+
+	trans, err := createRepoTrans(r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer trans.rollback()
+	if err = trans.load(Trans{BalanceAction{Account: id}}); err != nil {
+		return nil, err
+	}
+	account := trans.accounts[id]
+	if err = f(*account.account); err != nil {
+		return nil, err
+	}
+	account.account.Overdraft = overdraft
+	result := *account.account
+	if err = trans.commit(); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
 
-func (r *repo) GetTransList() ([]Trans, error) { return r.db.GetTransList() }
+func (r *repo) AddSchedule(
+	schedule Schedule, f func(account Account) error) (*Schedule, error) {
+
+	trans, err := createRepoTrans(r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer trans.rollback()
+	// The destination is locked too, so it could not be removed until the
+	// schedule is stored.
+	err = trans.load(
+		Trans{
+			BalanceAction{Account: schedule.Account},
+			BalanceAction{Account: schedule.Destination}})
+	if err != nil {
+		return nil, err
+	}
+	account := trans.accounts[schedule.Account]
+	if err = f(*account.account); err != nil {
+		return nil, err
+	}
+	id, err := trans.db.InsertSchedule(
+		schedule, account.pk, trans.accounts[schedule.Destination].pk)
+	if err != nil {
+		return nil, err
+	}
+	schedule.ID = *id
+	if err = trans.db.Commit(); err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+func (r *repo) GetSchedule(id int) (*Schedule, error) {
+	trans, err := createRepoTrans(r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer trans.rollback()
+	return trans.querySchedule(id)
+}
+
+func (r *repo) GetSchedules() ([]Schedule, error) {
+	return r.db.GetSchedules()
+}
+
+func (r *repo) GetDueSchedules(now time.Time) ([]Schedule, error) {
+	return r.db.GetDueSchedules(now)
+}
+
+func (r *repo) ModifySchedule(
+	id int,
+	run *ScheduleRun,
+	f func(schedule *Schedule) error) (*Schedule, error) {
+
+	trans, err := createRepoTrans(r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer trans.rollback()
+	schedule, err := trans.lockSchedule(id)
+	if err != nil {
+		return nil, err
+	}
+	account, destination := schedule.Account, schedule.Destination
+	if err = f(schedule); err != nil {
+		return nil, err
+	}
+	schedule.ID = id
+	schedule.Account, schedule.Destination = account, destination
+	if err = trans.db.UpdateSchedule(*schedule); err != nil {
+		return nil, err
+	}
+	if run != nil {
+		run.ScheduleID = id
+		if err = trans.db.InsertScheduleRun(*run); err != nil {
+			return nil, err
+		}
+	}
+	if err = trans.db.Commit(); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+func (r *repo) DeleteSchedule(id int) (*Schedule, error) {
+	trans, err := createRepoTrans(r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer trans.rollback()
+	schedule, err := trans.lockSchedule(id)
+	if err != nil {
+		return nil, err
+	}
+	if err = trans.db.DeleteSchedule(id); err != nil {
+		return nil, err
+	}
+	if err = trans.db.Commit(); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+func (r *repo) GetScheduleRuns(id int) ([]ScheduleRun, error) {
+	result, err := r.db.GetScheduleRuns(id)
+	if err == sql.ErrNoRows {
+		return nil, newScheduleNotFoundError(id)
+	}
+	return result, err
+}
+
+func (r *repo) AddAdjustment(
+	adjustment Adjustment,
+	f func(account Account) error) (*Adjustment, error) {
+
+	trans, err := createRepoTrans(r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer trans.rollback()
+	err = trans.load(Trans{BalanceAction{Account: adjustment.Account}})
+	if err != nil {
+		return nil, err
+	}
+	account := trans.accounts[adjustment.Account]
+	if err = f(*account.account); err != nil {
+		return nil, err
+	}
+
+	adjustment.Time = time.Now().UTC()
+	adjustment.Status = AdjustmentPending
+	id, err := trans.db.InsertAdjustment(adjustment, account.pk)
+	if err != nil {
+		return nil, err
+	}
+	adjustment.ID = *id
+	if err = trans.db.Commit(); err != nil {
+		return nil, err
+	}
+	return &adjustment, nil
+}
+
+func (r *repo) GetAdjustment(id int) (*Adjustment, error) {
+	trans, err := createRepoTrans(r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer trans.rollback()
+	return trans.queryAdjustment(id)
+}
+
+func (r *repo) GetAdjustments(status AdjustmentStatus) ([]Adjustment, error) {
+	return r.db.GetAdjustments(status)
+}
+
+func (r *repo) VerifyJournal() (*JournalReport, error) {
+	// Transactions, which are stored after the head reading, are verified
+	// too, but the head transaction has to be found.
+	headPk, headHash, err := r.db.GetChainHead()
+	if err != nil {
+		return nil, err
+	}
+	result := &JournalReport{Valid: true}
+	isHeadFound := headPk == nil
+	prevHash := ""
+	err = r.db.GetJournal(func(entry JournalEntry) bool {
+		result.Transactions++
+		if hashTrans(prevHash, entry) != entry.Hash {
+			result.setBroken(entry.ID, `Transaction %d does not match its hash`,
+				entry.ID)
+			return false
+		}
+		if headPk != nil && *headPk == entry.ID {
+			if entry.Hash != headHash {
+				result.setBroken(entry.ID,
+					`Transaction %d does not match the chain head`, entry.ID)
+				return false
+			}
+			isHeadFound = true
+		}
+		prevHash = entry.Hash
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Valid && !isHeadFound {
+		result.setBroken(*headPk,
+			`Transaction %d of the chain head is not found`, *headPk)
+	}
+	return result, nil
+}
+
+func (r *repo) VerifyIntegrity() (*IntegrityReport, error) {
+	result := &IntegrityReport{
+		BalanceDiscrepancies: []BalanceCheck{},
+		TransImbalances:      []TransImbalance{}}
+	var sumErr error
+	err := r.db.GetJournal(func(trans JournalEntry) bool {
+		result.Transactions++
+		if len(trans.Actions) < 2 {
+			return true
+		}
+		nets := map[string]Amount{}
+		for _, action := range trans.Actions {
+			currency := action.Account.Currency
+			nets[currency], sumErr = nets[currency].Add(action.Volume)
+			if sumErr != nil {
+				return false
+			}
+		}
+		imbalances := []TransImbalance{}
+		for currency, net := range nets {
+			if !net.IsZero() {
+				imbalances = append(imbalances, TransImbalance{
+					TransID: trans.ID, Currency: currency, Net: net})
+			}
+		}
+		sort.Slice(imbalances, func(i, j int) bool {
+			return imbalances[i].Currency < imbalances[j].Currency
+		})
+		result.TransImbalances = append(result.TransImbalances, imbalances...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sumErr != nil {
+		return nil, sumErr
+	}
+
+	checks, err := r.db.GetBalanceChecks()
+	if err != nil {
+		return nil, err
+	}
+	result.Accounts = len(checks)
+	for _, check := range checks {
+		if check.Balance != check.ActionSum {
+			result.BalanceDiscrepancies = append(result.BalanceDiscrepancies, check)
+		}
+	}
+
+	result.Valid = len(result.BalanceDiscrepancies) == 0 &&
+		len(result.TransImbalances) == 0
+	return result, nil
+}
+
+func (r *repo) ApproveAdjustment(id int, checker string) Repo {
+	return &adjustmentApprovalRepo{repo: r, adjustmentID: id, checker: checker}
+}
+
+func (r *repo) RejectAdjustment(id int, checker string) (*Adjustment, error) {
+	trans, err := createRepoTrans(r.db)
+	if err != nil {
+		return nil, err
+	}
+	defer trans.rollback()
+	adjustment, err := trans.queryAdjustment(id)
+	if err != nil {
+		return nil, err
+	}
+	// The adjustment is modified only with the locked account, so it has to be
+	// queried again after locking.
+	err = trans.load(Trans{BalanceAction{Account: adjustment.Account}})
+	if err != nil {
+		return nil, err
+	}
+	if adjustment, err = trans.queryPendingAdjustment(id); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	adjustment.Status = AdjustmentRejected
+	adjustment.Checker = checker
+	adjustment.DecisionTime = &now
+	if err = trans.db.UpdateAdjustment(*adjustment); err != nil {
+		return nil, err
+	}
+	if err = trans.db.Commit(); err != nil {
+		return nil, err
+	}
+	return adjustment, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// holdCaptureRepo captures the hold by each modification.
+type holdCaptureRepo struct {
+	*repo
+	holdID int
+}
+
+func (r *holdCaptureRepo) Modify(
+	trans Trans,
+	author string,
+	idempotencyKey string,
+	f func(tans RepoTrans) error,
+	replay func(stored Trans, repoTrans RepoTrans) error) (int, error) {
+
+	return r.modify(trans, author, idempotencyKey,
+		modifyExtension{
+			prepare: func(trans Trans, dbTrans *repoTrans) error {
+				return dbTrans.captureHold(r.holdID)
+			}},
+		f, replay)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// calculatedVolumesRepo does not compare calculated volumes by the idempotency
+// key check of each modification.
+type calculatedVolumesRepo struct {
+	*repo
+	accounts []AccountID
+}
+
+func (r *calculatedVolumesRepo) Modify(
+	trans Trans,
+	author string,
+	idempotencyKey string,
+	f func(tans RepoTrans) error,
+	replay func(stored Trans, repoTrans RepoTrans) error) (int, error) {
+
+	return r.modify(trans, author, idempotencyKey,
+		modifyExtension{calculated: r.accounts}, f, replay)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// paymentReversalRepo stores each modification as a reversal of the payment.
+type paymentReversalRepo struct {
+	*repo
+	paymentID int
+}
+
+func (r *paymentReversalRepo) Modify(
+	trans Trans,
+	author string,
+	idempotencyKey string,
+	f func(tans RepoTrans) error,
+	replay func(stored Trans, repoTrans RepoTrans) error) (int, error) {
+
+	// The reversal is checked after accounts locking, so concurrent reversals
+	// of the same payment are checked one by one.
+	return r.modify(trans, author, idempotencyKey,
+		modifyExtension{
+			prepare: func(trans Trans, dbTrans *repoTrans) error {
+				return dbTrans.checkReversal(trans, r.paymentID)
+			},
+			store: func(transID int, dbTrans *repoTrans) error {
+				return dbTrans.db.InsertReversal(transID, r.paymentID)
+			},
+			links: JournalEntry{
+				Transaction: Transaction{ReversalOf: &r.paymentID}}},
+		f, replay)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// adjustmentApprovalRepo approves the adjustment by each modification.
+type adjustmentApprovalRepo struct {
+	*repo
+	adjustmentID int
+	checker      string
+}
+
+func (r *adjustmentApprovalRepo) Modify(
+	trans Trans,
+	author string,
+	idempotencyKey string,
+	f func(tans RepoTrans) error,
+	replay func(stored Trans, repoTrans RepoTrans) error) (int, error) {
+
+	// The adjustment status is checked after accounts locking, so concurrent
+	// decisions on the same adjustment are made one by one.
+	var adjustment *Adjustment
+	return r.modify(trans, author, idempotencyKey,
+		modifyExtension{
+			prepare: func(trans Trans, dbTrans *repoTrans) error {
+				var err error
+				adjustment, err = dbTrans.queryPendingAdjustment(r.adjustmentID)
+				if err != nil {
+					return err
+				}
+				if len(trans) != 1 || trans[0].Account != adjustment.Account ||
+					trans[0].Volume.Cmp(adjustment.Amount) != 0 {
+
+					return newError(ErrInvalidTrans,
+						`Transaction is not the action of adjustment %d`,
+						r.adjustmentID)
+				}
+				return nil
+			},
+			store: func(transID int, dbTrans *repoTrans) error {
+				now := time.Now().UTC()
+				adjustment.Status = AdjustmentApproved
+				adjustment.Checker = r.checker
+				adjustment.DecisionTime = &now
+				adjustment.TransID = &transID
+				return dbTrans.db.UpdateAdjustment(*adjustment)
+			},
+			links: JournalEntry{AdjustmentID: &r.adjustmentID}},
+		f, replay)
+}
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -1,8 +1,11 @@
 package wallet_test
 
 import (
+	"database/sql"
 	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	w "github.com/palchukovsky/wallet"
@@ -16,7 +19,7 @@ func Test_Repo_AddAccount(test *testing.T) {
 
 	account := w.Account{
 		ID:      w.AccountID{ID: "qwerty", Currency: "123456"},
-		Balance: 12345.6789}
+		Balance: w.NewAmount(123456789, 4)}
 	{
 		errText := "Test error"
 		db := mw.NewMockDB(ctrl)
@@ -75,14 +78,14 @@ func Test_Repo_Modify_Success(test *testing.T) {
 	defer ctrl.Finish()
 
 	transData := []w.BalanceAction{
-		w.BalanceAction{Account: w.AccountID{ID: "bbb", Currency: "AAA"}, Volume: 2},
-		w.BalanceAction{Account: w.AccountID{ID: "aaa", Currency: "AAA"}, Volume: 1112},
-		w.BalanceAction{Account: w.AccountID{ID: "bbb", Currency: "AAA"}, Volume: -2},
-		w.BalanceAction{Account: w.AccountID{ID: "bbb", Currency: "AAA"}, Volume: 45},
-		w.BalanceAction{Account: w.AccountID{ID: "aaa", Currency: "AAA"}, Volume: -23},
-		w.BalanceAction{Account: w.AccountID{ID: "bbb", Currency: "BBB"}, Volume: -2},
-		w.BalanceAction{Account: w.AccountID{ID: "bbb", Currency: "AAA"}, Volume: 2342},
-		w.BalanceAction{Account: w.AccountID{ID: "aaa", Currency: "BBB"}, Volume: 1112}}
+		w.BalanceAction{Account: w.AccountID{ID: "bbb", Currency: "AAA"}, Volume: w.NewAmount(2, 0)},
+		w.BalanceAction{Account: w.AccountID{ID: "aaa", Currency: "AAA"}, Volume: w.NewAmount(1112, 0)},
+		w.BalanceAction{Account: w.AccountID{ID: "bbb", Currency: "AAA"}, Volume: w.NewAmount(-2, 0)},
+		w.BalanceAction{Account: w.AccountID{ID: "bbb", Currency: "AAA"}, Volume: w.NewAmount(45, 0)},
+		w.BalanceAction{Account: w.AccountID{ID: "aaa", Currency: "AAA"}, Volume: w.NewAmount(-23, 0)},
+		w.BalanceAction{Account: w.AccountID{ID: "bbb", Currency: "BBB"}, Volume: w.NewAmount(-2, 0)},
+		w.BalanceAction{Account: w.AccountID{ID: "bbb", Currency: "AAA"}, Volume: w.NewAmount(2342, 0)},
+		w.BalanceAction{Account: w.AccountID{ID: "aaa", Currency: "BBB"}, Volume: w.NewAmount(1112, 0)}}
 	pk1 := 1
 	pk2 := 2
 	pk3 := 3
@@ -100,40 +103,43 @@ func Test_Repo_Modify_Success(test *testing.T) {
 	dbTrans.EXPECT().Rollback().
 		After(dbTrans.EXPECT().Commit().Return(nil).Do(func(...interface{}) { hasCommit = true }).
 			After(dbTrans.EXPECT().QueryAccount(w.AccountID{ID: "bbb", Currency: "BBB"}, true).
-				Return(&w.Account{ID: w.AccountID{ID: "bbb", Currency: "BBB"}, Balance: 4}, &pk4, nil).
+				Return(&w.Account{ID: w.AccountID{ID: "bbb", Currency: "BBB"}, Balance: w.NewAmount(4, 0)}, &pk4, nil).
 				Do(func(...interface{}) {
-					dbTrans.EXPECT().UpdateAccount(w.Account{ID: w.AccountID{ID: "bbb", Currency: "BBB"}, Balance: 400}, 4).Return(nil).Do(checkCommit)
-					dbTrans.EXPECT().UpdateAccount(w.Account{ID: w.AccountID{ID: "aaa", Currency: "BBB"}, Balance: 3}, 3).Return(nil).Do(checkCommit)
-					dbTrans.EXPECT().UpdateAccount(w.Account{ID: w.AccountID{ID: "bbb", Currency: "AAA"}, Balance: 200}, 2).Return(nil).Do(checkCommit)
-					dbTrans.EXPECT().UpdateAccount(w.Account{ID: w.AccountID{ID: "aaa", Currency: "AAA"}, Balance: 1}, 1).Return(nil).Do(checkCommit)
+					dbTrans.EXPECT().UpdateAccount(w.Account{ID: w.AccountID{ID: "bbb", Currency: "BBB"}, Balance: w.NewAmount(400, 0)}, 4).Return(nil).Do(checkCommit)
+					dbTrans.EXPECT().UpdateAccount(w.Account{ID: w.AccountID{ID: "aaa", Currency: "BBB"}, Balance: w.NewAmount(3, 0)}, 3).Return(nil).Do(checkCommit)
+					dbTrans.EXPECT().UpdateAccount(w.Account{ID: w.AccountID{ID: "bbb", Currency: "AAA"}, Balance: w.NewAmount(200, 0)}, 2).Return(nil).Do(checkCommit)
+					dbTrans.EXPECT().UpdateAccount(w.Account{ID: w.AccountID{ID: "aaa", Currency: "AAA"}, Balance: w.NewAmount(1, 0)}, 1).Return(nil).Do(checkCommit)
 					transPk := 99
-					dbTrans.EXPECT().InsertTrans(gomock.Any(), "tester").Return(&transPk, nil).Do(checkCommit)
-					dbTrans.EXPECT().InsertAction(gomock.Any(), transPk, gomock.Any()).Times(len(transData)).Return(nil).Do(checkCommit)
+					dbTrans.EXPECT().LockChainHead().Return("", nil).Do(checkCommit)
+					dbTrans.EXPECT().InsertTrans(gomock.Any(), "tester", "").Return(&transPk, nil).Do(checkCommit)
+					dbTrans.EXPECT().InsertAction(gomock.Any(), transPk, gomock.Any(), false).Times(len(transData)).Return(nil).Do(checkCommit)
+					dbTrans.EXPECT().SetTransHash(transPk, gomock.Any()).Return(nil).Do(checkCommit)
 				}).
 				After(dbTrans.EXPECT().QueryAccount(w.AccountID{ID: "aaa", Currency: "BBB"}, true).
-					Return(&w.Account{ID: w.AccountID{ID: "aaa", Currency: "BBB"}, Balance: 3}, &pk3, nil).
+					Return(&w.Account{ID: w.AccountID{ID: "aaa", Currency: "BBB"}, Balance: w.NewAmount(3, 0)}, &pk3, nil).
 					After(dbTrans.EXPECT().QueryAccount(w.AccountID{ID: "bbb", Currency: "AAA"}, true).
-						Return(&w.Account{ID: w.AccountID{ID: "bbb", Currency: "AAA"}, Balance: 2}, &pk2, nil).
+						Return(&w.Account{ID: w.AccountID{ID: "bbb", Currency: "AAA"}, Balance: w.NewAmount(2, 0)}, &pk2, nil).
 						After(dbTrans.EXPECT().QueryAccount(w.AccountID{ID: "aaa", Currency: "AAA"}, true).
-							Return(&w.Account{ID: w.AccountID{ID: "aaa", Currency: "AAA"}, Balance: 1}, &pk1, nil).
+							Return(&w.Account{ID: w.AccountID{ID: "aaa", Currency: "AAA"}, Balance: w.NewAmount(1, 0)}, &pk1, nil).
 							After(db.EXPECT().Begin().Return(dbTrans, nil)))))))
 
 	repo := w.CreateRepo(db)
-	err := repo.Modify(
+	id, err := repo.Modify(
 		transData,
 		"tester",
+		"",
 		func(dbTrans w.RepoTrans) error {
 			checkCommit()
 			account, err := dbTrans.GetAccount(w.AccountID{ID: "bbb", Currency: "AAA"})
-			if err != nil || account == nil || account.Balance != 2 {
+			if err != nil || account == nil || account.Balance != w.NewAmount(2, 0) {
 				test.Errorf(`Failed to get account: "%s".`, err)
 			}
-			account.Balance = 200
+			account.Balance = w.NewAmount(200, 0)
 			account, err = dbTrans.GetAccount(w.AccountID{ID: "bbb", Currency: "BBB"})
-			if err != nil || account == nil || account.Balance != 4 {
+			if err != nil || account == nil || account.Balance != w.NewAmount(4, 0) {
 				test.Errorf(`Failed to get account: "%s".`, err)
 			}
-			account.Balance = 400
+			account.Balance = w.NewAmount(400, 0)
 			account, err = dbTrans.GetAccount(w.AccountID{ID: "5", Currency: "555"})
 			if err == nil || err.Error() != `Account "5" (555) was not prefetched` ||
 				account != nil {
@@ -141,10 +147,13 @@ func Test_Repo_Modify_Success(test *testing.T) {
 				test.Errorf(`Error expected: %v, %v.`, err, account)
 			}
 			return nil
-		})
+		}, nil)
 	if err != nil {
 		test.Errorf(`Failed to modify: "%s".`, err)
 	}
+	if id != 99 {
+		test.Errorf(`Wrong transaction ID: %d.`, id)
+	}
 }
 
 // Test_Repo_Modify_Success tests repository modification commit error.
@@ -153,7 +162,7 @@ func Test_Repo_Modify_CommitError(test *testing.T) {
 	defer ctrl.Finish()
 
 	transData := []w.BalanceAction{
-		w.BalanceAction{Account: w.AccountID{ID: "bbb", Currency: "AAA"}, Volume: 2}}
+		w.BalanceAction{Account: w.AccountID{ID: "bbb", Currency: "AAA"}, Volume: w.NewAmount(2, 0)}}
 	pk1 := 1
 
 	hasCommit := false
@@ -169,23 +178,26 @@ func Test_Repo_Modify_CommitError(test *testing.T) {
 	dbTrans.EXPECT().Rollback().
 		After(dbTrans.EXPECT().Commit().Return(errors.New(errText)).Do(func(...interface{}) { hasCommit = true }).
 			After(dbTrans.EXPECT().QueryAccount(w.AccountID{ID: "bbb", Currency: "AAA"}, true).
-				Return(&w.Account{ID: w.AccountID{ID: "bbb", Currency: "AAA"}, Balance: 1}, &pk1, nil).
+				Return(&w.Account{ID: w.AccountID{ID: "bbb", Currency: "AAA"}, Balance: w.NewAmount(1, 0)}, &pk1, nil).
 				Do(func(...interface{}) {
-					dbTrans.EXPECT().UpdateAccount(w.Account{ID: w.AccountID{ID: "bbb", Currency: "AAA"}, Balance: 1}, 1).Return(nil).Do(checkCommit)
+					dbTrans.EXPECT().UpdateAccount(w.Account{ID: w.AccountID{ID: "bbb", Currency: "AAA"}, Balance: w.NewAmount(1, 0)}, 1).Return(nil).Do(checkCommit)
 					transPk := 99
-					dbTrans.EXPECT().InsertTrans(gomock.Any(), "tester").Return(&transPk, nil).Do(checkCommit)
-					dbTrans.EXPECT().InsertAction(gomock.Any(), transPk, gomock.Any()).Times(len(transData)).Return(nil).Do(checkCommit)
+					dbTrans.EXPECT().LockChainHead().Return("", nil).Do(checkCommit)
+					dbTrans.EXPECT().InsertTrans(gomock.Any(), "tester", "").Return(&transPk, nil).Do(checkCommit)
+					dbTrans.EXPECT().InsertAction(gomock.Any(), transPk, gomock.Any(), false).Times(len(transData)).Return(nil).Do(checkCommit)
+					dbTrans.EXPECT().SetTransHash(transPk, gomock.Any()).Return(nil).Do(checkCommit)
 				}).
 				After(db.EXPECT().Begin().Return(dbTrans, nil))))
 
 	repo := w.CreateRepo(db)
-	err := repo.Modify(
+	_, err := repo.Modify(
 		transData,
 		"tester",
+		"",
 		func(dbTrans w.RepoTrans) error {
 			checkCommit()
 			return nil
-		})
+		}, nil)
 	if err == nil || err.Error() != errText {
 		test.Errorf(`Wrong error status: "%v".`, err)
 	}
@@ -197,7 +209,7 @@ func Test_Repo_Modify_UpdateError(test *testing.T) {
 	defer ctrl.Finish()
 
 	transData := []w.BalanceAction{
-		w.BalanceAction{Account: w.AccountID{ID: "aaa", Currency: "AAA"}, Volume: 1112}}
+		w.BalanceAction{Account: w.AccountID{ID: "aaa", Currency: "AAA"}, Volume: w.NewAmount(1112, 0)}}
 	pk1 := 1
 
 	hasRollback := false
@@ -212,24 +224,27 @@ func Test_Repo_Modify_UpdateError(test *testing.T) {
 	dbTrans := mw.NewMockDBTrans(ctrl)
 	dbTrans.EXPECT().Rollback().Do(func(...interface{}) { hasRollback = true }).
 		After(dbTrans.EXPECT().QueryAccount(w.AccountID{ID: "aaa", Currency: "AAA"}, true).
-			Return(&w.Account{ID: w.AccountID{ID: "aaa", Currency: "AAA"}, Balance: 2}, &pk1, nil).
+			Return(&w.Account{ID: w.AccountID{ID: "aaa", Currency: "AAA"}, Balance: w.NewAmount(2, 0)}, &pk1, nil).
 			Do(func(...interface{}) {
-				dbTrans.EXPECT().UpdateAccount(w.Account{ID: w.AccountID{ID: "aaa", Currency: "AAA"}, Balance: 2}, 1).
+				dbTrans.EXPECT().UpdateAccount(w.Account{ID: w.AccountID{ID: "aaa", Currency: "AAA"}, Balance: w.NewAmount(2, 0)}, 1).
 					Return(errors.New(errText)).Do(checkRollback)
 				transPk := 99
-				dbTrans.EXPECT().InsertTrans(gomock.Any(), "tester").Return(&transPk, nil).Do(checkRollback)
-				dbTrans.EXPECT().InsertAction(gomock.Any(), transPk, gomock.Any()).Times(len(transData)).Return(nil).Do(checkRollback)
+				dbTrans.EXPECT().LockChainHead().Return("", nil).Do(checkRollback)
+				dbTrans.EXPECT().InsertTrans(gomock.Any(), "tester", "").Return(&transPk, nil).Do(checkRollback)
+				dbTrans.EXPECT().InsertAction(gomock.Any(), transPk, gomock.Any(), false).Times(len(transData)).Return(nil).Do(checkRollback)
+				dbTrans.EXPECT().SetTransHash(transPk, gomock.Any()).Return(nil).Do(checkRollback)
 			}).
 			After(db.EXPECT().Begin().Return(dbTrans, nil)))
 
 	repo := w.CreateRepo(db)
-	err := repo.Modify(
+	_, err := repo.Modify(
 		transData,
 		"tester",
+		"",
 		func(dbTrans w.RepoTrans) error {
 			checkRollback()
 			return nil
-		})
+		}, nil)
 	if err == nil || err.Error() != errText {
 		test.Errorf(`Wrong error status: "%v".`, err)
 	}
@@ -241,7 +256,7 @@ func Test_Repo_Modify_ActionError(test *testing.T) {
 	defer ctrl.Finish()
 
 	transData := []w.BalanceAction{
-		w.BalanceAction{Account: w.AccountID{ID: "aaa", Currency: "AAA"}, Volume: 1112}}
+		w.BalanceAction{Account: w.AccountID{ID: "aaa", Currency: "AAA"}, Volume: w.NewAmount(1112, 0)}}
 	pk1 := 1
 
 	hasRollback := false
@@ -256,23 +271,25 @@ func Test_Repo_Modify_ActionError(test *testing.T) {
 	dbTrans := mw.NewMockDBTrans(ctrl)
 	dbTrans.EXPECT().Rollback().Do(func(...interface{}) { hasRollback = true }).
 		After(dbTrans.EXPECT().QueryAccount(w.AccountID{ID: "aaa", Currency: "AAA"}, true).
-			Return(&w.Account{ID: w.AccountID{ID: "aaa", Currency: "AAA"}, Balance: 2}, &pk1, nil).
+			Return(&w.Account{ID: w.AccountID{ID: "aaa", Currency: "AAA"}, Balance: w.NewAmount(2, 0)}, &pk1, nil).
 			Do(func(...interface{}) {
 				transPk := 99
-				dbTrans.EXPECT().InsertTrans(gomock.Any(), "tester").Return(&transPk, nil).Do(checkRollback)
-				dbTrans.EXPECT().InsertAction(gomock.Any(), transPk, gomock.Any()).Times(len(transData)).
+				dbTrans.EXPECT().LockChainHead().Return("", nil).Do(checkRollback)
+				dbTrans.EXPECT().InsertTrans(gomock.Any(), "tester", "").Return(&transPk, nil).Do(checkRollback)
+				dbTrans.EXPECT().InsertAction(gomock.Any(), transPk, gomock.Any(), false).Times(len(transData)).
 					Return(errors.New(errText)).Do(checkRollback)
 			}).
 			After(db.EXPECT().Begin().Return(dbTrans, nil)))
 
 	repo := w.CreateRepo(db)
-	err := repo.Modify(
+	_, err := repo.Modify(
 		transData,
 		"tester",
+		"",
 		func(dbTrans w.RepoTrans) error {
 			checkRollback()
 			return nil
-		})
+		}, nil)
 	if err == nil || err.Error() != errText {
 		test.Errorf(`Wrong error status: "%v".`, err)
 	}
@@ -284,7 +301,7 @@ func Test_Repo_Modify_TransError(test *testing.T) {
 	defer ctrl.Finish()
 
 	transData := []w.BalanceAction{
-		w.BalanceAction{Account: w.AccountID{ID: "aaa", Currency: "AAA"}, Volume: 1112}}
+		w.BalanceAction{Account: w.AccountID{ID: "aaa", Currency: "AAA"}, Volume: w.NewAmount(1112, 0)}}
 	pk1 := 1
 
 	hasRollback := false
@@ -299,21 +316,23 @@ func Test_Repo_Modify_TransError(test *testing.T) {
 	dbTrans := mw.NewMockDBTrans(ctrl)
 	dbTrans.EXPECT().Rollback().Do(func(...interface{}) { hasRollback = true }).
 		After(dbTrans.EXPECT().QueryAccount(w.AccountID{ID: "aaa", Currency: "AAA"}, true).
-			Return(&w.Account{ID: w.AccountID{ID: "aaa", Currency: "AAA"}, Balance: 2}, &pk1, nil).
+			Return(&w.Account{ID: w.AccountID{ID: "aaa", Currency: "AAA"}, Balance: w.NewAmount(2, 0)}, &pk1, nil).
 			Do(func(...interface{}) {
-				dbTrans.EXPECT().InsertTrans(gomock.Any(), "tester").
+				dbTrans.EXPECT().LockChainHead().Return("", nil).Do(checkRollback)
+				dbTrans.EXPECT().InsertTrans(gomock.Any(), "tester", "").
 					Return(nil, errors.New(errText)).Do(checkRollback)
 			}).
 			After(db.EXPECT().Begin().Return(dbTrans, nil)))
 
 	repo := w.CreateRepo(db)
-	err := repo.Modify(
+	_, err := repo.Modify(
 		transData,
 		"tester",
+		"",
 		func(dbTrans w.RepoTrans) error {
 			checkRollback()
 			return nil
-		})
+		}, nil)
 	if err == nil || err.Error() != errText {
 		test.Errorf(`Wrong error status: "%v".`, err)
 	}
@@ -325,7 +344,7 @@ func Test_Repo_Modify_CallbackError(test *testing.T) {
 	defer ctrl.Finish()
 
 	transData := []w.BalanceAction{
-		w.BalanceAction{Account: w.AccountID{ID: "aaa", Currency: "AAA"}, Volume: 1112}}
+		w.BalanceAction{Account: w.AccountID{ID: "aaa", Currency: "AAA"}, Volume: w.NewAmount(1112, 0)}}
 	pk1 := 1
 
 	hasRollback := false
@@ -333,19 +352,19 @@ func Test_Repo_Modify_CallbackError(test *testing.T) {
 	dbTrans := mw.NewMockDBTrans(ctrl)
 	dbTrans.EXPECT().Rollback().Do(func(...interface{}) { hasRollback = true }).
 		After(dbTrans.EXPECT().QueryAccount(w.AccountID{ID: "aaa", Currency: "AAA"}, true).
-			Return(&w.Account{ID: w.AccountID{ID: "aaa", Currency: "AAA"}, Balance: 1}, &pk1, nil).
+			Return(&w.Account{ID: w.AccountID{ID: "aaa", Currency: "AAA"}, Balance: w.NewAmount(1, 0)}, &pk1, nil).
 			After(db.EXPECT().Begin().Return(dbTrans, nil)))
 
 	repo := w.CreateRepo(db)
 	errText := "Test error"
-	err := repo.Modify(
-		transData, "tester",
+	_, err := repo.Modify(
+		transData, "tester", "",
 		func(dbTrans w.RepoTrans) error {
 			if hasRollback {
 				test.Error("Update after rollback.")
 			}
 			return errors.New(errText)
-		})
+		}, nil)
 	if err == nil || err.Error() != errText {
 		test.Errorf(`Wrong error status: "%v".`, err)
 	}
@@ -357,9 +376,9 @@ func Test_Repo_Modify_QueryError(test *testing.T) {
 	defer ctrl.Finish()
 
 	transData := []w.BalanceAction{
-		w.BalanceAction{Account: w.AccountID{ID: "bbb", Currency: "AAA"}, Volume: 2},
-		w.BalanceAction{Account: w.AccountID{ID: "aaa", Currency: "AAA"}, Volume: 1112},
-		w.BalanceAction{Account: w.AccountID{ID: "ссс", Currency: "AAA"}, Volume: -2}}
+		w.BalanceAction{Account: w.AccountID{ID: "bbb", Currency: "AAA"}, Volume: w.NewAmount(2, 0)},
+		w.BalanceAction{Account: w.AccountID{ID: "aaa", Currency: "AAA"}, Volume: w.NewAmount(1112, 0)},
+		w.BalanceAction{Account: w.AccountID{ID: "ссс", Currency: "AAA"}, Volume: w.NewAmount(-2, 0)}}
 	pk1 := 1
 
 	errText := "Test error"
@@ -369,17 +388,18 @@ func Test_Repo_Modify_QueryError(test *testing.T) {
 		After(dbTrans.EXPECT().QueryAccount(w.AccountID{ID: "bbb", Currency: "AAA"}, true).
 			Return(nil, nil, errors.New(errText)).
 			After(dbTrans.EXPECT().QueryAccount(w.AccountID{ID: "aaa", Currency: "AAA"}, true).
-				Return(&w.Account{ID: w.AccountID{ID: "aaa", Currency: "AAA"}, Balance: 1}, &pk1, nil).
+				Return(&w.Account{ID: w.AccountID{ID: "aaa", Currency: "AAA"}, Balance: w.NewAmount(1, 0)}, &pk1, nil).
 				After(db.EXPECT().Begin().Return(dbTrans, nil))))
 
 	repo := w.CreateRepo(db)
-	err := repo.Modify(
+	_, err := repo.Modify(
 		transData,
 		"tester",
+		"",
 		func(dbTrans w.RepoTrans) error {
 			test.Error("Unexpected callback call.")
 			return nil
-		})
+		}, nil)
 	if err == nil || err.Error() != errText {
 		test.Errorf(`Wrong error status: "%v".`, err)
 	}
@@ -392,21 +412,22 @@ func Test_Repo_Modify_BeginError(test *testing.T) {
 	defer ctrl.Finish()
 
 	transData := []w.BalanceAction{
-		w.BalanceAction{Account: w.AccountID{ID: "bbb", Currency: "AAA"}, Volume: 2},
-		w.BalanceAction{Account: w.AccountID{ID: "aaa", Currency: "AAA"}, Volume: 1112}}
+		w.BalanceAction{Account: w.AccountID{ID: "bbb", Currency: "AAA"}, Volume: w.NewAmount(2, 0)},
+		w.BalanceAction{Account: w.AccountID{ID: "aaa", Currency: "AAA"}, Volume: w.NewAmount(1112, 0)}}
 
 	errText := "Test error"
 	db := mw.NewMockDB(ctrl)
 	db.EXPECT().Begin().Return(nil, errors.New(errText))
 
 	repo := w.CreateRepo(db)
-	err := repo.Modify(
+	_, err := repo.Modify(
 		transData,
 		"tester",
+		"",
 		func(trans w.RepoTrans) error {
 			test.Error("Unexpected callback call.")
 			return nil
-		})
+		}, nil)
 	if err == nil || err.Error() != errText {
 		test.Errorf(`Wrong error status: "%v".`, err)
 	}
@@ -421,23 +442,28 @@ func Test_Repo_Modify_Lists(test *testing.T) {
 	db := mw.NewMockDB(ctrl)
 	accounts := []w.Account{
 		{ID: w.AccountID{ID: "123", Currency: "345"},
-			Balance: 456.678},
+			Balance: w.NewAmount(456678, 3)},
 		{ID: w.AccountID{ID: "678", Currency: "098"},
-			Balance: 123.123}}
-	transList := []w.Trans{
+			Balance: w.NewAmount(123123, 3)}}
+	transList := []w.Transaction{
 		{
-			{Account: w.AccountID{ID: "123", Currency: "345"},
-				Volume: 456.678},
-			{Account: w.AccountID{ID: "678", Currency: "098"},
-				Volume: 123.123}},
+			ID: 1,
+			Actions: w.Trans{
+				{Account: w.AccountID{ID: "123", Currency: "345"},
+					Volume: w.NewAmount(456678, 3)},
+				{Account: w.AccountID{ID: "678", Currency: "098"},
+					Volume: w.NewAmount(123123, 3)}}},
 		{
-			{Account: w.AccountID{ID: "1231", Currency: "3451"},
-				Volume: 456.678},
-			{Account: w.AccountID{ID: "6781", Currency: "0981"},
-				Volume: 123.123}}}
+			ID: 2,
+			Actions: w.Trans{
+				{Account: w.AccountID{ID: "1231", Currency: "3451"},
+					Volume: w.NewAmount(456678, 3)},
+				{Account: w.AccountID{ID: "6781", Currency: "0981"},
+					Volume: w.NewAmount(123123, 3)}}}}
 	errText := "Test error"
 	db.EXPECT().GetAccounts().Return(accounts, errors.New(errText))
-	db.EXPECT().GetTransList().Return(transList, errors.New(errText))
+	db.EXPECT().GetTransList(w.TransQuery{Author: "client"}).
+		Return(transList, errors.New(errText))
 
 	repo := w.CreateRepo(db)
 
@@ -457,13 +483,16 @@ func Test_Repo_Modify_Lists(test *testing.T) {
 		}
 	}
 	{
-		result, err := repo.GetTransList()
+		result, err := repo.GetTransList(w.TransQuery{Author: "client"})
 		if len(transList) != len(result) {
 			test.Errorf("Wrong result: %v.", result)
 		} else {
 			for i, trans := range result {
-				for j, action := range trans {
-					if action != transList[i][j] {
+				if trans.ID != transList[i].ID {
+					test.Errorf("Wrong result: %v.", result)
+				}
+				for j, action := range trans.Actions {
+					if action != transList[i].Actions[j] {
 						test.Errorf("Wrong result: %v.", result)
 					}
 				}
@@ -474,3 +503,460 @@ func Test_Repo_Modify_Lists(test *testing.T) {
 		}
 	}
 }
+
+// Test_Repo_GetAccount tests single account request without locking.
+func Test_Repo_GetAccount(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	db := mw.NewMockDB(ctrl)
+	repo := w.CreateRepo(db)
+
+	id := w.AccountID{ID: "123", Currency: "USD"}
+	pk := 1
+	{
+		dbTrans := mw.NewMockDBTrans(ctrl)
+		dbTrans.EXPECT().Rollback().
+			After(dbTrans.EXPECT().QueryAccount(id, false).
+				Return(&w.Account{ID: id, Balance: w.NewAmount(10, 0)}, &pk, nil).
+				After(db.EXPECT().Begin().Return(dbTrans, nil)))
+		result, err := repo.GetAccount(id)
+		if err != nil || result == nil ||
+			*result != (w.Account{ID: id, Balance: w.NewAmount(10, 0)}) {
+
+			test.Errorf(`Wrong result: "%v", "%v".`, result, err)
+		}
+	}
+	{
+		dbTrans := mw.NewMockDBTrans(ctrl)
+		dbTrans.EXPECT().Rollback().
+			After(dbTrans.EXPECT().QueryAccount(id, false).
+				Return(nil, nil, sql.ErrNoRows).
+				After(db.EXPECT().Begin().Return(dbTrans, nil)))
+		result, err := repo.GetAccount(id)
+		if result != nil || !w.IsError(err, w.ErrAccountNotFound) ||
+			err.Error() != `Account "123" (USD) doesn't exist` {
+
+			test.Errorf(`Wrong result: "%v", "%v".`, result, err)
+		}
+	}
+	{
+		db.EXPECT().Begin().Return(nil, errors.New("Test error"))
+		result, err := repo.GetAccount(id)
+		if result != nil || err == nil || err.Error() != "Test error" {
+			test.Errorf(`Wrong result: "%v", "%v".`, result, err)
+		}
+	}
+}
+
+// Test_Repo_Modify_Idempotency tests modification with idempotency key which
+// was already used.
+func Test_Repo_Modify_Idempotency(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	transData := []w.BalanceAction{
+		w.BalanceAction{Account: w.AccountID{ID: "aaa", Currency: "AAA"}, Volume: w.NewAmount(-1, 0)},
+		w.BalanceAction{Account: w.AccountID{ID: "bbb", Currency: "AAA"}, Volume: w.NewAmount(1, 0)}}
+	pk1 := 1
+	pk2 := 2
+	transPk := 99
+
+	calculatedData := w.Trans{transData[0],
+		w.BalanceAction{Account: transData[1].Account, Volume: w.NewAmount(2, 0)}}
+
+	for _, check := range []struct {
+		stored     w.Trans
+		calculated []w.AccountID
+		err        string
+	}{
+		{
+			// The same actions in another order, a retry.
+			stored: w.Trans{transData[1], transData[0]},
+			err:    ""},
+		{
+			stored: w.Trans{transData[0]},
+			err:    `Idempotency key "key" is already used for another transaction`},
+		{
+			stored: calculatedData,
+			err:    `Idempotency key "key" is already used for another transaction`},
+		{
+			// The volume is calculated by the retry again.
+			stored:     calculatedData,
+			calculated: []w.AccountID{transData[1].Account},
+			err:        ""},
+		{
+			stored:     w.Trans{calculatedData[1], transData[1]},
+			calculated: []w.AccountID{transData[1].Account},
+			err:        `Idempotency key "key" is already used for another transaction`}} {
+
+		db := mw.NewMockDB(ctrl)
+		dbTrans := mw.NewMockDBTrans(ctrl)
+		dbTrans.EXPECT().Rollback().
+			After(dbTrans.EXPECT().QueryTransByIdempotencyKey("key").Return(&transPk, check.stored, nil).
+				After(dbTrans.EXPECT().QueryAccount(w.AccountID{ID: "bbb", Currency: "AAA"}, true).
+					Return(&w.Account{ID: w.AccountID{ID: "bbb", Currency: "AAA"}}, &pk2, nil).
+					After(dbTrans.EXPECT().QueryAccount(w.AccountID{ID: "aaa", Currency: "AAA"}, true).
+						Return(&w.Account{ID: w.AccountID{ID: "aaa", Currency: "AAA"}}, &pk1, nil).
+						After(db.EXPECT().Begin().Return(dbTrans, nil)))))
+
+		repo := w.CreateRepo(db)
+		if check.calculated != nil {
+			repo = repo.CalculateVolumes(check.calculated...)
+		}
+		// The replay gets the stored actions and the locked accounts.
+		var replayed w.Trans
+		id, err := repo.Modify(
+			transData,
+			"tester",
+			"key",
+			func(dbTrans w.RepoTrans) error {
+				test.Error("Unexpected callback call.")
+				return nil
+			},
+			func(stored w.Trans, dbTrans w.RepoTrans) error {
+				for _, action := range transData {
+					account, err := dbTrans.GetAccount(action.Account)
+					if err != nil || account.ID != action.Account {
+						test.Errorf(`Wrong replay account: "%v", "%v".`, account, err)
+					}
+				}
+				replayed = stored
+				return nil
+			})
+		if (check.err == "" && err != nil) ||
+			(check.err != "" && (err == nil || err.Error() != check.err)) {
+
+			test.Errorf(`Wrong error status: "%v".`, err)
+		}
+		if check.err == "" && id != transPk {
+			test.Errorf(`Wrong transaction ID: %d.`, id)
+		}
+		if (check.err == "") != (replayed != nil) ||
+			(replayed != nil && len(replayed) != len(check.stored)) {
+
+			test.Errorf(`Wrong replay: "%v".`, replayed)
+		}
+	}
+}
+
+// Test_Repo_CaptureHold tests modification which captures the hold.
+func Test_Repo_CaptureHold(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	src := w.AccountID{ID: "aaa", Currency: "AAA"}
+	dst := w.AccountID{ID: "bbb", Currency: "AAA"}
+	transData := w.Trans{
+		w.BalanceAction{Account: src, Volume: w.NewAmount(-5, 0)},
+		w.BalanceAction{Account: dst, Volume: w.NewAmount(5, 0)}}
+	pk1 := 1
+	pk2 := 2
+	transPk := 99
+
+	for _, check := range []struct {
+		expiration time.Time
+		status     w.HoldStatus
+		err        string
+	}{
+		{expiration: time.Now().Add(time.Hour), status: w.HoldActive},
+		{
+			expiration: time.Now().Add(-time.Hour),
+			status:     w.HoldActive,
+			err:        "Hold 7 is not active"},
+		{
+			expiration: time.Now().Add(time.Hour),
+			status:     w.HoldReleased,
+			err:        "Hold 7 is not active"}} {
+
+		hold := w.Hold{
+			ID:          7,
+			Account:     src,
+			Destination: dst,
+			Amount:      w.NewAmount(5, 0),
+			Expiration:  check.expiration,
+			Status:      check.status}
+
+		db := mw.NewMockDB(ctrl)
+		dbTrans := mw.NewMockDBTrans(ctrl)
+		query := dbTrans.EXPECT().QueryHold(7).Return(&hold, nil).
+			After(dbTrans.EXPECT().QueryAccount(dst, true).
+				Return(&w.Account{ID: dst}, &pk2, nil).
+				After(dbTrans.EXPECT().QueryAccount(src, true).
+					Return(&w.Account{
+						ID:      src,
+						Balance: w.NewAmount(10, 0),
+						Held:    w.NewAmount(5, 0)}, &pk1, nil).
+					After(db.EXPECT().Begin().Return(dbTrans, nil))))
+		dbTrans.EXPECT().Rollback().After(query)
+		if check.err == "" {
+			update := dbTrans.EXPECT().UpdateHoldStatus(7, w.HoldCaptured, "").
+				Return(nil).After(query)
+			lock := dbTrans.EXPECT().LockChainHead().Return("", nil).After(update)
+			dbTrans.EXPECT().InsertTrans(gomock.Any(), "tester", "").
+				Return(&transPk, nil).After(lock)
+			dbTrans.EXPECT().InsertAction(gomock.Any(), transPk, gomock.Any(), false).
+				Times(len(transData)).Return(nil)
+			dbTrans.EXPECT().SetTransHash(transPk, gomock.Any()).Return(nil)
+			dbTrans.EXPECT().UpdateAccount(gomock.Any(), gomock.Any()).
+				Times(2).Return(nil)
+			dbTrans.EXPECT().Commit().Return(nil)
+		}
+
+		id, err := w.CreateRepo(db).CaptureHold(7).Modify(
+			transData,
+			"tester",
+			"",
+			func(dbTrans w.RepoTrans) error {
+				if check.err != "" {
+					test.Error("Unexpected callback call.")
+				}
+				account, err := dbTrans.GetAccount(src)
+				if err != nil || !account.Held.IsZero() ||
+					account.Available() != w.NewAmount(10, 0) {
+
+					test.Errorf(`Wrong account: "%v", "%v".`, account, err)
+				}
+				return nil
+			}, nil)
+		if (check.err == "" && (err != nil || id != transPk)) ||
+			(check.err != "" &&
+				(!w.IsError(err, w.ErrHoldNotActive) || err.Error() != check.err)) {
+
+			test.Errorf(`Wrong result: %d, "%v".`, id, err)
+		}
+	}
+}
+
+// Test_Repo_VerifyJournal tests the detection of modified, deleted and
+// inserted transactions by the hash chain.
+func Test_Repo_VerifyJournal(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	// The journal with valid hashes is created by the memory database.
+	source := w.CreateMemoryDB()
+	defer source.Close()
+	repo := w.CreateRepo(source)
+	account := w.AccountID{ID: "aaa", Currency: "AAA"}
+	if err := repo.AddAccount(w.Account{ID: account}); err != nil {
+		test.Fatalf(`Failed to add account: "%s".`, err)
+	}
+	for i := int64(1); i <= 3; i++ {
+		_, err := w.CreateManagerExecutor().Execute(
+			w.Trans{w.BalanceAction{Account: account, Volume: w.NewAmount(i, 0)}},
+			"tester", "", repo)
+		if err != nil {
+			test.Fatalf(`Failed to execute transaction: "%s".`, err)
+		}
+	}
+	_, err := repo.SetAccountStatus(account, w.AccountFrozen, "tester",
+		func(w.Account) error { return nil })
+	if err != nil {
+		test.Fatalf(`Failed to set account status: "%s".`, err)
+	}
+	report, err := repo.VerifyJournal()
+	if err != nil || !report.Valid || report.Transactions != 4 ||
+		report.BrokenTransID != nil {
+
+		test.Errorf(`Wrong report: "%v", "%v".`, report, err)
+	}
+	journal := []w.JournalEntry{}
+	source.GetJournal(func(entry w.JournalEntry) bool {
+		journal = append(journal, entry)
+		return true
+	})
+	headPk, headHash, _ := source.GetChainHead()
+	if len(journal) != 4 || *headPk != journal[3].ID ||
+		headHash != journal[3].Hash || journal[3].StatusAccount == nil ||
+		*journal[3].StatusAccount != account ||
+		journal[3].Status != w.AccountFrozen {
+
+		test.Fatalf(`Wrong journal: "%v".`, journal)
+	}
+
+	for _, check := range []struct {
+		name   string
+		modify func([]w.JournalEntry) []w.JournalEntry
+		broken int
+		err    string
+	}{
+		{
+			name: "modified volume",
+			modify: func(journal []w.JournalEntry) []w.JournalEntry {
+				journal[1].Actions = w.Trans{
+					w.BalanceAction{Account: account, Volume: w.NewAmount(20, 0)}}
+				return journal
+			},
+			broken: journal[1].ID,
+			err:    "does not match its hash"},
+		{
+			name: "modified author",
+			modify: func(journal []w.JournalEntry) []w.JournalEntry {
+				journal[0].Author = "intruder"
+				return journal
+			},
+			broken: journal[0].ID,
+			err:    "does not match its hash"},
+		{
+			name: "added reversal link",
+			modify: func(journal []w.JournalEntry) []w.JournalEntry {
+				journal[1].ReversalOf = &journal[0].ID
+				return journal
+			},
+			broken: journal[1].ID,
+			err:    "does not match its hash"},
+		{
+			name: "added adjustment link",
+			modify: func(journal []w.JournalEntry) []w.JournalEntry {
+				adjustmentID := 1
+				journal[2].AdjustmentID = &adjustmentID
+				return journal
+			},
+			broken: journal[2].ID,
+			err:    "does not match its hash"},
+		{
+			name: "modified status",
+			modify: func(journal []w.JournalEntry) []w.JournalEntry {
+				journal[3].Status = w.AccountActive
+				return journal
+			},
+			broken: journal[3].ID,
+			err:    "does not match its hash"},
+		{
+			name: "deleted status change",
+			modify: func(journal []w.JournalEntry) []w.JournalEntry {
+				journal[3].StatusAccount = nil
+				return journal
+			},
+			broken: journal[3].ID,
+			err:    "does not match its hash"},
+		{
+			name: "deleted transaction",
+			modify: func(journal []w.JournalEntry) []w.JournalEntry {
+				return append(journal[:1], journal[2:]...)
+			},
+			broken: journal[2].ID,
+			err:    "does not match its hash"},
+		{
+			name: "deleted last transaction",
+			modify: func(journal []w.JournalEntry) []w.JournalEntry {
+				return journal[:3]
+			},
+			broken: journal[3].ID,
+			err:    "of the chain head is not found"},
+		{
+			name: "replaced last transaction",
+			modify: func(journal []w.JournalEntry) []w.JournalEntry {
+				journal[3] = journal[2]
+				journal[3].ID = *headPk
+				return journal
+			},
+			broken: journal[3].ID,
+			err:    "does not match its hash"}} {
+
+		modified := check.modify(append([]w.JournalEntry{}, journal...))
+		db := mw.NewMockDB(ctrl)
+		db.EXPECT().GetChainHead().Return(headPk, headHash, nil)
+		db.EXPECT().GetJournal(gomock.Any()).DoAndReturn(
+			func(f func(w.JournalEntry) bool) error {
+				for _, entry := range modified {
+					if !f(entry) {
+						break
+					}
+				}
+				return nil
+			})
+		report, err := w.CreateRepo(db).VerifyJournal()
+		if err != nil || report.Valid || report.BrokenTransID == nil ||
+			*report.BrokenTransID != check.broken ||
+			!strings.Contains(report.Error, check.err) {
+
+			test.Errorf(`Wrong report for %s: "%v", "%v".`, check.name, report, err)
+		}
+	}
+}
+
+// Test_Repo_VerifyIntegrity tests the detection of balances which differ from
+// actions and of client transactions which don't net to zero.
+func Test_Repo_VerifyIntegrity(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	usd1 := w.AccountID{ID: "1", Currency: "USD"}
+	usd2 := w.AccountID{ID: "2", Currency: "USD"}
+	eur1 := w.AccountID{ID: "1", Currency: "EUR"}
+	eur2 := w.AccountID{ID: "2", Currency: "EUR"}
+	journal := []w.Transaction{
+		// The manager transaction is not balanced.
+		{ID: 1, Actions: w.Trans{
+			w.BalanceAction{Account: usd1, Volume: w.NewAmount(100, 0)}}},
+		{ID: 2, Actions: w.Trans{
+			w.BalanceAction{Account: usd1, Volume: w.NewAmount(-10, 0)},
+			w.BalanceAction{Account: usd2, Volume: w.NewAmount(10, 0)}}},
+		{ID: 3, Actions: w.Trans{
+			w.BalanceAction{Account: usd1, Volume: w.NewAmount(-10, 0)},
+			w.BalanceAction{Account: usd2, Volume: w.NewAmount(11, 0)}}},
+		// The conversion is balanced in each currency.
+		{ID: 4, Actions: w.Trans{
+			w.BalanceAction{Account: usd1, Volume: w.NewAmount(-10, 0)},
+			w.BalanceAction{Account: usd2, Volume: w.NewAmount(10, 0)},
+			w.BalanceAction{Account: eur2, Volume: w.NewAmount(-9, 0)},
+			w.BalanceAction{Account: eur1, Volume: w.NewAmount(9, 0)}}},
+		{ID: 5, Actions: w.Trans{
+			w.BalanceAction{Account: usd1, Volume: w.NewAmount(-10, 0)},
+			w.BalanceAction{Account: usd2, Volume: w.NewAmount(10, 0)},
+			w.BalanceAction{Account: eur2, Volume: w.NewAmount(-9, 0)},
+			w.BalanceAction{Account: eur1, Volume: w.NewAmount(8, 0)}}}}
+	checks := []w.BalanceCheck{
+		{Account: eur1, Balance: w.NewAmount(17, 0),
+			ActionSum: w.NewAmount(17, 0)},
+		{Account: usd1, Balance: w.NewAmount(70, 0),
+			ActionSum: w.NewAmount(60, 0)},
+		{Account: eur2, Balance: w.NewAmount(-18, 0),
+			ActionSum: w.NewAmount(-18, 0)}}
+
+	db := mw.NewMockDB(ctrl)
+	db.EXPECT().GetJournal(gomock.Any()).DoAndReturn(
+		func(f func(w.JournalEntry) bool) error {
+			for _, trans := range journal {
+				if !f(w.JournalEntry{Transaction: trans}) {
+					break
+				}
+			}
+			return nil
+		})
+	db.EXPECT().GetBalanceChecks().Return(checks, nil)
+	report, err := w.CreateRepo(db).VerifyIntegrity()
+	if err != nil {
+		test.Fatalf(`Failed to verify integrity: "%s".`, err)
+	}
+	if report.Valid || report.Accounts != 3 || report.Transactions != 5 ||
+		len(report.BalanceDiscrepancies) != 1 ||
+		report.BalanceDiscrepancies[0] != checks[1] ||
+		len(report.TransImbalances) != 2 ||
+		report.TransImbalances[0] != (w.TransImbalance{
+			TransID: 3, Currency: "USD", Net: w.NewAmount(1, 0)}) ||
+		report.TransImbalances[1] != (w.TransImbalance{
+			TransID: 5, Currency: "EUR", Net: w.NewAmount(-1, 0)}) {
+
+		test.Errorf(`Wrong integrity report: "%v".`, *report)
+	}
+
+	db.EXPECT().GetJournal(gomock.Any()).Return(nil)
+	db.EXPECT().GetBalanceChecks().Return(checks[:1], nil)
+	report, err = w.CreateRepo(db).VerifyIntegrity()
+	if err != nil || !report.Valid || report.Accounts != 1 ||
+		len(report.BalanceDiscrepancies) != 0 ||
+		len(report.TransImbalances) != 0 {
+
+		test.Errorf(`Wrong integrity report: "%v", "%v".`, report, err)
+	}
+
+	db.EXPECT().GetJournal(gomock.Any()).Return(errors.New("Journal error"))
+	if report, err := w.CreateRepo(db).VerifyIntegrity(); report != nil ||
+		err == nil || err.Error() != "Journal error" {
+
+		test.Errorf(`Wrong result: "%v", "%v".`, report, err)
+	}
+}
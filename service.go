@@ -1,50 +1,256 @@
 package wallet
 
-import "log"
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// InternalIdempotencyKeyPrefix is the prefix of idempotency keys which are
+// generated by the wallet itself, like keys of adjustment approvals and of
+// scheduled payments. Keys of client requests could not have it.
+const InternalIdempotencyKeyPrefix = "wallet/"
 
 // Service describes the interface to access to the wallets service to request
 // data and process payments.
 type Service interface {
 	// Close closes the service and frees resources.
 	Close()
-	// GetPayments returns information about all known payments for all accounts.
-	GetPayments() []Trans
+	// GetPayments returns information about payments, which pass the query
+	// filter, ordered by payment time and ID.
+	GetPayments(query TransQuery) ([]Transaction, error)
+	// GetPayment returns the transaction, or ErrTransNotFound if the
+	// transaction doesn't exist.
+	GetPayment(id int) (*Transaction, error)
 	// GetAccounts returns information about all known accounts.
 	GetAccounts() []Account
+	// GetAccount returns information about the account, or ErrAccountNotFound
+	// if the account doesn't exist.
+	GetAccount(AccountID) (*Account, error)
+	// GetAccountsAt returns all known accounts with balances and statuses as of
+	// the time, the balances are recomputed from transactions which were
+	// executed not after the time. Held amounts and overdrafts are not stored
+	// historically, so they are not set. Account opening is not stored, so
+	// accounts without transactions and status changes by the time are not
+	// listed.
+	GetAccountsAt(at time.Time) ([]Account, error)
+	// GetStatement returns the account balance modifications for the time
+	// range. Zero time "from" starts the statement from the account opening,
+	// zero time "to" ends the statement at the current time.
+	GetStatement(account AccountID, from, to time.Time) (*Statement, error)
 	// CreateAccount creates new account with zero balance.
 	CreateAccount(AccountID) error
-	// SetupAccount modifies account balance by the manager.
-	SetupAccount(BalanceAction) error
-	// MakePayment executes funds transfer between two accounts.
-	MakePayment(src BalanceAction, dst BalanceAction) error
+	// SetupAccount modifies account balance by the manager, the author is
+	// stored as the transaction initiator. Not empty idempotency key makes
+	// repeated calls with the same key and arguments successful without a
+	// second modification. Returns the transaction ID and the new account
+	// state.
+	SetupAccount(
+		action BalanceAction,
+		author, idempotencyKey string) (*TransResult, error)
+	// MakePayment executes funds transfer between two accounts by the client
+	// policy, the author is stored as the transaction initiator. Not empty
+	// idempotency key makes repeated calls with the same key and arguments
+	// successful without a second transfer. Returns the transaction ID and new
+	// states of the accounts.
+	MakePayment(
+		src BalanceAction,
+		dst BalanceAction,
+		author, idempotencyKey string) (*TransResult, error)
+	// MakeConversionPayment executes funds transfer between two accounts in
+	// different currencies, the source action volume is converted to the
+	// destination account currency by the actual exchange rate. Returns the
+	// transaction ID and new states of the accounts, including house accounts.
+	MakeConversionPayment(
+		src BalanceAction,
+		dst AccountID,
+		author, idempotencyKey string) (*TransResult, error)
+	// MakeBatchPayment executes funds transfers between several accounts by
+	// the batch policy in one transaction, the author is stored as the
+	// transaction initiator. Not empty idempotency key makes repeated calls
+	// with the same key and arguments successful without a second execution.
+	// Returns the transaction ID and new states of the accounts in the order of
+	// actions.
+	MakeBatchPayment(
+		trans Trans,
+		author, idempotencyKey string) (*TransResult, error)
+	// ReversePayment returns the amount of the payment between two accounts
+	// back to the source account, the payment fee is not returned. The
+	// reversal is stored as a new transaction which refers to the payment. The
+	// payment could be reversed partially by several calls, but the sum of
+	// reversals could not exceed the payment amount. Not empty idempotency key
+	// makes repeated calls with the same key and arguments successful without
+	// a second reversal. Returns the transaction ID and new states of the
+	// accounts.
+	ReversePayment(
+		paymentID int,
+		amount Amount,
+		author, idempotencyKey string) (*TransResult, error)
+
+	// Authorize reserves the amount on the account for the future payment to
+	// the destination account in the same currency. The hold reduces the
+	// account available balance, but not the ledger balance, until it is
+	// captured, released or expired.
+	Authorize(
+		account, destination AccountID,
+		amount Amount,
+		expiration time.Time,
+		author string) (*Hold, error)
+	// Capture executes the payment of the active hold by the client policy and
+	// closes the hold. Zero amount captures the full hold amount, the amount
+	// could not be greater than the hold amount, the rest of the hold amount
+	// is not held anymore. Not empty idempotency key makes repeated calls with
+	// the same key and arguments successful without a second payment. Returns
+	// the transaction ID and new states of the accounts.
+	Capture(
+		holdID int,
+		amount Amount,
+		author, idempotencyKey string) (*TransResult, error)
+	// Release closes the hold without a payment, the author is stored as the
+	// release initiator. Returns the released hold.
+	Release(holdID int, author string) (*Hold, error)
+	// GetHold returns information about the hold, or ErrHoldNotFound if the
+	// hold doesn't exist.
+	GetHold(holdID int) (*Hold, error)
+
+	// GetLimits returns outgoing payment limits of the account, or
+	// ErrAccountNotFound if the account doesn't exist.
+	GetLimits(account AccountID) (*Limits, error)
+	// SetLimits replaces outgoing payment limits of the account, the limits
+	// are checked by the client policy for each payment from the account.
+	SetLimits(account AccountID, limits Limits) error
+
+	// SetAccountStatus changes the account status by the manager, the author
+	// is stored as the change initiator. Active and frozen accounts could be
+	// frozen, unfrozen and closed, a closed account could not be changed
+	// anymore. Only account with zero balance and without active holds could
+	// be closed. Returns the stored change.
+	SetAccountStatus(
+		account AccountID,
+		status AccountStatus,
+		author string) (*AccountStatusChange, error)
+	// GetAccountStatusChanges returns status changes of the account ordered by
+	// time, or ErrAccountNotFound if the account doesn't exist.
+	GetAccountStatusChanges(account AccountID) ([]AccountStatusChange, error)
+
+	// SetOverdraft sets the credit line of the account by the manager, client
+	// payments could decrease the account available balance down to the
+	// negative overdraft value. The overdraft could be set less than the
+	// already used credit, then the account could not be debited until it is
+	// credited. Returns the new account state.
+	SetOverdraft(account AccountID, overdraft Amount) (*Account, error)
+
+	// CreateSchedule creates the standing order of the author, which pays the
+	// amount from the account to the destination account in the same currency
+	// at the start time and then with the period. Zero start time means the
+	// current time, the start time could not be before the current time.
+	// Payments are executed by the caller of CompleteScheduleRun. Returns the
+	// stored schedule.
+	CreateSchedule(schedule Schedule, now time.Time) (*Schedule, error)
+	// GetSchedule returns the schedule, or ErrScheduleNotFound if the schedule
+	// doesn't exist.
+	GetSchedule(id int) (*Schedule, error)
+	// GetSchedules returns all schedules ordered by ID.
+	GetSchedules() ([]Schedule, error)
+	// UpdateSchedule replaces the amount, the start time and the period of the
+	// schedule with the ID, the schedule is started again from the new start
+	// time, which is checked as by CreateSchedule. Returns the stored schedule.
+	UpdateSchedule(schedule Schedule, now time.Time) (*Schedule, error)
+	// DeleteSchedule deletes the schedule with its runs. Returns the deleted
+	// schedule.
+	DeleteSchedule(id int) (*Schedule, error)
+	// GetScheduleRuns returns payment attempts of the schedule in the
+	// execution order, or ErrScheduleNotFound if the schedule doesn't exist.
+	GetScheduleRuns(id int) ([]ScheduleRun, error)
+	// GetDueSchedules returns schedules with the next run time which is not
+	// after the time, ordered by the next run time.
+	GetDueSchedules(now time.Time) ([]Schedule, error)
+	// CompleteScheduleRun stores the result of the payment attempt of the next
+	// schedule run. The failed attempt is retried at the retry time, the run is
+	// completed by the payment, or by the failed attempt without the retry
+	// time. The schedule with the completed run is planned for the next run,
+	// or it has no next run time if it has no runs anymore. Returns the stored
+	// schedule, or ErrScheduleRunCompleted if the run is already completed.
+	CompleteScheduleRun(run ScheduleRun, retry *time.Time) (*Schedule, error)
+
+	// ProposeAdjustment stores the account balance modification by the
+	// manager, the proposer, as a pending adjustment, which is executed only
+	// after the approval by another manager. Returns the stored adjustment.
+	ProposeAdjustment(
+		action BalanceAction, proposer string) (*Adjustment, error)
+	// GetAdjustment returns the adjustment, or ErrAdjustmentNotFound if the
+	// adjustment doesn't exist.
+	GetAdjustment(id int) (*Adjustment, error)
+	// GetAdjustments returns adjustments with the status ordered by ID, empty
+	// status means all adjustments. Unknown status is ErrInvalidQuery.
+	GetAdjustments(status AdjustmentStatus) ([]Adjustment, error)
+	// ApproveAdjustment executes the pending adjustment by the manager policy,
+	// the proposer is stored as the transaction initiator and the checker is
+	// stored as the adjustment approver. The checker could not be the
+	// proposer. A repeated approval of the approved adjustment is successful
+	// without a second modification. Returns the transaction ID and the new
+	// account state.
+	ApproveAdjustment(id int, checker string) (*TransResult, error)
+	// RejectAdjustment declines the pending adjustment without the execution,
+	// the checker could not be the proposer. Returns the rejected adjustment.
+	RejectAdjustment(id int, checker string) (*Adjustment, error)
+
+	// VerifyJournal checks that stored transactions are not modified, inserted
+	// or deleted after the storing, by the hash chain of the transaction
+	// journal. Returns the report with the first broken link.
+	VerifyJournal() (*JournalReport, error)
+	// VerifyIntegrity checks that stored account balances are equal to the sums
+	// of account action volumes and that each client transaction nets to zero.
+	// Returns the report with all discrepancies.
+	VerifyIntegrity() (*IntegrityReport, error)
 }
 
 type service struct {
-	repo            Repo
-	clientExecutor  Executor
-	managerExecutor Executor
+	repo               Repo
+	clientExecutor     Executor
+	managerExecutor    Executor
+	conversionExecutor Executor
+	batchExecutor      Executor
+	// reversalExecutor executes reversals by the batch policy without fees and
+	// limits.
+	reversalExecutor Executor
 }
 
 // CreateService crates wallet service to access to the wallets service to
 // request data and process payments.
 func CreateService(
-	repo Repo, clientExecutor Executor, managerExecutor Executor) Service {
+	repo Repo,
+	clientExecutor Executor,
+	managerExecutor Executor,
+	conversionExecutor Executor,
+	batchExecutor Executor) Service {
 
 	return &service{
-		repo:            repo,
-		clientExecutor:  clientExecutor,
-		managerExecutor: managerExecutor}
+		repo:               repo,
+		clientExecutor:     clientExecutor,
+		managerExecutor:    managerExecutor,
+		conversionExecutor: conversionExecutor,
+		batchExecutor:      batchExecutor,
+		reversalExecutor:   createReversalExecutor()}
 }
 
 func (s *service) Close() {}
 
-func (s *service) GetPayments() []Trans {
-	result, err := s.repo.GetTransList()
+func (s *service) GetPayments(query TransQuery) ([]Transaction, error) {
+	if err := query.Check(); err != nil {
+		return nil, err
+	}
+	result, err := s.repo.GetTransList(query)
 	if err != nil {
 		log.Printf(`Failed to query transaction list: "%s".`, err)
-		return []Trans{}
+		return nil, errors.New("Failed to query transaction list")
 	}
-	return result
+	return result, nil
+}
+
+func (s *service) GetPayment(id int) (*Transaction, error) {
+	return s.repo.GetTrans(id)
 }
 
 func (s *service) GetAccounts() []Account {
@@ -56,16 +262,544 @@ func (s *service) GetAccounts() []Account {
 	return result
 }
 
+func (s *service) GetAccount(id AccountID) (*Account, error) {
+	result, err := s.repo.GetAccount(id)
+	if err != nil && !IsError(err, ErrAccountNotFound) {
+		log.Printf(`Failed to query account: "%s".`, err)
+		return nil, errors.New("Failed to query account")
+	}
+	return result, err
+}
+
+func (s *service) GetAccountsAt(at time.Time) ([]Account, error) {
+	if at.IsZero() {
+		return nil, newError(ErrInvalidQuery, "Time is not set")
+	}
+	result, err := s.repo.GetAccountsAt(at)
+	if err != nil {
+		log.Printf(`Failed to query account list at %s: "%s".`, at, err)
+		return nil, errors.New("Failed to query account list")
+	}
+	return result, nil
+}
+
+func (s *service) GetStatement(
+	account AccountID, from, to time.Time) (*Statement, error) {
+
+	if to.IsZero() {
+		to = time.Now()
+	}
+	result := &Statement{
+		Account: account,
+		From:    from.UTC(),
+		To:      to.UTC(),
+		Items:   []StatementItem{}}
+
+	query := TransQuery{
+		Account:  account.ID,
+		Currency: account.Currency,
+		From:     result.From,
+		To:       result.To}
+	if err := query.Check(); err != nil {
+		return nil, err
+	}
+
+	// The opening balance and the list are read at the same moment, so the
+	// closing balance is consistent with concurrent payments.
+	var transList []Transaction
+	var err error
+	result.OpeningBalance, transList, err = s.repo.GetStatementTrans(
+		account, query)
+	if err != nil {
+		return nil, err
+	}
+
+	balance := result.OpeningBalance
+	for _, trans := range transList {
+		item := StatementItem{
+			TransID:        trans.ID,
+			Time:           trans.Time,
+			Author:         trans.Author,
+			Counterparties: []AccountID{},
+			ReversalOf:     trans.ReversalOf}
+		for _, action := range trans.Actions {
+			if action.Account != account {
+				item.Counterparties = append(item.Counterparties, action.Account)
+				continue
+			}
+			if item.Volume, err = item.Volume.Add(action.Volume); err != nil {
+				return nil, err
+			}
+		}
+		if balance, err = balance.Add(item.Volume); err != nil {
+			return nil, err
+		}
+		item.Balance = balance
+		result.Items = append(result.Items, item)
+	}
+	result.ClosingBalance = balance
+
+	return result, nil
+}
+
 func (s *service) CreateAccount(id AccountID) error {
-	return s.repo.AddAccount(Account{ID: id, Balance: 0})
+	return s.repo.AddAccount(Account{ID: id})
 }
 
-func (s *service) SetupAccount(action BalanceAction) error {
-	_, err := s.managerExecutor.Execute(Trans{action}, s.repo)
-	return err
+func (s *service) SetupAccount(
+	action BalanceAction,
+	author, idempotencyKey string) (*TransResult, error) {
+
+	return s.managerExecutor.Execute(
+		Trans{action}, author, idempotencyKey, s.repo)
 }
 
-func (s *service) MakePayment(src BalanceAction, dst BalanceAction) error {
-	_, err := s.clientExecutor.Execute(Trans{src, dst}, s.repo)
-	return err
+func (s *service) MakePayment(
+	src BalanceAction,
+	dst BalanceAction,
+	author, idempotencyKey string) (*TransResult, error) {
+
+	return s.clientExecutor.Execute(
+		Trans{src, dst}, author, idempotencyKey, s.repo)
+}
+
+func (s *service) MakeConversionPayment(
+	src BalanceAction,
+	dst AccountID,
+	author, idempotencyKey string) (*TransResult, error) {
+
+	return s.conversionExecutor.Execute(
+		Trans{src, BalanceAction{Account: dst}}, author, idempotencyKey, s.repo)
+}
+
+func (s *service) MakeBatchPayment(
+	trans Trans,
+	author, idempotencyKey string) (*TransResult, error) {
+
+	return s.batchExecutor.Execute(trans, author, idempotencyKey, s.repo)
+}
+
+func (s *service) ReversePayment(
+	paymentID int,
+	amount Amount,
+	author, idempotencyKey string) (*TransResult, error) {
+
+	if !amount.IsPositive() {
+		return nil,
+			newError(ErrInvalidAmount, "Reversal amount has to be positive")
+	}
+	payment, err := s.repo.GetTrans(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	// The payment fee is not returned by the reversal, the fee action is marked
+	// when the payment is stored.
+	actions := Trans{}
+	for _, action := range payment.Actions {
+		if !action.Fee {
+			actions = append(actions, action)
+		}
+	}
+	if len(actions) != 2 {
+		return nil,
+			newError(ErrInvalidTrans,
+				"Transaction %d is not a payment between two accounts", paymentID)
+	}
+	src := actions[0].Account
+	dst := actions[1].Account
+	if actions[0].Volume.IsPositive() {
+		src, dst = dst, src
+	}
+	// The reversal is free and it is not a payment, so it is executed without
+	// the client fee policy and limits.
+	// Other checks are made by the repository after accounts locking.
+	return s.reversalExecutor.Execute(
+		Trans{
+			BalanceAction{Account: dst, Volume: amount.Neg()},
+			BalanceAction{Account: src, Volume: amount}},
+		author,
+		idempotencyKey,
+		s.repo.ReversePayment(paymentID))
+}
+
+func (s *service) Authorize(
+	account, destination AccountID,
+	amount Amount,
+	expiration time.Time,
+	author string) (*Hold, error) {
+
+	if !amount.IsPositive() {
+		return nil,
+			newError(ErrInvalidAmount, "Hold amount has to be positive")
+	}
+	if err := amount.CheckCurrency(account.Currency); err != nil {
+		return nil, err
+	}
+	if account.Currency != destination.Currency {
+		return nil,
+			newError(ErrCurrencyMismatch,
+				`Account "%s" (%s) has a different currency from "%s"`,
+				destination.ID, destination.Currency, account.Currency)
+	}
+	if account == destination {
+		return nil,
+			newError(ErrInvalidTrans,
+				`Hold has only one account "%s" (%s)`, account.ID, account.Currency)
+	}
+	if !expiration.After(time.Now()) {
+		return nil,
+			newError(ErrInvalidTrans, "Hold expiration time is in the past")
+	}
+	// The hold is captured by a client payment, so its accounts have to be
+	// allowed payment parties.
+	trans := Trans{
+		BalanceAction{Account: account, Volume: amount.Neg()},
+		BalanceAction{Account: destination, Volume: amount}}
+	for _, executor := range []Executor{s.clientExecutor, s.conversionExecutor} {
+		if checker, ok := executor.(partyChecker); ok {
+			if err := checker.checkParties(trans); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return s.repo.AddHold(
+		Hold{
+			Account:     account,
+			Destination: destination,
+			Amount:      amount,
+			Author:      author,
+			Expiration:  expiration.UTC()},
+		func(account, destination Account) error {
+			if err := checkAccountStatus(&account, amount.Neg()); err != nil {
+				return err
+			}
+			err := checkAccountStatus(&destination, amount)
+			if err != nil {
+				return err
+			}
+			if account.Held, err = account.Held.Add(amount); err != nil {
+				return err
+			}
+			return checkFunds(&account)
+		})
+}
+
+func (s *service) Capture(
+	holdID int,
+	amount Amount,
+	author, idempotencyKey string) (*TransResult, error) {
+
+	hold, err := s.repo.GetHold(holdID)
+	if err != nil {
+		return nil, err
+	}
+	if amount.IsZero() {
+		amount = hold.Amount
+	}
+	if amount.IsNegative() || amount.Cmp(hold.Amount) > 0 {
+		return nil,
+			newError(ErrInvalidAmount,
+				`Capture amount %s is not in range of hold amount %s`,
+				amount, hold.Amount)
+	}
+	// The hold status is checked by the repository after accounts locking, so
+	// a repeated call with the same idempotency key is successful.
+	return s.clientExecutor.Execute(
+		Trans{
+			BalanceAction{Account: hold.Account, Volume: amount.Neg()},
+			BalanceAction{Account: hold.Destination, Volume: amount}},
+		author,
+		idempotencyKey,
+		s.repo.CaptureHold(holdID))
+}
+
+func (s *service) Release(holdID int, author string) (*Hold, error) {
+	return s.repo.ReleaseHold(holdID, author)
+}
+
+func (s *service) GetHold(holdID int) (*Hold, error) {
+	return s.repo.GetHold(holdID)
+}
+
+func (s *service) GetLimits(account AccountID) (*Limits, error) {
+	return s.repo.GetLimits(account)
+}
+
+func (s *service) SetLimits(account AccountID, limits Limits) error {
+	if limits.DailyCount < 0 || limits.MonthlyCount < 0 {
+		return newError(ErrInvalidAmount, "Payment count limit is negative")
+	}
+	for _, amount := range []Amount{
+		limits.MaxPayment, limits.DailyAmount, limits.MonthlyAmount} {
+
+		if amount.IsNegative() {
+			return newError(ErrInvalidAmount, "Amount limit is negative")
+		}
+		if err := amount.CheckCurrency(account.Currency); err != nil {
+			return err
+		}
+	}
+	return s.repo.SetLimits(account, limits)
+}
+
+func (s *service) SetAccountStatus(
+	id AccountID,
+	status AccountStatus,
+	author string) (*AccountStatusChange, error) {
+
+	if status < AccountActive || status > AccountClosed {
+		return nil,
+			newError(ErrInvalidStatusChange, `Unknown account status %d`, status)
+	}
+	return s.repo.SetAccountStatus(id, status, author,
+		func(account Account) error {
+			switch {
+			case account.Status == AccountClosed:
+				return newError(ErrInvalidStatusChange,
+					`Account "%s" (%s) is closed`, id.ID, id.Currency)
+			case account.Status == status:
+				return newError(ErrInvalidStatusChange,
+					`Account "%s" (%s) is already %s`, id.ID, id.Currency, status)
+			case status == AccountClosed &&
+				(!account.Balance.IsZero() || !account.Held.IsZero()):
+
+				return newError(ErrInvalidStatusChange,
+					`Account "%s" (%s) with not zero balance or active holds`+
+						` could not be closed`,
+					id.ID, id.Currency)
+			}
+			return nil
+		})
+}
+
+func (s *service) GetAccountStatusChanges(
+	id AccountID) ([]AccountStatusChange, error) {
+
+	return s.repo.GetAccountStatusChanges(id)
+}
+
+func (s *service) SetOverdraft(
+	id AccountID, overdraft Amount) (*Account, error) {
+
+	if overdraft.IsNegative() {
+		return nil, newError(ErrInvalidAmount, "Overdraft is negative")
+	}
+	if err := overdraft.CheckCurrency(id.Currency); err != nil {
+		return nil, err
+	}
+	return s.repo.SetOverdraft(id, overdraft, func(account Account) error {
+		if account.Status == AccountClosed {
+			return newAccountClosedError(id)
+		}
+		return nil
+	})
+}
+
+func (s *service) CreateSchedule(
+	schedule Schedule, now time.Time) (*Schedule, error) {
+
+	if err := planSchedule(&schedule, now); err != nil {
+		return nil, err
+	}
+	return s.repo.AddSchedule(schedule, func(account Account) error {
+		if account.Status == AccountClosed {
+			return newAccountClosedError(account.ID)
+		}
+		return nil
+	})
+}
+
+func (s *service) GetSchedule(id int) (*Schedule, error) {
+	return s.repo.GetSchedule(id)
+}
+
+func (s *service) GetSchedules() ([]Schedule, error) {
+	return s.repo.GetSchedules()
+}
+
+func (s *service) UpdateSchedule(
+	request Schedule, now time.Time) (*Schedule, error) {
+
+	return s.repo.ModifySchedule(request.ID, nil,
+		func(schedule *Schedule) error {
+			schedule.Amount = request.Amount
+			schedule.Start = request.Start
+			schedule.Period = request.Period
+			return planSchedule(schedule, now)
+		})
+}
+
+func (s *service) DeleteSchedule(id int) (*Schedule, error) {
+	return s.repo.DeleteSchedule(id)
+}
+
+func (s *service) GetScheduleRuns(id int) ([]ScheduleRun, error) {
+	return s.repo.GetScheduleRuns(id)
+}
+
+func (s *service) GetDueSchedules(now time.Time) ([]Schedule, error) {
+	return s.repo.GetDueSchedules(now)
+}
+
+func (s *service) CompleteScheduleRun(
+	run ScheduleRun, retry *time.Time) (*Schedule, error) {
+
+	return s.repo.ModifySchedule(run.ScheduleID, &run,
+		func(schedule *Schedule) error {
+			// The run could be completed by another scheduler, or the schedule
+			// could be updated during the payment.
+			if schedule.NextRun == nil || schedule.Runs != run.Run {
+				return newError(ErrScheduleRunCompleted,
+					`Schedule %d run %d is already completed`, schedule.ID, run.Run)
+			}
+			run.Attempt = schedule.Attempts + 1
+			run.Time = run.Time.UTC()
+			if run.TransID == nil && retry != nil {
+				schedule.Attempts++
+				schedule.NextRun = utcTime(retry)
+				return nil
+			}
+			schedule.Runs++
+			schedule.Attempts = 0
+			if schedule.Period == ScheduleOnce {
+				schedule.NextRun = nil
+			} else {
+				next := schedule.GetRunTime(schedule.Runs)
+				schedule.NextRun = &next
+			}
+			return nil
+		})
+}
+
+func (s *service) ProposeAdjustment(
+	action BalanceAction, proposer string) (*Adjustment, error) {
+
+	if action.Volume.IsZero() {
+		return nil, newError(ErrInvalidAmount, "Adjustment amount is zero")
+	}
+	if err := action.Volume.CheckCurrency(action.Account.Currency); err != nil {
+		return nil, err
+	}
+	return s.repo.AddAdjustment(
+		Adjustment{
+			Account:  action.Account,
+			Amount:   action.Volume,
+			Proposer: proposer},
+		func(account Account) error {
+			if account.Status == AccountClosed {
+				return newAccountClosedError(account.ID)
+			}
+			return nil
+		})
+}
+
+func (s *service) GetAdjustment(id int) (*Adjustment, error) {
+	return s.repo.GetAdjustment(id)
+}
+
+func (s *service) GetAdjustments(
+	status AdjustmentStatus) ([]Adjustment, error) {
+
+	switch status {
+	case "", AdjustmentPending, AdjustmentApproved, AdjustmentRejected:
+	default:
+		return nil,
+			newError(ErrInvalidQuery, `Unknown adjustment status "%s"`, status)
+	}
+	return s.repo.GetAdjustments(status)
+}
+
+func (s *service) ApproveAdjustment(
+	id int, checker string) (*TransResult, error) {
+
+	adjustment, err := s.getAdjustmentForDecision(id, checker)
+	if err != nil {
+		return nil, err
+	}
+	// The idempotency key is unique for each adjustment, so it could not be
+	// executed twice. The status is checked by the repository after accounts
+	// locking.
+	action := BalanceAction{
+		Account: adjustment.Account, Volume: adjustment.Amount}
+	return s.managerExecutor.Execute(
+		Trans{action},
+		adjustment.Proposer,
+		fmt.Sprintf(InternalIdempotencyKeyPrefix+"adjustment-%d", id),
+		s.repo.ApproveAdjustment(id, checker))
+}
+
+func (s *service) RejectAdjustment(
+	id int, checker string) (*Adjustment, error) {
+
+	if _, err := s.getAdjustmentForDecision(id, checker); err != nil {
+		return nil, err
+	}
+	return s.repo.RejectAdjustment(id, checker)
+}
+
+func (s *service) VerifyJournal() (*JournalReport, error) {
+	return s.repo.VerifyJournal()
+}
+
+func (s *service) VerifyIntegrity() (*IntegrityReport, error) {
+	return s.repo.VerifyIntegrity()
+}
+
+// getAdjustmentForDecision returns the adjustment, or an error if the checker
+// is the adjustment proposer.
+func (s *service) getAdjustmentForDecision(
+	id int, checker string) (*Adjustment, error) {
+
+	adjustment, err := s.repo.GetAdjustment(id)
+	if err != nil {
+		return nil, err
+	}
+	if adjustment.Proposer == checker {
+		return nil,
+			newError(ErrSelfApproval,
+				`Adjustment %d is proposed by "%s", it has to be decided by`+
+					` another manager`, id, checker)
+	}
+	return adjustment, nil
+}
+
+// planSchedule checks the schedule plan at the current time and resets the
+// schedule run state, so the first run is planned for the start time.
+func planSchedule(schedule *Schedule, now time.Time) error {
+	switch schedule.Period {
+	case ScheduleOnce, ScheduleDaily, ScheduleWeekly, ScheduleMonthly:
+	default:
+		return newError(ErrInvalidSchedule,
+			`Unknown schedule period "%s"`, schedule.Period)
+	}
+	if schedule.Account == schedule.Destination {
+		return newError(ErrInvalidSchedule,
+			`Schedule has only one account "%s" (%s)`,
+			schedule.Account.ID, schedule.Account.Currency)
+	}
+	if schedule.Account.Currency != schedule.Destination.Currency {
+		return newError(ErrCurrencyMismatch,
+			`Account "%s" (%s) has a different currency from "%s"`,
+			schedule.Destination.ID, schedule.Destination.Currency,
+			schedule.Account.Currency)
+	}
+	if !schedule.Amount.IsPositive() {
+		return newError(ErrInvalidAmount, "Schedule amount has to be positive")
+	}
+	err := schedule.Amount.CheckCurrency(schedule.Account.Currency)
+	if err != nil {
+		return err
+	}
+	if schedule.Start.IsZero() {
+		schedule.Start = now
+	} else if schedule.Start.Before(now) {
+		return newError(ErrInvalidSchedule, "Schedule start time is in the past")
+	}
+	schedule.Start = schedule.Start.UTC()
+	schedule.Runs = 0
+	schedule.Attempts = 0
+	nextRun := schedule.Start
+	schedule.NextRun = &nextRun
+	return nil
 }
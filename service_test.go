@@ -3,6 +3,7 @@ package wallet_test
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	w "github.com/palchukovsky/wallet"
@@ -17,13 +18,16 @@ func Test_Service(test *testing.T) {
 	repo := mw.NewMockRepo(ctrl)
 	clientExec := mw.NewMockExecutor(ctrl)
 	managerExec := mw.NewMockExecutor(ctrl)
+	conversionExec := mw.NewMockExecutor(ctrl)
+	batchExec := mw.NewMockExecutor(ctrl)
 
-	service := w.CreateService(repo, clientExec, managerExec)
+	service := w.CreateService(
+		repo, clientExec, managerExec, conversionExec, batchExec)
 	defer service.Close()
 
 	{
 		account := w.AccountID{ID: "123", Currency: "asd"}
-		repo.EXPECT().AddAccount(w.Account{ID: account, Balance: 0}).
+		repo.EXPECT().AddAccount(w.Account{ID: account}).
 			Return(errors.New("AddAccount error"))
 		err := service.CreateAccount(account)
 		if err == nil || err.Error() != "AddAccount error" {
@@ -33,9 +37,9 @@ func Test_Service(test *testing.T) {
 	{
 		list := []w.Account{
 			{ID: w.AccountID{ID: "123", Currency: "345"},
-				Balance: 456.678},
+				Balance: w.NewAmount(456678, 3)},
 			{ID: w.AccountID{ID: "678", Currency: "098"},
-				Balance: 123.123}}
+				Balance: w.NewAmount(123123, 3)}}
 		repo.EXPECT().GetAccounts().Return(list, nil)
 		result := service.GetAccounts()
 		if len(list) != len(result) {
@@ -56,25 +60,67 @@ func Test_Service(test *testing.T) {
 		}
 	}
 	{
-		list := []w.Trans{
+		account := &w.Account{
+			ID:      w.AccountID{ID: "123", Currency: "345"},
+			Balance: w.NewAmount(456678, 3)}
+		repo.EXPECT().GetAccount(account.ID).Return(account, nil)
+		result, err := service.GetAccount(account.ID)
+		if result != account || err != nil {
+			test.Errorf("Wrong result: %v, %v.", result, err)
+		}
+	}
+	{
+		id := w.AccountID{ID: "123", Currency: "345"}
+		repo.EXPECT().GetAccount(id).Return(nil, w.ErrAccountNotFound)
+		result, err := service.GetAccount(id)
+		if result != nil || err != w.ErrAccountNotFound {
+			test.Errorf("Wrong result: %v, %v.", result, err)
+		}
+		repo.EXPECT().GetAccount(id).Return(nil, errors.New("Test error"))
+		result, err = service.GetAccount(id)
+		if result != nil || err == nil ||
+			err.Error() != "Failed to query account" {
+
+			test.Errorf("Wrong result: %v, %v.", result, err)
+		}
+	}
+	{
+		list := []w.Transaction{
 			{
-				{Account: w.AccountID{ID: "123", Currency: "345"},
-					Volume: 456.678},
-				{Account: w.AccountID{ID: "678", Currency: "098"},
-					Volume: 123.123}},
+				ID:     1,
+				Time:   time.Date(2019, 5, 1, 10, 0, 0, 0, time.UTC),
+				Author: "client",
+				Actions: w.Trans{
+					{Account: w.AccountID{ID: "123", Currency: "345"},
+						Volume: w.NewAmount(456678, 3)},
+					{Account: w.AccountID{ID: "678", Currency: "098"},
+						Volume: w.NewAmount(123123, 3)}}},
 			{
-				{Account: w.AccountID{ID: "1231", Currency: "3451"},
-					Volume: 456.678},
-				{Account: w.AccountID{ID: "6781", Currency: "0981"},
-					Volume: 123.123}}}
-		repo.EXPECT().GetTransList().Return(list, nil)
-		result := service.GetPayments()
+				ID:     2,
+				Time:   time.Date(2019, 5, 1, 11, 0, 0, 0, time.UTC),
+				Author: "manager",
+				Actions: w.Trans{
+					{Account: w.AccountID{ID: "1231", Currency: "3451"},
+						Volume: w.NewAmount(456678, 3)},
+					{Account: w.AccountID{ID: "6781", Currency: "0981"},
+						Volume: w.NewAmount(123123, 3)}}}}
+		query := w.TransQuery{Account: "123", Limit: 2}
+		repo.EXPECT().GetTransList(query).Return(list, nil)
+		result, err := service.GetPayments(query)
+		if err != nil {
+			test.Errorf(`Unexpected error: "%s".`, err)
+		}
 		if len(list) != len(result) {
 			test.Errorf("Wrong result: %v.", result)
 		} else {
 			for i, trans := range result {
-				for j, action := range trans {
-					if action != list[i][j] {
+				if trans.ID != list[i].ID || trans.Time != list[i].Time ||
+					trans.Author != list[i].Author {
+
+					test.Errorf("Wrong result: %v.", result)
+				}
+				for j, action := range trans.Actions {
+					if action != list[i].Actions[j] {
 						test.Errorf("Wrong result: %v.", result)
 					}
 				}
@@ -82,35 +128,170 @@ func Test_Service(test *testing.T) {
 		}
 	}
 	{
-		repo.EXPECT().GetTransList().Return(nil, errors.New("Test error"))
-		result := service.GetPayments()
-		if len(result) != 0 {
-			test.Errorf("Wrong result: %v.", result)
+		repo.EXPECT().GetTransList(w.TransQuery{}).
+			Return(nil, errors.New("Test error"))
+		result, err := service.GetPayments(w.TransQuery{})
+		if len(result) != 0 || err == nil ||
+			err.Error() != "Failed to query transaction list" {
+
+			test.Errorf(`Wrong result: "%v", "%v".`, result, err)
+		}
+	}
+	{
+		result, err := service.GetPayments(w.TransQuery{Limit: -1})
+		if len(result) != 0 || err == nil ||
+			err.Error() != "Transaction list limit -1 is negative" {
+
+			test.Errorf(`Wrong result: "%v", "%v".`, result, err)
 		}
 	}
 	{
 		action := w.BalanceAction{
 			Account: w.AccountID{ID: "123", Currency: "asd"},
-			Volume:  123123.123}
-		managerExec.EXPECT().Execute(w.Trans{action}, repo).
+			Volume:  w.NewAmount(123123123, 3)}
+		managerExec.EXPECT().Execute(w.Trans{action}, "bob", "key1", repo).
 			Return(nil, errors.New("manager error"))
-		err := service.SetupAccount(action)
-		if err == nil || err.Error() != "manager error" {
-			test.Errorf("Wrong result: %v.", err)
+		result, err := service.SetupAccount(action, "bob", "key1")
+		if result != nil || err == nil || err.Error() != "manager error" {
+			test.Errorf("Wrong result: %v, %v.", result, err)
 		}
 	}
 	{
 		src := w.BalanceAction{
 			Account: w.AccountID{ID: "123", Currency: "asd"},
-			Volume:  123123.32}
+			Volume:  w.NewAmount(12312332, 2)}
 		dst := w.BalanceAction{
 			Account: w.AccountID{ID: "45345", Currency: "123123"},
-			Volume:  4534}
-		clientExec.EXPECT().Execute(w.Trans{src, dst}, repo).
+			Volume:  w.NewAmount(4534, 0)}
+		reference := &w.TransResult{
+			TransID: 12,
+			Accounts: []w.Account{
+				{ID: src.Account, Balance: w.NewAmount(1, 0)},
+				{ID: dst.Account, Balance: w.NewAmount(2, 0)}}}
+		clientExec.EXPECT().Execute(w.Trans{src, dst}, "alice", "", repo).
+			Return(reference, nil)
+		result, err := service.MakePayment(src, dst, "alice", "")
+		if result != reference || err != nil {
+			test.Errorf("Wrong result: %v, %v.", result, err)
+		}
+
+		clientExec.EXPECT().Execute(w.Trans{src, dst}, "alice", "key2", repo).
 			Return(nil, errors.New("client error"))
-		err := service.MakePayment(src, dst)
-		if err == nil || err.Error() != "client error" {
-			test.Errorf("Wrong result: %v.", err)
+		result, err = service.MakePayment(src, dst, "alice", "key2")
+		if result != nil || err == nil || err.Error() != "client error" {
+			test.Errorf("Wrong result: %v, %v.", result, err)
+		}
+	}
+	{
+		src := w.BalanceAction{
+			Account: w.AccountID{ID: "123", Currency: "USD"},
+			Volume:  w.NewAmount(-10, 0)}
+		dst := w.AccountID{ID: "45345", Currency: "EUR"}
+		conversionExec.EXPECT().
+			Execute(
+				w.Trans{src, w.BalanceAction{Account: dst}}, "alice", "key3", repo).
+			Return(nil, errors.New("conversion error"))
+		result, err := service.MakeConversionPayment(src, dst, "alice", "key3")
+		if result != nil || err == nil || err.Error() != "conversion error" {
+			test.Errorf("Wrong result: %v, %v.", result, err)
+		}
+	}
+}
+
+// Test_Service_Statement tests account statement building.
+func Test_Service_Statement(test *testing.T) {
+	ctrl := gomock.NewController(test)
+	defer ctrl.Finish()
+
+	repo := mw.NewMockRepo(ctrl)
+	service := w.CreateService(repo,
+		mw.NewMockExecutor(ctrl), mw.NewMockExecutor(ctrl),
+		mw.NewMockExecutor(ctrl), mw.NewMockExecutor(ctrl))
+	defer service.Close()
+
+	account := w.AccountID{ID: "src", Currency: "USD"}
+	dst := w.AccountID{ID: "dst", Currency: "USD"}
+	from := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2019, 5, 2, 0, 0, 0, 0, time.UTC)
+	list := []w.Transaction{
+		{
+			ID:     10,
+			Time:   from.Add(time.Hour),
+			Author: "manager",
+			Actions: w.Trans{
+				{Account: account, Volume: w.NewAmount(100, 0)}}},
+		{
+			ID:     11,
+			Time:   from.Add(2 * time.Hour),
+			Author: "client",
+			Actions: w.Trans{
+				{Account: dst, Volume: w.NewAmount(2550, 2)},
+				{Account: account, Volume: w.NewAmount(-2550, 2)}}}}
+
+	query := w.TransQuery{
+		Account: account.ID, Currency: account.Currency, From: from, To: to}
+	repo.EXPECT().GetStatementTrans(account, query).
+		Return(w.NewAmount(5, 0), list, nil)
+	result, err := service.GetStatement(account, from, to)
+	if err != nil {
+		test.Fatalf(`Failed to get statement: "%s".`, err)
+	}
+	if result.Account != account || result.From != from || result.To != to ||
+		result.OpeningBalance != w.NewAmount(5, 0) ||
+		result.ClosingBalance != w.NewAmount(7950, 2) ||
+		len(result.Items) != 2 {
+
+		test.Fatalf(`Wrong statement: "%v".`, result)
+	}
+	if item := result.Items[0]; item.TransID != 10 ||
+		item.Time != list[0].Time || item.Author != "manager" ||
+		item.Volume != w.NewAmount(100, 0) || len(item.Counterparties) != 0 ||
+		item.Balance != w.NewAmount(105, 0) {
+
+		test.Errorf(`Wrong statement item: "%v".`, item)
+	}
+	if item := result.Items[1]; item.TransID != 11 ||
+		item.Volume != w.NewAmount(-2550, 2) ||
+		len(item.Counterparties) != 1 || item.Counterparties[0] != dst ||
+		item.Balance != w.NewAmount(7950, 2) {
+
+		test.Errorf(`Wrong statement item: "%v".`, item)
+	}
+
+	repo.EXPECT().GetStatementTrans(account, query).
+		Return(w.Amount{}, nil, errors.New("Test error"))
+	if _, err := service.GetStatement(account, from, to); err == nil ||
+		err.Error() != "Test error" {
+
+		test.Errorf(`Wrong error: "%v".`, err)
+	}
+
+	if _, err := service.GetStatement(account, to, from); err == nil {
+		test.Error("Statement for the empty time range has to fail.")
+	}
+}
+
+// Test_Service_ScheduleRunTime tests run time planning of payment schedules.
+func Test_Service_ScheduleRunTime(test *testing.T) {
+	start := time.Date(2019, 1, 31, 10, 0, 0, 0, time.UTC)
+	for _, check := range []struct {
+		period w.SchedulePeriod
+		run    int
+		time   time.Time
+	}{
+		{w.ScheduleOnce, 0, start},
+		{w.ScheduleDaily, 3, time.Date(2019, 2, 3, 10, 0, 0, 0, time.UTC)},
+		{w.ScheduleWeekly, 2, time.Date(2019, 2, 14, 10, 0, 0, 0, time.UTC)},
+		{w.ScheduleMonthly, 0, start},
+		{w.ScheduleMonthly, 1, time.Date(2019, 2, 28, 10, 0, 0, 0, time.UTC)},
+		{w.ScheduleMonthly, 2, time.Date(2019, 3, 31, 10, 0, 0, 0, time.UTC)},
+		{w.ScheduleMonthly, 3, time.Date(2019, 4, 30, 10, 0, 0, 0, time.UTC)},
+		{w.ScheduleMonthly, 13, time.Date(2020, 2, 29, 10, 0, 0, 0, time.UTC)}} {
+
+		schedule := w.Schedule{Start: start, Period: check.period}
+		if result := schedule.GetRunTime(check.run); !result.Equal(check.time) {
+			test.Errorf(`Wrong time of %s run %d: "%s".`,
+				check.period, check.run, result)
 		}
 	}
 }
@@ -0,0 +1,185 @@
+package wallet
+
+import (
+	"database/sql"
+	"fmt"
+
+	// The package also registers SQLite driver as "sqlite3".
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteSchema is the same schema as build/db/init.sql has for PostgreSQL.
+// Amounts are stored as text as SQLite converts not integer numeric values to
+// float numbers.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS account (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	name text,
+	currency text,
+	balance text NOT NULL DEFAULT '0',
+	status text NOT NULL DEFAULT 'active',
+	overdraft text NOT NULL DEFAULT '0',
+	CONSTRAINT account_unique UNIQUE(currency, name));
+
+CREATE TABLE IF NOT EXISTS trans (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	time timestamp NOT NULL,
+	author text NOT NULL,
+	idempotency_key text,
+	hash text NOT NULL DEFAULT '',
+	CONSTRAINT trans_idempotency_key_unique UNIQUE(idempotency_key));
+CREATE INDEX IF NOT EXISTS trans_time ON trans(time, id);
+
+CREATE TABLE IF NOT EXISTS trans_chain (
+	id integer PRIMARY KEY,
+	trans integer REFERENCES trans(id) ON DELETE RESTRICT,
+	hash text NOT NULL);
+INSERT OR IGNORE INTO trans_chain(id, trans, hash) VALUES(1, NULL, '');
+
+CREATE TABLE IF NOT EXISTS action (
+	account integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	trans integer NOT NULL REFERENCES trans(id) ON DELETE CASCADE,
+	volume text NOT NULL,
+	fee boolean NOT NULL DEFAULT 0,
+	PRIMARY KEY(account, trans));
+CREATE INDEX IF NOT EXISTS action_trans ON action(trans);
+
+CREATE TABLE IF NOT EXISTS reversal (
+	trans integer NOT NULL REFERENCES trans(id) ON DELETE CASCADE,
+	original integer NOT NULL REFERENCES trans(id) ON DELETE RESTRICT,
+	PRIMARY KEY(trans));
+CREATE INDEX IF NOT EXISTS reversal_original ON reversal(original);
+
+CREATE TABLE IF NOT EXISTS hold (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	account integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	destination integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	amount text NOT NULL,
+	author text NOT NULL,
+	time timestamp NOT NULL,
+	expiration timestamp NOT NULL,
+	status text NOT NULL,
+	released_by text NOT NULL DEFAULT '');
+CREATE INDEX IF NOT EXISTS hold_account ON hold(account, status);
+
+CREATE TABLE IF NOT EXISTS account_limits (
+	account integer NOT NULL REFERENCES account(id) ON DELETE CASCADE,
+	max_payment text NOT NULL DEFAULT '0',
+	daily_amount text NOT NULL DEFAULT '0',
+	monthly_amount text NOT NULL DEFAULT '0',
+	daily_count integer NOT NULL DEFAULT 0,
+	monthly_count integer NOT NULL DEFAULT 0,
+	PRIMARY KEY(account));
+
+CREATE TABLE IF NOT EXISTS account_status_change (
+	trans integer NOT NULL REFERENCES trans(id) ON DELETE CASCADE,
+	account integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	status text NOT NULL,
+	PRIMARY KEY(trans));
+CREATE INDEX IF NOT EXISTS account_status_change_account
+	ON account_status_change(account);
+
+CREATE TABLE IF NOT EXISTS schedule (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	account integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	destination integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	amount text NOT NULL,
+	author text NOT NULL,
+	start_time timestamp NOT NULL,
+	period text NOT NULL,
+	runs integer NOT NULL,
+	attempts integer NOT NULL,
+	next_run timestamp);
+CREATE INDEX IF NOT EXISTS schedule_next_run ON schedule(next_run);
+
+CREATE TABLE IF NOT EXISTS schedule_run (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	schedule integer NOT NULL REFERENCES schedule(id) ON DELETE CASCADE,
+	run integer NOT NULL,
+	attempt integer NOT NULL,
+	time timestamp NOT NULL,
+	trans integer REFERENCES trans(id) ON DELETE RESTRICT,
+	error text NOT NULL);
+CREATE INDEX IF NOT EXISTS schedule_run_schedule ON schedule_run(schedule);
+
+CREATE TABLE IF NOT EXISTS adjustment (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	account integer NOT NULL REFERENCES account(id) ON DELETE RESTRICT,
+	amount text NOT NULL,
+	proposer text NOT NULL,
+	time timestamp NOT NULL,
+	status text NOT NULL,
+	checker text NOT NULL,
+	decision_time timestamp,
+	trans integer REFERENCES trans(id) ON DELETE RESTRICT);
+CREATE INDEX IF NOT EXISTS adjustment_status ON adjustment(status);
+`
+
+// sqliteAddedColumns lists columns which are added to the schema after the
+// table creation, so databases, which were created by earlier versions, don't
+// have them. Each definition is the same as the schema has.
+var sqliteAddedColumns = []struct{ table, column, definition string }{
+	{"account", "status", "text NOT NULL DEFAULT 'active'"},
+	{"account", "overdraft", "text NOT NULL DEFAULT '0'"},
+	{"trans", "hash", "text NOT NULL DEFAULT ''"},
+	{"hold", "released_by", "text NOT NULL DEFAULT ''"},
+	{"action", "fee", "boolean NOT NULL DEFAULT 0"}}
+
+// createSQLiteDB opens SQLite database file and creates the schema if the
+// database is new, or adds missing tables, columns and transaction hashes if
+// the database was created by an earlier version.
+func createSQLiteDB(dataSourceName string) (*sqlDB, error) {
+	result, err := createSQLDB(sqliteDialect, dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite does not support row locking, so all transactions are serialized by
+	// the only connection. It also allows to use in-memory database, which
+	// exists only for one connection.
+	result.conn.SetMaxOpenConns(1)
+	if _, err = result.conn.Exec("PRAGMA foreign_keys = ON"); err != nil {
+		result.Close()
+		return nil, err
+	}
+	if _, err = result.conn.Exec(sqliteSchema); err != nil {
+		result.Close()
+		return nil, err
+	}
+	if err = addSQLiteColumns(result.conn); err != nil {
+		result.Close()
+		return nil, err
+	}
+	if err = result.chainJournal(); err != nil {
+		result.Close()
+		return nil, err
+	}
+	return result, nil
+}
+
+// addSQLiteColumns adds columns of sqliteAddedColumns which tables don't have.
+func addSQLiteColumns(conn *sql.DB) error {
+	for _, column := range sqliteAddedColumns {
+		var count int
+		err := conn.QueryRow(
+			"SELECT COUNT(*) FROM pragma_table_info(?1) WHERE name = ?2",
+			column.table, column.column).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count != 0 {
+			continue
+		}
+		_, err = conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s",
+			column.table, column.column, column.definition))
+		if err != nil {
+			return fmt.Errorf(`Failed to add column "%s" to table "%s": "%s"`,
+				column.column, column.table, err)
+		}
+	}
+	return nil
+}
+
+func isSQLiteUniqueViolation(err error) bool {
+	sqliteErr, isSQLiteErr := err.(sqlite3.Error)
+	return isSQLiteErr && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}